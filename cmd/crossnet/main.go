@@ -1,14 +1,24 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net/netip"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/CyberOakAlpha/CrossNet/internal/hostname"
 	"github.com/CyberOakAlpha/CrossNet/internal/osdetect"
 	"github.com/CyberOakAlpha/CrossNet/internal/scanner"
+	"github.com/CyberOakAlpha/CrossNet/internal/snmp"
+	"github.com/CyberOakAlpha/CrossNet/internal/targets"
 )
 
 const (
@@ -36,9 +46,44 @@ type Config struct {
 	showVersion bool
 	verbose     bool
 	outputFile  string
+	iface       string
+	ports       string
+	probePorts  string
+	exclude     string
+	rate        float64
+	burst       int
+	subnetRate  float64
+	timing      string
+	retries     int
+	// timeoutSet records whether --timeout was given, in which case it
+	// overrides the initial timeout of the timing template
+	timeoutSet bool
+	count      int
+	interval   time.Duration
+	trace      bool
+	resolve    string
+	dnsServer  string
+	dnsTimeout time.Duration
+	dnsRetries int
+	snmp       string
+	// methods and snmpClient are built from the flags once main has
+	// validated them
+	methods    []hostname.MethodConfig
+	snmpClient *snmp.Client
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "monitor":
+			os.Exit(runMonitor(os.Args[2:]))
+		case "trace":
+			os.Exit(runTrace(os.Args[2:]))
+		case "oui":
+			os.Exit(runOUI(os.Args[2:]))
+		}
+	}
+
 	config := parseFlags()
 
 	if config.showHelp {
@@ -51,62 +96,245 @@ func main() {
 		return
 	}
 
+	timing, err := config.pingTiming()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	config.methods, err = config.resolveMethods()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	config.snmpClient, err = config.newSNMPClient()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	set, err := targets.Parse(config.network)
+	if err == nil && config.exclude != "" {
+		err = set.Exclude(config.exclude)
+	}
+	if err != nil {
+		fmt.Printf("Error: Invalid targets: %v\n", err)
+		os.Exit(1)
+	}
+
 	fmt.Printf(banner, version)
 	fmt.Printf("Operating System: %s\n", osdetect.GetOSString())
 	fmt.Printf("Scan Type: %s\n", config.scanType)
-	fmt.Printf("Network: %s\n", config.network)
+	fmt.Printf("Network: %s (%s)\n", config.network, describeTargets(set))
+	if config.exclude != "" {
+		fmt.Printf("Excluding: %s\n", config.exclude)
+	}
 	fmt.Printf("Threads: %d\n", config.threads)
-	fmt.Printf("Timeout: %v\n\n", config.timeout)
+	if config.rate > 0 {
+		fmt.Printf("Rate limit: %g probes/s (burst %d)\n", config.rate, max(config.burst, 1))
+	}
+	if config.subnetRate > 0 {
+		fmt.Printf("Subnet pacing: %g probes/s per /24\n", config.subnetRate)
+	}
+	fmt.Printf("Timeout: %v\n", config.timeout)
+	fmt.Printf("Timing: %s (ping timeouts %v-%v, %d retries)\n", timing.Name, timing.MinTimeout, timing.MaxTimeout, timing.Retries)
+	fmt.Printf("Name resolution: %s\n", describeMethods(config.methods))
+	if config.dnsServer != "" {
+		fmt.Printf("DNS servers: %s (%v per query, %d retries)\n", config.dnsServer, config.dnsTimeout, config.dnsRetries)
+	}
+	if config.snmpClient != nil {
+		fmt.Printf("SNMP: %s\n", config.snmpClient.Config())
+	}
+	fmt.Println()
+
+	// Ctrl-C stops dispatching probes and prints what was found so far
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
+	rep := newReport(config)
 	switch strings.ToLower(config.scanType) {
 	case "ping":
-		runPingScan(config)
+		runPingScan(ctx, config, set, rep, false)
 	case "arp":
-		runARPScan(config)
+		runARPScan(ctx, config, set, rep)
 	case "both":
-		runPingScan(config)
-		fmt.Println()
-		runARPScan(config)
+		runPingScan(ctx, config, set, rep, true)
+	case "ports":
+		runPortScan(ctx, config, set, rep)
+	case "tcp":
+		runTCPScan(ctx, config, set, rep)
+	case "ssdp":
+		runSSDPScan(ctx, config, set, rep)
 	default:
-		fmt.Printf("Error: Invalid scan type '%s'. Use 'ping', 'arp', or 'both'\n", config.scanType)
+		fmt.Printf("Error: Invalid scan type '%s'. Use 'ping', 'arp', 'both', 'ports', 'tcp' or 'ssdp'\n", config.scanType)
 		os.Exit(1)
 	}
+
+	rep.Hosts = rep.hosts.Hosts()
+	fmt.Println()
+	printResults(os.Stdout, config, rep.Hosts)
+
+	if config.trace && ctx.Err() == nil {
+		traceSubnets(ctx, config, rep)
+	}
+
+	if config.outputFile != "" {
+		if err := rep.write(config.outputFile); err != nil {
+			fmt.Printf("Error writing output file: %v\n", err)
+		} else {
+			fmt.Printf("\nResults written to %s\n", config.outputFile)
+		}
+	}
+
+	if ctx.Err() != nil {
+		stop()
+		os.Exit(130)
+	}
 }
 
 func parseFlags() Config {
 	var config Config
 
-	flag.StringVar(&config.network, "network", "192.168.1.0/24", "Network to scan (CIDR notation)")
-	flag.StringVar(&config.network, "n", "192.168.1.0/24", "Network to scan (CIDR notation) - short")
-	flag.DurationVar(&config.timeout, "timeout", 2*time.Second, "Timeout for ping requests")
-	flag.DurationVar(&config.timeout, "t", 2*time.Second, "Timeout for ping requests - short")
+	flag.StringVar(&config.network, "network", "192.168.1.0/24", "Targets to scan: CIDRs, ranges, addresses, hostnames or @file")
+	flag.StringVar(&config.network, "n", "192.168.1.0/24", "Targets to scan - short")
+	flag.DurationVar(&config.timeout, "timeout", 2*time.Second, "Timeout for connections, and the initial ping timeout if given")
+	flag.DurationVar(&config.timeout, "t", 2*time.Second, "Timeout for connections, and the initial ping timeout if given - short")
 	flag.IntVar(&config.threads, "threads", 50, "Number of concurrent threads")
 	flag.IntVar(&config.threads, "T", 50, "Number of concurrent threads - short")
-	flag.StringVar(&config.scanType, "scan", "both", "Scan type: ping, arp, or both")
-	flag.StringVar(&config.scanType, "s", "both", "Scan type: ping, arp, or both - short")
+	flag.StringVar(&config.scanType, "scan", "both", "Scan type: ping, arp, both, ports, tcp or ssdp")
+	flag.StringVar(&config.scanType, "s", "both", "Scan type: ping, arp, both, ports, tcp or ssdp - short")
 	flag.BoolVar(&config.showHelp, "help", false, "Show help message")
 	flag.BoolVar(&config.showHelp, "h", false, "Show help message - short")
 	flag.BoolVar(&config.showVersion, "version", false, "Show version")
 	flag.BoolVar(&config.showVersion, "v", false, "Show version - short")
 	flag.BoolVar(&config.verbose, "verbose", false, "Verbose output")
-	flag.StringVar(&config.outputFile, "output", "", "Output file (optional)")
-	flag.StringVar(&config.outputFile, "o", "", "Output file (optional) - short")
+	flag.StringVar(&config.outputFile, "output", "", "Output file; .json and .csv select those formats, anything else is text")
+	flag.StringVar(&config.outputFile, "o", "", "Output file - short")
+	flag.StringVar(&config.iface, "interface", "", "Interface for ARP scans, SSDP and IPv6 multicast discovery (optional)")
+	flag.StringVar(&config.iface, "i", "", "Interface for ARP scans, SSDP and IPv6 multicast discovery (optional) - short")
+	flag.StringVar(&config.ports, "ports", "top", "Ports for port scans, e.g. 22,80,8000-8100 or top20")
+	flag.StringVar(&config.ports, "p", "top", "Ports for port scans - short")
+	flag.StringVar(&config.probePorts, "probe-ports", "22,80,443,445,3389", "Ports probed by TCP host discovery")
+	flag.StringVar(&config.exclude, "exclude", "", "Targets to leave out, in the same syntax as --network")
+	flag.Float64Var(&config.rate, "rate", 0, "Maximum probes per second across the scan (0 for unlimited)")
+	flag.IntVar(&config.burst, "burst", 1, "Probes that may be sent back to back before --rate applies")
+	flag.IntVar(&config.count, "count", 1, "Echo requests per host; more than one measures loss, jitter and latency")
+	flag.IntVar(&config.count, "c", 1, "Echo requests per host - short")
+	flag.DurationVar(&config.interval, "interval", 200*time.Millisecond, "Time between echo requests to a host when --count is above one")
+	flag.BoolVar(&config.trace, "trace", false, "Trace the path to one live host in each subnet after the scan")
+	flag.StringVar(&config.timing, "timing", "normal", "Timing template for ping scans: fast, normal or patient")
+	flag.IntVar(&config.retries, "retries", -1, "Retries for hosts that do not answer a ping (-1 uses the timing template)")
+	flag.Float64Var(&config.subnetRate, "subnet-rate", 0, "Maximum probes per second into any one /24 or IPv6 /64 (0 for unlimited)")
+	flag.StringVar(&config.dnsServer, "dns-server", "", "DNS servers to send PTR queries to instead of the system resolver, e.g. 10.0.0.53,10.0.1.53:5353")
+	flag.DurationVar(&config.dnsTimeout, "dns-timeout", time.Second, "How long each query to a --dns-server waits for an answer")
+	flag.IntVar(&config.dnsRetries, "dns-retries", 1, "Retries of an unanswered query before the next --dns-server is tried")
+	flag.StringVar(&config.resolve, "resolve", "", "Hostname resolution methods in order, each with an optional timeout, e.g. dns:1s,netbios, or none")
+	flag.StringVar(&config.snmp, "snmp", "", "Query the SNMP system group of live hosts with a v2c community, e.g. public, or v3:USER[:md5|sha|sha256:PASSWORD]")
 
 	flag.Parse()
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "timeout" || f.Name == "t" {
+			config.timeoutSet = true
+		}
+	})
 	return config
 }
 
+// pingTiming returns the timing template selected on the command line with
+// any --timeout and --retries overrides applied.
+func (config Config) pingTiming() (scanner.Timing, error) {
+	timing, err := scanner.ParseTiming(config.timing)
+	if err != nil {
+		return timing, err
+	}
+	if config.timeoutSet {
+		timing = timing.WithTimeout(config.timeout)
+	}
+	if config.retries >= 0 {
+		timing.Retries = config.retries
+	}
+	return timing, nil
+}
+
+// resolveMethods returns the hostname resolution methods selected with
+// --resolve, with reverse DNS sent to --dns-server if given. In verbose
+// mode the outcome of every PTR query is printed.
+func (config Config) resolveMethods() ([]hostname.MethodConfig, error) {
+	if config.dnsTimeout <= 0 || config.dnsRetries < 0 {
+		return nil, fmt.Errorf("--dns-timeout must be positive and --dns-retries at least 0")
+	}
+	methods, err := hostname.ParseMethods(config.resolve)
+	if err != nil || config.dnsServer == "" {
+		return methods, err
+	}
+	client, err := hostname.ParseDNSServers(config.dnsServer)
+	if err != nil {
+		return nil, err
+	}
+	client.SetTimeout(config.dnsTimeout)
+	client.SetRetries(config.dnsRetries)
+
+	method := hostname.NewDNSMethod(client)
+	if config.verbose {
+		method = hostname.NewMethod(hostname.MethodDNS, func(ctx context.Context, ip string) []string {
+			result, err := client.LookupPTR(ctx, ip)
+			switch {
+			case err != nil:
+				fmt.Printf("PTR %s: %v\n", ip, err)
+			case len(result.Names) == 0:
+				fmt.Printf("PTR %s: %s from %s\n", ip, hostname.RCodeName(result.RCode), result.Server)
+			default:
+				fmt.Printf("PTR %s: %s (TTL %v) from %s\n", ip, strings.Join(result.Names, ", "), result.TTL, result.Server)
+			}
+			return result.Names
+		})
+	}
+	return hostname.ReplaceMethod(methods, method), nil
+}
+
+// newSNMPClient returns the SNMP client for the credentials given with
+// --snmp, or nil if SNMP is not to be queried.
+func (config Config) newSNMPClient() (*snmp.Client, error) {
+	if config.snmp == "" {
+		return nil, nil
+	}
+	snmpConfig, err := snmp.ParseConfig(config.snmp)
+	if err != nil {
+		return nil, err
+	}
+	return snmp.NewClient(snmpConfig)
+}
+
 func showHelp() {
 	fmt.Printf(banner, version)
 	fmt.Println("USAGE:")
 	fmt.Println("  crossnet [OPTIONS]")
+	fmt.Println("  crossnet monitor [OPTIONS] <host>   Watch one host continuously (see crossnet monitor -h)")
+	fmt.Println("  crossnet trace [OPTIONS] <host>     Show the path to a host (see crossnet trace -h)")
+	fmt.Println("  crossnet oui import|lookup ...      Update or query the MAC vendor registry")
 	fmt.Println()
 	fmt.Println("OPTIONS:")
-	fmt.Println("  -n, --network    Network to scan (CIDR notation) [default: 192.168.1.0/24]")
-	fmt.Println("  -s, --scan       Scan type: ping, arp, or both [default: both]")
-	fmt.Println("  -t, --timeout    Timeout for ping requests [default: 2s]")
+	fmt.Println("  -n, --network    Targets: comma-separated CIDRs, ranges, addresses, hostnames or @file [default: 192.168.1.0/24]")
+	fmt.Println("      --exclude    Targets to leave out, in the same syntax as --network")
+	fmt.Println("  -s, --scan       Scan type: ping, arp, both, ports, tcp or ssdp [default: both]")
+	fmt.Println("  -t, --timeout    Timeout for connections, and the initial ping timeout if given [default: 2s]")
+	fmt.Println("      --timing     Ping timing template: fast, normal or patient [default: normal]")
+	fmt.Println("      --retries    Retries for hosts that do not answer a ping [default: from --timing]")
 	fmt.Println("  -T, --threads    Number of concurrent threads [default: 50]")
-	fmt.Println("  -o, --output     Output file (optional)")
+	fmt.Println("      --rate       Maximum probes per second across the scan [default: unlimited]")
+	fmt.Println("      --burst      Probes sent back to back before --rate applies [default: 1]")
+	fmt.Println("      --subnet-rate Maximum probes per second into any one /24 or IPv6 /64 [default: unlimited]")
+	fmt.Println("  -o, --output     Output file: .json, .csv or text by extension (optional)")
+	fmt.Println("  -c, --count      Echo requests per host; above one reports loss, jitter and min/avg/max [default: 1]")
+	fmt.Println("      --interval   Time between echo requests to a host with --count [default: 200ms]")
+	fmt.Println("      --trace      Trace the path to one live host per /24 or /64 and store it with the results")
+	fmt.Println("      --dns-server Send reverse DNS queries to these servers (ip or ip:port, comma-separated) [default: system resolver]")
+	fmt.Println("      --dns-timeout How long each query to a --dns-server waits, within the dns method's timeout [default: 1s]")
+	fmt.Println("      --dns-retries Retries of an unanswered query before the next --dns-server [default: 1]")
+	fmt.Println("      --resolve    Hostname methods in order, each with an optional :timeout, or none [default: dns,hosts,netbios,llmnr,mdns]")
+	fmt.Println("      --snmp       Read sysName, sysDescr and more from live hosts: COMMUNITY, v3:USER or v3:USER:md5|sha|sha256:PASSWORD")
+	fmt.Println("  -i, --interface  Interface for ARP/NDP scans, SSDP and IPv6 multicast discovery [default: auto-detect]")
+	fmt.Println("  -p, --ports      Ports for port scans: list, ranges, or topN [default: top]")
+	fmt.Println("      --probe-ports Ports for TCP host discovery [default: 22,80,443,445,3389]")
 	fmt.Println("  -v, --version    Show version")
 	fmt.Println("  -h, --help       Show this help message")
 	fmt.Println("      --verbose    Verbose output")
@@ -115,91 +343,579 @@ func showHelp() {
 	fmt.Println("  crossnet -n 192.168.0.0/24 -s ping")
 	fmt.Println("  crossnet -n 10.0.0.0/24 -s arp -T 100")
 	fmt.Println("  crossnet -n 172.16.1.0/24 -s both -o results.txt")
+	fmt.Println("  crossnet -n 192.168.1.0/24 -s ports -p 22,80,443,8000-8100")
+	fmt.Println("  crossnet -n 10.0.0.0/24 -s tcp --probe-ports 22,443,3389")
+	fmt.Println("  crossnet -n 10.0.0.0/16 -s ping --rate 100 --burst 20 --subnet-rate 10")
+	fmt.Println("  crossnet -n 192.168.1.0/24 -s ping --timing patient")
+	fmt.Println("  crossnet -n 192.168.1.20-40 -s ping -c 20 -o quality.json")
+	fmt.Println("  crossnet -n 2001:db8:1::/64 -s both -i eth0")
+	fmt.Println("  crossnet -n 10.0.1-3.1-254,192.168.1.10-20,nas.lan -s ping --exclude 10.0.2.0/24")
+	fmt.Println("  crossnet -n @targets.txt -s tcp")
+	fmt.Println("  crossnet -n 192.168.1.0/24 -s ssdp -o devices.csv")
+	fmt.Println("  crossnet -n 10.0.0.0/24 -s ping --resolve dns:500ms,netbios:5s")
+	fmt.Println("  crossnet -n 10.20.0.0/22 -s ping --dns-server 10.20.0.53,10.20.1.53 --dns-timeout 2s --resolve dns:8s")
+	fmt.Println("  crossnet -n 10.0.10.0/24 -s ping --snmp v3:monitor:sha:s3cretpass")
 	fmt.Println()
 }
 
-func runPingScan(config Config) {
-	fmt.Println("=== PING SCAN RESULTS ===")
+// rateLimit returns the probe pacing requested on the command line.
+func (config Config) rateLimit() scanner.RateLimit {
+	return scanner.RateLimit{
+		Rate:       config.rate,
+		Burst:      config.burst,
+		SubnetRate: config.subnetRate,
+	}
+}
+
+// printRate reports the probe rate a scan actually achieved.
+func printRate(progress scanner.Progress) {
+	if progress.Probes > 0 {
+		fmt.Printf("Effective rate: %.1f probes/s (%d probes in %v)\n", progress.Rate(), progress.Probes, progress.Elapsed.Round(time.Millisecond))
+	}
+}
+
+// describeMethods lists the hostname resolution methods for the banner.
+func describeMethods(methods []hostname.MethodConfig) string {
+	if len(methods) == 0 {
+		return "none"
+	}
+	parts := make([]string, 0, len(methods))
+	for _, method := range methods {
+		parts = append(parts, fmt.Sprintf("%s (%v)", method.Method.Name(), method.Timeout))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// describeTargets summarises a target set for the banner.
+func describeTargets(set *targets.Set) string {
+	summary := fmt.Sprintf("%d addresses", set.Len())
+	if n := len(set.Prefixes()); n > 0 {
+		summary += fmt.Sprintf(" plus %d IPv6 prefixes discovered by multicast", n)
+	}
+	return summary
+}
+
+// runPingScan pings every target once. With neighbors set, as for "-s
+// both", the MACs of the hosts that answer are then read from the
+// neighbour cache the pings have filled, instead of probing every target
+// again with an ARP sweep.
+func runPingScan(ctx context.Context, config Config, set *targets.Set, rep *report, neighbors bool) {
+	fmt.Println("=== PING SCAN ===")
 
 	pingScanner := scanner.NewPingScanner(config.timeout, config.threads)
-	results, err := pingScanner.ScanRange(config.network)
-	if err != nil {
+	pingScanner.SetInterface(config.iface)
+	pingScanner.SetRateLimit(config.rateLimit())
+	if timing, err := config.pingTiming(); err == nil {
+		pingScanner.SetTiming(timing)
+	}
+	pingScanner.SetQuality(config.count, config.interval)
+	summary, err := runPipeline(ctx, config, set, rep, pingScanner, neighbors)
+	if err != nil && !isInterrupted(err) {
 		fmt.Printf("Error running ping scan: %v\n", err)
 		return
 	}
 
-	aliveCount := 0
-	fmt.Printf("%-15s %-10s %-8s %-30s\n", "IP Address", "Status", "RTT", "Hostname")
-	fmt.Println(strings.Repeat("-", 70))
+	if isInterrupted(err) {
+		fmt.Printf("Ping scan interrupted. %d/%d probed hosts are alive.\n", summary.alive, summary.progress.Completed)
+	} else {
+		fmt.Printf("Ping scan completed. %d/%d hosts are alive.\n", summary.alive, summary.progress.Completed)
+	}
+	if neighbors {
+		fmt.Printf("Neighbour cache: MACs for %d live hosts, %d more devices listed that did not answer.\n", summary.macs, summary.neighborsOnly)
+	}
+	printRate(summary.progress)
+}
+
+// pipelineSummary counts what the stages of a discovery pipeline found.
+type pipelineSummary struct {
+	alive         int
+	macs          int
+	neighborsOnly int
+	progress      scanner.Progress
+}
+
+// runPipeline runs discoverer over the targets in a single pass and merges
+// the live hosts, plus the dead ones in verbose mode, into the report, so
+// that sweeping a large range does not hold a result for every address.
+func runPipeline(ctx context.Context, config Config, set *targets.Set, rep *report, discoverer scanner.Discoverer, neighbors bool) (pipelineSummary, error) {
+	pipeline := scanner.NewPipeline(discoverer, rep.hosts)
+	pipeline.SetNeighbors(neighbors)
+	pipeline.SetKeepDown(config.verbose)
+	pipeline.SetResolveMethods(config.methods)
+	pipeline.SetSNMP(config.snmpClient)
 
-	for _, result := range results {
-		if result.Alive {
-			aliveCount++
-			status := "UP"
-			rtt := result.RTT.Truncate(time.Millisecond).String()
-			hostname := result.Hostname
-			if hostname == "" {
-				hostname = "N/A"
+	var summary pipelineSummary
+	err := pipeline.Run(ctx, set, func(update scanner.PipelineUpdate) {
+		summary.progress = update.Progress
+		switch update.Stage {
+		case scanner.StageDiscovery:
+			if update.Host != nil && update.Host.Alive {
+				summary.alive++
+			}
+		case scanner.StageNeighbors:
+			if update.Host.Alive {
+				summary.macs++
+			} else {
+				summary.neighborsOnly++
 			}
-			fmt.Printf("%-15s %-10s %-8s %-30s\n", result.IP, status, rtt, hostname)
-		} else if config.verbose {
-			status := "DOWN"
-			fmt.Printf("%-15s %-10s %-8s %-30s\n", result.IP, status, "N/A", "N/A")
 		}
+	})
+	return summary, err
+}
+
+// isInterrupted reports whether err only says the scan was cut short by
+// Ctrl-C, in which case the partial results are still worth printing.
+func isInterrupted(err error) bool {
+	return errors.Is(err, context.Canceled)
+}
+
+func runTCPScan(ctx context.Context, config Config, set *targets.Set, rep *report) {
+	fmt.Println("=== TCP DISCOVERY ===")
+
+	probePorts, err := scanner.ParsePorts(config.probePorts)
+	if err != nil {
+		fmt.Printf("Error parsing probe ports: %v\n", err)
+		return
 	}
 
-	fmt.Printf("\nPing scan completed. %d/%d hosts are alive.\n", aliveCount, len(results))
+	tcpScanner := scanner.NewTCPDiscoveryScanner(config.timeout, config.threads, probePorts)
+	tcpScanner.SetRateLimit(config.rateLimit())
+	summary, err := runPipeline(ctx, config, set, rep, tcpScanner, false)
+	if err != nil && !isInterrupted(err) {
+		fmt.Printf("Error running TCP discovery: %v\n", err)
+		return
+	}
+
+	if isInterrupted(err) {
+		fmt.Printf("TCP discovery interrupted. %d/%d probed hosts are alive.\n", summary.alive, summary.progress.Completed)
+	} else {
+		fmt.Printf("TCP discovery completed. %d/%d hosts are alive.\n", summary.alive, summary.progress.Completed)
+	}
+	printRate(summary.progress)
 }
 
-func runARPScan(config Config) {
-	fmt.Println("=== ARP SCAN RESULTS ===")
+func runSSDPScan(ctx context.Context, config Config, set *targets.Set, rep *report) {
+	fmt.Println("=== SSDP DISCOVERY ===")
+
+	ssdpScanner := scanner.NewSSDPScanner(config.timeout)
+	ssdpScanner.SetInterface(config.iface)
+
+	enricher := scanner.NewEnricher(rep.hosts)
+	enricher.SetMethods(config.methods)
+	enricher.SetSNMP(config.snmpClient)
+	enricher.Start(ctx, func(scanner.Host) {})
+	defer enricher.Wait()
+
+	fmt.Println("Searching for UPnP devices...")
+	devices := 0
+	err := ssdpScanner.StreamTargets(ctx, set, func(result scanner.SSDPResult) {
+		devices++
+		if result.Err != nil && config.verbose {
+			fmt.Printf("%s: %v\n", result.IP, result.Err)
+		}
+		enricher.Add(rep.hosts.AddSSDP(result))
+	})
+	interrupted := isInterrupted(err)
+	if err != nil && !interrupted {
+		fmt.Printf("Error running SSDP discovery: %v\n", err)
+		return
+	}
+
+	hosts := countUPnPHosts(rep.hosts.Hosts())
+	if interrupted {
+		fmt.Printf("SSDP discovery interrupted. %d UPnP devices on %d hosts so far.\n", devices, hosts)
+	} else {
+		fmt.Printf("SSDP discovery completed. %d UPnP devices on %d hosts.\n", devices, hosts)
+	}
+}
+
+// printResults prints the merged hosts of the run, or the ports found on
+// them after a port scan.
+func printResults(w io.Writer, config Config, hosts []scanner.Host) {
+	if strings.EqualFold(config.scanType, "ports") {
+		fmt.Fprintln(w, "=== PORT SCAN RESULTS ===")
+		printPortResults(w, hosts)
+		return
+	}
+
+	fmt.Fprintln(w, "=== HOSTS ===")
+	if len(hosts) == 0 {
+		fmt.Fprintln(w, "No hosts found.")
+		return
+	}
+	printHosts(w, hosts, config.verbose)
+	if countNetBIOS(hosts) > 0 {
+		fmt.Fprintln(w, "\n=== NETBIOS ===")
+		printNetBIOS(w, hosts)
+	}
+	if countServices(hosts) > 0 {
+		fmt.Fprintln(w, "\n=== SERVICES ===")
+		printServices(w, hosts)
+	}
+	if countUPnPHosts(hosts) > 0 {
+		fmt.Fprintln(w, "\n=== UPNP DEVICES ===")
+		printUPnP(w, hosts)
+	}
+	if countSNMP(hosts) > 0 {
+		fmt.Fprintln(w, "\n=== SNMP ===")
+		printSNMP(w, hosts)
+	}
+}
+
+// printHosts prints one line per host with what every scan learnt about
+// it. Down hosts are only listed in verbose mode.
+func printHosts(w io.Writer, hosts []scanner.Host, verbose bool) {
+	for _, host := range hosts {
+		if host.Stats != nil {
+			printQualityResults(w, hosts, verbose)
+			return
+		}
+	}
+
+	fmt.Fprintf(w, "%-15s %-18s %-7s %-9s %-5s %-20s %-30s %s\n", "IP Address", "MAC Address", "Status", "RTT", "TTL", "Seen By", "Hostname", "Vendor")
+	fmt.Fprintln(w, strings.Repeat("-", 144))
+
+	for _, host := range hosts {
+		status := host.Status()
+		if status == "down" && !verbose {
+			continue
+		}
+
+		mac, vendor := "N/A", "N/A"
+		if host.MAC != "" {
+			mac, vendor = host.MAC, vendorName(host)
+		}
+		rtt := "N/A"
+		if host.RTT > 0 {
+			rtt = formatRTT(host.RTT)
+		}
+		fmt.Fprintf(w, "%-15s %-18s %-7s %-9s %-5s %-20s %-30s %s\n", host.IP, mac, strings.ToUpper(status), rtt,
+			formatTTL(host.TTL), strings.Join(host.Sources, "+"), hostnames(host), vendor)
+	}
+}
+
+// hostnames lists every name found for a host with the method that found
+// it, or N/A.
+func hostnames(host scanner.Host) string {
+	if len(host.Hostnames) == 0 {
+		return "N/A"
+	}
+	names := make([]string, 0, len(host.Hostnames))
+	for _, name := range host.Hostnames {
+		names = append(names, fmt.Sprintf("%s (%s)", name.Name, name.Source))
+	}
+	return strings.Join(names, ", ")
+}
+
+// printNetBIOS prints what the hosts that answered a NetBIOS node status
+// query said about themselves.
+func printNetBIOS(w io.Writer, hosts []scanner.Host) {
+	fmt.Fprintf(w, "%-15s %-16s %-16s %-18s %s\n", "IP Address", "Name", "Domain", "Reported MAC", "Users")
+	fmt.Fprintln(w, strings.Repeat("-", 90))
+
+	for _, host := range hosts {
+		status := host.NetBIOS
+		if status == nil {
+			continue
+		}
+		fmt.Fprintf(w, "%-15s %-16s %-16s %-18s %s\n", host.IP, status.Name, orNA(status.Domain), orNA(status.MAC),
+			orNA(strings.Join(status.Users, ", ")))
+	}
+}
+
+// countNetBIOS returns how many hosts answered a NetBIOS node status query.
+func countNetBIOS(hosts []scanner.Host) int {
+	n := 0
+	for _, host := range hosts {
+		if host.NetBIOS != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// printServices prints the DNS-SD services each host advertises over mDNS.
+func printServices(w io.Writer, hosts []scanner.Host) {
+	fmt.Fprintf(w, "%-15s %-24s %-7s %-30s %s\n", "IP Address", "Service", "Port", "Instance", "Target")
+	fmt.Fprintln(w, strings.Repeat("-", 110))
+
+	for _, host := range hosts {
+		for _, service := range host.Services {
+			port := "N/A"
+			if service.Port != 0 {
+				port = strconv.Itoa(service.Port)
+			}
+			fmt.Fprintf(w, "%-15s %-24s %-7s %-30s %s\n", host.IP, service.Type, port, service.Instance, orNA(service.Host))
+		}
+	}
+}
+
+// countServices returns how many services the hosts advertise.
+func countServices(hosts []scanner.Host) int {
+	n := 0
+	for _, host := range hosts {
+		n += len(host.Services)
+	}
+	return n
+}
+
+// printUPnP prints the description of each UPnP device found with SSDP,
+// or where it was said to be if the description could not be read.
+func printUPnP(w io.Writer, hosts []scanner.Host) {
+	fmt.Fprintf(w, "%-15s %-30s %-20s %-25s %s\n", "IP Address", "Friendly Name", "Manufacturer", "Model", "Serial")
+	fmt.Fprintln(w, strings.Repeat("-", 110))
+
+	for _, host := range hosts {
+		for _, device := range host.UPnP {
+			name := device.FriendlyName
+			if name == "" {
+				name = device.Location
+			}
+			fmt.Fprintf(w, "%-15s %-30s %-20s %-25s %s\n", host.IP, name, orNA(device.Manufacturer),
+				orNA(device.Model()), orNA(device.SerialNumber))
+		}
+	}
+}
+
+// countUPnPHosts returns how many hosts announced UPnP devices.
+func countUPnPHosts(hosts []scanner.Host) int {
+	n := 0
+	for _, host := range hosts {
+		if len(host.UPnP) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// printSNMP prints what the SNMP agents of the hosts said about them in
+// the system group.
+func printSNMP(w io.Writer, hosts []scanner.Host) {
+	fmt.Fprintf(w, "%-15s %-20s %-12s %-25s %-20s %s\n", "IP Address", "Name", "Uptime", "Object ID", "Location", "Description")
+	fmt.Fprintln(w, strings.Repeat("-", 130))
+
+	for _, host := range hosts {
+		system := host.SNMP
+		if system == nil {
+			continue
+		}
+		fmt.Fprintf(w, "%-15s %-20s %-12s %-25s %-20s %s\n", host.IP, orNA(system.Name), formatUptime(system.UpTime),
+			orNA(system.ObjectID), orNA(system.Location), orNA(system.Descr))
+	}
+}
+
+// countSNMP returns how many hosts answered an SNMP query.
+func countSNMP(hosts []scanner.Host) int {
+	n := 0
+	for _, host := range hosts {
+		if host.SNMP != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// formatUptime shows an uptime in days, hours and minutes.
+func formatUptime(uptime time.Duration) string {
+	if uptime == 0 {
+		return "N/A"
+	}
+	minutes := int(uptime / time.Minute)
+	if days := minutes / (24 * 60); days > 0 {
+		return fmt.Sprintf("%dd %dh %dm", days, minutes/60%24, minutes%60)
+	}
+	return fmt.Sprintf("%dh %dm", minutes/60, minutes%60)
+}
+
+// orNA returns s, or N/A if it is empty.
+func orNA(s string) string {
+	if s == "" {
+		return "N/A"
+	}
+	return s
+}
+
+// printQualityResults prints the loss and latency statistics gathered
+// with --count.
+func printQualityResults(w io.Writer, hosts []scanner.Host, verbose bool) {
+	fmt.Fprintf(w, "%-15s %-6s %-7s %-9s %-9s %-9s %-9s %-9s %-5s %-30s\n", "IP Address", "Status", "Loss", "Min", "Avg", "Max", "MDev", "Jitter", "TTL", "Hostname")
+	fmt.Fprintln(w, strings.Repeat("-", 115))
+
+	for _, host := range hosts {
+		status := host.Status()
+		if status == "down" && !verbose {
+			continue
+		}
+
+		stats := host.Stats
+		if stats == nil {
+			stats = &scanner.LatencyStats{}
+		}
+
+		rtts := []string{"N/A", "N/A", "N/A", "N/A", "N/A"}
+		if stats.Received > 0 {
+			rtts = []string{formatRTT(stats.Min), formatRTT(stats.Avg), formatRTT(stats.Max), formatRTT(stats.MDev), formatRTT(stats.Jitter)}
+		}
+		fmt.Fprintf(w, "%-15s %-6s %-7s %-9s %-9s %-9s %-9s %-9s %-5s %-30s\n", host.IP, strings.ToUpper(status), fmt.Sprintf("%.0f%%", stats.Loss),
+			rtts[0], rtts[1], rtts[2], rtts[3], rtts[4], formatTTL(host.TTL), hostnames(host))
+	}
+}
+
+func formatTTL(ttl int) string {
+	if ttl > 0 {
+		return strconv.Itoa(ttl)
+	}
+	return "N/A"
+}
+
+func formatRTT(rtt time.Duration) string {
+	if rtt < 10*time.Millisecond {
+		return fmt.Sprintf("%.2fms", float64(rtt.Microseconds())/1000)
+	}
+	return rtt.Truncate(time.Millisecond).String()
+}
+
+func runARPScan(ctx context.Context, config Config, set *targets.Set, rep *report) {
+	fmt.Println("=== ARP SCAN ===")
 
 	arpScanner := scanner.NewARPScanner(config.threads)
+	arpScanner.SetInterface(config.iface)
+	arpScanner.SetRateLimit(config.rateLimit())
+
+	// Names are resolved while the sweep runs rather than holding it up
+	enricher := scanner.NewEnricher(rep.hosts)
+	enricher.SetMethods(config.methods)
+	enricher.SetSNMP(config.snmpClient)
+	enricher.Start(ctx, func(scanner.Host) {})
+	defer enricher.Wait()
 
 	fmt.Println("Getting ARP table...")
-	arpEntries, err := arpScanner.GetARPTable()
+	arpEntries, err := arpScanner.GetARPTableContext(ctx)
+	if isInterrupted(err) {
+		fmt.Println("ARP scan interrupted.")
+		return
+	}
 	if err != nil {
 		fmt.Printf("Error getting ARP table: %v\n", err)
 	} else {
-		if len(arpEntries) > 0 {
-			fmt.Printf("%-15s %-18s %-10s %-30s\n", "IP Address", "MAC Address", "Status", "Hostname")
-			fmt.Println(strings.Repeat("-", 80))
-
-			for _, entry := range arpEntries {
-				hostname := entry.Hostname
-				if hostname == "" {
-					hostname = "N/A"
-				}
-				status := "CACHED"
-				fmt.Printf("%-15s %-18s %-10s %-30s\n", entry.IP, entry.MAC, status, hostname)
+		// Cached entries are reported but only the sweep below proves a
+		// host alive and worth enriching
+		cached := 0
+		for _, entry := range arpEntries {
+			if addr, err := netip.ParseAddr(entry.IP); err != nil || !set.Contains(addr) {
+				continue
 			}
-			fmt.Printf("\nFound %d entries in ARP table.\n", len(arpEntries))
+			rep.hosts.AddARP(entry, true)
+			cached++
+		}
+		if cached > 0 {
+			fmt.Printf("Found %d entries in ARP table.\n", cached)
 		} else {
 			fmt.Println("No entries found in ARP table.")
 		}
 	}
 
-	fmt.Println("\nScanning network for active devices...")
-	networkEntries, err := arpScanner.ScanNetwork(config.network)
-	if err != nil {
+	fmt.Println("Scanning network for active devices...")
+	found := 0
+	var progress scanner.Progress
+	err = arpScanner.StreamTargets(ctx, set, func(entry scanner.ARPEntry, p scanner.Progress) {
+		progress = p
+		if entry.MAC != "" {
+			found++
+			enricher.Add(rep.hosts.AddARP(entry, false))
+		}
+	})
+	interrupted := isInterrupted(err)
+	if err != nil && !interrupted {
 		fmt.Printf("Error running network ARP scan: %v\n", err)
 		return
 	}
 
-	if len(networkEntries) > 0 {
-		fmt.Printf("\n%-15s %-18s %-10s %-30s\n", "IP Address", "MAC Address", "Status", "Hostname")
-		fmt.Println(strings.Repeat("-", 80))
+	switch {
+	case found > 0 && interrupted:
+		fmt.Printf("Network scan interrupted. Found %d active devices so far.\n", found)
+	case found > 0:
+		fmt.Printf("Network scan completed. Found %d active devices.\n", found)
+	case interrupted:
+		fmt.Println("Network scan interrupted before any devices were found.")
+	default:
+		fmt.Println("No active devices found in network scan.")
+	}
+	printRate(progress)
+}
 
-		for _, entry := range networkEntries {
-			hostname := entry.Hostname
-			if hostname == "" {
-				hostname = "N/A"
-			}
-			status := "ACTIVE"
-			fmt.Printf("%-15s %-18s %-10s %-30s\n", entry.IP, entry.MAC, status, hostname)
+// vendorName describes who made a device, calling out MACs that were made
+// up locally so that they are not taken for an unknown vendor.
+func vendorName(host scanner.Host) string {
+	switch {
+	case host.LocallyAdministered:
+		return "Locally administered (random)"
+	case host.Vendor == "":
+		return "Unknown"
+	}
+	return host.Vendor
+}
+
+func runPortScan(ctx context.Context, config Config, set *targets.Set, rep *report) {
+	fmt.Println("=== PORT SCAN ===")
+
+	ports, err := scanner.ParsePorts(config.ports)
+	if err != nil {
+		fmt.Printf("Error parsing ports: %v\n", err)
+		return
+	}
+
+	portScanner := scanner.NewPortScanner(config.timeout, config.threads)
+	portScanner.SetRateLimit(config.rateLimit())
+	// Closed and filtered ports are only kept for verbose output, so that
+	// scanning a large range does not hold a result for every probe
+	openCount := 0
+	hosts := make(map[string]bool)
+	var progress scanner.Progress
+	err = portScanner.StreamTargets(ctx, set, ports, func(result scanner.PortResult, p scanner.Progress) {
+		progress = p
+		if result.State == scanner.PortOpen {
+			openCount++
+			hosts[result.IP] = true
+		}
+		if result.State == scanner.PortOpen || config.verbose {
+			rep.hosts.AddPort(result)
 		}
-		fmt.Printf("\nNetwork scan completed. Found %d active devices.\n", len(networkEntries))
+	})
+	if err != nil && !isInterrupted(err) {
+		fmt.Printf("Error running port scan: %v\n", err)
+		return
+	}
+
+	if isInterrupted(err) {
+		fmt.Printf("Port scan interrupted. %d open ports on %d hosts (%d ports probed).\n", openCount, len(hosts), progress.Completed)
 	} else {
-		fmt.Println("No active devices found in network scan.")
+		fmt.Printf("Port scan completed. %d open ports on %d hosts (%d ports probed).\n", openCount, len(hosts), progress.Completed)
 	}
-}
\ No newline at end of file
+	printRate(progress)
+}
+
+// printPortResults prints the ports probed on each host.
+func printPortResults(w io.Writer, hosts []scanner.Host) {
+	fmt.Fprintf(w, "%-15s %-7s %-10s %-20s %-10s\n", "IP Address", "Port", "State", "Service", "Latency")
+	fmt.Fprintln(w, strings.Repeat("-", 66))
+
+	for _, host := range hosts {
+		for _, result := range host.Ports {
+			service := result.Service
+			if service == "" {
+				service = "unknown"
+			}
+			fmt.Fprintf(w, "%-15s %-7d %-10s %-20s %-10s\n", result.IP, result.Port, result.State, service, formatRTT(result.Latency))
+		}
+	}
+}
+
+// countPorts returns how many port results the hosts hold.
+func countPorts(hosts []scanner.Host) int {
+	n := 0
+	for _, host := range hosts {
+		n += len(host.Ports)
+	}
+	return n
+}
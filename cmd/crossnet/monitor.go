@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/CyberOakAlpha/CrossNet/internal/scanner"
+)
+
+// runMonitor implements "crossnet monitor <host>", which probes one host
+// until interrupted or --count probes have been sent, printing a line per
+// probe and a summary at the end. It returns the exit status.
+func runMonitor(args []string) int {
+	flags := flag.NewFlagSet("monitor", flag.ExitOnError)
+	var (
+		port     int
+		count    int
+		window   int
+		interval time.Duration
+		timeout  time.Duration
+	)
+	flags.IntVar(&port, "port", 0, "Probe with TCP connects to this port instead of ICMP")
+	flags.IntVar(&port, "p", 0, "Probe with TCP connects to this port - short")
+	flags.IntVar(&count, "count", 0, "Stop after this many probes (0 runs until interrupted)")
+	flags.IntVar(&count, "c", 0, "Stop after this many probes - short")
+	flags.DurationVar(&interval, "interval", time.Second, "Time between probes")
+	flags.DurationVar(&timeout, "timeout", time.Second, "Time to wait for each reply")
+	flags.DurationVar(&timeout, "t", time.Second, "Time to wait for each reply - short")
+	flags.IntVar(&window, "window", scanner.DefaultMonitorWindow, "Probes covered by the rolling loss and jitter")
+	flags.Usage = showMonitorHelp
+
+	host, ok := parseHostArgs(flags, args)
+	if !ok {
+		showMonitorHelp()
+		return 2
+	}
+
+	monitor := scanner.NewMonitor(timeout, interval)
+	monitor.SetPort(port)
+	monitor.SetWindow(window)
+
+	probe := "ICMP"
+	if port != 0 {
+		probe = fmt.Sprintf("TCP port %d", port)
+	}
+	fmt.Printf("Monitoring %s with %s every %v (Ctrl-C to stop)\n", host, probe, interval)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	start := time.Now()
+	ip := host
+	summary, err := monitor.Run(ctx, host, count, func(sample scanner.MonitorSample) {
+		ip = sample.IP
+		printMonitorSample(sample)
+	})
+	if err != nil && !isInterrupted(err) {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+
+	printMonitorSummary(ip, summary, time.Since(start))
+	if err != nil {
+		return 130
+	}
+	return 0
+}
+
+// parseHostArgs parses the arguments of a subcommand that takes a single
+// host, allowing flags both before and after it.
+func parseHostArgs(flags *flag.FlagSet, args []string) (string, bool) {
+	flags.Parse(args)
+	host := flags.Arg(0)
+	if flags.NArg() > 0 {
+		flags.Parse(flags.Args()[1:])
+	}
+	return host, host != "" && flags.NArg() == 0
+}
+
+func printMonitorSample(sample scanner.MonitorSample) {
+	result := "timeout"
+	switch {
+	case sample.Alive && sample.TTL > 0:
+		result = fmt.Sprintf("rtt=%s ttl=%d", formatRTT(sample.RTT), sample.TTL)
+	case sample.Alive:
+		result = fmt.Sprintf("rtt=%s %s", formatRTT(sample.RTT), sample.Probe)
+	case sample.Error != "":
+		result = sample.Error
+	}
+
+	window := sample.Window
+	avg, jitter := "N/A", "N/A"
+	if window.Received > 0 {
+		avg, jitter = formatRTT(window.Avg), formatRTT(window.Jitter)
+	}
+	fmt.Printf("%s seq=%-5d %-15s %-24s loss %5.1f%%  avg %-9s jitter %s\n",
+		sample.Time.Format("15:04:05"), sample.Seq, sample.IP, result, window.Loss, avg, jitter)
+}
+
+func printMonitorSummary(ip string, stats *scanner.LatencyStats, elapsed time.Duration) {
+	fmt.Printf("\n--- %s monitor statistics ---\n", ip)
+	fmt.Printf("%d probes sent, %d received, %.1f%% loss, time %v\n",
+		stats.Sent, stats.Received, stats.Loss, elapsed.Round(time.Millisecond))
+	if stats.Received > 0 {
+		fmt.Printf("rtt min/avg/max/mdev = %s/%s/%s/%s, jitter %s\n",
+			formatRTT(stats.Min), formatRTT(stats.Avg), formatRTT(stats.Max), formatRTT(stats.MDev), formatRTT(stats.Jitter))
+	}
+}
+
+func showMonitorHelp() {
+	fmt.Println("USAGE:")
+	fmt.Println("  crossnet monitor [OPTIONS] <host>")
+	fmt.Println()
+	fmt.Println("Probes one host continuously and reports each round-trip time together")
+	fmt.Println("with the loss and jitter over the last --window probes. A summary is")
+	fmt.Println("printed on exit.")
+	fmt.Println()
+	fmt.Println("OPTIONS:")
+	fmt.Println("  -p, --port       Probe with TCP connects to this port instead of ICMP")
+	fmt.Println("  -c, --count      Stop after this many probes [default: until Ctrl-C]")
+	fmt.Println("      --interval   Time between probes [default: 1s]")
+	fmt.Println("  -t, --timeout    Time to wait for each reply [default: 1s]")
+	fmt.Println("      --window     Probes covered by the rolling loss and jitter [default: 20]")
+	fmt.Println()
+	fmt.Println("EXAMPLES:")
+	fmt.Println("  crossnet monitor 192.168.1.1")
+	fmt.Println("  crossnet monitor -p 443 --interval 500ms nas.lan")
+}
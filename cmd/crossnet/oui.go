@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/CyberOakAlpha/CrossNet/internal/oui"
+	"github.com/CyberOakAlpha/CrossNet/internal/scanner"
+)
+
+// runOUI implements "crossnet oui", which looks up MAC vendors and updates
+// the vendor registry from files downloaded from the IEEE. It returns the
+// exit status.
+func runOUI(args []string) int {
+	if len(args) < 2 {
+		showOUIHelp()
+		return 2
+	}
+
+	switch args[0] {
+	case "import":
+		path, n, err := oui.Import(args[1:]...)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Imported %d assignments into %s\n", n, path)
+	case "lookup":
+		for _, mac := range args[1:] {
+			if _, err := net.ParseMAC(mac); err != nil {
+				fmt.Printf("%-18s invalid MAC address\n", mac)
+				continue
+			}
+			host := scanner.Host{
+				MAC:                 mac,
+				Vendor:              oui.Vendor(mac),
+				LocallyAdministered: oui.IsLocallyAdministered(mac),
+			}
+			fmt.Printf("%-18s %s\n", mac, vendorName(host))
+		}
+	default:
+		showOUIHelp()
+		return 2
+	}
+	return 0
+}
+
+func showOUIHelp() {
+	fmt.Println("USAGE:")
+	fmt.Println("  crossnet oui import <file.csv>...")
+	fmt.Println("  crossnet oui lookup <mac>...")
+	fmt.Println()
+	fmt.Println("A registry of MAC vendor assignments is built into CrossNet. To bring it")
+	fmt.Println("up to date without network access from the scanner, download oui.csv,")
+	fmt.Println("mam.csv and oui36.csv from the IEEE Registration Authority and import")
+	fmt.Println("them. The imported registry is kept in the user configuration directory")
+	fmt.Println("and used on top of the built-in one.")
+	fmt.Println()
+	fmt.Println("EXAMPLES:")
+	fmt.Println("  crossnet oui import oui.csv mam.csv oui36.csv")
+	fmt.Println("  crossnet oui lookup B8:27:EB:12:34:56")
+}
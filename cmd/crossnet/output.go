@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CyberOakAlpha/CrossNet/internal/scanner"
+)
+
+// report collects the results of a run for --output. Like the tables on
+// the terminal, it holds down hosts and closed ports only in verbose mode.
+type report struct {
+	Targets  string    `json:"targets"`
+	Exclude  string    `json:"exclude,omitempty"`
+	ScanType string    `json:"scan_type"`
+	Started  time.Time `json:"started"`
+	// Hosts holds one entry per device with the evidence of every scan
+	// merged, filled from hosts once the scans are done
+	Hosts []scanner.Host `json:"hosts,omitempty"`
+	// Traces holds the paths followed with --trace or crossnet trace, so
+	// that routes to each subnet can be compared between runs
+	Traces []scanner.TraceResult `json:"traces,omitempty"`
+
+	hosts *scanner.HostTable
+}
+
+func newReport(config Config) *report {
+	return &report{
+		Targets:  config.network,
+		Exclude:  config.exclude,
+		ScanType: config.scanType,
+		Started:  time.Now(),
+		hosts:    scanner.NewHostTable(),
+	}
+}
+
+// write saves the report in the format picked by the file extension:
+// .json, .csv or plain text tables for anything else.
+func (r *report) write(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %v", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = r.writeJSON(file)
+	case ".csv":
+		err = r.writeCSV(file)
+	default:
+		err = r.writeText(file)
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (r *report) writeJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// Columns of the CSV output, in order.
+const (
+	csvType = iota
+	csvIP
+	csvMAC
+	csvHostname
+	csvStatus
+	csvRTT
+	csvTTL
+	csvProbe
+	csvSent
+	csvReceived
+	csvLoss
+	csvMin
+	csvAvg
+	csvMax
+	csvMDev
+	csvJitter
+	csvPort
+	csvService
+	csvTarget
+	csvVendor
+	csvSources
+	csvFirstSeen
+	csvLastSeen
+	csvHostnameMethods
+	csvNetBIOSDomain
+	csvNetBIOSUsers
+	csvNetBIOSMAC
+	csvMDNSServices
+	csvUPnPFriendlyName
+	csvUPnPManufacturer
+	csvUPnPModel
+	csvUPnPSerial
+	csvSNMPName
+	csvSNMPDescr
+	csvSNMPObjectID
+	csvSNMPUpTime
+	csvSNMPLocation
+	csvColumns
+)
+
+var csvHeader = [csvColumns]string{
+	csvType:             "type",
+	csvIP:               "ip",
+	csvMAC:              "mac",
+	csvHostname:         "hostname",
+	csvStatus:           "status",
+	csvRTT:              "rtt_ms",
+	csvTTL:              "ttl",
+	csvProbe:            "probe",
+	csvSent:             "sent",
+	csvReceived:         "received",
+	csvLoss:             "loss_pct",
+	csvMin:              "min_ms",
+	csvAvg:              "avg_ms",
+	csvMax:              "max_ms",
+	csvMDev:             "mdev_ms",
+	csvJitter:           "jitter_ms",
+	csvPort:             "port",
+	csvService:          "service",
+	csvTarget:           "target",
+	csvVendor:           "vendor",
+	csvSources:          "sources",
+	csvFirstSeen:        "first_seen",
+	csvLastSeen:         "last_seen",
+	csvHostnameMethods:  "hostname_methods",
+	csvNetBIOSDomain:    "netbios_domain",
+	csvNetBIOSUsers:     "netbios_users",
+	csvNetBIOSMAC:       "netbios_mac",
+	csvMDNSServices:     "mdns_services",
+	csvUPnPFriendlyName: "upnp_friendly_name",
+	csvUPnPManufacturer: "upnp_manufacturer",
+	csvUPnPModel:        "upnp_model",
+	csvUPnPSerial:       "upnp_serial",
+	csvSNMPName:         "snmp_name",
+	csvSNMPDescr:        "snmp_descr",
+	csvSNMPObjectID:     "snmp_object_id",
+	csvSNMPUpTime:       "snmp_uptime_s",
+	csvSNMPLocation:     "snmp_location",
+}
+
+// writeCSV writes one row per host, port or hop. Columns that do not
+// apply to a row are left empty and times are in milliseconds.
+func (r *report) writeCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write(csvHeader[:])
+
+	for _, host := range r.Hosts {
+		writer.Write(hostRow(host))
+	}
+	for _, host := range r.Hosts {
+		for _, result := range host.Ports {
+			writer.Write(portRow(host, result))
+		}
+	}
+	for _, trace := range r.Traces {
+		for _, hop := range trace.Hops {
+			writer.Write(hopRow(trace, hop))
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// hostRow describes a host. Its names are separated by semicolons, with
+// the resolution method of each at the same position in hostname_methods,
+// and sources lists the scans that saw it. The netbios columns hold what
+// the host said about itself in a NetBIOS node status reply and
+// mdns_services the DNS-SD services it advertises. The upnp columns
+// describe the UPnP devices it announced, one per position as with names,
+// and the snmp columns hold its SNMP system group, with the uptime in
+// seconds.
+func hostRow(host scanner.Host) []string {
+	names := make([]string, 0, len(host.Hostnames))
+	methods := make([]string, 0, len(host.Hostnames))
+	for _, name := range host.Hostnames {
+		names = append(names, name.Name)
+		methods = append(methods, name.Source)
+	}
+	vendor := ""
+	if host.MAC != "" {
+		vendor = vendorName(host)
+	}
+
+	row := make([]string, csvColumns)
+	row[csvType] = "host"
+	row[csvIP] = host.IP
+	row[csvMAC] = host.MAC
+	row[csvHostname] = strings.Join(names, ";")
+	row[csvStatus] = host.Status()
+	row[csvRTT] = csvMillis(host.RTT)
+	row[csvTTL] = csvInt(host.TTL)
+	row[csvProbe] = host.Probe
+	if host.Stats != nil {
+		setCSVStats(row, host.Stats)
+	}
+	row[csvVendor] = vendor
+	row[csvSources] = strings.Join(host.Sources, ";")
+	row[csvFirstSeen] = host.FirstSeen.Format(time.RFC3339)
+	row[csvLastSeen] = host.LastSeen.Format(time.RFC3339)
+	row[csvHostnameMethods] = strings.Join(methods, ";")
+	if status := host.NetBIOS; status != nil {
+		row[csvNetBIOSDomain] = status.Domain
+		row[csvNetBIOSUsers] = strings.Join(status.Users, ";")
+		row[csvNetBIOSMAC] = status.MAC
+	}
+	services := make([]string, 0, len(host.Services))
+	for _, service := range host.Services {
+		services = append(services, service.String())
+	}
+	row[csvMDNSServices] = strings.Join(services, ";")
+
+	var friendlyNames, manufacturers, models, serials []string
+	for _, device := range host.UPnP {
+		friendlyNames = append(friendlyNames, device.FriendlyName)
+		manufacturers = append(manufacturers, device.Manufacturer)
+		models = append(models, device.Model())
+		serials = append(serials, device.SerialNumber)
+	}
+	row[csvUPnPFriendlyName] = strings.Join(friendlyNames, ";")
+	row[csvUPnPManufacturer] = strings.Join(manufacturers, ";")
+	row[csvUPnPModel] = strings.Join(models, ";")
+	row[csvUPnPSerial] = strings.Join(serials, ";")
+
+	if system := host.SNMP; system != nil {
+		row[csvSNMPName] = system.Name
+		row[csvSNMPDescr] = system.Descr
+		row[csvSNMPObjectID] = system.ObjectID
+		if system.UpTime > 0 {
+			row[csvSNMPUpTime] = strconv.FormatInt(int64(system.UpTime/time.Second), 10)
+		}
+		row[csvSNMPLocation] = system.Location
+	}
+	return row
+}
+
+// portRow describes a TCP port of host.
+func portRow(host scanner.Host, result scanner.PortResult) []string {
+	row := make([]string, csvColumns)
+	row[csvType] = "port"
+	row[csvIP] = result.IP
+	row[csvMAC] = host.MAC
+	row[csvHostname] = host.Hostname()
+	row[csvStatus] = string(result.State)
+	row[csvRTT] = csvMillis(result.Latency)
+	row[csvProbe] = "tcp"
+	row[csvPort] = strconv.Itoa(result.Port)
+	row[csvService] = result.Service
+	return row
+}
+
+// hopRow describes one hop of a path. The ttl column holds the hop number
+// and status says whether the destination answered.
+func hopRow(trace scanner.TraceResult, hop scanner.TraceHop) []string {
+	status := "timeout"
+	switch {
+	case hop.Reached:
+		status = "reached"
+	case hop.Note != "":
+		status = hop.Note
+	case hop.IP != "":
+		status = "transit"
+	case hop.Error != "":
+		status = "error"
+	}
+	row := make([]string, csvColumns)
+	row[csvType] = "hop"
+	row[csvIP] = hop.IP
+	row[csvHostname] = hop.Hostname
+	row[csvStatus] = status
+	row[csvRTT] = csvMillis(hop.Stats.Avg)
+	row[csvTTL] = strconv.Itoa(hop.TTL)
+	row[csvProbe] = string(trace.Protocol)
+	setCSVStats(row, hop.Stats)
+	row[csvPort] = csvInt(trace.Port)
+	row[csvTarget] = trace.IP
+	return row
+}
+
+// setCSVStats fills the latency columns of row.
+func setCSVStats(row []string, stats *scanner.LatencyStats) {
+	row[csvSent] = strconv.Itoa(stats.Sent)
+	row[csvReceived] = strconv.Itoa(stats.Received)
+	row[csvLoss] = strconv.FormatFloat(stats.Loss, 'f', 1, 64)
+	row[csvMin] = csvMillis(stats.Min)
+	row[csvAvg] = csvMillis(stats.Avg)
+	row[csvMax] = csvMillis(stats.Max)
+	row[csvMDev] = csvMillis(stats.MDev)
+	row[csvJitter] = csvMillis(stats.Jitter)
+}
+
+func csvMillis(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
+
+func csvInt(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+// writeText writes the same tables as the terminal output.
+func (r *report) writeText(w io.Writer) error {
+	fmt.Fprintf(w, "CrossNet v%s scan of %s (%s) started %s\n", version, r.Targets, r.ScanType, r.Started.Format(time.RFC3339))
+	if r.Exclude != "" {
+		fmt.Fprintf(w, "Excluding: %s\n", r.Exclude)
+	}
+
+	if len(r.Hosts) > 0 && !strings.EqualFold(r.ScanType, "ports") {
+		fmt.Fprintln(w, "\n=== HOSTS ===")
+		printHosts(w, r.Hosts, true)
+	}
+	if countNetBIOS(r.Hosts) > 0 {
+		fmt.Fprintln(w, "\n=== NETBIOS ===")
+		printNetBIOS(w, r.Hosts)
+	}
+	if countServices(r.Hosts) > 0 {
+		fmt.Fprintln(w, "\n=== SERVICES ===")
+		printServices(w, r.Hosts)
+	}
+	if countUPnPHosts(r.Hosts) > 0 {
+		fmt.Fprintln(w, "\n=== UPNP DEVICES ===")
+		printUPnP(w, r.Hosts)
+	}
+	if countSNMP(r.Hosts) > 0 {
+		fmt.Fprintln(w, "\n=== SNMP ===")
+		printSNMP(w, r.Hosts)
+	}
+	if countPorts(r.Hosts) > 0 {
+		fmt.Fprintln(w, "\n=== PORTS ===")
+		printPortResults(w, r.Hosts)
+	}
+	if len(r.Traces) > 0 {
+		fmt.Fprintln(w, "\n=== PATHS ===")
+		for _, trace := range r.Traces {
+			printTrace(w, trace)
+		}
+	}
+	return nil
+}
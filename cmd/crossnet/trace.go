@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/CyberOakAlpha/CrossNet/internal/hostname"
+	"github.com/CyberOakAlpha/CrossNet/internal/scanner"
+)
+
+// runTrace implements "crossnet trace <host>", which prints the path to
+// host hop by hop. It returns the exit status.
+func runTrace(args []string) int {
+	flags := flag.NewFlagSet("trace", flag.ExitOnError)
+	var (
+		protocol   string
+		port       int
+		maxHops    int
+		queries    int
+		timeout    time.Duration
+		outputFile string
+		resolve    string
+	)
+	flags.StringVar(&protocol, "proto", "icmp", "Probe protocol: icmp, udp or tcp")
+	flags.IntVar(&port, "port", 0, "Destination port for UDP and TCP probes")
+	flags.IntVar(&port, "p", 0, "Destination port for UDP and TCP probes - short")
+	flags.IntVar(&maxHops, "max-hops", 30, "Maximum number of hops")
+	flags.IntVar(&maxHops, "m", 30, "Maximum number of hops - short")
+	flags.IntVar(&queries, "queries", 3, "Probes per hop")
+	flags.IntVar(&queries, "q", 3, "Probes per hop - short")
+	flags.DurationVar(&timeout, "timeout", time.Second, "Time to wait for each reply")
+	flags.DurationVar(&timeout, "t", time.Second, "Time to wait for each reply - short")
+	flags.StringVar(&outputFile, "output", "", "Save the path to a .json, .csv or text file")
+	flags.StringVar(&outputFile, "o", "", "Save the path to a file - short")
+	flags.StringVar(&resolve, "resolve", hostname.MethodDNS, "Hostname resolution methods for the hops, as with --resolve of a scan, or none")
+	flags.Usage = showTraceHelp
+
+	host, ok := parseHostArgs(flags, args)
+	if !ok {
+		showTraceHelp()
+		return 2
+	}
+	traceProtocol, err := scanner.ParseTraceProtocol(protocol)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 2
+	}
+	methods, err := hostname.ParseMethods(resolve)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 2
+	}
+
+	tracer := scanner.NewTracer(timeout, maxHops)
+	tracer.SetProtocol(traceProtocol, port)
+	tracer.SetProbes(queries)
+	tracer.SetResolveMethods(methods)
+
+	fmt.Printf("Tracing route to %s over %s, %d hops max\n", host, strings.ToUpper(protocol), maxHops)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	trace, err := tracer.Trace(ctx, host, func(hop scanner.TraceHop) {
+		printTraceHop(os.Stdout, hop, queries)
+	})
+	if err != nil && !isInterrupted(err) {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+
+	if trace.Reached {
+		fmt.Printf("\nReached %s in %d hops.\n", trace.IP, len(trace.Hops))
+	} else if err == nil {
+		fmt.Printf("\n%s was not reached.\n", trace.IP)
+	}
+
+	if outputFile != "" {
+		rep := &report{
+			Targets:  host,
+			ScanType: "trace",
+			Started:  trace.Started,
+			Traces:   []scanner.TraceResult{*trace},
+		}
+		if err := rep.write(outputFile); err != nil {
+			fmt.Printf("Error writing output file: %v\n", err)
+		} else {
+			fmt.Printf("Path written to %s\n", outputFile)
+		}
+	}
+
+	if err != nil {
+		return 130
+	}
+	return 0
+}
+
+// printTraceHop prints a hop in the style of traceroute, with a * for
+// each of the queries that went unanswered.
+func printTraceHop(w io.Writer, hop scanner.TraceHop, queries int) {
+	rtts := make([]string, 0, queries)
+	for _, rtt := range hop.RTTs {
+		rtts = append(rtts, formatRTT(rtt))
+	}
+	for len(rtts) < max(queries, hop.Stats.Sent) {
+		rtts = append(rtts, "*")
+	}
+
+	if hop.Error != "" {
+		rtts = append(rtts, "("+hop.Error+")")
+	}
+	if hop.IP == "" {
+		fmt.Fprintf(w, "%2d  %s\n", hop.TTL, strings.Join(rtts, "  "))
+		return
+	}
+
+	hostname := hop.Hostname
+	if hostname == "" {
+		hostname = "N/A"
+	}
+	if hop.Note != "" {
+		rtts = append(rtts, hop.Note)
+	}
+	fmt.Fprintf(w, "%2d  %-15s %-30s %s\n", hop.TTL, hop.IP, hostname, strings.Join(rtts, "  "))
+}
+
+// printTrace prints a stored path with its hops.
+func printTrace(w io.Writer, trace scanner.TraceResult) {
+	fmt.Fprintf(w, "Path to %s (%s) over %s, started %s:\n", trace.Target, trace.IP, strings.ToUpper(string(trace.Protocol)), trace.Started.Format(time.RFC3339))
+	for _, hop := range trace.Hops {
+		printTraceHop(w, hop, 0)
+	}
+}
+
+// traceSubnets follows the path to one live host in each /24, or /64 for
+// IPv6, found by the scan, so that the routes to every subnet are stored
+// with the results.
+func traceSubnets(ctx context.Context, config Config, rep *report) {
+	fmt.Println("\n=== PATHS ===")
+
+	tracer := scanner.NewTracer(time.Second, 30)
+	tracer.SetRateLimit(config.rateLimit())
+
+	for _, ip := range subnetRepresentatives(rep) {
+		if ctx.Err() != nil {
+			return
+		}
+		trace, err := tracer.Trace(ctx, ip, func(scanner.TraceHop) {})
+		if err != nil && !isInterrupted(err) {
+			fmt.Printf("Error tracing %s: %v\n", ip, err)
+			return
+		}
+		rep.Traces = append(rep.Traces, *trace)
+
+		hops := make([]string, 0, len(trace.Hops))
+		for _, hop := range trace.Hops {
+			if hop.IP == "" {
+				hops = append(hops, "*")
+				continue
+			}
+			hops = append(hops, fmt.Sprintf("%s (%s)", hop.IP, formatRTT(hop.Stats.Avg)))
+		}
+		fmt.Printf("%-15s %s\n", ip, strings.Join(hops, " -> "))
+	}
+}
+
+// subnetRepresentatives returns the first live host the scan found in
+// each subnet. Link-local addresses are skipped since they are never
+// more than one hop away.
+func subnetRepresentatives(rep *report) []string {
+	seen := make(map[netip.Prefix]bool)
+	var representatives []string
+	for _, host := range rep.Hosts {
+		if !host.Alive {
+			continue
+		}
+		addr, err := netip.ParseAddr(host.IP)
+		if err != nil || addr.IsLinkLocalUnicast() {
+			continue
+		}
+		bits := 24
+		if addr.Is6() {
+			bits = 64
+		}
+		subnet, _ := addr.Prefix(bits)
+		if !seen[subnet] {
+			seen[subnet] = true
+			representatives = append(representatives, host.IP)
+		}
+	}
+	return representatives
+}
+
+func showTraceHelp() {
+	fmt.Println("USAGE:")
+	fmt.Println("  crossnet trace [OPTIONS] <host>")
+	fmt.Println()
+	fmt.Println("Prints the routers on the path to host with the round-trip time of each")
+	fmt.Println("probe. Needs root or administrator rights for the raw ICMP socket.")
+	fmt.Println()
+	fmt.Println("OPTIONS:")
+	fmt.Println("      --proto      Probe protocol: icmp, udp or tcp [default: icmp]")
+	fmt.Println("  -p, --port       Destination port [default: 33434 and up for udp, 80 for tcp]")
+	fmt.Println("  -m, --max-hops   Maximum number of hops [default: 30]")
+	fmt.Println("  -q, --queries    Probes per hop [default: 3]")
+	fmt.Println("  -t, --timeout    Time to wait for each reply [default: 1s]")
+	fmt.Println("  -o, --output     Save the path to a .json, .csv or text file")
+	fmt.Println("      --resolve    Methods the hops are named with, or none [default: dns]")
+	fmt.Println()
+	fmt.Println("EXAMPLES:")
+	fmt.Println("  crossnet trace 10.20.0.1")
+	fmt.Println("  crossnet trace --proto tcp -p 443 -o path.json nas.example.com")
+}
@@ -0,0 +1,471 @@
+package hostname
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// DNS message constants used by the PTR client.
+const (
+	dnsTypePTR   = 12
+	dnsClassIN   = 1
+	dnsHeaderLen = 12
+	// dnsUDPSize is the largest reply read over UDP. Servers truncate
+	// anything longer than 512 bytes unless EDNS is used, which this client
+	// does not.
+	dnsUDPSize = 512
+	// linkLocalResend is how long NetBIOS, mDNS and LLMNR queries wait
+	// before they are sent again.
+	linkLocalResend = time.Second
+)
+
+// DNS response codes.
+const (
+	RCodeSuccess        = 0
+	RCodeFormatError    = 1
+	RCodeServerFailure  = 2
+	RCodeNameError      = 3
+	RCodeNotImplemented = 4
+	RCodeRefused        = 5
+)
+
+// RCodeName returns the mnemonic of a DNS response code, such as NXDOMAIN.
+func RCodeName(rcode int) string {
+	switch rcode {
+	case RCodeSuccess:
+		return "NOERROR"
+	case RCodeFormatError:
+		return "FORMERR"
+	case RCodeServerFailure:
+		return "SERVFAIL"
+	case RCodeNameError:
+		return "NXDOMAIN"
+	case RCodeNotImplemented:
+		return "NOTIMP"
+	case RCodeRefused:
+		return "REFUSED"
+	}
+	return fmt.Sprintf("RCODE%d", rcode)
+}
+
+// PTRResult is the answer to a PTR query.
+type PTRResult struct {
+	// Names are the names in the PTR records of the answer, without the
+	// trailing dot.
+	Names []string
+	// TTL is the lowest time to live of those records.
+	TTL time.Duration
+	// RCode is the response code. NXDOMAIN means the server knows no name
+	// for the address.
+	RCode int
+	// Server is the server that answered and TCP is set if the answer was
+	// fetched over TCP because the UDP one was truncated.
+	Server string
+	TCP    bool
+}
+
+// DNSClient sends PTR queries straight to chosen DNS servers instead of
+// going through the system resolver. Each server is tried in turn until
+// one gives a usable answer.
+type DNSClient struct {
+	servers []string
+	timeout time.Duration
+	retries int
+}
+
+// NewDNSClient returns a client for servers given as addresses with an
+// optional port, such as "10.0.0.53" or "[fd00::53]:5353". Each query
+// waits one second for an answer and is retried once.
+func NewDNSClient(servers ...string) (*DNSClient, error) {
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no DNS servers given")
+	}
+	client := &DNSClient{
+		timeout: time.Second,
+		retries: 1,
+	}
+	for _, server := range servers {
+		server = strings.TrimSpace(server)
+		if _, err := netip.ParseAddr(server); err == nil {
+			server = net.JoinHostPort(server, "53")
+		} else if _, err := netip.ParseAddrPort(server); err != nil {
+			return nil, fmt.Errorf("invalid DNS server %q: want an IP address with an optional port", server)
+		}
+		client.servers = append(client.servers, server)
+	}
+	return client, nil
+}
+
+// ParseDNSServers reads a comma-separated list of servers for
+// NewDNSClient.
+func ParseDNSServers(spec string) (*DNSClient, error) {
+	var servers []string
+	for _, server := range strings.Split(spec, ",") {
+		if server = strings.TrimSpace(server); server != "" {
+			servers = append(servers, server)
+		}
+	}
+	return NewDNSClient(servers...)
+}
+
+// SetTimeout sets how long each query waits for an answer.
+func (c *DNSClient) SetTimeout(timeout time.Duration) {
+	if timeout > 0 {
+		c.timeout = timeout
+	}
+}
+
+// SetRetries sets how often a query that got no answer is sent again
+// before moving on to the next server.
+func (c *DNSClient) SetRetries(retries int) {
+	c.retries = max(retries, 0)
+}
+
+// Servers returns the addresses the client queries.
+func (c *DNSClient) Servers() []string {
+	return c.servers
+}
+
+// LookupPTR asks the servers for the names of ip. An answer of NOERROR or
+// NXDOMAIN is final; other response codes and silence move on to the next
+// server. If no server gives a final answer, the last result is returned
+// with an error saying why.
+//
+// When ctx has a deadline, a query waits no longer than its share of the
+// time left, so that a silent server cannot keep the others from being
+// asked.
+func (c *DNSClient) LookupPTR(ctx context.Context, ip string) (PTRResult, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return PTRResult{}, fmt.Errorf("invalid address %q", ip)
+	}
+	name := reverseName(addr)
+
+	var result PTRResult
+	var lastErr error
+	attempts := len(c.servers) * (c.retries + 1)
+	for i, server := range c.servers {
+		for attempt := 0; attempt <= c.retries; attempt++ {
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+			timeout := c.timeout
+			if deadline, ok := ctx.Deadline(); ok {
+				left := attempts - i*(c.retries+1) - attempt
+				timeout = min(timeout, time.Until(deadline)/time.Duration(left))
+			}
+			result, lastErr = c.query(ctx, server, name, timeout)
+			if lastErr == nil || !isTimeout(lastErr) {
+				break
+			}
+		}
+		if lastErr != nil {
+			continue
+		}
+		if result.RCode == RCodeSuccess || result.RCode == RCodeNameError {
+			return result, nil
+		}
+		lastErr = fmt.Errorf("%s answered %s", server, RCodeName(result.RCode))
+	}
+	return result, fmt.Errorf("failed to look up %s: %v", name, lastErr)
+}
+
+// query sends one PTR query to server over UDP, and again over TCP if the
+// answer was truncated, waiting at most timeout for both.
+func (c *DNSClient) query(ctx context.Context, server, name string, timeout time.Duration) (PTRResult, error) {
+	id := uint16(rand.Uint32())
+	message, err := buildQuery(id, 0x0100, strings.Split(strings.TrimSuffix(name, "."), "."), dnsTypePTR)
+	if err != nil {
+		return PTRResult{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	reply, err := exchangeUDP(ctx, server, id, message, dnsUDPSize)
+	if err != nil {
+		return PTRResult{Server: server}, err
+	}
+	result, truncated, err := parseReply(reply, id, name)
+	result.Server = server
+	if err != nil || !truncated {
+		return result, err
+	}
+
+	reply, err = exchangeTCP(ctx, server, message)
+	if err != nil {
+		return result, err
+	}
+	result, _, err = parseReply(reply, id, name)
+	result.Server = server
+	result.TCP = true
+	return result, err
+}
+
+// exchangeUDP sends message to server and waits for the reply with the
+// same ID, reading at most size bytes of it.
+func exchangeUDP(ctx context.Context, server string, id uint16, message []byte, size int) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if _, err := conn.Write(message); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+		// Ignore stray datagrams, such as late answers to an earlier try
+		if n >= dnsHeaderLen && binary.BigEndian.Uint16(buf) == id {
+			return buf[:n], nil
+		}
+	}
+}
+
+// resendUDP is exchangeUDP for the link-local protocols, which have no
+// retry logic of their own. The query is repeated every second until the
+// host answers or ctx is done, so the caller's deadline bounds the lookup.
+// A host that refuses the query, with an ICMP port unreachable, ends it
+// at once.
+func resendUDP(ctx context.Context, server string, id uint16, message []byte, size int) ([]byte, error) {
+	for ctx.Err() == nil {
+		attemptCtx, cancel := context.WithTimeout(ctx, linkLocalResend)
+		reply, err := exchangeUDP(attemptCtx, server, id, message, size)
+		cancel()
+		if err == nil || !isTimeout(err) {
+			return reply, err
+		}
+	}
+	return nil, ctx.Err()
+}
+
+func exchangeTCP(ctx context.Context, server string, message []byte) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	// Over TCP every message is preceded by its length
+	framed := binary.BigEndian.AppendUint16(nil, uint16(len(message)))
+	if _, err := conn.Write(append(framed, message...)); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	reply := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// parseServer reads an IP address with an optional port, which defaults to
+// port, for the mDNS and LLMNR clients.
+func parseServer(addr string, port uint16) (netip.AddrPort, error) {
+	if ip, err := netip.ParseAddr(addr); err == nil {
+		return netip.AddrPortFrom(ip, port), nil
+	}
+	server, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid address %q", addr)
+	}
+	return server, nil
+}
+
+// isTimeout reports whether err means that no answer came in time.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// reverseName returns the in-addr.arpa or ip6.arpa name of addr.
+func reverseName(addr netip.Addr) string {
+	var b strings.Builder
+	if addr.Is4() || addr.Is4In6() {
+		octets := addr.Unmap().As4()
+		for i := len(octets) - 1; i >= 0; i-- {
+			fmt.Fprintf(&b, "%d.", octets[i])
+		}
+		b.WriteString("in-addr.arpa.")
+		return b.String()
+	}
+	const hexDigits = "0123456789abcdef"
+	bytes := addr.As16()
+	for i := len(bytes) - 1; i >= 0; i-- {
+		b.WriteByte(hexDigits[bytes[i]&0x0f])
+		b.WriteByte('.')
+		b.WriteByte(hexDigits[bytes[i]>>4])
+		b.WriteByte('.')
+	}
+	b.WriteString("ip6.arpa.")
+	return b.String()
+}
+
+// buildQuery encodes a query with the given header flags, such as 0x0100
+// for recursion desired, for records of rrType under the name made of
+// labels. Labels are taken as they are, so they may contain dots.
+func buildQuery(id, flags uint16, labels []string, rrType uint16) ([]byte, error) {
+	message := make([]byte, dnsHeaderLen, 512)
+	binary.BigEndian.PutUint16(message[0:], id)
+	binary.BigEndian.PutUint16(message[2:], flags)
+	// One question
+	binary.BigEndian.PutUint16(message[4:], 1)
+
+	for _, label := range labels {
+		if label == "" || len(label) > 63 {
+			return nil, fmt.Errorf("invalid name %q", strings.Join(labels, "."))
+		}
+		message = append(message, byte(len(label)))
+		message = append(message, label...)
+	}
+	message = append(message, 0)
+	message = binary.BigEndian.AppendUint16(message, rrType)
+	message = binary.BigEndian.AppendUint16(message, dnsClassIN)
+	return message, nil
+}
+
+// parseReply decodes the PTR records in the answer section of reply to the
+// query id for name and reports whether the reply was truncated. A reply
+// must repeat the question it answers.
+func parseReply(reply []byte, id uint16, name string) (PTRResult, bool, error) {
+	var result PTRResult
+	if len(reply) < dnsHeaderLen {
+		return result, false, fmt.Errorf("short DNS reply")
+	}
+	if binary.BigEndian.Uint16(reply) != id {
+		return result, false, fmt.Errorf("DNS reply for another query")
+	}
+	flags := binary.BigEndian.Uint16(reply[2:])
+	if flags&0x8000 == 0 {
+		return result, false, fmt.Errorf("DNS message is not a reply")
+	}
+	truncated := flags&0x0200 != 0
+	result.RCode = int(flags & 0x000f)
+
+	if binary.BigEndian.Uint16(reply[4:]) != 1 {
+		return result, truncated, fmt.Errorf("DNS reply for another question")
+	}
+	answers := int(binary.BigEndian.Uint16(reply[6:]))
+	question, next, err := readName(reply, dnsHeaderLen)
+	if err != nil || next+4 > len(reply) {
+		return result, truncated, fmt.Errorf("malformed DNS question")
+	}
+	if !strings.EqualFold(question, strings.TrimSuffix(name, ".")) ||
+		binary.BigEndian.Uint16(reply[next:]) != dnsTypePTR || binary.BigEndian.Uint16(reply[next+2:]) != dnsClassIN {
+		return result, truncated, fmt.Errorf("DNS reply for another question")
+	}
+	offset := next + 4
+
+	for i := 0; i < answers; i++ {
+		_, next, err := readName(reply, offset)
+		if err != nil || next+10 > len(reply) {
+			// A truncated reply may end mid-record
+			if truncated {
+				break
+			}
+			return result, truncated, fmt.Errorf("malformed DNS answer")
+		}
+		rrType := binary.BigEndian.Uint16(reply[next:])
+		rrClass := binary.BigEndian.Uint16(reply[next+2:])
+		ttl := time.Duration(binary.BigEndian.Uint32(reply[next+4:])) * time.Second
+		length := int(binary.BigEndian.Uint16(reply[next+8:]))
+		data := next + 10
+		if data+length > len(reply) {
+			if truncated {
+				break
+			}
+			return result, truncated, fmt.Errorf("malformed DNS answer")
+		}
+		offset = data + length
+
+		// Classless delegations (RFC 2317) answer with a CNAME first;
+		// only the PTR records carry names
+		if rrType != dnsTypePTR || rrClass != dnsClassIN {
+			continue
+		}
+		target, _, err := readName(reply, data)
+		if err != nil {
+			return result, truncated, fmt.Errorf("malformed PTR record")
+		}
+		if len(result.Names) == 0 || ttl < result.TTL {
+			result.TTL = ttl
+		}
+		result.Names = append(result.Names, target)
+	}
+	return result, truncated, nil
+}
+
+// readName decodes the possibly compressed name at offset and returns it
+// without the trailing dot, together with the offset just past it.
+func readName(message []byte, offset int) (string, int, error) {
+	labels, next, err := readLabels(message, offset)
+	return strings.Join(labels, "."), next, err
+}
+
+// readLabels is like readName but keeps the labels apart, for names such
+// as DNS-SD instance names whose first label may contain dots.
+func readLabels(message []byte, offset int) ([]string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; ; {
+		if offset >= len(message) {
+			return nil, 0, fmt.Errorf("name runs past the message")
+		}
+		length := int(message[offset])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return labels, next, nil
+		case length&0xc0 == 0xc0:
+			if offset+1 >= len(message) || jumps > 10 {
+				return nil, 0, fmt.Errorf("bad name compression")
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(message[offset:]) & 0x3fff)
+			jumps++
+		case length > 63 || offset+1+length > len(message):
+			return nil, 0, fmt.Errorf("bad label")
+		default:
+			labels = append(labels, string(message[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+}
+
+// NewDNSMethod returns a "dns" Method that sends PTR queries with client
+// instead of using the system resolver.
+func NewDNSMethod(client *DNSClient) Method {
+	return NewMethod(MethodDNS, func(ctx context.Context, ip string) []string {
+		result, _ := client.LookupPTR(ctx, ip)
+		return result.Names
+	})
+}
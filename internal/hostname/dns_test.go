@@ -0,0 +1,400 @@
+package hostname
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testResponder answers DNS-style queries on the same port of a loopback
+// address over UDP and TCP. Its handler gets each query and whether it came over TCP,
+// and returns the replies to send, or none to stay silent.
+type testResponder struct {
+	addr string
+
+	mutex   sync.Mutex
+	queries []testQuery
+}
+
+type testQuery struct {
+	message []byte
+	tcp     bool
+}
+
+func newTestResponder(t *testing.T, host string, handler func(query []byte, tcp bool) [][]byte) *testResponder {
+	t.Helper()
+	var udp *net.UDPConn
+	var tcp net.Listener
+	for tries := 0; ; tries++ {
+		var err error
+		udp, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(host)})
+		if err != nil {
+			t.Skipf("cannot listen on %s: %v", host, err)
+		}
+		port := udp.LocalAddr().(*net.UDPAddr).Port
+		tcp, err = net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err == nil {
+			break
+		}
+		udp.Close()
+		if tries == 10 {
+			t.Fatalf("no free port for UDP and TCP: %v", err)
+		}
+	}
+	r := &testResponder{addr: udp.LocalAddr().String()}
+	t.Cleanup(func() {
+		udp.Close()
+		tcp.Close()
+	})
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := udp.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			query := append([]byte(nil), buf[:n]...)
+			for _, reply := range r.handle(handler, query, false) {
+				udp.WriteToUDP(reply, from)
+			}
+		}
+	}()
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var length [2]byte
+				if _, err := io.ReadFull(conn, length[:]); err != nil {
+					return
+				}
+				query := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(conn, query); err != nil {
+					return
+				}
+				for _, reply := range r.handle(handler, query, true) {
+					conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(reply))), reply...))
+				}
+			}()
+		}
+	}()
+	return r
+}
+
+func (r *testResponder) handle(handler func([]byte, bool) [][]byte, query []byte, tcp bool) [][]byte {
+	r.mutex.Lock()
+	r.queries = append(r.queries, testQuery{query, tcp})
+	r.mutex.Unlock()
+	return handler(query, tcp)
+}
+
+// received returns the queries received so far.
+func (r *testResponder) received() []testQuery {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return slices.Clone(r.queries)
+}
+
+// count returns how many queries came over UDP and over TCP.
+func (r *testResponder) count() (udp, tcp int) {
+	for _, query := range r.received() {
+		if query.tcp {
+			tcp++
+		} else {
+			udp++
+		}
+	}
+	return udp, tcp
+}
+
+// testReply builds a reply to query with the given header flags, such as
+// 0x8180 for a recursive NOERROR answer, which repeats its question and
+// carries the given answer records.
+func testReply(query []byte, flags uint16, answers ...[]byte) []byte {
+	_, next, _ := readName(query, dnsHeaderLen)
+	reply := append([]byte(nil), query[:next+4]...)
+	binary.BigEndian.PutUint16(reply[2:], flags)
+	binary.BigEndian.PutUint16(reply[6:], uint16(len(answers)))
+	for _, answer := range answers {
+		reply = append(reply, answer...)
+	}
+	return reply
+}
+
+// testRecord builds an answer record for the question name, which it
+// points to, with the given data.
+func testRecord(rrType uint16, ttl uint32, data []byte) []byte {
+	record := []byte{0xc0, dnsHeaderLen}
+	record = binary.BigEndian.AppendUint16(record, rrType)
+	record = binary.BigEndian.AppendUint16(record, dnsClassIN)
+	record = binary.BigEndian.AppendUint32(record, ttl)
+	record = binary.BigEndian.AppendUint16(record, uint16(len(data)))
+	return append(record, data...)
+}
+
+// testName encodes a dotted name without compression.
+func testName(name string) []byte {
+	message, _ := buildQuery(0, 0, strings.Split(name, "."), 0)
+	return message[dnsHeaderLen : len(message)-4]
+}
+
+// testPTR builds a PTR record for the question name.
+func testPTR(ttl uint32, target string) []byte {
+	return testRecord(dnsTypePTR, ttl, testName(target))
+}
+
+func newTestDNSClient(t *testing.T, servers ...string) *DNSClient {
+	t.Helper()
+	client, err := NewDNSClient(servers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetTimeout(200 * time.Millisecond)
+	client.SetRetries(0)
+	return client
+}
+
+func TestLookupPTR(t *testing.T) {
+	server := newTestResponder(t, "127.0.0.1", func(query []byte, tcp bool) [][]byte {
+		return [][]byte{testReply(query, 0x8180, testPTR(300, "printer.example.com"), testRecord(5, 10, testName("x.example.com")), testPTR(60, "Printer.Example.com"))}
+	})
+
+	result, err := newTestDNSClient(t, server.addr).LookupPTR(context.Background(), "192.0.2.10")
+	if err != nil {
+		t.Fatalf("LookupPTR: %v", err)
+	}
+	if question, _, _ := readName(server.received()[0].message, dnsHeaderLen); question != "10.2.0.192.in-addr.arpa" {
+		t.Errorf("question = %q, want 10.2.0.192.in-addr.arpa", question)
+	}
+	if want := []string{"printer.example.com", "Printer.Example.com"}; !slices.Equal(result.Names, want) {
+		t.Errorf("Names = %q, want %q", result.Names, want)
+	}
+	if result.TTL != time.Minute {
+		t.Errorf("TTL = %v, want the lowest of the PTR records, 1m", result.TTL)
+	}
+	if result.RCode != RCodeSuccess || result.Server != server.addr || result.TCP {
+		t.Errorf("result = %+v, want NOERROR from %s over UDP", result, server.addr)
+	}
+}
+
+func TestLookupPTRNameError(t *testing.T) {
+	first := newTestResponder(t, "127.0.0.1", func(query []byte, tcp bool) [][]byte {
+		return [][]byte{testReply(query, 0x8180|RCodeNameError)}
+	})
+	second := newTestResponder(t, "127.0.0.1", func(query []byte, tcp bool) [][]byte {
+		return [][]byte{testReply(query, 0x8180, testPTR(60, "other.example.com"))}
+	})
+
+	result, err := newTestDNSClient(t, first.addr, second.addr).LookupPTR(context.Background(), "192.0.2.10")
+	if err != nil {
+		t.Fatalf("LookupPTR: %v", err)
+	}
+	if result.RCode != RCodeNameError || len(result.Names) != 0 || result.Server != first.addr {
+		t.Errorf("result = %+v, want a final NXDOMAIN from %s", result, first.addr)
+	}
+	if udp, _ := second.count(); udp != 0 {
+		t.Errorf("second server got %d queries after NXDOMAIN, want 0", udp)
+	}
+}
+
+func TestLookupPTRServerFailure(t *testing.T) {
+	failing := newTestResponder(t, "127.0.0.1", func(query []byte, tcp bool) [][]byte {
+		return [][]byte{testReply(query, 0x8180|RCodeServerFailure)}
+	})
+	working := newTestResponder(t, "127.0.0.1", func(query []byte, tcp bool) [][]byte {
+		return [][]byte{testReply(query, 0x8180, testPTR(60, "host.example.com"))}
+	})
+
+	result, err := newTestDNSClient(t, failing.addr, working.addr).LookupPTR(context.Background(), "192.0.2.10")
+	if err != nil {
+		t.Fatalf("LookupPTR: %v", err)
+	}
+	if result.Server != working.addr || !slices.Equal(result.Names, []string{"host.example.com"}) {
+		t.Errorf("result = %+v, want host.example.com from %s", result, working.addr)
+	}
+
+	// With no other server to turn to, SERVFAIL is an error
+	result, err = newTestDNSClient(t, failing.addr).LookupPTR(context.Background(), "192.0.2.10")
+	if err == nil || result.RCode != RCodeServerFailure {
+		t.Errorf("LookupPTR = %+v, %v; want SERVFAIL and an error", result, err)
+	}
+}
+
+func TestLookupPTRTruncated(t *testing.T) {
+	server := newTestResponder(t, "127.0.0.1", func(query []byte, tcp bool) [][]byte {
+		if !tcp {
+			// The first record is cut short
+			return [][]byte{testReply(query, 0x8380, testPTR(60, "a.example.com")[:8])}
+		}
+		return [][]byte{testReply(query, 0x8180, testPTR(60, "a.example.com"), testPTR(60, "b.example.com"))}
+	})
+
+	result, err := newTestDNSClient(t, server.addr).LookupPTR(context.Background(), "2001:db8::1")
+	if err != nil {
+		t.Fatalf("LookupPTR: %v", err)
+	}
+	if !result.TCP || !slices.Equal(result.Names, []string{"a.example.com", "b.example.com"}) {
+		t.Errorf("result = %+v, want both names over TCP", result)
+	}
+	if udp, tcp := server.count(); udp != 1 || tcp != 1 {
+		t.Errorf("server got %d UDP and %d TCP queries, want 1 and 1", udp, tcp)
+	}
+}
+
+func TestLookupPTRRetry(t *testing.T) {
+	// The first query goes unanswered
+	var dropped atomic.Bool
+	server := newTestResponder(t, "127.0.0.1", func(query []byte, tcp bool) [][]byte {
+		if dropped.CompareAndSwap(false, true) {
+			return nil
+		}
+		return [][]byte{testReply(query, 0x8180, testPTR(60, "slow.example.com"))}
+	})
+
+	client := newTestDNSClient(t, server.addr)
+	client.SetRetries(1)
+	result, err := client.LookupPTR(context.Background(), "192.0.2.10")
+	if err != nil {
+		t.Fatalf("LookupPTR: %v", err)
+	}
+	if !slices.Equal(result.Names, []string{"slow.example.com"}) {
+		t.Errorf("Names = %q, want slow.example.com", result.Names)
+	}
+	if udp, _ := server.count(); udp != 2 {
+		t.Errorf("server got %d queries, want 2", udp)
+	}
+
+	// A silent server is given up on once the retries are used up
+	silent := newTestResponder(t, "127.0.0.1", func(query []byte, tcp bool) [][]byte {
+		return nil
+	})
+	client = newTestDNSClient(t, silent.addr)
+	client.SetRetries(2)
+	if _, err := client.LookupPTR(context.Background(), "192.0.2.10"); err == nil {
+		t.Error("LookupPTR of a silent server: no error")
+	}
+	if udp, _ := silent.count(); udp != 3 {
+		t.Errorf("silent server got %d queries, want 3", udp)
+	}
+}
+
+func TestDNSMethodFailover(t *testing.T) {
+	// With the client's and the method's default timeouts, a silent first
+	// server must leave time for the second
+	silent := newTestResponder(t, "127.0.0.1", func(query []byte, tcp bool) [][]byte {
+		return nil
+	})
+	working := newTestResponder(t, "127.0.0.1", func(query []byte, tcp bool) [][]byte {
+		return [][]byte{testReply(query, 0x8180, testPTR(60, "host.example.com"))}
+	})
+	client, err := NewDNSClient(silent.addr, working.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	methods, err := ParseMethods(MethodDNS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := NewHostnameResolver()
+	resolver.SetMethods(ReplaceMethod(methods, NewDNSMethod(client)))
+
+	result := resolver.ResolveAll(context.Background(), "192.0.2.10")
+	if want := []Name{{Name: "host.example.com", Method: MethodDNS}}; !slices.Equal(result.Names, want) {
+		t.Errorf("Names = %v, want %v", result.Names, want)
+	}
+	if udp, _ := silent.count(); udp != 2 {
+		t.Errorf("silent server got %d queries, want a query and its retry", udp)
+	}
+}
+
+func TestLookupPTRMismatch(t *testing.T) {
+	// A reply with another ID is ignored and the real one still accepted
+	server := newTestResponder(t, "127.0.0.1", func(query []byte, tcp bool) [][]byte {
+		stray := testReply(query, 0x8180, testPTR(60, "spoofed.example.com"))
+		binary.BigEndian.PutUint16(stray, binary.BigEndian.Uint16(query)+1)
+		return [][]byte{stray, testReply(query, 0x8180, testPTR(60, "real.example.com"))}
+	})
+	result, err := newTestDNSClient(t, server.addr).LookupPTR(context.Background(), "192.0.2.10")
+	if err != nil || !slices.Equal(result.Names, []string{"real.example.com"}) {
+		t.Errorf("LookupPTR = %+v, %v; want real.example.com", result, err)
+	}
+
+	// A reply with only another ID times out
+	stray := newTestResponder(t, "127.0.0.1", func(query []byte, tcp bool) [][]byte {
+		reply := testReply(query, 0x8180, testPTR(60, "spoofed.example.com"))
+		binary.BigEndian.PutUint16(reply, binary.BigEndian.Uint16(query)^0xffff)
+		return [][]byte{reply}
+	})
+	if result, err := newTestDNSClient(t, stray.addr).LookupPTR(context.Background(), "192.0.2.10"); err == nil || len(result.Names) != 0 {
+		t.Errorf("LookupPTR = %+v, %v; want no names and an error", result, err)
+	}
+
+	tests := []struct {
+		name  string
+		reply func(query []byte) []byte
+	}{
+		{"another name", func(query []byte) []byte {
+			other, _ := buildQuery(binary.BigEndian.Uint16(query), 0, strings.Split("11.2.0.192.in-addr.arpa", "."), dnsTypePTR)
+			return testReply(other, 0x8180, testPTR(60, "spoofed.example.com"))
+		}},
+		{"another type", func(query []byte) []byte {
+			reply := testReply(query, 0x8180, testPTR(60, "spoofed.example.com"))
+			_, next, _ := readName(reply, dnsHeaderLen)
+			binary.BigEndian.PutUint16(reply[next:], dnsTypeA)
+			return reply
+		}},
+		{"no question", func(query []byte) []byte {
+			reply := append([]byte(nil), query[:dnsHeaderLen]...)
+			binary.BigEndian.PutUint16(reply[2:], 0x8180)
+			binary.BigEndian.PutUint16(reply[4:], 0)
+			return reply
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spoofing := newTestResponder(t, "127.0.0.1", func(query []byte, tcp bool) [][]byte {
+				return [][]byte{test.reply(query)}
+			})
+			working := newTestResponder(t, "127.0.0.1", func(query []byte, tcp bool) [][]byte {
+				return [][]byte{testReply(query, 0x8180, testPTR(60, "real.example.com"))}
+			})
+
+			result, err := newTestDNSClient(t, spoofing.addr).LookupPTR(context.Background(), "192.0.2.10")
+			if err == nil || len(result.Names) != 0 {
+				t.Errorf("LookupPTR = %+v, %v; want the reply rejected", result, err)
+			}
+			result, err = newTestDNSClient(t, spoofing.addr, working.addr).LookupPTR(context.Background(), "192.0.2.10")
+			if err != nil || result.Server != working.addr || !slices.Equal(result.Names, []string{"real.example.com"}) {
+				t.Errorf("LookupPTR = %+v, %v; want real.example.com from the next server", result, err)
+			}
+		})
+	}
+}
+
+func TestReverseName(t *testing.T) {
+	tests := []struct {
+		addr, want string
+	}{
+		{"192.0.2.10", "10.2.0.192.in-addr.arpa."},
+		{"::ffff:192.0.2.10", "10.2.0.192.in-addr.arpa."},
+		{"2001:db8::567:89ab", "b.a.9.8.7.6.5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa."},
+	}
+	for _, test := range tests {
+		if got := reverseName(netip.MustParseAddr(test.addr)); got != test.want {
+			t.Errorf("reverseName(%s) = %s, want %s", test.addr, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package hostname
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// LLMNR constants used by the LLMNR client.
+const (
+	llmnrPort    = 5355
+	dnsTypeA     = 1
+	dnsTypeAAAA  = 28
+	llmnrFlagTC  = 0x0200
+	llmnrMaxSize = 9000
+)
+
+// LookupLLMNR asks the host at addr, an IP address with an optional port
+// that defaults to 5355, for the names of its address with Link-Local
+// Multicast Name Resolution (RFC 4795). Windows answers LLMNR even when
+// the machine is in no DNS zone and has NetBIOS turned off.
+//
+// As the RFC asks of reverse lookups, the PTR query goes straight to the
+// address rather than to the multicast group. A truncated reply is fetched
+// again over TCP.
+func LookupLLMNR(ctx context.Context, addr string) ([]string, error) {
+	server, err := parseServer(addr, llmnrPort)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.Split(strings.TrimSuffix(reverseName(server.Addr()), "."), ".")
+	records, err := llmnrQuery(ctx, server, name, dnsTypePTR)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, record := range records {
+		if record.rrType != dnsTypePTR || !sameName(record.name, name) {
+			continue
+		}
+		target, _, err := readName(record.message, record.data)
+		if err == nil && target != "" && !slices.Contains(names, target) {
+			names = append(names, target)
+		}
+	}
+	return names, nil
+}
+
+// ResolveLLMNR asks the host at addr, given as for LookupLLMNR, for the
+// addresses of name: its A records, or its AAAA records if addr is an IPv6
+// address.
+func ResolveLLMNR(ctx context.Context, addr, name string) ([]netip.Addr, error) {
+	server, err := parseServer(addr, llmnrPort)
+	if err != nil {
+		return nil, err
+	}
+	rrType := uint16(dnsTypeA)
+	if server.Addr().Unmap().Is6() {
+		rrType = dnsTypeAAAA
+	}
+	labels := strings.Split(strings.TrimSuffix(name, "."), ".")
+	records, err := llmnrQuery(ctx, server, labels, rrType)
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []netip.Addr
+	for _, record := range records {
+		if record.rrType != rrType || !sameName(record.name, labels) {
+			continue
+		}
+		if ip, ok := netip.AddrFromSlice(record.message[record.data : record.data+record.length]); ok {
+			addrs = append(addrs, ip)
+		}
+	}
+	return addrs, nil
+}
+
+// llmnrQuery asks server for the records of rrType under the name made of
+// labels, over UDP and again over TCP if the reply was truncated.
+func llmnrQuery(ctx context.Context, server netip.AddrPort, labels []string, rrType uint16) ([]dnsRecord, error) {
+	id := uint16(rand.Uint32())
+	message, err := buildQuery(id, 0, labels, rrType)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := resendUDP(ctx, server.String(), id, message, llmnrMaxSize)
+	if err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint16(reply[2:])&llmnrFlagTC != 0 {
+		if reply, err = exchangeTCP(ctx, server.String(), message); err != nil {
+			return nil, err
+		}
+		if len(reply) < dnsHeaderLen || binary.BigEndian.Uint16(reply) != id {
+			return nil, fmt.Errorf("LLMNR reply for another query")
+		}
+	}
+	return parseRecords(reply)
+}
+
+// llmnrMethod is the built-in "llmnr" Method. Each name the host gives
+// for its address is looked up again with the host, and names that it
+// says belong to other addresses are dropped. A name whose forward lookup
+// gets no answer is kept, since not every responder answers both.
+type llmnrMethod struct{}
+
+func (llmnrMethod) Name() string {
+	return MethodLLMNR
+}
+
+func (llmnrMethod) Lookup(ctx context.Context, ip string) []string {
+	names, err := LookupLLMNR(ctx, ip)
+	if err != nil {
+		return nil
+	}
+	// Like the lookups, ip may carry a port
+	server, _ := parseServer(ip, llmnrPort)
+
+	var confirmed []string
+	for _, name := range names {
+		// One try is enough to confirm, and keeps a host that ignores
+		// forward queries from using up the time of the method
+		confirmCtx, cancel := context.WithTimeout(ctx, linkLocalResend)
+		addrs, err := ResolveLLMNR(confirmCtx, ip, name)
+		cancel()
+		if err != nil || len(addrs) == 0 || slices.ContainsFunc(addrs, func(a netip.Addr) bool { return a.Unmap() == server.Addr().Unmap() }) {
+			confirmed = append(confirmed, name)
+		}
+	}
+	return confirmed
+}
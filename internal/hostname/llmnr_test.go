@@ -0,0 +1,145 @@
+package hostname
+
+import (
+	"context"
+	"encoding/binary"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testLLMNRResponder answers PTR queries for its own address with names
+// and A or AAAA queries with the addresses in forward, whose keys are in
+// upper case. Names missing from forward get an empty answer.
+func testLLMNRResponder(t *testing.T, host string, names []string, forward map[string]string) *testResponder {
+	return newTestResponder(t, host, func(query []byte, tcp bool) [][]byte {
+		labels, next, err := readLabels(query, dnsHeaderLen)
+		if err != nil {
+			return nil
+		}
+		var answers [][]byte
+		switch binary.BigEndian.Uint16(query[next:]) {
+		case dnsTypePTR:
+			for _, name := range names {
+				answers = append(answers, testPTR(30, name))
+			}
+		case dnsTypeA, dnsTypeAAAA:
+			if addr, ok := forward[strings.ToUpper(strings.Join(labels, "."))]; ok {
+				ip := netip.MustParseAddr(addr)
+				rrType := uint16(dnsTypeA)
+				if ip.Is6() {
+					rrType = dnsTypeAAAA
+				}
+				answers = append(answers, testRecord(rrType, 30, ip.AsSlice()))
+			}
+		}
+		return [][]byte{testReply(query, 0x8000, answers...)}
+	})
+}
+
+func TestLookupLLMNR(t *testing.T) {
+	server := testLLMNRResponder(t, "127.0.0.1", []string{"WS1", "ws1"}, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	names, err := LookupLLMNR(ctx, server.addr)
+	if err != nil {
+		t.Fatalf("LookupLLMNR: %v", err)
+	}
+	if want := []string{"WS1", "ws1"}; !slices.Equal(names, want) {
+		t.Errorf("names = %q, want %q", names, want)
+	}
+
+	query := server.received()[0].message
+	if flags := binary.BigEndian.Uint16(query[2:]); flags != 0 {
+		t.Errorf("query flags = %#04x, want none", flags)
+	}
+	if question, _, _ := readName(query, dnsHeaderLen); question != "1.0.0.127.in-addr.arpa" {
+		t.Errorf("question = %q, want 1.0.0.127.in-addr.arpa", question)
+	}
+}
+
+func TestResolveLLMNR(t *testing.T) {
+	server := testLLMNRResponder(t, "127.0.0.1", nil, map[string]string{"WS1": "127.0.0.1"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	addrs, err := ResolveLLMNR(ctx, server.addr, "ws1.")
+	if err != nil {
+		t.Fatalf("ResolveLLMNR: %v", err)
+	}
+	if want := []netip.Addr{netip.MustParseAddr("127.0.0.1")}; !slices.Equal(addrs, want) {
+		t.Errorf("addrs = %v, want %v", addrs, want)
+	}
+	query := server.received()[0].message
+	if _, next, _ := readName(query, dnsHeaderLen); binary.BigEndian.Uint16(query[next:]) != dnsTypeA {
+		t.Errorf("query type = %d, want A", binary.BigEndian.Uint16(query[next:]))
+	}
+
+	// IPv6 hosts are asked for AAAA records
+	server6 := testLLMNRResponder(t, "::1", nil, map[string]string{"WS1": "::1"})
+	addrs, err = ResolveLLMNR(ctx, server6.addr, "WS1")
+	if err != nil || !slices.Equal(addrs, []netip.Addr{netip.IPv6Loopback()}) {
+		t.Errorf("ResolveLLMNR over IPv6 = %v, %v; want ::1", addrs, err)
+	}
+}
+
+func TestLLMNRMethodConfirms(t *testing.T) {
+	// WS1 maps back to the host, OTHER belongs to another address and
+	// SILENT gets no answer to its forward query
+	server := testLLMNRResponder(t, "127.0.0.1", []string{"WS1", "OTHER", "SILENT"}, map[string]string{
+		"WS1":   "127.0.0.1",
+		"OTHER": "192.0.2.99",
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	names := llmnrMethod{}.Lookup(ctx, server.addr)
+	if want := []string{"WS1", "SILENT"}; !slices.Equal(names, want) {
+		t.Errorf("Lookup = %q, want %q", names, want)
+	}
+	if udp, _ := server.count(); udp != 4 {
+		t.Errorf("responder got %d queries, want a reverse and three forward ones", udp)
+	}
+}
+
+func TestLookupLLMNRTruncated(t *testing.T) {
+	names := []string{"WS1", "WS1-ALIAS"}
+	server := newTestResponder(t, "127.0.0.1", func(query []byte, tcp bool) [][]byte {
+		if !tcp {
+			return [][]byte{testReply(query, 0x8000|llmnrFlagTC)}
+		}
+		var answers [][]byte
+		for _, name := range names {
+			answers = append(answers, testPTR(30, name))
+		}
+		return [][]byte{testReply(query, 0x8000, answers...)}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	got, err := LookupLLMNR(ctx, server.addr)
+	if err != nil {
+		t.Fatalf("LookupLLMNR: %v", err)
+	}
+	if !slices.Equal(got, names) {
+		t.Errorf("names = %q, want %q from the TCP reply", got, names)
+	}
+	if udp, tcp := server.count(); udp != 1 || tcp != 1 {
+		t.Errorf("responder got %d UDP and %d TCP queries, want 1 and 1", udp, tcp)
+	}
+
+	// The TCP reply must be to the same query
+	stray := newTestResponder(t, "127.0.0.1", func(query []byte, tcp bool) [][]byte {
+		reply := testReply(query, 0x8000|llmnrFlagTC, testPTR(30, "SPOOFED"))
+		if tcp {
+			binary.BigEndian.PutUint16(reply, binary.BigEndian.Uint16(query)^0xffff)
+		}
+		return [][]byte{reply}
+	})
+	if got, err := LookupLLMNR(ctx, stray.addr); err == nil {
+		t.Errorf("LookupLLMNR = %q, want an error for a TCP reply to another query", got)
+	}
+}
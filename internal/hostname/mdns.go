@@ -0,0 +1,286 @@
+package hostname
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Multicast DNS constants used by the mDNS client.
+const (
+	mdnsPort   = 5353
+	dnsTypeTXT = 16
+	dnsTypeSRV = 33
+	// mdnsMaxSize is the largest mDNS message, which may fill a jumbo frame
+	// (RFC 6762, section 17).
+	mdnsMaxSize = 9000
+	// mdnsMaxTypes bounds the service types browsed on one host.
+	mdnsMaxTypes = 32
+)
+
+// servicesName is the DNS-SD meta-query name that lists the service types
+// a host advertises (RFC 6763, section 9).
+var servicesName = []string{"_services", "_dns-sd", "_udp", "local"}
+
+// Service is a DNS-SD service instance advertised by a host, such as an
+// AirPlay receiver or a printer.
+type Service struct {
+	// Instance is the user-visible name, for example "Living Room".
+	Instance string
+	// Type is the service type and protocol, for example "_airplay._tcp".
+	Type string
+	// Host and Port are the target of the SRV record, where the service
+	// listens.
+	Host string `json:",omitempty"`
+	Port int    `json:",omitempty"`
+	// TXT holds the key=value strings of the TXT record.
+	TXT []string `json:",omitempty"`
+}
+
+func (s Service) String() string {
+	if s.Port == 0 {
+		return fmt.Sprintf("%s (%s)", s.Instance, s.Type)
+	}
+	return fmt.Sprintf("%s (%s:%d)", s.Instance, s.Type, s.Port)
+}
+
+// LookupMDNS asks the host at addr, an IP address with an optional port
+// that defaults to 5353, for the names of its address. Names under .local
+// are returned without that domain.
+//
+// Queries go straight to the host rather than to the multicast group.
+// Responders answer such one-shot queries with a unicast reply (RFC 6762,
+// section 5.1), which leaves no doubt about which host said what and does
+// not depend on the route to 224.0.0.251.
+func LookupMDNS(ctx context.Context, addr string) ([]string, error) {
+	server, err := parseServer(addr, mdnsPort)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.Split(strings.TrimSuffix(reverseName(server.Addr()), "."), ".")
+	records, err := mdnsQuery(ctx, server, name, dnsTypePTR)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, record := range records {
+		if record.rrType != dnsTypePTR || !sameName(record.name, name) {
+			continue
+		}
+		target, _, err := readName(record.message, record.data)
+		if err != nil {
+			continue
+		}
+		target = strings.TrimSuffix(target, ".local")
+		if !slices.Contains(names, target) {
+			names = append(names, target)
+		}
+	}
+	return names, nil
+}
+
+// BrowseServices asks the host at addr, an IP address with an optional
+// port that defaults to 5353, for the DNS-SD service types it advertises
+// and then for the instances of each, with where they listen. It returns
+// what it found before ctx was done or the host stopped answering.
+func BrowseServices(ctx context.Context, addr string) ([]Service, error) {
+	server, err := parseServer(addr, mdnsPort)
+	if err != nil {
+		return nil, err
+	}
+	records, err := mdnsQuery(ctx, server, servicesName, dnsTypePTR)
+	if err != nil {
+		return nil, err
+	}
+
+	var types [][]string
+	for _, record := range records {
+		if record.rrType != dnsTypePTR || !sameName(record.name, servicesName) {
+			continue
+		}
+		labels, _, err := readLabels(record.message, record.data)
+		if err == nil && len(labels) >= 3 && len(types) < mdnsMaxTypes &&
+			!slices.ContainsFunc(types, func(t []string) bool { return sameName(t, labels) }) {
+			types = append(types, labels)
+		}
+	}
+
+	var services []Service
+	for _, serviceType := range types {
+		records, err := mdnsQuery(ctx, server, serviceType, dnsTypePTR)
+		if err != nil {
+			if ctx.Err() != nil {
+				return services, ctx.Err()
+			}
+			continue
+		}
+		for _, record := range records {
+			if record.rrType != dnsTypePTR || !sameName(record.name, serviceType) {
+				continue
+			}
+			instance, _, err := readLabels(record.message, record.data)
+			if err != nil || len(instance) != len(serviceType)+1 {
+				continue
+			}
+			service := Service{
+				Instance: instance[0],
+				Type:     strings.Join(serviceType[:len(serviceType)-1], "."),
+			}
+			// Responders usually send the SRV and TXT records along with
+			// the PTR record; ask for them only if they did not
+			if !describeService(&service, instance, records) {
+				if more, err := mdnsQuery(ctx, server, instance, dnsTypeSRV); err == nil {
+					describeService(&service, instance, more)
+				}
+			}
+			services = append(services, service)
+		}
+	}
+	return services, nil
+}
+
+// describeService fills in the SRV and TXT details of the service instance
+// from records and reports whether an SRV record was among them.
+func describeService(service *Service, instance []string, records []dnsRecord) bool {
+	found := false
+	for _, record := range records {
+		if !sameName(record.name, instance) {
+			continue
+		}
+		switch record.rrType {
+		case dnsTypeSRV:
+			if record.length < 7 {
+				continue
+			}
+			target, _, err := readName(record.message, record.data+6)
+			if err != nil {
+				continue
+			}
+			service.Host = target
+			service.Port = int(binary.BigEndian.Uint16(record.message[record.data+4:]))
+			found = true
+		case dnsTypeTXT:
+			service.TXT = nil
+			data := record.message[record.data : record.data+record.length]
+			for len(data) > 0 {
+				length := int(data[0])
+				if 1+length > len(data) {
+					break
+				}
+				if length > 0 {
+					service.TXT = append(service.TXT, string(data[1:1+length]))
+				}
+				data = data[1+length:]
+			}
+		}
+	}
+	return found
+}
+
+// mdnsQuery asks server for the records of rrType under the name made of
+// labels and returns every record of the reply, additional ones included.
+// The query is sent again every second until an answer comes, the host
+// refuses it or ctx is done.
+func mdnsQuery(ctx context.Context, server netip.AddrPort, labels []string, rrType uint16) ([]dnsRecord, error) {
+	id := uint16(rand.Uint32())
+	message, err := buildQuery(id, 0, labels, rrType)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := resendUDP(ctx, server.String(), id, message, mdnsMaxSize)
+	if err != nil {
+		return nil, err
+	}
+	return parseRecords(reply)
+}
+
+// dnsRecord is a resource record of a reply. Its data is left in place in
+// the message, where compressed names in it point.
+type dnsRecord struct {
+	name    []string
+	rrType  uint16
+	message []byte
+	data    int
+	length  int
+}
+
+// parseRecords returns the records of every section of reply.
+func parseRecords(reply []byte) ([]dnsRecord, error) {
+	if len(reply) < dnsHeaderLen || binary.BigEndian.Uint16(reply[2:])&0x8000 == 0 {
+		return nil, fmt.Errorf("not a DNS reply")
+	}
+	if rcode := int(binary.BigEndian.Uint16(reply[2:]) & 0x000f); rcode != RCodeSuccess {
+		return nil, fmt.Errorf("query failed with %s", RCodeName(rcode))
+	}
+
+	questions := int(binary.BigEndian.Uint16(reply[4:]))
+	offset := dnsHeaderLen
+	for i := 0; i < questions; i++ {
+		_, next, err := readLabels(reply, offset)
+		if err != nil || next+4 > len(reply) {
+			return nil, fmt.Errorf("malformed DNS question")
+		}
+		offset = next + 4
+	}
+
+	count := int(binary.BigEndian.Uint16(reply[6:])) + int(binary.BigEndian.Uint16(reply[8:])) + int(binary.BigEndian.Uint16(reply[10:]))
+	var records []dnsRecord
+	for i := 0; i < count; i++ {
+		name, next, err := readLabels(reply, offset)
+		if err != nil || next+10 > len(reply) {
+			return records, fmt.Errorf("malformed DNS record")
+		}
+		length := int(binary.BigEndian.Uint16(reply[next+8:]))
+		if next+10+length > len(reply) {
+			return records, fmt.Errorf("malformed DNS record")
+		}
+		// The top bit of the class is the mDNS cache-flush flag
+		if binary.BigEndian.Uint16(reply[next+2:])&0x7fff == dnsClassIN {
+			records = append(records, dnsRecord{
+				name:    name,
+				rrType:  binary.BigEndian.Uint16(reply[next:]),
+				message: reply,
+				data:    next + 10,
+				length:  length,
+			})
+		}
+		offset = next + 10 + length
+	}
+	return records, nil
+}
+
+// sameName compares two names label by label, ignoring case as DNS does.
+func sameName(a, b []string) bool {
+	return slices.EqualFunc(a, b, strings.EqualFold)
+}
+
+// mdnsMethod is the built-in "mdns" Method. It looks the address up and
+// browses the services of the host at the same time, keeping the services
+// for Result.Services.
+type mdnsMethod struct{}
+
+func (mdnsMethod) Name() string {
+	return MethodMDNS
+}
+
+func (m mdnsMethod) Lookup(ctx context.Context, ip string) []string {
+	return m.lookupDetails(ctx, ip, &Result{})
+}
+
+func (mdnsMethod) lookupDetails(ctx context.Context, ip string, result *Result) []string {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		result.Services, _ = BrowseServices(ctx, ip)
+	}()
+	names, _ := LookupMDNS(ctx, ip)
+	wg.Wait()
+	return names
+}
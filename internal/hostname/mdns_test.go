@@ -0,0 +1,154 @@
+package hostname
+
+import (
+	"context"
+	"encoding/binary"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testNamedRecord builds a record for name, spelt out in full, with the
+// mDNS cache-flush bit set in its class.
+func testNamedRecord(name string, rrType uint16, data []byte) []byte {
+	record := testName(name)
+	record = binary.BigEndian.AppendUint16(record, rrType)
+	record = binary.BigEndian.AppendUint16(record, 0x8000|dnsClassIN)
+	record = binary.BigEndian.AppendUint32(record, 120)
+	record = binary.BigEndian.AppendUint16(record, uint16(len(data)))
+	return append(record, data...)
+}
+
+// testSRV builds the data of an SRV record.
+func testSRV(port uint16, target string) []byte {
+	data := []byte{0, 0, 0, 0}
+	data = binary.BigEndian.AppendUint16(data, port)
+	return append(data, testName(target)...)
+}
+
+// testTXT builds the data of a TXT record.
+func testTXT(strs ...string) []byte {
+	var data []byte
+	for _, s := range strs {
+		data = append(data, byte(len(s)))
+		data = append(data, s...)
+	}
+	return data
+}
+
+// testMDNSResponder answers as an Apple TV that advertises an AirPlay
+// receiver with its SRV and TXT records alongside, and a printer whose SRV
+// record must be asked for.
+func testMDNSResponder(t *testing.T) *testResponder {
+	return newTestResponder(t, "127.0.0.1", func(query []byte, tcp bool) [][]byte {
+		labels, next, err := readLabels(query, dnsHeaderLen)
+		if err != nil {
+			return nil
+		}
+		name := strings.ToLower(strings.Join(labels, "."))
+		rrType := binary.BigEndian.Uint16(query[next:])
+
+		var answers [][]byte
+		switch {
+		case rrType == dnsTypePTR && name == "1.0.0.127.in-addr.arpa":
+			answers = append(answers,
+				testPTR(120, "appletv.local"),
+				testPTR(120, "appletv.local"),
+				testNamedRecord("2.0.0.127.in-addr.arpa", dnsTypePTR, testName("other.local")))
+		case rrType == dnsTypePTR && name == "_services._dns-sd._udp.local":
+			answers = append(answers,
+				testPTR(120, "_airplay._tcp.local"),
+				testPTR(120, "_AirPlay._tcp.local"),
+				testPTR(120, "_ipp._tcp.local"),
+				// Too short to be a service type
+				testPTR(120, "local"))
+		case rrType == dnsTypePTR && name == "_airplay._tcp.local":
+			answers = append(answers,
+				testPTR(120, "Living Room._airplay._tcp.local"),
+				testNamedRecord("Living Room._airplay._tcp.local", dnsTypeSRV, testSRV(7000, "appletv.local")),
+				testNamedRecord("Living Room._airplay._tcp.local", dnsTypeTXT, testTXT("model=AppleTV5,3", "", "srcvers=220.68")))
+		case rrType == dnsTypePTR && name == "_ipp._tcp.local":
+			answers = append(answers,
+				testPTR(120, "Office._ipp._tcp.local"),
+				// Not an instance of the type
+				testPTR(120, "Office._ipp._tcp.example.local"))
+		case rrType == dnsTypeSRV && name == "office._ipp._tcp.local":
+			answers = append(answers, testRecord(dnsTypeSRV, 120, testSRV(631, "printer.local")))
+		}
+		return [][]byte{testReply(query, 0x8400, answers...)}
+	})
+}
+
+func TestLookupMDNS(t *testing.T) {
+	server := testMDNSResponder(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	names, err := LookupMDNS(ctx, server.addr)
+	if err != nil {
+		t.Fatalf("LookupMDNS: %v", err)
+	}
+	if want := []string{"appletv"}; !slices.Equal(names, want) {
+		t.Errorf("names = %q, want %q", names, want)
+	}
+}
+
+func TestBrowseServices(t *testing.T) {
+	server := testMDNSResponder(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	var result Result
+	names := mdnsMethod{}.lookupDetails(ctx, server.addr, &result)
+	if want := []string{"appletv"}; !slices.Equal(names, want) {
+		t.Errorf("names = %q, want %q", names, want)
+	}
+
+	want := []Service{
+		{Instance: "Living Room", Type: "_airplay._tcp", Host: "appletv.local", Port: 7000, TXT: []string{"model=AppleTV5,3", "srcvers=220.68"}},
+		{Instance: "Office", Type: "_ipp._tcp", Host: "printer.local", Port: 631},
+	}
+	if !reflect.DeepEqual(result.Services, want) {
+		t.Errorf("services = %+v, want %+v", result.Services, want)
+	}
+
+	// The AirPlay details came with the PTR record, so only the printer's
+	// SRV record was asked for
+	var srv []string
+	for _, query := range server.received() {
+		labels, next, _ := readLabels(query.message, dnsHeaderLen)
+		if binary.BigEndian.Uint16(query.message[next:]) == dnsTypeSRV {
+			srv = append(srv, strings.Join(labels, "."))
+		}
+	}
+	if want := []string{"Office._ipp._tcp.local"}; !slices.Equal(srv, want) {
+		t.Errorf("SRV queries = %q, want %q", srv, want)
+	}
+}
+
+func TestParseRecordsTruncated(t *testing.T) {
+	query, _ := buildQuery(1, 0, strings.Split("_airplay._tcp.local", "."), dnsTypePTR)
+	reply := testReply(query, 0x8400,
+		testPTR(120, "Living Room._airplay._tcp.local"),
+		testNamedRecord("Living Room._airplay._tcp.local", dnsTypeSRV, testSRV(7000, "appletv.local")))
+
+	records, err := parseRecords(reply)
+	if err != nil || len(records) != 2 {
+		t.Fatalf("parseRecords = %d records, %v; want 2", len(records), err)
+	}
+	for n := range len(reply) {
+		if records, err := parseRecords(reply[:n]); err == nil {
+			t.Errorf("parseRecords of %d bytes = %d records, want an error", n, len(records))
+		}
+	}
+
+	// An SRV record too short for its port is skipped
+	short := testReply(query, 0x8400, testRecord(dnsTypeSRV, 120, []byte{0, 0, 0, 0, 0x1b}))
+	records, _ = parseRecords(short)
+	var service Service
+	if describeService(&service, strings.Split("_airplay._tcp.local", "."), records) || service.Port != 0 {
+		t.Errorf("describeService of a short SRV record = %+v, want nothing", service)
+	}
+}
@@ -0,0 +1,79 @@
+package hostname
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/CyberOakAlpha/CrossNet/internal/osdetect"
+)
+
+// Names of the built-in methods.
+const (
+	MethodDNS     = "dns"
+	MethodHosts   = "hosts"
+	MethodNetBIOS = "netbios"
+	MethodLLMNR   = "llmnr"
+	MethodMDNS    = "mdns"
+)
+
+func init() {
+	Register(NewMethod(MethodDNS, lookupReverseDNS), 2*time.Second)
+	Register(NewMethod(MethodHosts, lookupHostsFile), time.Second)
+	Register(netbiosMethod{}, 3*time.Second)
+	Register(llmnrMethod{}, 2*time.Second)
+	Register(mdnsMethod{}, 2*time.Second)
+	defaultOrder = []string{MethodDNS, MethodHosts, MethodNetBIOS, MethodLLMNR, MethodMDNS}
+}
+
+// Method 1: Standard reverse DNS lookup
+func lookupReverseDNS(ctx context.Context, ip string) []string {
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip)
+	if err != nil {
+		return nil
+	}
+	for i, name := range names {
+		// Remove trailing dot if present
+		names[i] = strings.TrimSuffix(name, ".")
+	}
+	return names
+}
+
+// Method 2: Check the hosts file
+func lookupHostsFile(ctx context.Context, ip string) []string {
+	path := "/etc/hosts"
+	if osdetect.DetectOS() == osdetect.Windows {
+		root := os.Getenv("SystemRoot")
+		if root == "" {
+			root = `C:\Windows`
+		}
+		path = filepath.Join(root, "System32", "drivers", "etc", "hosts")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var names []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == ip {
+			names = append(names, fields[1:]...)
+		}
+	}
+	return names
+}
+
+// Method 3: NetBIOS node status queries, in netbios.go
+
+// Method 4: LLMNR reverse lookups, in llmnr.go
+
+// Method 5: mDNS reverse lookups and DNS-SD service browsing, in mdns.go
@@ -0,0 +1,206 @@
+package hostname
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strings"
+)
+
+// NetBIOS name service constants used by node status queries.
+const (
+	netbiosPort       = 137
+	netbiosTypeNBSTAT = 0x0021
+	netbiosClassIN    = 0x0001
+	// netbiosMaxSize is the largest reply read. A name table of 255
+	// entries with its statistics fits in a standard frame.
+	netbiosMaxSize = 1500
+)
+
+// NetBIOS name suffixes, the 16th byte of a name, that say what the name
+// stands for.
+const (
+	NetBIOSWorkstation = 0x00
+	NetBIOSMessenger   = 0x03
+	NetBIOSFileServer  = 0x20
+)
+
+// NetBIOSName is one entry in the name table of a node.
+type NetBIOSName struct {
+	Name   string
+	Suffix byte
+	Group  bool
+}
+
+func (n NetBIOSName) String() string {
+	kind := "UNIQUE"
+	if n.Group {
+		kind = "GROUP"
+	}
+	return fmt.Sprintf("%s<%02X> %s", n.Name, n.Suffix, kind)
+}
+
+// NodeStatus is the answer of a host to a NetBIOS node status (NBSTAT)
+// query.
+type NodeStatus struct {
+	// Name is the workstation name, the unique <00> entry.
+	Name string
+	// Domain is the domain or workgroup, the group <00> entry.
+	Domain string `json:",omitempty"`
+	// Users are the logged-in users, the unique <03> entries other than
+	// the workstation's own.
+	Users []string `json:",omitempty"`
+	// MAC is the address the node reports for itself, which Samba leaves
+	// empty.
+	MAC   string        `json:",omitempty"`
+	Names []NetBIOSName `json:",omitempty"`
+}
+
+// QueryNodeStatus asks the node at addr, an IP address with an optional
+// port that defaults to 137, for its NetBIOS name table. The query is for
+// the wildcard name "*", which Windows and Samba answer with every name
+// the node has registered, whatever its workstation name.
+func QueryNodeStatus(ctx context.Context, addr string) (*NodeStatus, error) {
+	server, err := parseServer(addr, netbiosPort)
+	if err != nil {
+		return nil, err
+	}
+	id := uint16(rand.Uint32())
+	reply, err := resendUDP(ctx, server.String(), id, buildNodeStatusQuery(id), netbiosMaxSize)
+	if err != nil {
+		return nil, err
+	}
+	return parseNodeStatus(reply)
+}
+
+// encodeNetBIOSName applies the first-level encoding of RFC 1001 to name,
+// which is padded to 15 characters and followed by suffix: every byte
+// becomes two letters from A to P.
+func encodeNetBIOSName(name string, pad, suffix byte) []byte {
+	raw := make([]byte, 16)
+	for i := range 15 {
+		raw[i] = pad
+	}
+	copy(raw, name)
+	raw[15] = suffix
+
+	encoded := []byte{32}
+	for _, b := range raw {
+		encoded = append(encoded, 'A'+b>>4, 'A'+b&0x0f)
+	}
+	return append(encoded, 0)
+}
+
+// buildNodeStatusQuery encodes an NBSTAT query for the wildcard name "*",
+// which every node answers.
+func buildNodeStatusQuery(id uint16) []byte {
+	query := make([]byte, 12)
+	binary.BigEndian.PutUint16(query[0:], id)
+	// One question, no flags
+	binary.BigEndian.PutUint16(query[4:], 1)
+	query = append(query, encodeNetBIOSName("*", 0, 0)...)
+	query = binary.BigEndian.AppendUint16(query, netbiosTypeNBSTAT)
+	return binary.BigEndian.AppendUint16(query, netbiosClassIN)
+}
+
+// parseNodeStatus decodes the name table and unit ID of an NBSTAT reply.
+func parseNodeStatus(reply []byte) (*NodeStatus, error) {
+	if len(reply) < 12 || reply[2]&0x80 == 0 {
+		return nil, fmt.Errorf("not a NetBIOS reply")
+	}
+	if rcode := reply[3] & 0x0f; rcode != 0 {
+		return nil, fmt.Errorf("node status query failed with rcode %d", rcode)
+	}
+	if binary.BigEndian.Uint16(reply[6:]) == 0 {
+		return nil, fmt.Errorf("empty node status reply")
+	}
+
+	// The answer repeats the encoded question name, possibly compressed
+	offset := 12
+	for offset < len(reply) {
+		length := int(reply[offset])
+		if length == 0 {
+			offset++
+			break
+		}
+		if length&0xc0 == 0xc0 {
+			offset += 2
+			break
+		}
+		offset += 1 + length
+	}
+	if offset+10 > len(reply) || binary.BigEndian.Uint16(reply[offset:]) != netbiosTypeNBSTAT {
+		return nil, fmt.Errorf("malformed node status reply")
+	}
+	length := int(binary.BigEndian.Uint16(reply[offset+8:]))
+	data := reply[offset+10:]
+	if length > len(data) {
+		return nil, fmt.Errorf("truncated node status reply")
+	}
+	data = data[:length]
+	if len(data) < 1 || len(data) < 1+int(data[0])*18 {
+		return nil, fmt.Errorf("truncated node status reply")
+	}
+
+	status := &NodeStatus{}
+	count := int(data[0])
+	for i := range count {
+		entry := data[1+i*18 : 1+(i+1)*18]
+		name := NetBIOSName{
+			Name:   strings.TrimRight(string(entry[:15]), " \x00"),
+			Suffix: entry[15],
+			Group:  binary.BigEndian.Uint16(entry[16:])&0x8000 != 0,
+		}
+		status.Names = append(status.Names, name)
+	}
+	for _, name := range status.Names {
+		switch {
+		case name.Suffix == NetBIOSWorkstation && !name.Group && status.Name == "":
+			status.Name = name.Name
+		case name.Suffix == NetBIOSWorkstation && name.Group && status.Domain == "":
+			status.Domain = name.Name
+		}
+	}
+	for _, name := range status.Names {
+		if name.Suffix == NetBIOSMessenger && !name.Group && !strings.EqualFold(name.Name, status.Name) &&
+			!strings.HasPrefix(name.Name, "__") && !slices.Contains(status.Users, name.Name) {
+			status.Users = append(status.Users, name.Name)
+		}
+	}
+
+	// The statistics after the names start with the unit ID
+	if stats := data[1+count*18:]; len(stats) >= 6 {
+		mac := net.HardwareAddr(stats[:6])
+		if !slices.Equal(mac, make([]byte, 6)) {
+			status.MAC = strings.ToUpper(mac.String())
+		}
+	}
+	return status, nil
+}
+
+// netbiosMethod is the built-in "netbios" Method. Besides the workstation
+// name, it keeps the whole node status for Result.NodeStatus.
+type netbiosMethod struct{}
+
+func (netbiosMethod) Name() string {
+	return MethodNetBIOS
+}
+
+func (m netbiosMethod) Lookup(ctx context.Context, ip string) []string {
+	return m.lookupDetails(ctx, ip, &Result{})
+}
+
+func (netbiosMethod) lookupDetails(ctx context.Context, ip string, result *Result) []string {
+	status, err := QueryNodeStatus(ctx, ip)
+	if err != nil {
+		return nil
+	}
+	result.NodeStatus = status
+	if status.Name == "" {
+		return nil
+	}
+	return []string{status.Name}
+}
@@ -0,0 +1,221 @@
+package hostname
+
+import (
+	"context"
+	"encoding/binary"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testNodeStatus builds the NBSTAT reply to query that lists names and
+// reports unit as the unit ID.
+func testNodeStatus(query []byte, unit []byte, names ...NetBIOSName) []byte {
+	reply := make([]byte, dnsHeaderLen)
+	copy(reply, query[:2])
+	// Authoritative answer, one record
+	binary.BigEndian.PutUint16(reply[2:], 0x8400)
+	binary.BigEndian.PutUint16(reply[6:], 1)
+	reply = append(reply, query[dnsHeaderLen:len(query)-4]...)
+	reply = binary.BigEndian.AppendUint16(reply, netbiosTypeNBSTAT)
+	reply = binary.BigEndian.AppendUint16(reply, netbiosClassIN)
+	reply = binary.BigEndian.AppendUint32(reply, 0)
+
+	data := []byte{byte(len(names))}
+	for _, name := range names {
+		entry := []byte(name.Name + strings.Repeat(" ", 15-len(name.Name)))
+		entry = append(entry, name.Suffix)
+		// Active and, for groups, the group bit
+		flags := uint16(0x0400)
+		if name.Group {
+			flags |= 0x8000
+		}
+		data = binary.BigEndian.AppendUint16(append(data, entry...), flags)
+	}
+	// The unit ID starts 46 bytes of statistics
+	stats := make([]byte, 46)
+	copy(stats, unit)
+	data = append(data, stats...)
+
+	reply = binary.BigEndian.AppendUint16(reply, uint16(len(data)))
+	return append(reply, data...)
+}
+
+var testNames = []NetBIOSName{
+	{Name: "FILESRV", Suffix: NetBIOSWorkstation},
+	{Name: "CORP", Suffix: NetBIOSWorkstation, Group: true},
+	{Name: "FILESRV", Suffix: NetBIOSFileServer},
+	{Name: "FILESRV", Suffix: NetBIOSMessenger},
+	{Name: "ALICE", Suffix: NetBIOSMessenger},
+	{Name: "CORP", Suffix: 0x1e, Group: true},
+	{Name: "__MSBROWSE__", Suffix: 0x01, Group: true},
+	{Name: "BOB", Suffix: NetBIOSMessenger},
+}
+
+func TestQueryNodeStatus(t *testing.T) {
+	server := newTestResponder(t, "127.0.0.1", func(query []byte, tcp bool) [][]byte {
+		return [][]byte{testNodeStatus(query, []byte{0x00, 0x15, 0x5d, 0x0a, 0xbc, 0xde}, testNames...)}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	status, err := QueryNodeStatus(ctx, server.addr)
+	if err != nil {
+		t.Fatalf("QueryNodeStatus: %v", err)
+	}
+
+	question := server.received()[0].message
+	if want := buildNodeStatusQuery(binary.BigEndian.Uint16(question)); !slices.Equal(question, want) {
+		t.Errorf("query = %x, want %x", question, want)
+	}
+	if status.Name != "FILESRV" {
+		t.Errorf("Name = %q, want FILESRV", status.Name)
+	}
+	if status.Domain != "CORP" {
+		t.Errorf("Domain = %q, want CORP", status.Domain)
+	}
+	if want := []string{"ALICE", "BOB"}; !slices.Equal(status.Users, want) {
+		t.Errorf("Users = %q, want %q", status.Users, want)
+	}
+	if status.MAC != "00:15:5D:0A:BC:DE" {
+		t.Errorf("MAC = %q, want 00:15:5D:0A:BC:DE", status.MAC)
+	}
+	if !slices.Equal(status.Names, testNames) {
+		t.Errorf("Names = %v, want %v", status.Names, testNames)
+	}
+}
+
+func TestQueryNodeStatusSamba(t *testing.T) {
+	// Samba reports no unit ID and pads names with NULs
+	server := newTestResponder(t, "127.0.0.1", func(query []byte, tcp bool) [][]byte {
+		reply := testNodeStatus(query, nil, NetBIOSName{Name: "NAS", Suffix: NetBIOSWorkstation}, NetBIOSName{Name: "WORKGROUP", Suffix: NetBIOSWorkstation, Group: true})
+		entry := len(reply) - 46 - 2*18
+		copy(reply[entry+3:entry+15], make([]byte, 12))
+		return [][]byte{reply}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	status, err := QueryNodeStatus(ctx, server.addr)
+	if err != nil {
+		t.Fatalf("QueryNodeStatus: %v", err)
+	}
+	if status.Name != "NAS" || status.Domain != "WORKGROUP" || status.MAC != "" || len(status.Users) != 0 {
+		t.Errorf("status = %+v, want NAS in WORKGROUP without a MAC or users", status)
+	}
+}
+
+func TestQueryNodeStatusErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply func(query []byte) []byte
+	}{
+		{"truncated", func(query []byte) []byte {
+			reply := testNodeStatus(query, nil, testNames...)
+			return reply[:len(reply)-50]
+		}},
+		{"name error", func(query []byte) []byte {
+			reply := testNodeStatus(query, nil, testNames...)
+			reply[3] |= RCodeNameError
+			return reply
+		}},
+		{"no answer", func(query []byte) []byte {
+			reply := testNodeStatus(query, nil)
+			binary.BigEndian.PutUint16(reply[6:], 0)
+			return reply
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestResponder(t, "127.0.0.1", func(query []byte, tcp bool) [][]byte {
+				return [][]byte{test.reply(query)}
+			})
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			start := time.Now()
+			if status, err := QueryNodeStatus(ctx, server.addr); err == nil {
+				t.Errorf("QueryNodeStatus = %+v, want an error", status)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("QueryNodeStatus took %v to give up on a bad reply", elapsed)
+			}
+		})
+	}
+
+	// A reply to another query is ignored until the node answers
+	server := newTestResponder(t, "127.0.0.1", func(query []byte, tcp bool) [][]byte {
+		stray := testNodeStatus(query, nil, NetBIOSName{Name: "SPOOFED"})
+		binary.BigEndian.PutUint16(stray, binary.BigEndian.Uint16(query)^0xffff)
+		return [][]byte{stray, testNodeStatus(query, nil, NetBIOSName{Name: "REAL"})}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if status, err := QueryNodeStatus(ctx, server.addr); err != nil || status.Name != "REAL" {
+		t.Errorf("QueryNodeStatus = %+v, %v; want REAL", status, err)
+	}
+}
+
+func TestParseNodeStatusMalformed(t *testing.T) {
+	query := buildNodeStatusQuery(0x1234)
+	valid := testNodeStatus(query, []byte{0x00, 0x15, 0x5d, 0x0a, 0xbc, 0xde}, testNames...)
+	if _, err := parseNodeStatus(valid); err != nil {
+		t.Fatalf("parseNodeStatus of a valid reply: %v", err)
+	}
+
+	// Every reply cut short must be refused rather than read past its end
+	for n := range len(valid) {
+		if status, err := parseNodeStatus(valid[:n]); err == nil {
+			t.Errorf("parseNodeStatus of the first %d bytes = %+v, want an error", n, status)
+		}
+	}
+
+	answer := len(query) - 4
+	rdata := answer + 10
+	tests := []struct {
+		name   string
+		modify func(reply []byte) []byte
+	}{
+		{"a query", func(reply []byte) []byte {
+			reply[2] &^= 0x80
+			return reply
+		}},
+		{"another record type", func(reply []byte) []byte {
+			binary.BigEndian.PutUint16(reply[answer:], dnsTypePTR)
+			return reply
+		}},
+		{"more names than data", func(reply []byte) []byte {
+			reply[rdata] = 0xff
+			return reply
+		}},
+		{"data length past the end", func(reply []byte) []byte {
+			binary.BigEndian.PutUint16(reply[answer+8:], 0xffff)
+			return reply
+		}},
+		{"label past the end", func(reply []byte) []byte {
+			return append(reply[:dnsHeaderLen], 0x3f, 'A')
+		}},
+		{"unterminated name", func(reply []byte) []byte {
+			return append(reply[:dnsHeaderLen], 1, 'A', 1, 'B')
+		}},
+		{"compression pointer at the end", func(reply []byte) []byte {
+			return append(reply[:dnsHeaderLen], 0xc0)
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reply := test.modify(slices.Clone(valid))
+			if status, err := parseNodeStatus(reply); err == nil {
+				t.Errorf("parseNodeStatus = %+v, want an error", status)
+			}
+		})
+	}
+
+	// A compressed question name is followed like a repeated one
+	compressed := append(slices.Clone(valid[:dnsHeaderLen]), 0xc0, dnsHeaderLen)
+	compressed = append(compressed, valid[answer:]...)
+	status, err := parseNodeStatus(compressed)
+	if err != nil || status.Name != "FILESRV" {
+		t.Errorf("parseNodeStatus of a compressed reply = %+v, %v; want FILESRV", status, err)
+	}
+}
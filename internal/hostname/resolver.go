@@ -1,248 +1,273 @@
 package hostname
 
 import (
-	"bufio"
+	"context"
 	"fmt"
-	"net"
-	"os"
-	"os/exec"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
-
-	"github.com/CyberOakAlpha/CrossNet/internal/osdetect"
+	"time"
 )
 
-type HostnameResolver struct {
-	cache map[string]string
-	mutex sync.RWMutex
+// Method is one way of finding the names of an address, such as reverse
+// DNS or a NetBIOS status query. Implement it and Register the result to
+// add a resolver of your own.
+type Method interface {
+	// Name identifies the method in configuration and in results.
+	Name() string
+	// Lookup returns the names the method knows for ip, best first. It
+	// should give up once ctx is done.
+	Lookup(ctx context.Context, ip string) []string
 }
 
-func NewHostnameResolver() *HostnameResolver {
-	return &HostnameResolver{
-		cache: make(map[string]string),
-	}
+type methodFunc struct {
+	name   string
+	lookup func(context.Context, string) []string
 }
 
-func (hr *HostnameResolver) Resolve(ip string) string {
-	// Check cache first
-	hr.mutex.RLock()
-	if hostname, exists := hr.cache[ip]; exists {
-		hr.mutex.RUnlock()
-		return hostname
-	}
-	hr.mutex.RUnlock()
+func (m methodFunc) Name() string {
+	return m.name
+}
 
-	// Try multiple resolution methods
-	hostname := hr.resolveMultiple(ip)
+func (m methodFunc) Lookup(ctx context.Context, ip string) []string {
+	return m.lookup(ctx, ip)
+}
 
-	// Cache the result (even if empty)
-	hr.mutex.Lock()
-	hr.cache[ip] = hostname
-	hr.mutex.Unlock()
+// NewMethod returns a Method called name that looks names up with lookup.
+func NewMethod(name string, lookup func(ctx context.Context, ip string) []string) Method {
+	return methodFunc{name: name, lookup: lookup}
+}
 
-	return hostname
+// MethodConfig is a Method together with how long each lookup may take.
+type MethodConfig struct {
+	Method  Method
+	Timeout time.Duration
 }
 
-func (hr *HostnameResolver) resolveMultiple(ip string) string {
-	methods := []func(string) string{
-		hr.resolveReverseDNS,
-		hr.resolveFromHosts,
-		hr.resolveNetBIOS,
-		hr.resolveMDNS,
-	}
+// Name is a name found for an address and the method that found it.
+type Name struct {
+	Name   string
+	Method string
+}
 
-	for _, method := range methods {
-		if hostname := method(ip); hostname != "" {
-			return hostname
-		}
-	}
+// Result is everything the methods found out about an address.
+type Result struct {
+	Names []Name
+	// NodeStatus is the answer to the NetBIOS node status query of the
+	// netbios method, if the host gave one.
+	NodeStatus *NodeStatus
+	// Services are the DNS-SD services the mdns method found on the host.
+	Services []Service
+}
 
-	return ""
+// detailMethod is implemented by the built-in methods that learn more
+// about a host than its names, such as netbios and mdns. They fill in
+// their part of result.
+type detailMethod interface {
+	lookupDetails(ctx context.Context, ip string, result *Result) []string
 }
 
-// Method 1: Standard reverse DNS lookup
-func (hr *HostnameResolver) resolveReverseDNS(ip string) string {
-	names, err := net.LookupAddr(ip)
-	if err == nil && len(names) > 0 {
-		hostname := names[0]
-		// Remove trailing dot if present
-		if strings.HasSuffix(hostname, ".") {
-			hostname = hostname[:len(hostname)-1]
-		}
-		return hostname
-	}
-	return ""
+var (
+	registryMutex sync.RWMutex
+	registry      = make(map[string]MethodConfig)
+	// defaultOrder lists the methods NewHostnameResolver uses
+	defaultOrder []string
+)
+
+// Register makes method available to ParseMethods under its name, with
+// timeout as its default time limit. A method registered under the name of
+// another replaces it.
+func Register(method Method, timeout time.Duration) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	registry[method.Name()] = MethodConfig{Method: method, Timeout: timeout}
 }
 
-// Method 2: Check /etc/hosts file
-func (hr *HostnameResolver) resolveFromHosts(ip string) string {
-	if osdetect.DetectOS() == osdetect.Windows {
-		return hr.resolveFromWindowsHosts(ip)
-	}
-	return hr.resolveFromUnixHosts(ip)
+// MethodNames returns the names of the registered methods in alphabetical
+// order.
+func MethodNames() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	return registeredNames()
 }
 
-func (hr *HostnameResolver) resolveFromUnixHosts(ip string) string {
-	file, err := os.Open("/etc/hosts")
-	if err != nil {
-		return ""
+// registeredNames lists the registry. The caller holds registryMutex.
+func registeredNames() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
 	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if strings.HasPrefix(line, "#") || line == "" {
-			continue
-		}
+	sort.Strings(names)
+	return names
+}
 
-		fields := strings.Fields(line)
-		if len(fields) >= 2 && fields[0] == ip {
-			return fields[1]
-		}
-	}
-	return ""
+// DefaultMethods returns the built-in methods in their default order: reverse
+// DNS, the hosts file, NetBIOS, LLMNR and mDNS.
+func DefaultMethods() []MethodConfig {
+	methods, _ := ParseMethods(strings.Join(defaultOrder, ","))
+	return methods
 }
 
-func (hr *HostnameResolver) resolveFromWindowsHosts(ip string) string {
-	// Windows hosts file location
-	cmd := exec.Command("type", "C:\\Windows\\System32\\drivers\\etc\\hosts")
-	output, err := cmd.Output()
-	if err != nil {
-		return ""
+// ParseMethods reads a comma-separated list of registered method names in
+// order of preference. A name may be followed by a colon and a timeout,
+// for example "dns:500ms,netbios:3s". Methods left out are disabled; "none"
+// disables them all and an empty list selects DefaultMethods.
+func ParseMethods(spec string) ([]MethodConfig, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return DefaultMethods(), nil
+	}
+	if strings.EqualFold(spec, "none") {
+		return nil, nil
 	}
 
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "#") || line == "" {
-			continue
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	var methods []MethodConfig
+	var seen []string
+	for _, part := range strings.Split(spec, ",") {
+		name, timeoutSpec, hasTimeout := strings.Cut(strings.TrimSpace(part), ":")
+		name = strings.ToLower(strings.TrimSpace(name))
+		config, ok := registry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown resolution method %q (known: %s)", name, strings.Join(registeredNames(), ", "))
+		}
+		if slices.Contains(seen, name) {
+			return nil, fmt.Errorf("resolution method %q listed twice", name)
 		}
+		seen = append(seen, name)
 
-		fields := strings.Fields(line)
-		if len(fields) >= 2 && fields[0] == ip {
-			return fields[1]
+		if hasTimeout {
+			timeout, err := time.ParseDuration(strings.TrimSpace(timeoutSpec))
+			if err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("invalid timeout %q for resolution method %q", timeoutSpec, name)
+			}
+			config.Timeout = timeout
 		}
+		methods = append(methods, config)
 	}
-	return ""
+	return methods, nil
 }
 
-// Method 3: NetBIOS name resolution (for Windows networks)
-func (hr *HostnameResolver) resolveNetBIOS(ip string) string {
-	switch osdetect.DetectOS() {
-	case osdetect.Linux:
-		return hr.resolveNetBIOSLinux(ip)
-	case osdetect.Windows:
-		return hr.resolveNetBIOSWindows(ip)
-	default:
-		return ""
+// ReplaceMethod returns a copy of methods in which the method with the
+// name of method is swapped for it, keeping its place and timeout. This is
+// how NewDNSMethod takes over from the system resolver.
+func ReplaceMethod(methods []MethodConfig, method Method) []MethodConfig {
+	methods = slices.Clone(methods)
+	for i := range methods {
+		if methods[i].Method.Name() == method.Name() {
+			methods[i].Method = method
+		}
 	}
+	return methods
 }
 
-func (hr *HostnameResolver) resolveNetBIOSLinux(ip string) string {
-	// Try nmblookup if available
-	cmd := exec.Command("timeout", "3", "nmblookup", "-A", ip)
-	output, err := cmd.Output()
-	if err != nil {
-		return ""
-	}
-
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		if strings.Contains(line, "<00>") && strings.Contains(line, "UNIQUE") {
-			fields := strings.Fields(line)
-			if len(fields) > 0 {
-				name := strings.TrimSpace(fields[0])
-				if name != "" && !strings.HasPrefix(name, "__") {
-					return name
-				}
-			}
-		}
-	}
-	return ""
+// HostnameResolver looks addresses up with every configured method and
+// caches what each one found.
+type HostnameResolver struct {
+	methods []MethodConfig
+	cache   map[string]Result
+	mutex   sync.RWMutex
 }
 
-func (hr *HostnameResolver) resolveNetBIOSWindows(ip string) string {
-	// Use nbtstat on Windows
-	cmd := exec.Command("nbtstat", "-A", ip)
-	output, err := cmd.Output()
-	if err != nil {
-		return ""
+func NewHostnameResolver() *HostnameResolver {
+	return &HostnameResolver{
+		methods: DefaultMethods(),
+		cache:   make(map[string]Result),
 	}
+}
 
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		if strings.Contains(line, "<00>") && strings.Contains(line, "UNIQUE") {
-			fields := strings.Fields(line)
-			if len(fields) > 0 {
-				return strings.TrimSpace(fields[0])
-			}
-		}
-	}
-	return ""
+// SetMethods replaces the methods of later lookups, which are reported in
+// the order given, and clears the cache.
+func (hr *HostnameResolver) SetMethods(methods []MethodConfig) {
+	hr.mutex.Lock()
+	defer hr.mutex.Unlock()
+	hr.methods = slices.Clone(methods)
+	hr.cache = make(map[string]Result)
 }
 
-// Method 4: mDNS/Bonjour resolution (for Apple devices and modern networks)
-func (hr *HostnameResolver) resolveMDNS(ip string) string {
-	switch osdetect.DetectOS() {
-	case osdetect.Linux:
-		return hr.resolveMDNSLinux(ip)
-	case osdetect.Darwin:
-		return hr.resolveMDNSDarwin(ip)
-	default:
-		return ""
-	}
+func (hr *HostnameResolver) Resolve(ip string) string {
+	return hr.ResolveContext(context.Background(), ip)
 }
 
-func (hr *HostnameResolver) resolveMDNSLinux(ip string) string {
-	// Try avahi-resolve if available
-	cmd := exec.Command("timeout", "2", "avahi-resolve", "-a", ip)
-	output, err := cmd.Output()
-	if err != nil {
+// ResolveContext is like Resolve but gives up, without caching anything,
+// once ctx is cancelled. Queries still in flight are abandoned with it.
+func (hr *HostnameResolver) ResolveContext(ctx context.Context, ip string) string {
+	result := hr.ResolveAll(ctx, ip)
+	if len(result.Names) == 0 {
 		return ""
 	}
+	return result.Names[0].Name
+}
 
-	line := strings.TrimSpace(string(output))
-	fields := strings.Fields(line)
-	if len(fields) >= 2 {
-		hostname := fields[1]
-		// Remove .local suffix
-		if strings.HasSuffix(hostname, ".local") {
-			hostname = hostname[:len(hostname)-6]
-		}
-		return hostname
+// ResolveAll returns every name found for ip, grouped by method in the
+// configured order, and what else the methods learnt. The methods run
+// concurrently, each within its own timeout, so that a slow one does not
+// hold up the others. Nothing is cached once ctx is cancelled.
+func (hr *HostnameResolver) ResolveAll(ctx context.Context, ip string) Result {
+	hr.mutex.RLock()
+	result, exists := hr.cache[ip]
+	methods := hr.methods
+	hr.mutex.RUnlock()
+	if exists {
+		result.Names = slices.Clone(result.Names)
+		result.Services = slices.Clone(result.Services)
+		return result
 	}
-	return ""
-}
 
-func (hr *HostnameResolver) resolveMDNSDarwin(ip string) string {
-	// Try dns-sd on macOS
-	cmd := exec.Command("dns-sd", "-q", ip, "PTR")
-	output, err := cmd.Output()
-	if err != nil {
-		return ""
+	found := make([][]string, len(methods))
+	details := make([]Result, len(methods))
+	var wg sync.WaitGroup
+	for i, config := range methods {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lookupCtx, cancel := context.WithTimeout(ctx, config.Timeout)
+			defer cancel()
+			if method, ok := config.Method.(detailMethod); ok {
+				found[i] = method.lookupDetails(lookupCtx, ip, &details[i])
+				return
+			}
+			found[i] = config.Method.Lookup(lookupCtx, ip)
+		}()
 	}
+	wg.Wait()
 
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		if strings.Contains(line, ".local.") {
-			fields := strings.Fields(line)
-			for _, field := range fields {
-				if strings.HasSuffix(field, ".local.") {
-					hostname := field[:len(field)-7] // Remove ".local."
-					return hostname
-				}
+	result = Result{}
+	for i, config := range methods {
+		if details[i].NodeStatus != nil {
+			result.NodeStatus = details[i].NodeStatus
+		}
+		result.Services = append(result.Services, details[i].Services...)
+		for _, name := range found[i] {
+			name = strings.TrimSpace(name)
+			if name == "" || slices.Contains(result.Names, Name{Name: name, Method: config.Method.Name()}) {
+				continue
 			}
+			result.Names = append(result.Names, Name{Name: name, Method: config.Method.Name()})
 		}
 	}
-	return ""
+	if ctx.Err() != nil {
+		return result
+	}
+
+	// Cache the result (even if empty)
+	hr.mutex.Lock()
+	hr.cache[ip] = result
+	hr.mutex.Unlock()
+
+	result.Names = slices.Clone(result.Names)
+	result.Services = slices.Clone(result.Services)
+	return result
 }
 
 // Clear the cache
 func (hr *HostnameResolver) ClearCache() {
 	hr.mutex.Lock()
 	defer hr.mutex.Unlock()
-	hr.cache = make(map[string]string)
+	hr.cache = make(map[string]Result)
 }
 
 // Get cache statistics
@@ -251,11 +276,11 @@ func (hr *HostnameResolver) GetCacheStats() (int, []string) {
 	defer hr.mutex.RUnlock()
 
 	var resolved []string
-	for ip, hostname := range hr.cache {
-		if hostname != "" {
-			resolved = append(resolved, fmt.Sprintf("%s -> %s", ip, hostname))
+	for ip, result := range hr.cache {
+		for _, name := range result.Names {
+			resolved = append(resolved, fmt.Sprintf("%s -> %s (%s)", ip, name.Name, name.Method))
 		}
 	}
 
 	return len(hr.cache), resolved
-}
\ No newline at end of file
+}
@@ -7,10 +7,21 @@ import (
 )
 
 type NetworkInterface struct {
-	Name    string `json:"name"`
+	Name      string             `json:"name"`
+	IP        string             `json:"ip"`
+	Network   string             `json:"network"`
+	MAC       string             `json:"mac"`
+	Addresses []InterfaceAddress `json:"addresses"`
+}
+
+// InterfaceAddress is one IPv4 or IPv6 address of an interface together
+// with the prefix it was actually configured with.
+type InterfaceAddress struct {
 	IP      string `json:"ip"`
+	Prefix  int    `json:"prefix"`
 	Network string `json:"network"`
-	MAC     string `json:"mac"`
+	Family  string `json:"family"`
+	Scope   string `json:"scope"`
 }
 
 func GetCurrentIP() (string, string, error) {
@@ -30,21 +41,14 @@ func GetCurrentIP() (string, string, error) {
 		}
 
 		for _, addr := range addrs {
-			var ip net.IP
-			switch v := addr.(type) {
-			case *net.IPNet:
-				ip = v.IP
-			case *net.IPAddr:
-				ip = v.IP
-			}
-
-			if ip == nil || ip.IsLoopback() {
+			// Only an IPNet carries the prefix the address was configured with
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.IsLoopback() {
 				continue
 			}
 
-			if ip.To4() != nil {
-				network := calculateNetwork(ip.String())
-				return ip.String(), network, nil
+			if ipNet.IP.To4() != nil {
+				return ipNet.IP.String(), prefixNetwork(ipNet), nil
 			}
 		}
 	}
@@ -76,26 +80,20 @@ func GetAllNetworkInterfaces() ([]NetworkInterface, error) {
 		}
 
 		for _, addr := range addrs {
-			var ip net.IP
-			switch v := addr.(type) {
-			case *net.IPNet:
-				ip = v.IP
-			case *net.IPAddr:
-				ip = v.IP
-			}
-
-			if ip == nil || ip.IsLoopback() {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.IsLoopback() {
 				continue
 			}
 
-			if ip.To4() != nil {
-				netIface.IP = ip.String()
-				netIface.Network = calculateNetwork(ip.String())
-				break
+			netIface.Addresses = append(netIface.Addresses, newInterfaceAddress(ipNet))
+
+			if ipNet.IP.To4() != nil && netIface.IP == "" {
+				netIface.IP = ipNet.IP.String()
+				netIface.Network = prefixNetwork(ipNet)
 			}
 		}
 
-		if netIface.IP != "" {
+		if len(netIface.Addresses) > 0 {
 			interfaces = append(interfaces, netIface)
 		}
 	}
@@ -103,13 +101,63 @@ func GetAllNetworkInterfaces() ([]NetworkInterface, error) {
 	return interfaces, nil
 }
 
-func calculateNetwork(ip string) string {
-	parts := strings.Split(ip, ".")
-	if len(parts) != 4 {
-		return ""
+// GetCurrentIPv6 returns the first global IPv6 address of an active
+// interface together with its real prefix, e.g. 2001:db8:1::10 and
+// 2001:db8:1::/64.
+func GetCurrentIPv6() (string, string, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get interfaces: %v", err)
+	}
+
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.To4() != nil || !ipNet.IP.IsGlobalUnicast() {
+				continue
+			}
+
+			return ipNet.IP.String(), prefixNetwork(ipNet), nil
+		}
+	}
+
+	return "", "", fmt.Errorf("no global IPv6 address found")
+}
+
+func newInterfaceAddress(ipNet *net.IPNet) InterfaceAddress {
+	ones, _ := ipNet.Mask.Size()
+
+	address := InterfaceAddress{
+		IP:      ipNet.IP.String(),
+		Prefix:  ones,
+		Network: prefixNetwork(ipNet),
+		Family:  "ipv6",
+		Scope:   "global",
 	}
+	if ipNet.IP.To4() != nil {
+		address.Family = "ipv4"
+	}
+	if ipNet.IP.IsLinkLocalUnicast() {
+		address.Scope = "link"
+	}
+
+	return address
+}
 
-	return fmt.Sprintf("%s.%s.%s.0/24", parts[0], parts[1], parts[2])
+// prefixNetwork returns the network an interface address belongs to in
+// CIDR notation, e.g. 10.1.0.0/16 for 10.1.2.3 with a /16 mask.
+func prefixNetwork(ipNet *net.IPNet) string {
+	prefix := &net.IPNet{IP: ipNet.IP.Mask(ipNet.Mask), Mask: ipNet.Mask}
+	return prefix.String()
 }
 
 func GetDefaultGateway() (string, error) {
@@ -0,0 +1,64 @@
+// Command gen rebuilds the registry embedded in package oui from the CSV
+// files published by the IEEE Registration Authority:
+//
+//	go run ./internal/oui/gen -o internal/oui/data/oui.csv.gz oui.csv mam.csv oui36.csv
+//
+// "make oui" downloads the files and runs it.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/CyberOakAlpha/CrossNet/internal/oui"
+)
+
+func main() {
+	output := flag.String("o", "data/oui.csv.gz", "File to write the registry to")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: gen [-o file] oui.csv mam.csv oui36.csv")
+		os.Exit(2)
+	}
+
+	registry := oui.New()
+	for _, name := range flag.Args() {
+		file, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		n, err := registry.Load(file)
+		file.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s: %d assignments\n", name, n)
+	}
+
+	// A registry missing a block size would resolve those blocks to their
+	// MA-L holder, so all three files are required
+	for _, block := range []struct {
+		name string
+		bits int
+	}{{"MA-L", 24}, {"MA-M", 28}, {"MA-S", 36}} {
+		if registry.Count(block.bits) == 0 {
+			fmt.Fprintf(os.Stderr, "Error: no %s assignments loaded; pass oui.csv, mam.csv and oui36.csv\n", block.name)
+			os.Exit(1)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := registry.Write(&buf); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(*output, buf.Bytes(), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote %d assignments to %s (%d bytes)\n", registry.Len(), *output, buf.Len())
+}
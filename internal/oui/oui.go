@@ -0,0 +1,276 @@
+// Package oui maps MAC addresses to the organisation the IEEE assigned
+// them to. Lookups cover MA-L (24-bit), MA-M (28-bit) and MA-S (36-bit)
+// assignments. The registry embedded in the binary holds the MA-L
+// assignments and can be completed offline with the CSV files published by
+// the IEEE Registration Authority.
+package oui
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//go:embed data/oui.csv.gz
+var embedded []byte
+
+// prefixLengths are the sizes in bits of the MA-S, MA-M and MA-L blocks,
+// longest first so that Lookup finds the most specific assignment.
+var prefixLengths = [...]int{36, 28, 24}
+
+// Registry holds IEEE assignments keyed by prefix. The zero value is not
+// usable; create one with New or Parse.
+type Registry struct {
+	prefixes [len(prefixLengths)]map[uint64]string
+}
+
+func New() *Registry {
+	r := &Registry{}
+	for i := range r.prefixes {
+		r.prefixes[i] = make(map[uint64]string)
+	}
+	return r
+}
+
+// Parse reads a registry from r. See Registry.Load for the formats
+// accepted.
+func Parse(r io.Reader) (*Registry, error) {
+	registry := New()
+	if _, err := registry.Load(r); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+// Load adds the assignments in r to the registry, replacing any with the
+// same prefix, and returns how many were read. rd may be one of the IEEE
+// files oui.csv, mam.csv or oui36.csv, whose rows start with the registry
+// name, or the two-column form written by Write. Either may be gzipped.
+func (r *Registry) Load(rd io.Reader) (int, error) {
+	buffered := bufio.NewReader(rd)
+	if magic, _ := buffered.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decompress registry: %v", err)
+		}
+		defer gz.Close()
+		buffered = bufio.NewReader(gz)
+	}
+
+	reader := csv.NewReader(buffered)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	count := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, fmt.Errorf("failed to read registry: %v", err)
+		}
+
+		switch strings.TrimSpace(record[0]) {
+		case "Registry", "Assignment":
+			// Header row
+			continue
+		case "MA-L", "MA-M", "MA-S":
+			record = record[1:]
+		}
+		if len(record) < 2 {
+			continue
+		}
+
+		bits, prefix, ok := parseAssignment(record[0])
+		organization := strings.Join(strings.Fields(record[1]), " ")
+		if !ok || organization == "" {
+			continue
+		}
+		r.prefixes[slices.Index(prefixLengths[:], bits)][prefix] = organization
+		count++
+	}
+	return count, nil
+}
+
+// parseAssignment converts an assignment such as "00000C", "70B3D5F" or
+// "70B3D5E0C" to its length in bits and value.
+func parseAssignment(s string) (int, uint64, bool) {
+	s = strings.TrimSpace(s)
+	bits := len(s) * 4
+	if !slices.Contains(prefixLengths[:], bits) {
+		return 0, 0, false
+	}
+	prefix, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return bits, prefix, true
+}
+
+// Lookup returns the organisation holding the longest assignment that
+// contains mac, or "" if there is none.
+func (r *Registry) Lookup(mac string) string {
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) != 6 {
+		return ""
+	}
+	var value uint64
+	for _, b := range hw {
+		value = value<<8 | uint64(b)
+	}
+	for i, bits := range prefixLengths {
+		if organization, ok := r.prefixes[i][value>>(48-bits)]; ok {
+			return organization
+		}
+	}
+	return ""
+}
+
+// Len returns the number of assignments in the registry.
+func (r *Registry) Len() int {
+	n := 0
+	for _, prefixes := range r.prefixes {
+		n += len(prefixes)
+	}
+	return n
+}
+
+// Count returns the number of assignments that are bits long: 24 for
+// MA-L, 28 for MA-M or 36 for MA-S.
+func (r *Registry) Count(bits int) int {
+	i := slices.Index(prefixLengths[:], bits)
+	if i < 0 {
+		return 0
+	}
+	return len(r.prefixes[i])
+}
+
+// Write stores the registry as a gzipped CSV of assignment and
+// organisation, sorted by assignment so that the output is reproducible.
+func (r *Registry) Write(w io.Writer) error {
+	var rows [][]string
+	for i, bits := range prefixLengths {
+		for prefix, organization := range r.prefixes[i] {
+			rows = append(rows, []string{fmt.Sprintf("%0*X", bits/4, prefix), organization})
+		}
+	}
+	slices.SortFunc(rows, func(a, b []string) int { return strings.Compare(a[0], b[0]) })
+
+	gz, _ := gzip.NewWriterLevel(w, gzip.BestCompression)
+	writer := csv.NewWriter(gz)
+	writer.Write([]string{"Assignment", "Organization Name"})
+	writer.WriteAll(rows)
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to write registry: %v", err)
+	}
+	return gz.Close()
+}
+
+// IsLocallyAdministered reports whether mac has the U/L bit set, meaning
+// it was not assigned by the IEEE and its prefix identifies no vendor.
+// Phones and laptops use such addresses when randomising their MAC for
+// privacy, and hypervisors make them up for virtual machines.
+func IsLocallyAdministered(mac string) bool {
+	hw, err := net.ParseMAC(mac)
+	return err == nil && len(hw) > 0 && hw[0]&0x02 != 0
+}
+
+var (
+	defaultOnce     sync.Once
+	defaultRegistry *Registry
+)
+
+// Default returns the embedded registry overlaid with the one written by
+// Import, if any. It is loaded on first use.
+func Default() *Registry {
+	defaultOnce.Do(func() {
+		registry, err := Parse(bytes.NewReader(embedded))
+		if err != nil {
+			registry = New()
+		}
+		if path, err := UserFile(); err == nil {
+			if file, err := os.Open(path); err == nil {
+				registry.Load(file)
+				file.Close()
+			}
+		}
+		defaultRegistry = registry
+	})
+	return defaultRegistry
+}
+
+// Vendor looks mac up in the default registry. Locally administered
+// addresses never match a vendor.
+func Vendor(mac string) string {
+	if IsLocallyAdministered(mac) {
+		return ""
+	}
+	return Default().Lookup(mac)
+}
+
+// UserFile returns where Import stores the registry, under the user's
+// configuration directory.
+func UserFile() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "crossnet", "oui.csv.gz"), nil
+}
+
+// Import loads the IEEE CSV files at paths and adds them to the registry
+// kept in UserFile, which Default reads on top of the embedded one. It
+// returns the path written and the number of assignments imported.
+func Import(paths ...string) (string, int, error) {
+	path, err := UserFile()
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to locate configuration directory: %v", err)
+	}
+
+	registry := New()
+	if file, err := os.Open(path); err == nil {
+		registry.Load(file)
+		file.Close()
+	}
+
+	imported := 0
+	for _, name := range paths {
+		file, err := os.Open(name)
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to open %s: %v", name, err)
+		}
+		n, err := registry.Load(file)
+		file.Close()
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to import %s: %v", name, err)
+		}
+		if n == 0 {
+			return "", 0, fmt.Errorf("no assignments found in %s", name)
+		}
+		imported += n
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", 0, fmt.Errorf("failed to create %s: %v", filepath.Dir(path), err)
+	}
+	var buf bytes.Buffer
+	if err := registry.Write(&buf); err != nil {
+		return "", 0, err
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return "", 0, fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return path, imported, nil
+}
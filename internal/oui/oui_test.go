@@ -0,0 +1,171 @@
+package oui
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+)
+
+// ieeeCSV follows the layout of the IEEE oui.csv, mam.csv and oui36.csv
+// files, with one block of each size nested in the one before it.
+const ieeeCSV = `Registry,Assignment,Organization Name,Organization Address
+MA-L,70B3D5,IEEE Registration Authority,445 Hoes Lane Piscataway NJ US 08554
+MA-M,70B3D5E,"Medium   Block, Inc.",1 Main Street Springfield US
+MA-S,70B3D5E0C,Small Block Ltd,2 High Street Cambridge GB
+MA-L,00000C,"Cisco Systems, Inc",170 West Tasman Drive San Jose CA US 95134
+`
+
+func TestLookup(t *testing.T) {
+	registry, err := Parse(strings.NewReader(ieeeCSV))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	tests := []struct {
+		mac  string
+		want string
+	}{
+		{"70:B3:D5:E0:C1:23", "Small Block Ltd"},
+		{"70-b3-d5-e0-cf-ff", "Small Block Ltd"},
+		{"70:B3:D5:E0:D0:00", "Medium Block, Inc."},
+		{"70:B3:D5:EF:FF:FF", "Medium Block, Inc."},
+		{"70:B3:D5:F0:00:00", "IEEE Registration Authority"},
+		{"70:B3:D5:00:00:01", "IEEE Registration Authority"},
+		{"00:00:0C:07:AC:01", "Cisco Systems, Inc"},
+		{"00:00:0D:00:00:01", ""},
+		// Only 48-bit addresses are looked up
+		{"70:B3:D5:E0:C1:23:45:67", ""},
+		{"not a mac", ""},
+	}
+	for _, test := range tests {
+		if got := registry.Lookup(test.mac); got != test.want {
+			t.Errorf("Lookup(%q) = %q, want %q", test.mac, got, test.want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	malformed := ieeeCSV + `MA-L,ZZZZZZ,Not Hex Corp,Nowhere
+MA-L,12345,Short Prefix Corp,Nowhere
+MA-M,1234567890,Long Prefix Corp,Nowhere
+MA-L,001122,   ,Nowhere
+MA-L
+`
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write([]byte(malformed))
+	gz.Close()
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"plain", []byte(malformed)},
+		{"gzipped", gzipped.Bytes()},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			registry := New()
+			n, err := registry.Load(bytes.NewReader(test.data))
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if n != 4 || registry.Len() != 4 {
+				t.Errorf("Load read %d assignments and holds %d, want 4", n, registry.Len())
+			}
+			for bits, want := range map[int]int{24: 2, 28: 1, 36: 1, 32: 0} {
+				if got := registry.Count(bits); got != want {
+					t.Errorf("Count(%d) = %d, want %d", bits, got, want)
+				}
+			}
+			if got := registry.Lookup("70:B3:D5:E0:C1:23"); got != "Small Block Ltd" {
+				t.Errorf("Lookup of an MA-S address = %q, want Small Block Ltd", got)
+			}
+			if got := registry.Lookup("00:11:22:33:44:55"); got != "" {
+				t.Errorf("Lookup of a row without organisation = %q, want none", got)
+			}
+		})
+	}
+
+	// A later load replaces the organisation of an existing prefix
+	registry, _ := Parse(strings.NewReader(ieeeCSV))
+	if _, err := registry.Load(strings.NewReader("MA-L,00000C,Cisco Meraki,\n")); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got := registry.Lookup("00:00:0C:00:00:01"); got != "Cisco Meraki" || registry.Len() != 4 {
+		t.Errorf("after reload: Lookup = %q with %d assignments, want Cisco Meraki with 4", got, registry.Len())
+	}
+
+	// A stream that claims to be gzipped but is not fails to load
+	if _, err := Parse(bytes.NewReader([]byte{0x1f, 0x8b, 0x00})); err == nil {
+		t.Error("Parse of a corrupt gzip stream succeeded")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	registry, err := Parse(strings.NewReader(ieeeCSV))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := registry.Write(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	written := buf.Bytes()
+
+	loaded, err := Parse(bytes.NewReader(written))
+	if err != nil {
+		t.Fatalf("Parse of written registry: %v", err)
+	}
+	if loaded.Len() != registry.Len() {
+		t.Errorf("written registry holds %d assignments, want %d", loaded.Len(), registry.Len())
+	}
+	for _, mac := range []string{"70:B3:D5:E0:C1:23", "70:B3:D5:E0:D0:00", "70:B3:D5:00:00:01", "00:00:0C:07:AC:01"} {
+		if got, want := loaded.Lookup(mac), registry.Lookup(mac); got != want {
+			t.Errorf("Lookup(%q) after round trip = %q, want %q", mac, got, want)
+		}
+	}
+
+	// The output is sorted, so writing it again gives the same bytes
+	buf.Reset()
+	if err := loaded.Write(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), written) {
+		t.Error("writing a registry twice gave different output")
+	}
+}
+
+func TestIsLocallyAdministered(t *testing.T) {
+	tests := []struct {
+		mac  string
+		want bool
+	}{
+		{"00:00:0C:07:AC:01", false},
+		{"02:00:00:00:00:01", true},
+		{"DA:A1:19:12:34:56", true},
+		{"ff:ff:ff:ff:ff:ff", true},
+		{"01:00:5E:00:00:FB", false},
+		{"not a mac", false},
+	}
+	for _, test := range tests {
+		if got := IsLocallyAdministered(test.mac); got != test.want {
+			t.Errorf("IsLocallyAdministered(%q) = %v, want %v", test.mac, got, test.want)
+		}
+	}
+
+	// The IEEE assigned 02608C before the U/L bit was defined, but a MAC
+	// with that bit set is taken as made up and gets no vendor
+	const mac = "02:60:8C:12:34:56"
+	if Default().Lookup(mac) == "" {
+		t.Fatalf("embedded registry has no assignment for %s", mac)
+	}
+	if got := Vendor(mac); got != "" {
+		t.Errorf("Vendor(%q) = %q, want none", mac, got)
+	}
+	if got := Vendor("00:00:0C:07:AC:01"); got == "" {
+		t.Error("Vendor of a Cisco MAC found none")
+	}
+}
@@ -2,148 +2,356 @@ package scanner
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"iter"
+	"net"
+	"net/netip"
 	"os/exec"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
-	"github.com/CyberOakAlpha/CrossNet/internal/hostname"
 	"github.com/CyberOakAlpha/CrossNet/internal/osdetect"
+	"github.com/CyberOakAlpha/CrossNet/internal/oui"
+	"github.com/CyberOakAlpha/CrossNet/internal/targets"
 )
 
 type ARPEntry struct {
-	IP          string
-	MAC         string
-	Hostname    string
-	Vendor      string
-	Online      bool
-	Error       string
+	IP     string
+	MAC    string
+	Vendor string
+	// LocallyAdministered is set for MACs that were not assigned by the
+	// IEEE, typically randomised for privacy, which have no vendor
+	LocallyAdministered bool
+	Online              bool
+	Error               string
 }
 
+// identify fills in the vendor of the entry's MAC.
+func (e *ARPEntry) identify() {
+	e.LocallyAdministered = oui.IsLocallyAdministered(e.MAC)
+	e.Vendor = oui.Vendor(e.MAC)
+}
+
+const (
+	// neighborReplyWait is how long ARP and Neighbor Discovery sweeps wait
+	// for stragglers after the last request has been sent.
+	neighborReplyWait = time.Second
+	// solicitSpacing keeps sweeps from flooding the link.
+	solicitSpacing = 200 * time.Microsecond
+)
+
 type ARPScanner struct {
-	threads  int
-	resolver *hostname.HostnameResolver
+	threads int
+	iface   string
+	limiter *rateLimiter
 }
 
 func NewARPScanner(threads int) *ARPScanner {
 	return &ARPScanner{
-		threads:  threads,
-		resolver: hostname.NewHostnameResolver(),
+		threads: threads,
+		limiter: newRateLimiter(RateLimit{}),
 	}
 }
 
+// SetInterface pins layer-2 scans to the named interface instead of picking
+// the one attached to the target network.
+func (as *ARPScanner) SetInterface(name string) {
+	as.iface = name
+}
+
+// SetRateLimit paces the ARP requests and Neighbor Solicitations sent by
+// later scans.
+func (as *ARPScanner) SetRateLimit(limit RateLimit) {
+	as.limiter = newRateLimiter(limit)
+}
+
 func (as *ARPScanner) ScanNetwork(network string) ([]ARPEntry, error) {
-	ips, err := generateIPRange(network)
+	return as.ScanNetworkContext(context.Background(), network)
+}
+
+// ScanNetworkContext is like ScanNetwork but stops soliciting once ctx is
+// cancelled and returns the devices found so far together with ctx.Err().
+func (as *ARPScanner) ScanNetworkContext(ctx context.Context, network string) ([]ARPEntry, error) {
+	set, err := targets.Parse(network)
 	if err != nil {
 		return nil, err
 	}
+	return as.ScanTargets(ctx, set)
+}
 
+// ScanTargets is like ScanNetworkContext for an already parsed target set.
+func (as *ARPScanner) ScanTargets(ctx context.Context, set *targets.Set) ([]ARPEntry, error) {
 	results := make([]ARPEntry, 0)
-	resultChan := make(chan ARPEntry, len(ips))
-	semaphore := make(chan struct{}, as.threads)
+	err := as.StreamTargets(ctx, set, func(entry ARPEntry, _ Progress) {
+		if entry.MAC != "" {
+			results = append(results, entry)
+		}
+	})
+	if err != nil && ctx.Err() == nil {
+		return nil, err
+	}
+	return results, err
+}
+
+// StreamNetwork solicits every address in network and hands each target to
+// fn as soon as it is settled, together with the scan's progress. Targets
+// that never answered are reported with an empty MAC so that progress
+// covers the whole range. fn is never called concurrently.
+func (as *ARPScanner) StreamNetwork(ctx context.Context, network string, fn func(ARPEntry, Progress)) error {
+	set, err := targets.Parse(network)
+	if err != nil {
+		return err
+	}
+	return as.StreamTargets(ctx, set, fn)
+}
+
+// StreamTargets is like StreamNetwork for an already parsed target set.
+// IPv4 targets are swept with ARP and IPv6 targets with Neighbor Discovery.
+func (as *ARPScanner) StreamTargets(ctx context.Context, set *targets.Set, fn func(ARPEntry, Progress)) error {
+	var discovered []string
+	for _, prefix := range set.Prefixes() {
+		ips, err := as.discoverIPv6Targets(ctx, prefix, set)
+		if err != nil {
+			return err
+		}
+		discovered = append(discovered, ips...)
+	}
 
-	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	progress := newProgressCounter(set.Len()+len(discovered), as.limiter)
+	emit := func(entry ARPEntry) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		fn(entry, progress.step())
+	}
 
-	for _, ip := range ips {
-		wg.Add(1)
-		go func(ipAddr string) {
-			defer wg.Done()
-			semaphore <- struct{}{}
-			defer func() { <-semaphore }()
+	wanted := func(ip string) bool {
+		addr, err := netip.ParseAddr(ip)
+		return err == nil && set.Contains(addr)
+	}
 
-			entry := as.scanHost(ipAddr)
-			if entry.MAC != "" {
-				resultChan <- entry
+	ipv4 := familyAddrs(set, true)
+	ipv6 := func(yield func(string) bool) {
+		for ip := range familyAddrs(set, false) {
+			if !yield(ip) {
+				return
 			}
-		}(ip)
+		}
+		for _, ip := range discovered {
+			if !yield(ip) {
+				return
+			}
+		}
 	}
 
-	go func() {
-		wg.Wait()
-		close(resultChan)
-	}()
+	if hasAny(ipv4) {
+		as.sweep(ctx, ipv4, wanted, as.nativeScan, emit)
+	}
+	if hasAny(ipv6) && ctx.Err() == nil {
+		as.sweep(ctx, ipv6, wanted, as.ndpScan, emit)
+	}
+
+	return ctx.Err()
+}
+
+// solicitFunc sends layer-2 solicitations to every address in ips and
+// calls found for each reply from an address accepted by wanted.
+type solicitFunc func(ctx context.Context, ips iter.Seq[string], wanted func(string) bool, found func(ARPEntry)) error
+
+// sweep solicits ips of one address family and emits every target once.
+// It prefers real ARP or Neighbor Discovery solicitations and falls back to
+// ping plus the neighbour table when raw packet access is unavailable. ips
+// is walked again to report the silent targets instead of being kept in
+// memory; only the hosts that answered are remembered.
+func (as *ARPScanner) sweep(ctx context.Context, ips iter.Seq[string], wanted func(string) bool, solicit solicitFunc, emit func(ARPEntry)) {
+	answered := make(map[string]bool)
+	err := solicit(ctx, ips, wanted, func(entry ARPEntry) {
+		answered[entry.IP] = true
+		entry.identify()
+		emit(entry)
+	})
+	if err == nil {
+		if ctx.Err() == nil {
+			for ip := range ips {
+				if !answered[ip] {
+					emit(ARPEntry{IP: ip})
+				}
+			}
+		}
+		return
+	}
+
+	runPool(ctx, as.threads, ips, func(ip string) (ARPEntry, bool) {
+		entry := as.scanHost(ctx, ip)
+		return entry, entry.MAC != "" || ctx.Err() == nil
+	}, emit)
+}
 
-	for entry := range resultChan {
-		results = append(results, entry)
+// familyAddrs yields the enumerable targets in set of one address family.
+func familyAddrs(set *targets.Set, ipv4 bool) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for _, r := range set.Ranges() {
+			if r.First.Is4() != ipv4 {
+				continue
+			}
+			for addr := range r.All() {
+				if !yield(addr.String()) {
+					return
+				}
+			}
+		}
 	}
+}
 
-	return results, nil
+// hasAny reports whether ips yields at least one address.
+func hasAny(ips iter.Seq[string]) bool {
+	_, ok := firstAddr(ips)
+	return ok
+}
+
+// firstAddr returns the first address yielded by ips.
+func firstAddr(ips iter.Seq[string]) (string, bool) {
+	for ip := range ips {
+		return ip, true
+	}
+	return "", false
+}
+
+// discoverIPv6Targets finds the responders in an IPv6 prefix too large to
+// enumerate so they can be solicited individually.
+func (as *ARPScanner) discoverIPv6Targets(ctx context.Context, prefix netip.Prefix, set *targets.Set) ([]string, error) {
+	engine, err := NewICMPEngine()
+	if err != nil {
+		return nil, err
+	}
+	defer engine.Close()
+
+	responders, err := discoverIPv6Link(ctx, engine, prefixNet(prefix), as.iface, neighborReplyWait)
+	if err != nil {
+		return nil, err
+	}
+
+	ips := make([]string, 0, len(responders))
+	for _, responder := range responders {
+		if addr, err := netip.ParseAddr(responder.IP); err == nil && !set.Contains(addr) {
+			continue
+		}
+		ips = append(ips, responder.IP)
+	}
+	return ips, nil
 }
 
 func (as *ARPScanner) GetARPTable() ([]ARPEntry, error) {
-	var cmd *exec.Cmd
-	var entries []ARPEntry
+	return as.GetARPTableContext(context.Background())
+}
+
+// GetARPTableContext is like GetARPTable but kills the neighbour table
+// tools once ctx is cancelled.
+func (as *ARPScanner) GetARPTableContext(ctx context.Context) ([]ARPEntry, error) {
+	return readNeighborTable(ctx)
+}
+
+// readNeighborTable lists the system's ARP and IPv6 neighbour caches with
+// the vendor of each MAC. Hostnames are left to an Enricher.
+func readNeighborTable(ctx context.Context) ([]ARPEntry, error) {
+	var commands [][]string
 
 	switch osdetect.DetectOS() {
 	case osdetect.Windows:
-		cmd = exec.Command("arp", "-a")
+		commands = [][]string{
+			{"arp", "-a"},
+			{"netsh", "interface", "ipv6", "show", "neighbors"},
+		}
 	case osdetect.Linux:
-		cmd = exec.Command("ip", "neigh", "show")
+		// ip neigh lists both ARP and IPv6 neighbor cache entries
+		commands = [][]string{{"ip", "neigh", "show"}}
 	case osdetect.Darwin:
-		cmd = exec.Command("arp", "-a")
+		commands = [][]string{
+			{"arp", "-a"},
+			{"ndp", "-an"},
+		}
 	default:
 		return nil, fmt.Errorf("unsupported operating system")
 	}
 
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute arp command: %v", err)
+	var entries []ARPEntry
+	var firstErr error
+	for i, args := range commands {
+		output, err := exec.CommandContext(ctx, args[0], args[1:]...).Output()
+		if err != nil {
+			// Only the IPv4 table is required; IPv6 tools may be missing
+			if i == 0 {
+				firstErr = err
+			}
+			continue
+		}
+		entries = append(entries, parseARPOutput(string(output))...)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return entries, err
+	}
+	if firstErr != nil && len(entries) == 0 {
+		return nil, fmt.Errorf("failed to execute arp command: %v", firstErr)
 	}
 
-	entries = as.parseARPOutput(string(output))
 	return entries, nil
 }
 
-func (as *ARPScanner) scanHost(ip string) ARPEntry {
+func (as *ARPScanner) scanHost(ctx context.Context, ip string) ARPEntry {
 	entry := ARPEntry{
 		IP:     ip,
 		Online: false,
 	}
 
-	var cmd *exec.Cmd
-	switch osdetect.DetectOS() {
-	case osdetect.Windows:
-		cmd = exec.Command("ping", "-n", "1", "-w", "1000", ip)
-	case osdetect.Linux, osdetect.Darwin:
-		cmd = exec.Command("ping", "-c", "1", "-W", "1", ip)
-	default:
-		entry.Error = "Unsupported operating system"
+	if as.limiter.wait(ctx, ip) != nil {
 		return entry
 	}
 
-	output, err := cmd.Output()
-	if err != nil {
+	alive, _, _, err := execPingAlive(ctx, ip, time.Second)
+	if err != nil || !alive {
 		return entry
 	}
 
-	outputStr := string(output)
-	outputLower := strings.ToLower(outputStr)
-	if strings.Contains(outputLower, "ttl=") ||
-		strings.Contains(outputLower, "time=") ||
-		strings.Contains(outputLower, "time<") ||
-		strings.Contains(outputStr, "bytes from") {
-		entry.Online = true
-
-		mac, _ := as.getMACForIP(ip)
-		entry.MAC = mac
+	entry.Online = true
 
-		entry.Hostname = as.resolver.Resolve(ip)
+	mac, _ := as.getMACForIP(ctx, ip)
+	entry.MAC = mac
+	if mac != "" {
+		entry.identify()
 	}
 
 	return entry
 }
 
-func (as *ARPScanner) getMACForIP(ip string) (string, error) {
+func (as *ARPScanner) getMACForIP(ctx context.Context, ip string) (string, error) {
 	var cmd *exec.Cmd
+	v6 := isIPv6(ip)
 
 	switch osdetect.DetectOS() {
 	case osdetect.Windows:
-		cmd = exec.Command("arp", "-a", ip)
+		if v6 {
+			cmd = exec.CommandContext(ctx, "netsh", "interface", "ipv6", "show", "neighbors")
+		} else {
+			cmd = exec.CommandContext(ctx, "arp", "-a", ip)
+		}
 	case osdetect.Linux:
-		cmd = exec.Command("ip", "neigh", "show", ip)
+		addr, zone := splitZone(ip)
+		if zone != "" {
+			cmd = exec.CommandContext(ctx, "ip", "neigh", "show", addr, "dev", zone)
+		} else {
+			cmd = exec.CommandContext(ctx, "ip", "neigh", "show", addr)
+		}
 	case osdetect.Darwin:
-		cmd = exec.Command("arp", "-n", ip)
+		if v6 {
+			cmd = exec.CommandContext(ctx, "ndp", "-n", ip)
+		} else {
+			cmd = exec.CommandContext(ctx, "arp", "-n", ip)
+		}
 	default:
 		return "", fmt.Errorf("unsupported operating system")
 	}
@@ -153,10 +361,10 @@ func (as *ARPScanner) getMACForIP(ip string) (string, error) {
 		return "", err
 	}
 
-	return as.extractMACFromOutput(string(output)), nil
+	return as.extractMACFromOutput(string(output), ip), nil
 }
 
-func (as *ARPScanner) parseARPOutput(output string) []ARPEntry {
+func parseARPOutput(output string) []ARPEntry {
 	var entries []ARPEntry
 	scanner := bufio.NewScanner(strings.NewReader(output))
 
@@ -166,9 +374,9 @@ func (as *ARPScanner) parseARPOutput(output string) []ARPEntry {
 			continue
 		}
 
-		entry := as.parseARPLine(line)
+		entry := parseARPLine(line)
 		if entry.IP != "" && entry.MAC != "" {
-			entry.Hostname = as.resolver.Resolve(entry.IP)
+			entry.identify()
 			entry.Online = true
 			entries = append(entries, entry)
 		}
@@ -177,44 +385,101 @@ func (as *ARPScanner) parseARPOutput(output string) []ARPEntry {
 	return entries
 }
 
-func (as *ARPScanner) parseARPLine(line string) ARPEntry {
+var (
+	// arp -a: "  192.168.1.1           aa-bb-cc-dd-ee-ff     dynamic"
+	windowsARPLine = regexp.MustCompile(`^\s*(\d+\.\d+\.\d+\.\d+)\s+([0-9a-fA-F-]{17})\s+\w+`)
+	// netsh interface ipv6 show neighbors: "fe80::1    aa-bb-cc-dd-ee-ff  Reachable (Router)"
+	windowsNeighborLine = regexp.MustCompile(`^\s*([0-9a-fA-F:]+:[0-9a-fA-F:.]*(?:%\d+)?)\s+([0-9a-fA-F-]{17})\s+\w+`)
+	// ip neigh: "10.1.0.31 dev wlp0s20f3 lladdr a8:03:2a:b8:1d:14 STALE"
+	// or "fe80::1 dev eth0 lladdr 02:fc:00:00:00:05 router STALE"
+	linuxNeighborLine = regexp.MustCompile(`^(\S+)\s+dev\s+(\S+)\s+lladdr\s+([0-9a-fA-F:]{17})`)
+	// arp -a: "? (192.168.1.1) at 0:11:22:33:44:55 on en0 ifscope [ethernet]"
+	darwinARPLine = regexp.MustCompile(`.*\((\d+\.\d+\.\d+\.\d+)\) at ([0-9a-fA-F:]{11,17})`)
+	// ndp -an: "fe80::1%en0   0:11:22:33:44:55   en0 23h59m58s S R"
+	darwinNeighborLine = regexp.MustCompile(`^([0-9a-fA-F:]+:[0-9a-fA-F:.]*(?:%\w+)?)\s+([0-9a-fA-F:]{11,17})\s+\w+`)
+)
+
+func parseARPLine(line string) ARPEntry {
 	var entry ARPEntry
 
 	switch osdetect.DetectOS() {
 	case osdetect.Windows:
-		re := regexp.MustCompile(`\s*(\d+\.\d+\.\d+\.\d+)\s+([0-9a-fA-F-]{17})\s+\w+`)
-		matches := re.FindStringSubmatch(line)
-		if len(matches) >= 3 {
+		if matches := windowsARPLine.FindStringSubmatch(line); len(matches) >= 3 {
+			entry.IP = matches[1]
+			entry.MAC = normalizeMAC(matches[2])
+		} else if matches := windowsNeighborLine.FindStringSubmatch(line); len(matches) >= 3 {
 			entry.IP = matches[1]
-			entry.MAC = strings.ToUpper(strings.Replace(matches[2], "-", ":", -1))
+			entry.MAC = normalizeMAC(matches[2])
 		}
 	case osdetect.Linux:
-		// Parse ip neigh output: "10.1.0.31 dev wlp0s20f3 lladdr a8:03:2a:b8:1d:14 STALE"
-		re := regexp.MustCompile(`(\d+\.\d+\.\d+\.\d+)\s+dev\s+\w+\s+lladdr\s+([0-9a-fA-F:]{17})`)
-		matches := re.FindStringSubmatch(line)
-		if len(matches) >= 3 {
-			entry.IP = matches[1]
-			entry.MAC = strings.ToUpper(matches[2])
+		if matches := linuxNeighborLine.FindStringSubmatch(line); len(matches) >= 4 {
+			if ip := net.ParseIP(matches[1]); ip != nil {
+				entry.IP = formatIP(ip, matches[2])
+				entry.MAC = normalizeMAC(matches[3])
+			}
 		}
 	case osdetect.Darwin:
-		re := regexp.MustCompile(`.*\((\d+\.\d+\.\d+\.\d+)\) at ([0-9a-fA-F:]{17})`)
-		matches := re.FindStringSubmatch(line)
-		if len(matches) >= 3 {
+		if matches := darwinARPLine.FindStringSubmatch(line); len(matches) >= 3 {
+			entry.IP = matches[1]
+			entry.MAC = normalizeMAC(matches[2])
+		} else if matches := darwinNeighborLine.FindStringSubmatch(line); len(matches) >= 3 {
 			entry.IP = matches[1]
-			entry.MAC = strings.ToUpper(matches[2])
+			entry.MAC = normalizeMAC(matches[2])
 		}
 	}
 
+	// Multicast groups show up in the Windows and macOS tables with
+	// synthetic MACs and are not hosts
+	if target := parseTarget(entry.IP); target == nil || target.IP.IsMulticast() {
+		return ARPEntry{}
+	}
+
 	return entry
 }
 
-func (as *ARPScanner) extractMACFromOutput(output string) string {
+func (as *ARPScanner) extractMACFromOutput(output, ip string) string {
+	want := parseTarget(ip)
+
 	lines := strings.Split(output, "\n")
 	for _, line := range lines {
-		entry := as.parseARPLine(line)
-		if entry.MAC != "" {
-			return entry.MAC
+		entry := parseARPLine(line)
+		if entry.MAC == "" {
+			continue
 		}
+		if got := parseTarget(entry.IP); want != nil && got != nil && !got.IP.Equal(want.IP) {
+			continue
+		}
+		return entry.MAC
 	}
 	return ""
-}
\ No newline at end of file
+}
+
+// normalizeMAC converts a MAC in any of the notations the neighbour tools
+// print (dashes, or macOS's unpadded "0:11:22:...") to upper-case colon form.
+// It returns "" for anything else.
+func normalizeMAC(mac string) string {
+	parts := strings.FieldsFunc(mac, func(r rune) bool { return r == ':' || r == '-' })
+	if len(parts) != 6 {
+		return ""
+	}
+	for i, part := range parts {
+		if _, err := strconv.ParseUint(part, 16, 8); err != nil || len(part) > 2 {
+			return ""
+		}
+		if len(part) == 1 {
+			parts[i] = "0" + part
+		}
+	}
+	return strings.ToUpper(strings.Join(parts, ":"))
+}
+
+// sleepContext pauses for d or until ctx is cancelled, whichever is first.
+func sleepContext(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
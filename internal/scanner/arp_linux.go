@@ -0,0 +1,175 @@
+//go:build linux
+
+package scanner
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"iter"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	ethPARP      = 0x0806
+	ethPIPv4     = 0x0800
+	arpFrameLen  = 42
+	arpOpRequest = 1
+	arpOpReply   = 2
+)
+
+// nativeScan sweeps the targets with ARP who-has requests sent straight on
+// the wire through an AF_PACKET socket, so hosts that drop ICMP still show
+// up. found is called from a reader goroutine once for each target that
+// answers and is accepted by wanted. It needs CAP_NET_RAW.
+func (as *ARPScanner) nativeScan(ctx context.Context, ips iter.Seq[string], wanted func(string) bool, found func(ARPEntry)) error {
+	first, ok := firstAddr(ips)
+	if !ok {
+		return errors.New("no targets to scan")
+	}
+
+	iface, srcIP, err := selectInterface(as.iface, net.ParseIP(first))
+	if err != nil {
+		return err
+	}
+	if len(iface.HardwareAddr) != 6 {
+		return fmt.Errorf("interface %s has no Ethernet address", iface.Name)
+	}
+	srcIP = srcIP.To4()
+
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(ethPARP)))
+	if err != nil {
+		return fmt.Errorf("failed to open packet socket: %v", err)
+	}
+	defer syscall.Close(fd)
+
+	link := &syscall.SockaddrLinklayer{
+		Protocol: htons(ethPARP),
+		Ifindex:  iface.Index,
+	}
+	if err := syscall.Bind(fd, link); err != nil {
+		return fmt.Errorf("failed to bind packet socket to %s: %v", iface.Name, err)
+	}
+
+	readTimeout := syscall.NsecToTimeval((100 * time.Millisecond).Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &readTimeout); err != nil {
+		return fmt.Errorf("failed to set packet socket timeout: %v", err)
+	}
+
+	// Only the reader goroutine touches seen until it has stopped
+	seen := make(map[string]bool)
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		buf := make([]byte, 1500)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			n, _, err := syscall.Recvfrom(fd, buf, 0)
+			if err != nil {
+				continue
+			}
+
+			ip, mac, ok := parseARPReply(buf[:n])
+			if !ok || !wanted(ip) {
+				continue
+			}
+
+			if !seen[ip] {
+				seen[ip] = true
+				found(ARPEntry{IP: ip, MAC: mac, Online: true})
+			}
+		}
+	}()
+
+	broadcast := &syscall.SockaddrLinklayer{
+		Protocol: htons(ethPARP),
+		Ifindex:  iface.Index,
+		Halen:    6,
+	}
+	copy(broadcast.Addr[:], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+
+	var sendErr error
+	for ip := range ips {
+		dst := net.ParseIP(ip).To4()
+		if dst == nil {
+			continue
+		}
+		if as.limiter.wait(ctx, ip) != nil {
+			break
+		}
+
+		frame := marshalARPRequest(iface.HardwareAddr, srcIP, dst)
+		if err := syscall.Sendto(fd, frame, 0, broadcast); err != nil {
+			sendErr = err
+			break
+		}
+		time.Sleep(solicitSpacing)
+	}
+
+	if sendErr == nil {
+		sleepContext(ctx, neighborReplyWait)
+	}
+	close(done)
+	<-stopped
+
+	if sendErr != nil && len(seen) == 0 {
+		return fmt.Errorf("failed to send ARP request: %v", sendErr)
+	}
+
+	return nil
+}
+
+func marshalARPRequest(srcMAC net.HardwareAddr, srcIP, dstIP net.IP) []byte {
+	frame := make([]byte, arpFrameLen)
+
+	// Ethernet header
+	copy(frame[0:6], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	copy(frame[6:12], srcMAC)
+	binary.BigEndian.PutUint16(frame[12:], ethPARP)
+
+	// ARP payload
+	binary.BigEndian.PutUint16(frame[14:], 1)
+	binary.BigEndian.PutUint16(frame[16:], ethPIPv4)
+	frame[18] = 6
+	frame[19] = 4
+	binary.BigEndian.PutUint16(frame[20:], arpOpRequest)
+	copy(frame[22:28], srcMAC)
+	copy(frame[28:32], srcIP.To4())
+	copy(frame[38:42], dstIP.To4())
+
+	return frame
+}
+
+func parseARPReply(frame []byte) (string, string, bool) {
+	if len(frame) < arpFrameLen || binary.BigEndian.Uint16(frame[12:]) != ethPARP {
+		return "", "", false
+	}
+	if binary.BigEndian.Uint16(frame[16:]) != ethPIPv4 || frame[18] != 6 || frame[19] != 4 {
+		return "", "", false
+	}
+	if binary.BigEndian.Uint16(frame[20:]) != arpOpReply {
+		return "", "", false
+	}
+
+	mac := strings.ToUpper(net.HardwareAddr(frame[22:28]).String())
+	ip := net.IP(frame[28:32]).String()
+	return ip, mac, true
+}
+
+func htons(v uint16) uint16 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	return binary.NativeEndian.Uint16(b[:])
+}
@@ -0,0 +1,51 @@
+//go:build linux
+
+package scanner
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func TestParseARPReply(t *testing.T) {
+	srcMAC, _ := net.ParseMAC("00:15:5d:0a:bc:de")
+	request := marshalARPRequest(srcMAC, net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.1"))
+	if len(request) != arpFrameLen || binary.BigEndian.Uint16(request[20:]) != arpOpRequest {
+		t.Fatalf("request = %x", request)
+	}
+	if _, _, ok := parseARPReply(request); ok {
+		t.Error("parseARPReply accepted a request")
+	}
+
+	// The reply from 10.0.0.1, padded to the Ethernet minimum
+	reply := make([]byte, 60)
+	copy(reply, request)
+	copy(reply[0:6], srcMAC)
+	copy(reply[6:12], []byte{0xa8, 0x03, 0x2a, 0xb8, 0x1d, 0x14})
+	binary.BigEndian.PutUint16(reply[20:], arpOpReply)
+	copy(reply[22:28], []byte{0xa8, 0x03, 0x2a, 0xb8, 0x1d, 0x14})
+	copy(reply[28:32], net.IPv4(10, 0, 0, 1).To4())
+	copy(reply[32:38], srcMAC)
+	copy(reply[38:42], net.IPv4(10, 0, 0, 2).To4())
+
+	ip, mac, ok := parseARPReply(reply)
+	if !ok || ip != "10.0.0.1" || mac != "A8:03:2A:B8:1D:14" {
+		t.Errorf("parseARPReply = %s, %s, %v; want 10.0.0.1, A8:03:2A:B8:1D:14", ip, mac, ok)
+	}
+
+	for n := range arpFrameLen {
+		if ip, mac, ok := parseARPReply(reply[:n]); ok {
+			t.Errorf("parseARPReply of %d bytes = %s, %s", n, ip, mac)
+		}
+	}
+
+	// Other protocols and address sizes
+	for _, change := range []struct{ offset, value int }{{13, 0x00}, {16, 0x86}, {18, 8}, {19, 16}} {
+		bad := append([]byte(nil), reply...)
+		bad[change.offset] = byte(change.value)
+		if ip, mac, ok := parseARPReply(bad); ok {
+			t.Errorf("parseARPReply with byte %d = %#x: %s, %s", change.offset, change.value, ip, mac)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package scanner
+
+import (
+	"context"
+	"errors"
+	"iter"
+)
+
+func (as *ARPScanner) nativeScan(ctx context.Context, ips iter.Seq[string], wanted func(string) bool, found func(ARPEntry)) error {
+	return errors.New("native ARP scanning is only supported on Linux")
+}
@@ -0,0 +1,74 @@
+package scanner
+
+import (
+	"testing"
+
+	"github.com/CyberOakAlpha/CrossNet/internal/osdetect"
+)
+
+func TestNormalizeMAC(t *testing.T) {
+	tests := []struct {
+		mac, want string
+	}{
+		{"a8:03:2a:b8:1d:14", "A8:03:2A:B8:1D:14"},
+		{"aa-bb-cc-dd-ee-ff", "AA:BB:CC:DD:EE:FF"},
+		// macOS leaves out leading zeros
+		{"0:11:22:3:44:5", "00:11:22:03:44:05"},
+		{"1:0:5e:0:0:fb", "01:00:5E:00:00:FB"},
+		{"", ""},
+		{"00:11:22:33:44", ""},
+		{"00:11:22:33:44:55:66", ""},
+		{"aaa:bb:cc:dd:ee:f", ""},
+		{"zz:11:22:33:44:55", ""},
+		{"(incomplete)", ""},
+	}
+	for _, test := range tests {
+		if got := normalizeMAC(test.mac); got != test.want {
+			t.Errorf("normalizeMAC(%q) = %q, want %q", test.mac, got, test.want)
+		}
+	}
+}
+
+func TestParseARPLine(t *testing.T) {
+	// Lines as each system's neighbour tools print them, for the system
+	// the test runs on
+	fixtures := map[osdetect.OSType][]struct {
+		line, ip, mac string
+	}{
+		osdetect.Linux: {
+			{"10.1.0.31 dev wlp0s20f3 lladdr a8:03:2a:b8:1d:14 STALE", "10.1.0.31", "A8:03:2A:B8:1D:14"},
+			{"fe80::1 dev eth0 lladdr 02:fc:00:00:00:05 router STALE", "fe80::1%eth0", "02:FC:00:00:00:05"},
+			{"2001:db8::5 dev eth0 lladdr 02:fc:00:00:00:06 REACHABLE", "2001:db8::5", "02:FC:00:00:00:06"},
+			{"10.1.0.40 dev eth0  FAILED", "", ""},
+			{"ff02::16 dev eth0 lladdr 33:33:00:00:00:16 NOARP", "", ""},
+			{"not-an-ip dev eth0 lladdr 02:fc:00:00:00:05 STALE", "", ""},
+			{"10.1.0.31 dev wlp0s20f3 lladdr a8:03:2a:b8:1d", "", ""},
+			{"10.1.0.31 dev", "", ""},
+			{"", "", ""},
+		},
+		osdetect.Darwin: {
+			{"? (192.168.1.1) at 0:11:22:33:44:55 on en0 ifscope [ethernet]", "192.168.1.1", "00:11:22:33:44:55"},
+			{"router.lan (192.168.1.254) at a8:3:2a:b8:1d:14 on en0 ifscope [ethernet]", "192.168.1.254", "A8:03:2A:B8:1D:14"},
+			{"fe80::1%en0   0:11:22:33:44:55   en0 23h59m58s S R", "fe80::1%en0", "00:11:22:33:44:55"},
+			{"? (224.0.0.251) at 1:0:5e:0:0:fb on en0 ifscope permanent [ethernet]", "", ""},
+			{"? (192.168.1.9) at (incomplete) on en0 ifscope [ethernet]", "", ""},
+			{"Neighbor                 Linklayer Address  Netif Expire    St Flgs Prbs", "", ""},
+			{"? (192.168.1.1) at", "", ""},
+			{"", "", ""},
+		},
+		osdetect.Windows: {
+			{"  192.168.1.1           aa-bb-cc-dd-ee-ff     dynamic", "192.168.1.1", "AA:BB:CC:DD:EE:FF"},
+			{"fe80::1                  aa-bb-cc-dd-ee-ff  Reachable (Router)", "fe80::1", "AA:BB:CC:DD:EE:FF"},
+			{"  224.0.0.22            01-00-5e-00-00-16     static", "", ""},
+			{"Interface: 192.168.1.10 --- 0xb", "", ""},
+			{"  192.168.1.1           aa-bb-cc-dd-ee", "", ""},
+			{"", "", ""},
+		},
+	}
+	for _, test := range fixtures[osdetect.DetectOS()] {
+		entry := parseARPLine(test.line)
+		if entry.IP != test.ip || entry.MAC != test.mac {
+			t.Errorf("parseARPLine(%q) = %q, %q; want %q, %q", test.line, entry.IP, entry.MAC, test.ip, test.mac)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package scanner
+
+import (
+	"context"
+	"sync"
+
+	"github.com/CyberOakAlpha/CrossNet/internal/hostname"
+	"github.com/CyberOakAlpha/CrossNet/internal/snmp"
+)
+
+// defaultEnrichWorkers bounds the lookups an Enricher runs at a time. Name
+// resolution can wait seconds on hosts that ignore NetBIOS or mDNS queries,
+// so a few workers keep up with discovery without querying every live host
+// at once.
+const defaultEnrichWorkers = 8
+
+// Enricher resolves the names of hosts off the probe path, and optionally
+// asks the live ones for their SNMP system group. Hosts queued with Add are
+// looked up by a fixed number of workers while the scan goes on, and every
+// name found is merged into a HostTable and reported as a follow-up
+// update. An Enricher is good for one run: Start it, Add the live hosts
+// and Wait for the lookups to finish.
+type Enricher struct {
+	hosts    *HostTable
+	resolver *hostname.HostnameResolver
+	snmp     *snmp.Client
+	workers  int
+	in       chan Host
+	done     chan struct{}
+}
+
+func NewEnricher(hosts *HostTable) *Enricher {
+	return &Enricher{
+		hosts:    hosts,
+		resolver: hostname.NewHostnameResolver(),
+		workers:  defaultEnrichWorkers,
+		in:       make(chan Host),
+		done:     make(chan struct{}),
+	}
+}
+
+// SetWorkers bounds the lookups made at a time. It must be called before
+// Start.
+func (e *Enricher) SetWorkers(workers int) {
+	if workers > 0 {
+		e.workers = workers
+	}
+}
+
+// SetMethods selects the resolution methods, in order, used by the
+// lookups. It must be called before Start.
+func (e *Enricher) SetMethods(methods []hostname.MethodConfig) {
+	e.resolver.SetMethods(methods)
+}
+
+// SetSNMP makes the lookups of live hosts query their SNMP agents with
+// client as well, at the same time as the names are resolved. It must be
+// called before Start.
+func (e *Enricher) SetSNMP(client *snmp.Client) {
+	e.snmp = client
+}
+
+// Start begins resolving queued hosts and hands each host a name, NetBIOS
+// node status, service or SNMP system group was found for, as merged into
+// the table with the method that found each name, to fn. fn is never
+// called concurrently. Once ctx is cancelled the hosts still queued are
+// dropped.
+func (e *Enricher) Start(ctx context.Context, fn func(Host)) {
+	queue := make(chan Host)
+	go e.buffer(ctx, queue)
+	go func() {
+		defer close(e.done)
+		jobs := func(yield func(Host) bool) {
+			for host := range queue {
+				if !yield(host) {
+					return
+				}
+			}
+		}
+		runPool(ctx, e.workers, jobs, func(host Host) (Host, bool) {
+			var system *snmp.SystemInfo
+			var wg sync.WaitGroup
+			if e.snmp != nil {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					system, _ = e.snmp.System(ctx, host.IP)
+				}()
+			}
+			result := e.resolver.ResolveAll(ctx, host.IP)
+			wg.Wait()
+			if (len(result.Names) == 0 && result.NodeStatus == nil && len(result.Services) == 0 && system == nil) || ctx.Err() != nil {
+				return host, false
+			}
+			for _, name := range result.Names {
+				host = e.hosts.AddHostname(host.IP, host.MAC, name.Name, name.Method)
+			}
+			if result.NodeStatus != nil {
+				host = e.hosts.AddNodeStatus(host.IP, host.MAC, result.NodeStatus)
+			}
+			if len(result.Services) > 0 {
+				host = e.hosts.AddServices(host.IP, host.MAC, result.Services)
+			}
+			if system != nil {
+				host = e.hosts.AddSNMP(host.IP, host.MAC, system)
+			}
+			return host, true
+		}, fn)
+	}()
+}
+
+// buffer passes the hosts from Add to queue, holding as many as the
+// workers are behind by so that Add never waits for a lookup. Each address
+// is queued once.
+func (e *Enricher) buffer(ctx context.Context, queue chan<- Host) {
+	defer close(queue)
+
+	in := e.in
+	done := ctx.Done()
+	queued := make(map[string]bool)
+	var pending []Host
+	for in != nil || len(pending) > 0 {
+		var out chan<- Host
+		var next Host
+		if len(pending) > 0 {
+			out = queue
+			next = pending[0]
+		}
+		select {
+		case host, ok := <-in:
+			switch {
+			case !ok:
+				in = nil
+			case ctx.Err() == nil && !queued[host.IP]:
+				queued[host.IP] = true
+				pending = append(pending, host)
+			}
+		case out <- next:
+			pending = pending[1:]
+		case <-done:
+			// Keep draining Add but look nothing else up
+			pending = nil
+			done = nil
+		}
+	}
+}
+
+// Add queues host, which must be alive, to have its name resolved and, with
+// SetSNMP, its SNMP agent queried. It does not wait for the lookup.
+func (e *Enricher) Add(host Host) {
+	e.in <- host
+}
+
+// Wait stops accepting hosts and returns once every queued lookup is done.
+func (e *Enricher) Wait() {
+	close(e.in)
+	<-e.done
+}
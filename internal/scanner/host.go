@@ -0,0 +1,324 @@
+package scanner
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CyberOakAlpha/CrossNet/internal/hostname"
+	"github.com/CyberOakAlpha/CrossNet/internal/snmp"
+)
+
+// Sources of the evidence merged into a Host.
+const (
+	SourcePing     = "ping"
+	SourceTCP      = "tcp"
+	SourceARP      = "arp"
+	SourceARPCache = "arp-cache"
+	SourcePorts    = "ports"
+	SourceSSDP     = "ssdp"
+)
+
+// HostName is a name found for a host together with where it came from:
+// the hostname resolution method, such as "dns" or "netbios", that found
+// it.
+type HostName struct {
+	Name   string
+	Source string
+}
+
+// Host is everything a run has learnt about one device, merged from the
+// ping, TCP, ARP, SSDP and port scans that saw it.
+type Host struct {
+	IP     string
+	MAC    string `json:",omitempty"`
+	Vendor string `json:",omitempty"`
+	// LocallyAdministered is set for MACs that were not assigned by the
+	// IEEE, typically randomised for privacy, which have no vendor
+	LocallyAdministered bool `json:",omitempty"`
+	// Alive is set once the host answered a probe. Hosts known only from
+	// the neighbour cache are not alive.
+	Alive bool
+	RTT   time.Duration `json:",omitempty"`
+	TTL   int           `json:",omitempty"`
+	Probe string        `json:",omitempty"`
+	// Stats is only set in quality mode, where RTT is the average of the
+	// replies.
+	Stats     *LatencyStats `json:",omitempty"`
+	Hostnames []HostName    `json:",omitempty"`
+	// NetBIOS is the host's answer to a NetBIOS node status query: its
+	// workgroup, logged-in users and the MAC it reports for itself.
+	NetBIOS *hostname.NodeStatus `json:",omitempty"`
+	// Services are the DNS-SD services the host advertises over mDNS.
+	Services []hostname.Service `json:",omitempty"`
+	// UPnP holds the description of each UPnP root device the host
+	// announced over SSDP.
+	UPnP []UPnPDevice `json:",omitempty"`
+	// SNMP is what the host's SNMP agent says about it in the system
+	// group: its configured name, model, uptime and location.
+	SNMP  *snmp.SystemInfo `json:",omitempty"`
+	Ports []PortResult     `json:",omitempty"`
+	// Sources lists the scans that produced evidence for the host, in the
+	// order they first did
+	Sources   []string
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// Hostname returns the first name found for the host, or "".
+func (h *Host) Hostname() string {
+	if len(h.Hostnames) == 0 {
+		return ""
+	}
+	return h.Hostnames[0].Name
+}
+
+// Status summarises the host as "up", "cached" when it is only listed in
+// the neighbour cache, or "down".
+func (h *Host) Status() string {
+	switch {
+	case h.Alive:
+		return "up"
+	case slices.Contains(h.Sources, SourceARPCache):
+		return "cached"
+	}
+	return "down"
+}
+
+func (h *Host) addSource(source string) {
+	now := time.Now()
+	if h.FirstSeen.IsZero() {
+		h.FirstSeen = now
+	}
+	h.LastSeen = now
+	if !slices.Contains(h.Sources, source) {
+		h.Sources = append(h.Sources, source)
+	}
+}
+
+func (h *Host) addHostname(name, source string) {
+	if name == "" {
+		return
+	}
+	for _, known := range h.Hostnames {
+		if strings.EqualFold(known.Name, name) {
+			return
+		}
+	}
+	h.Hostnames = append(h.Hostnames, HostName{Name: name, Source: source})
+}
+
+func (h *Host) setMAC(mac, vendor string, locallyAdministered bool) {
+	if mac == "" {
+		return
+	}
+	h.MAC = mac
+	h.Vendor = vendor
+	h.LocallyAdministered = locallyAdministered
+}
+
+// clone returns a copy of the host that shares no slices with it.
+func (h *Host) clone() Host {
+	c := *h
+	c.Hostnames = slices.Clone(h.Hostnames)
+	c.Services = slices.Clone(h.Services)
+	c.UPnP = slices.Clone(h.UPnP)
+	c.Ports = slices.Clone(h.Ports)
+	c.Sources = slices.Clone(h.Sources)
+	return c
+}
+
+// HostTable merges the results of the scans of a run into one Host per
+// device. Devices are told apart by IP and, once known, MAC, so that two
+// devices answering for the same address are both kept. Results without
+// a MAC go to the first host with their IP. It is safe for concurrent use
+// and every Add method returns a copy of the host as merged so far.
+type HostTable struct {
+	mutex sync.Mutex
+	hosts []*Host
+	byIP  map[string][]*Host
+}
+
+func NewHostTable() *HostTable {
+	return &HostTable{
+		byIP: make(map[string][]*Host),
+	}
+}
+
+// host returns the host that evidence about ip and mac belongs to,
+// creating it if there is none. The caller holds the mutex.
+func (t *HostTable) host(ip, mac string) *Host {
+	var unclaimed *Host
+	for _, host := range t.byIP[ip] {
+		if mac == "" || host.MAC == mac {
+			return host
+		}
+		if host.MAC == "" && unclaimed == nil {
+			unclaimed = host
+		}
+	}
+	if unclaimed != nil {
+		return unclaimed
+	}
+
+	host := &Host{IP: ip}
+	t.hosts = append(t.hosts, host)
+	t.byIP[ip] = append(t.byIP[ip], host)
+	return host
+}
+
+// AddPing merges the result of a ping or TCP discovery probe.
+func (t *HostTable) AddPing(result PingResult) Host {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	source := SourcePing
+	if strings.HasPrefix(result.Probe, "tcp") {
+		source = SourceTCP
+	}
+
+	host := t.host(result.IP, "")
+	host.addSource(source)
+	if result.Alive {
+		host.Alive = true
+		host.RTT = result.RTT
+		host.TTL = result.TTL
+		host.Probe = result.Probe
+	}
+	if result.Stats != nil {
+		host.Stats = result.Stats
+	}
+	return host.clone()
+}
+
+// AddARP merges a neighbour found by an ARP or Neighbor Discovery sweep,
+// or, if cached is set, listed in the system's neighbour cache.
+func (t *HostTable) AddARP(entry ARPEntry, cached bool) Host {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	source := SourceARP
+	if cached {
+		source = SourceARPCache
+	}
+
+	host := t.host(entry.IP, entry.MAC)
+	host.addSource(source)
+	host.setMAC(entry.MAC, entry.Vendor, entry.LocallyAdministered)
+	if !cached && entry.MAC != "" {
+		host.Alive = true
+		if host.Probe == "" {
+			host.Probe = "arp"
+		}
+	}
+	return host.clone()
+}
+
+// AddPort merges the result of a port probe. An open or closed port shows
+// that the host is up.
+func (t *HostTable) AddPort(result PortResult) Host {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	host := t.host(result.IP, "")
+	host.addSource(SourcePorts)
+	if result.State != PortFiltered {
+		host.Alive = true
+	}
+	i, found := slices.BinarySearchFunc(host.Ports, result.Port, func(p PortResult, port int) int { return p.Port - port })
+	if found {
+		host.Ports[i] = result
+	} else {
+		host.Ports = slices.Insert(host.Ports, i, result)
+	}
+	return host.clone()
+}
+
+// AddSSDP merges a device that answered an SSDP search. A device already
+// known by its location is updated.
+func (t *HostTable) AddSSDP(result SSDPResult) Host {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	host := t.host(result.IP, "")
+	host.addSource(SourceSSDP)
+	host.Alive = true
+	if host.Probe == "" {
+		host.Probe = "ssdp"
+	}
+	i := slices.IndexFunc(host.UPnP, func(d UPnPDevice) bool { return d.Location == result.Device.Location })
+	if i >= 0 {
+		host.UPnP[i] = result.Device
+	} else {
+		host.UPnP = append(host.UPnP, result.Device)
+	}
+	return host.clone()
+}
+
+// AddHostname merges a name found for the host with the given IP and MAC
+// by source, the resolution method.
+func (t *HostTable) AddHostname(ip, mac, name, source string) Host {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	host := t.host(ip, mac)
+	host.addHostname(name, source)
+	return host.clone()
+}
+
+// AddNodeStatus attaches the NetBIOS node status of the host with the given
+// IP and MAC.
+func (t *HostTable) AddNodeStatus(ip, mac string, status *hostname.NodeStatus) Host {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	host := t.host(ip, mac)
+	host.NetBIOS = status
+	return host.clone()
+}
+
+// AddSNMP attaches the SNMP system group of the host with the given IP and
+// MAC.
+func (t *HostTable) AddSNMP(ip, mac string, info *snmp.SystemInfo) Host {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	host := t.host(ip, mac)
+	host.SNMP = info
+	return host.clone()
+}
+
+// AddServices merges the DNS-SD services advertised by the host with the
+// given IP and MAC. A service already known by its instance name and type
+// is updated.
+func (t *HostTable) AddServices(ip, mac string, services []hostname.Service) Host {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	host := t.host(ip, mac)
+	for _, service := range services {
+		i := slices.IndexFunc(host.Services, func(s hostname.Service) bool {
+			return s.Instance == service.Instance && s.Type == service.Type
+		})
+		if i >= 0 {
+			host.Services[i] = service
+		} else {
+			host.Services = append(host.Services, service)
+		}
+	}
+	return host.clone()
+}
+
+// Hosts returns a copy of every host, ordered by address.
+func (t *HostTable) Hosts() []Host {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	hosts := make([]Host, 0, len(t.hosts))
+	for _, host := range t.hosts {
+		hosts = append(hosts, host.clone())
+	}
+	slices.SortStableFunc(hosts, func(a, b Host) int { return compareIPs(a.IP, b.IP) })
+	return hosts
+}
@@ -0,0 +1,313 @@
+package scanner
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/CyberOakAlpha/CrossNet/internal/hostname"
+	"github.com/CyberOakAlpha/CrossNet/internal/snmp"
+)
+
+const (
+	testMAC1 = "00:15:5d:0a:bc:de"
+	testMAC2 = "02:11:22:33:44:55"
+)
+
+func TestHostTableStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		add     func(*HostTable) Host
+		alive   bool
+		status  string
+		probe   string
+		sources []string
+	}{
+		{
+			name:    "ping reply",
+			add:     func(t *HostTable) Host { return t.AddPing(PingResult{IP: "10.0.0.1", Alive: true, Probe: "icmp"}) },
+			alive:   true,
+			status:  "up",
+			probe:   "icmp",
+			sources: []string{SourcePing},
+		},
+		{
+			name:    "unanswered TCP probe",
+			add:     func(t *HostTable) Host { return t.AddPing(PingResult{IP: "10.0.0.1", Probe: "tcp-syn"}) },
+			status:  "down",
+			sources: []string{SourceTCP},
+		},
+		{
+			name: "cache only",
+			add: func(t *HostTable) Host {
+				t.AddPing(PingResult{IP: "10.0.0.1", Probe: "icmp"})
+				return t.AddARP(ARPEntry{IP: "10.0.0.1", MAC: testMAC1}, true)
+			},
+			status:  "cached",
+			sources: []string{SourcePing, SourceARPCache},
+		},
+		{
+			name:    "ARP reply",
+			add:     func(t *HostTable) Host { return t.AddARP(ARPEntry{IP: "10.0.0.1", MAC: testMAC1}, false) },
+			alive:   true,
+			status:  "up",
+			probe:   "arp",
+			sources: []string{SourceARP},
+		},
+		{
+			name: "ARP reply keeps the probe that found the host",
+			add: func(t *HostTable) Host {
+				t.AddPing(PingResult{IP: "10.0.0.1", Alive: true, Probe: "icmp"})
+				return t.AddARP(ARPEntry{IP: "10.0.0.1", MAC: testMAC1}, false)
+			},
+			alive:   true,
+			status:  "up",
+			probe:   "icmp",
+			sources: []string{SourcePing, SourceARP},
+		},
+		{
+			name: "a later lost probe does not bring a host down",
+			add: func(t *HostTable) Host {
+				t.AddPing(PingResult{IP: "10.0.0.1", Alive: true, Probe: "icmp"})
+				return t.AddPing(PingResult{IP: "10.0.0.1", Probe: "tcp-syn"})
+			},
+			alive:   true,
+			status:  "up",
+			probe:   "icmp",
+			sources: []string{SourcePing, SourceTCP},
+		},
+		{
+			name:    "filtered port",
+			add:     func(t *HostTable) Host { return t.AddPort(PortResult{IP: "10.0.0.1", Port: 22, State: PortFiltered}) },
+			status:  "down",
+			sources: []string{SourcePorts},
+		},
+		{
+			name:    "closed port",
+			add:     func(t *HostTable) Host { return t.AddPort(PortResult{IP: "10.0.0.1", Port: 22, State: PortClosed}) },
+			alive:   true,
+			status:  "up",
+			sources: []string{SourcePorts},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			table := NewHostTable()
+			host := test.add(table)
+			if host.Alive != test.alive || host.Status() != test.status || host.Probe != test.probe {
+				t.Errorf("host = alive %v, %s, probe %q; want alive %v, %s, probe %q",
+					host.Alive, host.Status(), host.Probe, test.alive, test.status, test.probe)
+			}
+			if !slices.Equal(host.Sources, test.sources) {
+				t.Errorf("sources = %q, want %q", host.Sources, test.sources)
+			}
+			if hosts := table.Hosts(); len(hosts) != 1 {
+				t.Errorf("table holds %d hosts, want 1", len(hosts))
+			}
+		})
+	}
+}
+
+func TestHostTableConflictingMACs(t *testing.T) {
+	table := NewHostTable()
+
+	// A probe without a MAC is claimed by the first neighbour with the IP
+	table.AddPing(PingResult{IP: "10.0.0.5", Alive: true, RTT: time.Millisecond, Probe: "icmp"})
+	first := table.AddARP(ARPEntry{IP: "10.0.0.5", MAC: testMAC1, Vendor: "Microsoft"}, false)
+	if first.MAC != testMAC1 || first.RTT != time.Millisecond {
+		t.Errorf("first = %+v, want the pinged host with %s", first, testMAC1)
+	}
+
+	// Another device answering for the same address is kept apart
+	second := table.AddARP(ARPEntry{IP: "10.0.0.5", MAC: testMAC2, LocallyAdministered: true}, false)
+	if second.MAC != testMAC2 || !second.LocallyAdministered || second.RTT != 0 {
+		t.Errorf("second = %+v, want a new host with %s", second, testMAC2)
+	}
+
+	// Results without a MAC go to the first of them
+	table.AddPort(PortResult{IP: "10.0.0.5", Port: 445, State: PortOpen})
+
+	hosts := table.Hosts()
+	if len(hosts) != 2 {
+		t.Fatalf("table holds %d hosts, want 2", len(hosts))
+	}
+	byMAC := map[string]Host{hosts[0].MAC: hosts[0], hosts[1].MAC: hosts[1]}
+	if got := byMAC[testMAC1]; len(got.Ports) != 1 || got.Vendor != "Microsoft" {
+		t.Errorf("host with %s = %+v, want port 445", testMAC1, got)
+	}
+	if got := byMAC[testMAC2]; len(got.Ports) != 0 {
+		t.Errorf("host with %s = %+v, want no ports", testMAC2, got)
+	}
+}
+
+func TestHostTableSharedMAC(t *testing.T) {
+	// A router answering for two addresses, or a host with an IPv4 and an
+	// IPv6 address, is listed once per address
+	table := NewHostTable()
+	table.AddARP(ARPEntry{IP: "10.0.0.1", MAC: testMAC1}, false)
+	table.AddARP(ARPEntry{IP: "fe80::1", MAC: testMAC1}, false)
+	table.AddARP(ARPEntry{IP: "10.0.0.10", MAC: testMAC1}, true)
+
+	hosts := table.Hosts()
+	var ips []string
+	for _, host := range hosts {
+		if host.MAC != testMAC1 {
+			t.Errorf("%s has MAC %q, want %s", host.IP, host.MAC, testMAC1)
+		}
+		ips = append(ips, host.IP)
+	}
+	if want := []string{"10.0.0.1", "10.0.0.10", "fe80::1"}; !slices.Equal(ips, want) {
+		t.Errorf("hosts = %q, want %q", ips, want)
+	}
+	if hosts[1].Status() != "cached" {
+		t.Errorf("10.0.0.10 is %s, want cached", hosts[1].Status())
+	}
+}
+
+func TestHostTableMerge(t *testing.T) {
+	table := NewHostTable()
+	ip := "10.0.0.7"
+
+	table.AddPing(PingResult{IP: ip, Alive: true, RTT: 2 * time.Millisecond, TTL: 64, Probe: "icmp"})
+	// A later probe replaces the latency, and stats only when it has them
+	table.AddPing(PingResult{IP: ip, Alive: true, RTT: 3 * time.Millisecond, TTL: 64, Probe: "icmp", Stats: &LatencyStats{Sent: 3}})
+	table.AddPing(PingResult{IP: ip, Alive: true, RTT: 4 * time.Millisecond, TTL: 128, Probe: "tcp-syn"})
+
+	// Ports are kept in order and a later result for a port replaces it
+	table.AddPort(PortResult{IP: ip, Port: 443, State: PortFiltered})
+	table.AddPort(PortResult{IP: ip, Port: 22, State: PortOpen})
+	host := table.AddPort(PortResult{IP: ip, Port: 443, State: PortOpen, Service: "https"})
+
+	if host.RTT != 4*time.Millisecond || host.TTL != 128 || host.Probe != "tcp-syn" || host.Stats == nil || host.Stats.Sent != 3 {
+		t.Errorf("probe = RTT %v, TTL %d, %s, stats %+v; want the last probe and the stats", host.RTT, host.TTL, host.Probe, host.Stats)
+	}
+	if want := []PortResult{{IP: ip, Port: 22, State: PortOpen}, {IP: ip, Port: 443, State: PortOpen, Service: "https"}}; !reflect.DeepEqual(host.Ports, want) {
+		t.Errorf("ports = %+v, want %+v", host.Ports, want)
+	}
+	if want := []string{SourcePing, SourceTCP, SourcePorts}; !slices.Equal(host.Sources, want) {
+		t.Errorf("sources = %q, want %q", host.Sources, want)
+	}
+	if host.FirstSeen.IsZero() || host.LastSeen.Before(host.FirstSeen) {
+		t.Errorf("seen from %v to %v", host.FirstSeen, host.LastSeen)
+	}
+
+	// The copies handed out share nothing with the table
+	host.Ports[0].State = PortClosed
+	host.Sources[0] = "changed"
+	if again := table.Hosts()[0]; again.Ports[0].State != PortOpen || again.Sources[0] != SourcePing {
+		t.Errorf("changing a returned host changed the table: %+v", again)
+	}
+}
+
+func TestHostTableHostnames(t *testing.T) {
+	table := NewHostTable()
+	table.AddARP(ARPEntry{IP: "10.0.0.5", MAC: testMAC1}, false)
+	table.AddARP(ARPEntry{IP: "10.0.0.5", MAC: testMAC2}, false)
+
+	// The first spelling of a name wins, and names without a MAC go to the
+	// first host with the IP
+	table.AddHostname("10.0.0.5", testMAC2, "phone", "mdns")
+	table.AddHostname("10.0.0.5", "", "desktop", "netbios")
+	table.AddHostname("10.0.0.5", "", "DESKTOP", "llmnr")
+	table.AddHostname("10.0.0.5", "", "", "dns")
+	table.AddHostname("10.0.0.5", testMAC1, "desktop.example.com", "dns")
+
+	hosts := table.Hosts()
+	if len(hosts) != 2 {
+		t.Fatalf("table holds %d hosts, want 2", len(hosts))
+	}
+	byMAC := map[string]Host{hosts[0].MAC: hosts[0], hosts[1].MAC: hosts[1]}
+	if got, want := byMAC[testMAC1].Hostnames, []HostName{{"desktop", "netbios"}, {"desktop.example.com", "dns"}}; !slices.Equal(got, want) {
+		t.Errorf("names of %s = %+v, want %+v", testMAC1, got, want)
+	}
+	if got, want := byMAC[testMAC2].Hostnames, []HostName{{"phone", "mdns"}}; !slices.Equal(got, want) {
+		t.Errorf("names of %s = %+v, want %+v", testMAC2, got, want)
+	}
+
+	// A name for an IP not seen yet creates its host, which is not alive
+	if host := table.AddHostname("10.0.0.9", "", "ghost", "dns"); host.Alive || host.Hostname() != "ghost" {
+		t.Errorf("AddHostname for a new IP = %+v", host)
+	}
+}
+
+func TestHostTableNodeStatus(t *testing.T) {
+	table := NewHostTable()
+	table.AddPing(PingResult{IP: "10.0.0.7", Alive: true, Probe: "icmp"})
+	table.AddNodeStatus("10.0.0.7", "", &hostname.NodeStatus{Name: "OLD"})
+	host := table.AddNodeStatus("10.0.0.7", "", &hostname.NodeStatus{Name: "NAS", Domain: "WORKGROUP"})
+	if host.NetBIOS == nil || host.NetBIOS.Name != "NAS" || host.NetBIOS.Domain != "WORKGROUP" {
+		t.Errorf("NetBIOS = %+v, want the last node status", host.NetBIOS)
+	}
+	if !slices.Equal(host.Sources, []string{SourcePing}) {
+		t.Errorf("sources = %q, want the node status not to count as a scan", host.Sources)
+	}
+}
+
+func TestHostTableServices(t *testing.T) {
+	table := NewHostTable()
+	table.AddServices("10.0.0.7", "", []hostname.Service{
+		{Instance: "NAS", Type: "_smb._tcp", Port: 445},
+		{Instance: "NAS", Type: "_http._tcp", Port: 80},
+	})
+	// A service known by its instance and type is updated in place
+	host := table.AddServices("10.0.0.7", "", []hostname.Service{
+		{Instance: "NAS", Type: "_http._tcp", Port: 5000},
+		{Instance: "Backup", Type: "_http._tcp", Port: 8080},
+	})
+	want := []hostname.Service{
+		{Instance: "NAS", Type: "_smb._tcp", Port: 445},
+		{Instance: "NAS", Type: "_http._tcp", Port: 5000},
+		{Instance: "Backup", Type: "_http._tcp", Port: 8080},
+	}
+	if !reflect.DeepEqual(host.Services, want) {
+		t.Errorf("services = %+v, want %+v", host.Services, want)
+	}
+
+	host.Services[0].Port = 1
+	if again := table.Hosts()[0]; again.Services[0].Port != 445 {
+		t.Errorf("changing a returned host changed the table: %+v", again.Services)
+	}
+}
+
+func TestHostTableSSDP(t *testing.T) {
+	table := NewHostTable()
+	host := table.AddSSDP(SSDPResult{IP: "10.0.0.1", Device: UPnPDevice{Location: "http://10.0.0.1/a.xml", FriendlyName: "old"}})
+	if !host.Alive || host.Status() != "up" || host.Probe != "ssdp" {
+		t.Errorf("host = alive %v, %s, probe %q; want up by ssdp", host.Alive, host.Status(), host.Probe)
+	}
+
+	// A device known by its location is updated, and SSDP does not replace
+	// the probe that found the host
+	table.AddPing(PingResult{IP: "10.0.0.7", Alive: true, Probe: "icmp"})
+	table.AddSSDP(SSDPResult{IP: "10.0.0.7", Device: UPnPDevice{Location: "http://10.0.0.7/a.xml", FriendlyName: "old"}})
+	table.AddSSDP(SSDPResult{IP: "10.0.0.7", Device: UPnPDevice{Location: "http://10.0.0.7/b.xml"}})
+	host = table.AddSSDP(SSDPResult{IP: "10.0.0.7", Device: UPnPDevice{Location: "http://10.0.0.7/a.xml", FriendlyName: "NAS"}})
+	if len(host.UPnP) != 2 || host.UPnP[0].FriendlyName != "NAS" || host.UPnP[1].Location != "http://10.0.0.7/b.xml" {
+		t.Errorf("UPnP = %+v, want a.xml updated and b.xml", host.UPnP)
+	}
+	if host.Probe != "icmp" || !slices.Equal(host.Sources, []string{SourcePing, SourceSSDP}) {
+		t.Errorf("probe %q, sources %q; want icmp, then ssdp", host.Probe, host.Sources)
+	}
+}
+
+func TestHostTableSNMP(t *testing.T) {
+	table := NewHostTable()
+	table.AddARP(ARPEntry{IP: "10.0.0.5", MAC: testMAC1}, false)
+	table.AddARP(ARPEntry{IP: "10.0.0.5", MAC: testMAC2}, false)
+	table.AddSNMP("10.0.0.5", testMAC2, &snmp.SystemInfo{Name: "old"})
+	table.AddSNMP("10.0.0.5", testMAC2, &snmp.SystemInfo{Name: "switch", Descr: "Linux switch"})
+
+	for _, host := range table.Hosts() {
+		switch host.MAC {
+		case testMAC1:
+			if host.SNMP != nil {
+				t.Errorf("SNMP of %s = %+v, want none", testMAC1, host.SNMP)
+			}
+		case testMAC2:
+			if host.SNMP == nil || host.SNMP.Name != "switch" {
+				t.Errorf("SNMP of %s = %+v, want the last system group", testMAC2, host.SNMP)
+			}
+		}
+	}
+}
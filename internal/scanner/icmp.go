@@ -0,0 +1,373 @@
+package scanner
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	icmpEchoReply     = 0
+	icmpEchoRequest   = 8
+	icmpv6EchoRequest = 128
+	icmpv6EchoReply   = 129
+
+	icmpPayloadSize = 56
+)
+
+var errPingTimeout = errors.New("request timed out")
+
+type icmpReply struct {
+	from     *net.IPAddr
+	ttl      int
+	received time.Time
+}
+
+type pendingEcho struct {
+	dst       net.IP
+	multicast bool
+	reply     chan icmpReply
+}
+
+type icmpSocket struct {
+	conn       net.PacketConn
+	privileged bool
+	ipv6       bool
+}
+
+// ICMPEngine sends ICMP and ICMPv6 echo requests over one shared socket
+// per address family and matches the replies back to the waiting callers by
+// identifier and sequence number. Unprivileged datagram sockets are
+// preferred; raw sockets are used when the datagram variant is not
+// permitted.
+type ICMPEngine struct {
+	sock4 *icmpSocket
+	sock6 *icmpSocket
+	id    uint16
+
+	mutex   sync.Mutex
+	seq     uint16
+	pending map[uint16]*pendingEcho
+
+	done chan struct{}
+}
+
+func NewICMPEngine() (*ICMPEngine, error) {
+	engine := &ICMPEngine{
+		id:      uint16(os.Getpid() & 0xffff),
+		pending: make(map[uint16]*pendingEcho),
+		done:    make(chan struct{}),
+	}
+
+	conn4, privileged4, err4 := openICMPConn(false, nil)
+	if err4 == nil {
+		engine.sock4 = &icmpSocket{conn: conn4, privileged: privileged4}
+		go engine.receiveLoop(engine.sock4)
+	}
+
+	conn6, privileged6, err6 := openICMPConn(true, nil)
+	if err6 == nil {
+		engine.sock6 = &icmpSocket{conn: conn6, privileged: privileged6, ipv6: true}
+		go engine.receiveLoop(engine.sock6)
+	}
+
+	if err4 != nil && err6 != nil {
+		return nil, fmt.Errorf("failed to open ICMP socket: %v", err4)
+	}
+
+	return engine, nil
+}
+
+// Privileged reports whether the engine is using raw sockets.
+func (e *ICMPEngine) Privileged() bool {
+	if e.sock4 != nil {
+		return e.sock4.privileged
+	}
+	return e.sock6.privileged
+}
+
+// Ping sends a single echo request to dst and waits up to timeout for the
+// matching reply. It returns the round-trip time measured around the socket
+// calls and the TTL (hop limit for IPv6) of the reply when the platform
+// reports it. Cancelling ctx abandons the wait and returns ctx.Err().
+func (e *ICMPEngine) Ping(ctx context.Context, dst *net.IPAddr, timeout time.Duration) (time.Duration, int, error) {
+	sock, err := e.socketFor(dst.IP)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	seq, waiter := e.register(dst.IP, false)
+	defer e.unregister(seq)
+
+	start, err := e.send(sock, dst, seq)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case reply := <-waiter.reply:
+		return reply.received.Sub(start), reply.ttl, nil
+	case <-timer.C:
+		return 0, 0, errPingTimeout
+	case <-ctx.Done():
+		return 0, 0, ctx.Err()
+	case <-e.done:
+		return 0, 0, net.ErrClosed
+	}
+}
+
+// pingMulticast sends one echo request to a multicast group such as
+// ff02::1 and collects every reply that arrives within wait. Responders
+// answer from an address of the same scope as the request's source, so a
+// non-nil src is used to pull out their global addresses instead of the
+// link-local ones.
+func (e *ICMPEngine) pingMulticast(ctx context.Context, group *net.IPAddr, src net.IP, wait time.Duration) ([]icmpReply, time.Time, error) {
+	sock, err := e.socketFor(group.IP)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if src != nil {
+		conn, privileged, err := openICMPConn(sock.ipv6, src)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("failed to open ICMP socket on %s: %v", src, err)
+		}
+		sock = &icmpSocket{conn: conn, privileged: privileged, ipv6: sock.ipv6}
+		defer conn.Close()
+		go e.receiveLoop(sock)
+	}
+
+	seq, waiter := e.register(group.IP, true)
+	defer e.unregister(seq)
+
+	start, err := e.send(sock, group, seq)
+	if err != nil {
+		return nil, start, err
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	var replies []icmpReply
+	for {
+		select {
+		case reply := <-waiter.reply:
+			replies = append(replies, reply)
+		case <-timer.C:
+			return replies, start, nil
+		case <-ctx.Done():
+			return replies, start, ctx.Err()
+		case <-e.done:
+			return replies, start, net.ErrClosed
+		}
+	}
+}
+
+func (e *ICMPEngine) Close() error {
+	select {
+	case <-e.done:
+		return nil
+	default:
+	}
+	close(e.done)
+
+	var err error
+	for _, sock := range []*icmpSocket{e.sock4, e.sock6} {
+		if sock != nil {
+			if closeErr := sock.conn.Close(); closeErr != nil {
+				err = closeErr
+			}
+		}
+	}
+	return err
+}
+
+func (e *ICMPEngine) socketFor(ip net.IP) (*icmpSocket, error) {
+	if ip.To4() != nil {
+		if e.sock4 == nil {
+			return nil, errors.New("no IPv4 ICMP socket available")
+		}
+		return e.sock4, nil
+	}
+	if ip.To16() == nil {
+		return nil, fmt.Errorf("invalid address: %s", ip)
+	}
+	if e.sock6 == nil {
+		return nil, errors.New("no IPv6 ICMP socket available")
+	}
+	return e.sock6, nil
+}
+
+func (e *ICMPEngine) send(sock *icmpSocket, dst *net.IPAddr, seq uint16) (time.Time, error) {
+	var packet []byte
+	if sock.ipv6 {
+		packet = marshalEcho(icmpv6EchoRequest, e.id, seq, false)
+	} else {
+		packet = marshalEcho(icmpEchoRequest, e.id, seq, true)
+	}
+
+	var addr net.Addr = &net.UDPAddr{IP: dst.IP, Zone: dst.Zone}
+	if sock.privileged {
+		addr = &net.IPAddr{IP: dst.IP, Zone: dst.Zone}
+	}
+
+	start := time.Now()
+	if _, err := sock.conn.WriteTo(packet, addr); err != nil {
+		return start, fmt.Errorf("failed to send echo request: %v", err)
+	}
+	return start, nil
+}
+
+func (e *ICMPEngine) register(dst net.IP, multicast bool) (uint16, *pendingEcho) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	for {
+		e.seq++
+		if _, busy := e.pending[e.seq]; !busy {
+			break
+		}
+	}
+
+	waiter := &pendingEcho{
+		dst:       dst,
+		multicast: multicast,
+		reply:     make(chan icmpReply, 1),
+	}
+	if multicast {
+		waiter.reply = make(chan icmpReply, 1024)
+	}
+	e.pending[e.seq] = waiter
+
+	return e.seq, waiter
+}
+
+func (e *ICMPEngine) unregister(seq uint16) {
+	e.mutex.Lock()
+	delete(e.pending, seq)
+	e.mutex.Unlock()
+}
+
+func (e *ICMPEngine) receiveLoop(sock *icmpSocket) {
+	buf := make([]byte, 1500)
+
+	replyType := uint8(icmpEchoReply)
+	if sock.ipv6 {
+		replyType = icmpv6EchoReply
+	}
+
+	for {
+		n, from, ttl, err := readICMP(sock, buf)
+		received := time.Now()
+		if err != nil {
+			select {
+			case <-e.done:
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		typ, id, seq, ok := parseEcho(buf[:n])
+		if !ok || typ != replyType || from == nil {
+			continue
+		}
+		// Linux rewrites the identifier of datagram sockets to their port
+		// and only hands them their own replies. Elsewhere the identifier
+		// is left alone and every socket sees every reply, so it has to
+		// match ours.
+		if (sock.privileged || !datagramEchoIDRewritten) && id != e.id {
+			continue
+		}
+
+		e.mutex.Lock()
+		waiter, exists := e.pending[seq]
+		e.mutex.Unlock()
+
+		if !exists || (!waiter.multicast && !waiter.dst.Equal(from.IP)) {
+			continue
+		}
+
+		select {
+		case waiter.reply <- icmpReply{from: from, ttl: ttl, received: received}:
+		default:
+		}
+	}
+}
+
+// marshalEcho builds an echo request. The kernel fills in ICMPv6 checksums
+// because they cover a pseudo-header with the source address.
+func marshalEcho(typ uint8, id, seq uint16, checksum bool) []byte {
+	packet := make([]byte, 8+icmpPayloadSize)
+	packet[0] = typ
+	packet[1] = 0
+	binary.BigEndian.PutUint16(packet[4:], id)
+	binary.BigEndian.PutUint16(packet[6:], seq)
+
+	binary.BigEndian.PutUint64(packet[8:], uint64(time.Now().UnixNano()))
+	for i := 16; i < len(packet); i++ {
+		packet[i] = byte(i)
+	}
+
+	if checksum {
+		binary.BigEndian.PutUint16(packet[2:], icmpChecksum(packet))
+	}
+	return packet
+}
+
+func parseEcho(packet []byte) (typ uint8, id, seq uint16, ok bool) {
+	if len(packet) < 8 {
+		return 0, 0, 0, false
+	}
+	return packet[0], binary.BigEndian.Uint16(packet[4:]), binary.BigEndian.Uint16(packet[6:]), true
+}
+
+// stripIPv4 removes a leading IPv4 header from buf in place and returns the
+// remaining length together with the header's TTL.
+func stripIPv4(buf []byte, n int) (int, int) {
+	if n < 20 || buf[0]>>4 != 4 {
+		return n, 0
+	}
+	headerLen := int(buf[0]&0x0f) << 2
+	if headerLen < 20 || headerLen > n {
+		return n, 0
+	}
+	ttl := int(buf[8])
+	copy(buf, buf[headerLen:n])
+	return n - headerLen, ttl
+}
+
+func toIPAddr(addr net.Addr) *net.IPAddr {
+	switch v := addr.(type) {
+	case *net.IPAddr:
+		return v
+	case *net.UDPAddr:
+		return &net.IPAddr{IP: v.IP, Zone: v.Zone}
+	}
+	return nil
+}
+
+func icmpChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
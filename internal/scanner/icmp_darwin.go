@@ -0,0 +1,12 @@
+package scanner
+
+// datagramEchoIDRewritten reports whether the kernel replaces the echo
+// identifier of unprivileged ICMP sockets with one of its own.
+const datagramEchoIDRewritten = false
+
+// Values from <netinet6/in6.h>; the syscall package does not export them
+// for darwin.
+const (
+	ipv6RecvHopLimit = 0x25
+	ipv6HopLimit     = 0x2f
+)
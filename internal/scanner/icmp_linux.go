@@ -0,0 +1,12 @@
+package scanner
+
+import "syscall"
+
+// datagramEchoIDRewritten reports whether the kernel replaces the echo
+// identifier of unprivileged ICMP sockets with one of its own.
+const datagramEchoIDRewritten = true
+
+const (
+	ipv6RecvHopLimit = syscall.IPV6_RECVHOPLIMIT
+	ipv6HopLimit     = syscall.IPV6_HOPLIMIT
+)
@@ -0,0 +1,33 @@
+//go:build !linux && !darwin
+
+package scanner
+
+import "net"
+
+// datagramEchoIDRewritten is only consulted for datagram sockets, which are
+// not opened on these platforms.
+const datagramEchoIDRewritten = false
+
+func openICMPConn(ipv6 bool, src net.IP) (net.PacketConn, bool, error) {
+	network, address := "ip4:icmp", "0.0.0.0"
+	if ipv6 {
+		network, address = "ip6:ipv6-icmp", "::"
+	}
+	if src != nil {
+		address = src.String()
+	}
+
+	conn, err := net.ListenPacket(network, address)
+	if err != nil {
+		return nil, false, err
+	}
+	return conn, true, nil
+}
+
+func readICMP(sock *icmpSocket, buf []byte) (int, *net.IPAddr, int, error) {
+	n, addr, err := sock.conn.ReadFrom(buf)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	return n, toIPAddr(addr), 0, nil
+}
@@ -0,0 +1,143 @@
+//go:build linux || darwin
+
+package scanner
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/CyberOakAlpha/CrossNet/internal/osdetect"
+)
+
+// openICMPConn opens an ICMP socket for one address family, bound to src
+// when it is not nil.
+func openICMPConn(ipv6 bool, src net.IP) (net.PacketConn, bool, error) {
+	if conn, err := openDatagramICMP(ipv6, src); err == nil {
+		return conn, false, nil
+	}
+
+	network, address := "ip4:icmp", "0.0.0.0"
+	if ipv6 {
+		network, address = "ip6:ipv6-icmp", "::"
+	}
+	if src != nil {
+		address = src.String()
+	}
+
+	conn, err := net.ListenPacket(network, address)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if ipv6 {
+		// Raw IPv4 sockets carry the TTL in the header they deliver, but
+		// IPv6 never exposes its header so the hop limit has to be
+		// requested as ancillary data
+		if raw, err := conn.(*net.IPConn).SyscallConn(); err == nil {
+			raw.Control(func(fd uintptr) {
+				syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, ipv6RecvHopLimit, 1)
+			})
+		}
+	}
+
+	return conn, true, nil
+}
+
+// openDatagramICMP opens an unprivileged ICMP socket. Linux only allows
+// this for groups listed in net.ipv4.ping_group_range.
+func openDatagramICMP(ipv6 bool, src net.IP) (net.PacketConn, error) {
+	family, proto := syscall.AF_INET, syscall.IPPROTO_ICMP
+	addr4 := &syscall.SockaddrInet4{}
+	var addr syscall.Sockaddr = addr4
+	if src != nil {
+		copy(addr4.Addr[:], src.To4())
+	}
+	if ipv6 {
+		family, proto = syscall.AF_INET6, syscall.IPPROTO_ICMPV6
+		addr6 := &syscall.SockaddrInet6{}
+		if src != nil {
+			copy(addr6.Addr[:], src.To16())
+		}
+		addr = addr6
+	}
+
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM, proto)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Bind(fd, addr); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	if ipv6 {
+		syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, ipv6RecvHopLimit, 1)
+	} else {
+		syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_RECVTTL, 1)
+	}
+
+	file := os.NewFile(uintptr(fd), "icmp")
+	defer file.Close()
+
+	return net.FilePacketConn(file)
+}
+
+func readICMP(sock *icmpSocket, buf []byte) (int, *net.IPAddr, int, error) {
+	oob := make([]byte, 64)
+
+	switch c := sock.conn.(type) {
+	case *net.IPConn:
+		n, oobn, _, addr, err := c.ReadMsgIP(buf, oob)
+		if err != nil {
+			return 0, nil, 0, err
+		}
+		if sock.ipv6 {
+			return n, addr, parseHopControl(oob[:oobn]), nil
+		}
+		// Raw IPv4 sockets deliver the header in front of the message
+		n, ttl := stripIPv4(buf, n)
+		return n, addr, ttl, nil
+	case *net.UDPConn:
+		n, oobn, _, addr, err := c.ReadMsgUDP(buf, oob)
+		if err != nil {
+			return 0, nil, 0, err
+		}
+		from := toIPAddr(addr)
+		if sock.ipv6 {
+			return n, from, parseHopControl(oob[:oobn]), nil
+		}
+		if osdetect.IsDarwin() {
+			n, ttl := stripIPv4(buf, n)
+			return n, from, ttl, nil
+		}
+		return n, from, parseHopControl(oob[:oobn]), nil
+	default:
+		n, addr, err := sock.conn.ReadFrom(buf)
+		if err != nil {
+			return 0, nil, 0, err
+		}
+		return n, toIPAddr(addr), 0, nil
+	}
+}
+
+// parseHopControl extracts the TTL or hop limit from the ancillary data
+// requested with IP_RECVTTL or IPV6_RECVHOPLIMIT.
+func parseHopControl(oob []byte) int {
+	messages, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0
+	}
+
+	for _, msg := range messages {
+		if len(msg.Data) < 4 {
+			continue
+		}
+		if (msg.Header.Level == syscall.IPPROTO_IP && msg.Header.Type == syscall.IP_TTL) ||
+			(msg.Header.Level == syscall.IPPROTO_IPV6 && msg.Header.Type == ipv6HopLimit) {
+			return int(binary.NativeEndian.Uint32(msg.Data))
+		}
+	}
+	return 0
+}
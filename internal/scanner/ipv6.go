@@ -0,0 +1,174 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/CyberOakAlpha/CrossNet/internal/targets"
+)
+
+var errIPv6RangeTooLarge = errors.New("IPv6 prefix too large to enumerate")
+
+// enumerate returns the addresses in set for scanners that have to probe
+// each one, refusing IPv6 prefixes only multicast discovery can cover.
+func enumerate(set *targets.Set) (iter.Seq[string], error) {
+	if prefixes := set.Prefixes(); len(prefixes) > 0 {
+		return nil, fmt.Errorf("%w: %s", errIPv6RangeTooLarge, prefixes[0])
+	}
+	return addrStrings(set.All()), nil
+}
+
+// addrStrings formats addresses lazily for the string-based probes.
+func addrStrings(addrs iter.Seq[netip.Addr]) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for addr := range addrs {
+			if !yield(addr.String()) {
+				return
+			}
+		}
+	}
+}
+
+// discoverIPv6Link pings the all-nodes group ff02::1 on the link that
+// carries ipnet and returns every responder inside the prefix. Replies
+// collected before ctx is cancelled are returned along with ctx.Err().
+func discoverIPv6Link(ctx context.Context, engine *ICMPEngine, ipnet *net.IPNet, ifaceName string, wait time.Duration) ([]PingResult, error) {
+	iface, local, err := selectInterface(ifaceName, ipnet.IP)
+	if err != nil {
+		return nil, err
+	}
+
+	var src net.IP
+	if ipnet.Contains(local) && !local.IsLinkLocalUnicast() {
+		src = local
+	}
+
+	group := &net.IPAddr{IP: net.IPv6linklocalallnodes, Zone: iface.Name}
+	replies, start, err := engine.pingMulticast(ctx, group, src, wait)
+	if err != nil && ctx.Err() == nil {
+		return nil, fmt.Errorf("multicast discovery on %s failed: %v", iface.Name, err)
+	}
+
+	seen := make(map[string]bool)
+	var results []PingResult
+	for _, reply := range replies {
+		if !ipnet.Contains(reply.from.IP) {
+			continue
+		}
+
+		ip := formatIP(reply.from.IP, iface.Name)
+		if seen[ip] {
+			continue
+		}
+		seen[ip] = true
+
+		results = append(results, PingResult{
+			IP:    ip,
+			Alive: true,
+			RTT:   reply.received.Sub(start),
+			TTL:   reply.ttl,
+			Probe: "icmp6-multicast",
+		})
+	}
+
+	return results, ctx.Err()
+}
+
+// selectInterface returns the named interface, or the first up interface
+// with an address in the same subnet as target. The returned address is
+// the interface's own address of the target's family.
+func selectInterface(name string, target net.IP) (*net.Interface, net.IP, error) {
+	wantV4 := target.To4() != nil
+	linkLocal := !wantV4 && target.IsLinkLocalUnicast()
+
+	var candidates []net.Interface
+	if name != "" {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unknown interface %s: %v", name, err)
+		}
+		candidates = []net.Interface{*iface}
+	} else {
+		ifaces, err := net.Interfaces()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get interfaces: %v", err)
+		}
+		candidates = ifaces
+	}
+
+	for i := range candidates {
+		iface := &candidates[i]
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || (ipNet.IP.To4() != nil) != wantV4 {
+				continue
+			}
+			// Every interface carries fe80::/64, so link-local targets are
+			// matched to the first interface with a link-local address
+			// unless one was named
+			if name != "" || ipNet.Contains(target) || (linkLocal && ipNet.IP.IsLinkLocalUnicast()) {
+				return iface, ipNet.IP, nil
+			}
+		}
+	}
+
+	if name != "" {
+		return nil, nil, fmt.Errorf("interface %s has no usable address for %s", name, target)
+	}
+	return nil, nil, fmt.Errorf("no local interface is attached to %s", target)
+}
+
+func prefixNet(prefix netip.Prefix) *net.IPNet {
+	return &net.IPNet{
+		IP:   prefix.Addr().AsSlice(),
+		Mask: net.CIDRMask(prefix.Bits(), prefix.Addr().BitLen()),
+	}
+}
+
+// parseTarget parses an address that may carry an IPv6 zone, such as
+// fe80::1%eth0.
+func parseTarget(ip string) *net.IPAddr {
+	addr, zone := splitZone(ip)
+
+	parsed := net.ParseIP(addr)
+	if parsed == nil {
+		return nil
+	}
+	return &net.IPAddr{IP: parsed, Zone: zone}
+}
+
+func splitZone(ip string) (string, string) {
+	if i := strings.LastIndex(ip, "%"); i >= 0 {
+		return ip[:i], ip[i+1:]
+	}
+	return ip, ""
+}
+
+// formatIP renders ip for results, keeping the zone only where it is
+// needed to reach the address again.
+func formatIP(ip net.IP, zone string) string {
+	if zone != "" && ip.To4() == nil && (ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast()) {
+		return ip.String() + "%" + zone
+	}
+	return ip.String()
+}
+
+func isIPv6(ip string) bool {
+	addr := parseTarget(ip)
+	return addr != nil && addr.IP.To4() == nil
+}
@@ -0,0 +1,201 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// DefaultMonitorWindow is how many recent probes the rolling statistics
+// of a Monitor cover unless SetWindow says otherwise.
+const DefaultMonitorWindow = 20
+
+// MonitorSample is the outcome of one probe sent by a Monitor.
+type MonitorSample struct {
+	Seq   int
+	Time  time.Time
+	IP    string
+	Alive bool
+	RTT   time.Duration
+	TTL   int
+	Probe string
+	Error string
+	// Window holds the statistics over the most recent probes, this one
+	// included.
+	Window *LatencyStats
+}
+
+// Monitor probes a single host at a fixed interval, in the manner of
+// ping -t or mtr, using ICMP echo requests or, after SetPort, TCP
+// connects. It shares the probe code of PingScanner and
+// TCPDiscoveryScanner but needs no target range.
+type Monitor struct {
+	timeout  time.Duration
+	interval time.Duration
+	window   int
+	port     int
+	limiter  *rateLimiter
+}
+
+func NewMonitor(timeout, interval time.Duration) *Monitor {
+	return &Monitor{
+		timeout:  timeout,
+		interval: interval,
+		window:   DefaultMonitorWindow,
+		limiter:  newRateLimiter(RateLimit{}),
+	}
+}
+
+// SetPort makes later runs probe with TCP connects to port instead of
+// echo requests. An accepted or refused connection counts as a reply. A
+// port of zero restores ICMP.
+func (m *Monitor) SetPort(port int) {
+	m.port = port
+}
+
+// SetWindow sets how many recent probes the rolling statistics cover.
+func (m *Monitor) SetWindow(size int) {
+	if size > 0 {
+		m.window = size
+	}
+}
+
+// SetRateLimit paces the probes of later runs.
+func (m *Monitor) SetRateLimit(limit RateLimit) {
+	m.limiter = newRateLimiter(limit)
+}
+
+// Run probes host, an address or a name, every interval and hands each
+// sample to fn. It stops after count probes, or runs until ctx is
+// cancelled when count is zero or less. The statistics over every probe
+// sent are returned, together with ctx.Err() if the run was cut short.
+// The statistics are never nil: a run that fails or is cancelled before
+// its first probe reports none sent.
+func (m *Monitor) Run(ctx context.Context, host string, count int, fn func(MonitorSample)) (*LatencyStats, error) {
+	target, err := resolveMonitorTarget(ctx, host)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return &LatencyStats{}, err
+	}
+	ip := formatIP(target.IP, target.Zone)
+
+	// Fall back to the system ping command when no ICMP socket is available
+	var engine *ICMPEngine
+	if m.port == 0 {
+		if engine, err = NewICMPEngine(); err == nil {
+			defer engine.Close()
+		}
+	}
+
+	var total latencyAccumulator
+	recent := make([]MonitorSample, 0, m.window)
+	start := time.Now()
+
+	for seq := 1; count <= 0 || seq <= count; seq++ {
+		// Probes go out on a fixed schedule, so a slow reply delays the
+		// next probe only when it took longer than the interval
+		if seq > 1 {
+			sleepContext(ctx, time.Until(start.Add(time.Duration(seq-1)*m.interval)))
+		}
+		if err := m.limiter.wait(ctx, ip); err != nil {
+			break
+		}
+
+		sample := m.probe(ctx, engine, target, ip)
+		if ctx.Err() != nil {
+			// An interrupted probe says nothing about the host
+			break
+		}
+		sample.Seq = seq
+
+		if sample.Alive {
+			total.reply(sample.RTT)
+		} else {
+			total.lost()
+		}
+
+		if len(recent) == m.window {
+			recent = append(recent[:0], recent[1:]...)
+		}
+		recent = append(recent, sample)
+		sample.Window = windowStats(recent)
+
+		fn(sample)
+	}
+
+	return total.stats(), ctx.Err()
+}
+
+func (m *Monitor) probe(ctx context.Context, engine *ICMPEngine, target *net.IPAddr, ip string) MonitorSample {
+	sample := MonitorSample{
+		Time: time.Now(),
+		IP:   ip,
+	}
+
+	if m.port != 0 {
+		probeCtx, cancel := context.WithTimeout(ctx, m.timeout)
+		defer cancel()
+
+		state, rtt, _ := tcpConnect(probeCtx, ip, m.port)
+		sample.Probe = fmt.Sprintf("tcp/%d %s", m.port, state)
+		if state == PortFiltered {
+			sample.Error = "no response"
+			return sample
+		}
+		sample.Alive = true
+		sample.RTT = rtt
+		return sample
+	}
+
+	alive, rtt, ttl, err := echo(ctx, engine, target, ip, m.timeout)
+	sample.Probe = "icmp"
+	switch {
+	case alive:
+		sample.Alive = true
+		sample.RTT = rtt
+		sample.TTL = ttl
+	case err != nil:
+		sample.Error = err.Error()
+	default:
+		sample.Error = errPingTimeout.Error()
+	}
+	return sample
+}
+
+func windowStats(samples []MonitorSample) *LatencyStats {
+	var acc latencyAccumulator
+	for _, sample := range samples {
+		if sample.Alive {
+			acc.reply(sample.RTT)
+		} else {
+			acc.lost()
+		}
+	}
+	return acc.stats()
+}
+
+// resolveMonitorTarget parses host as an address, with an optional IPv6
+// zone, or looks it up, preferring IPv4 as ping does.
+func resolveMonitorTarget(ctx context.Context, host string) (*net.IPAddr, error) {
+	if target := parseTarget(host); target != nil {
+		return target, nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %v", host, err)
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("no addresses found for " + host)
+	}
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			return &addr, nil
+		}
+	}
+	return &addrs[0], nil
+}
@@ -0,0 +1,27 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMonitorCancelledDuringResolution(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	monitor := NewMonitor(time.Second, time.Second)
+	summary, err := monitor.Run(ctx, "monitor.invalid", 0, func(sample MonitorSample) {
+		t.Errorf("unexpected sample %+v", sample)
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v, want %v", err, context.Canceled)
+	}
+	if summary == nil {
+		t.Fatal("nil summary")
+	}
+	if summary.Sent != 0 || summary.Received != 0 {
+		t.Errorf("summary %+v, want no probes", summary)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package scanner
+
+import (
+	"context"
+	"errors"
+	"iter"
+)
+
+func (as *ARPScanner) ndpScan(ctx context.Context, ips iter.Seq[string], wanted func(string) bool, found func(ARPEntry)) error {
+	return errors.New("native neighbor discovery is not supported on this platform")
+}
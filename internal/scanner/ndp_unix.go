@@ -0,0 +1,175 @@
+//go:build linux || darwin
+
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	ndpNeighborSolicitation  = 135
+	ndpNeighborAdvertisement = 136
+
+	ndpOptSourceLinkAddr = 1
+	ndpOptTargetLinkAddr = 2
+)
+
+// ndpScan is the IPv6 counterpart of the ARP sweep: it sends Neighbor
+// Solicitations to each target's solicited-node multicast group and reads
+// the MAC from the target link-layer option of the advertisements. found is
+// called as in nativeScan. It needs a raw ICMPv6 socket.
+func (as *ARPScanner) ndpScan(ctx context.Context, ips iter.Seq[string], wanted func(string) bool, found func(ARPEntry)) error {
+	firstIP, ok := firstAddr(ips)
+	if !ok {
+		return errors.New("no targets to scan")
+	}
+
+	first := parseTarget(firstIP)
+	if first == nil {
+		return fmt.Errorf("invalid address: %s", firstIP)
+	}
+
+	ifaceName := as.iface
+	if ifaceName == "" {
+		ifaceName = first.Zone
+	}
+	iface, _, err := selectInterface(ifaceName, first.IP)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		return fmt.Errorf("failed to open ICMPv6 socket: %v", err)
+	}
+	defer conn.Close()
+
+	// Receivers drop Neighbor Discovery messages whose hop limit is not 255
+	raw, err := conn.(*net.IPConn).SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	raw.Control(func(fd uintptr) {
+		if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_HOPS, 255); err != nil {
+			sockErr = err
+			return
+		}
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, 255)
+	})
+	if sockErr != nil {
+		return fmt.Errorf("failed to set ICMPv6 hop limit: %v", sockErr)
+	}
+
+	// Only the reader goroutine touches seen until it has stopped
+	seen := make(map[string]bool)
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		buf := make([]byte, 1500)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			target, mac, ok := parseNeighborAdvertisement(buf[:n])
+			if !ok {
+				continue
+			}
+			// Link-local targets may have been given with or without
+			// their zone
+			ip := formatIP(target, iface.Name)
+			if !wanted(ip) {
+				ip = target.String()
+				if !wanted(ip) {
+					continue
+				}
+			}
+
+			if !seen[ip] {
+				seen[ip] = true
+				found(ARPEntry{IP: ip, MAC: mac, Online: true})
+			}
+		}
+	}()
+
+	var sendErr error
+	for ip := range ips {
+		addr := parseTarget(ip)
+		if addr == nil || addr.IP.To4() != nil {
+			continue
+		}
+		if as.limiter.wait(ctx, ip) != nil {
+			break
+		}
+
+		group := &net.IPAddr{IP: solicitedNodeMulticast(addr.IP), Zone: iface.Name}
+		if _, err := conn.WriteTo(marshalNeighborSolicitation(addr.IP, iface.HardwareAddr), group); err != nil {
+			sendErr = err
+			break
+		}
+		time.Sleep(solicitSpacing)
+	}
+
+	if sendErr == nil {
+		sleepContext(ctx, neighborReplyWait)
+	}
+	conn.Close()
+	<-stopped
+
+	if sendErr != nil && len(seen) == 0 {
+		return fmt.Errorf("failed to send neighbor solicitation: %v", sendErr)
+	}
+
+	return nil
+}
+
+// solicitedNodeMulticast returns ff02::1:ffXX:XXXX for the low 24 bits of ip.
+func solicitedNodeMulticast(ip net.IP) net.IP {
+	group := net.ParseIP("ff02::1:ff00:0")
+	copy(group[13:], ip.To16()[13:])
+	return group
+}
+
+func marshalNeighborSolicitation(target net.IP, srcMAC net.HardwareAddr) []byte {
+	packet := make([]byte, 24, 32)
+	packet[0] = ndpNeighborSolicitation
+	copy(packet[8:24], target.To16())
+
+	if len(srcMAC) == 6 {
+		packet = append(packet, ndpOptSourceLinkAddr, 1)
+		packet = append(packet, srcMAC...)
+	}
+
+	// The kernel computes the ICMPv6 checksum
+	return packet
+}
+
+func parseNeighborAdvertisement(packet []byte) (net.IP, string, bool) {
+	if len(packet) < 24 || packet[0] != ndpNeighborAdvertisement || packet[1] != 0 {
+		return nil, "", false
+	}
+	target := net.IP(append([]byte(nil), packet[8:24]...))
+
+	for options := packet[24:]; len(options) >= 8; {
+		length := int(options[1]) * 8
+		if length == 0 || length > len(options) {
+			break
+		}
+		if options[0] == ndpOptTargetLinkAddr && length >= 8 {
+			mac := net.HardwareAddr(options[2:8]).String()
+			return target, normalizeMAC(mac), true
+		}
+		options = options[length:]
+	}
+
+	return nil, "", false
+}
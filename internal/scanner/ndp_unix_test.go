@@ -0,0 +1,78 @@
+//go:build linux || darwin
+
+package scanner
+
+import (
+	"net"
+	"testing"
+)
+
+// testNeighborAdvertisement builds an advertisement for target followed by
+// options.
+func testNeighborAdvertisement(target string, options ...byte) []byte {
+	packet := make([]byte, 24)
+	packet[0] = ndpNeighborAdvertisement
+	packet[4] = 0x60 // solicited, override
+	copy(packet[8:], net.ParseIP(target))
+	return append(packet, options...)
+}
+
+func TestParseNeighborAdvertisement(t *testing.T) {
+	targetLink := []byte{ndpOptTargetLinkAddr, 1, 0x02, 0xfc, 0x00, 0x00, 0x00, 0x05}
+	sourceLink := []byte{ndpOptSourceLinkAddr, 1, 0x02, 0xfc, 0x00, 0x00, 0x00, 0x06}
+	// A nonce option, two units long
+	nonce := []byte{14, 2, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14}
+
+	tests := []struct {
+		name   string
+		packet []byte
+		ip     string
+		mac    string
+	}{
+		{"target link-layer address", testNeighborAdvertisement("fe80::1", targetLink...), "fe80::1", "02:FC:00:00:00:05"},
+		{"after other options", testNeighborAdvertisement("2001:db8::5", append(append(sourceLink, nonce...), targetLink...)...), "2001:db8::5", "02:FC:00:00:00:05"},
+		{"no options", testNeighborAdvertisement("fe80::1"), "", ""},
+		{"only a source address", testNeighborAdvertisement("fe80::1", sourceLink...), "", ""},
+		{"zero-length option", testNeighborAdvertisement("fe80::1", append([]byte{ndpOptSourceLinkAddr, 0, 0, 0, 0, 0, 0, 0}, targetLink...)...), "", ""},
+		{"option longer than the packet", testNeighborAdvertisement("fe80::1", ndpOptTargetLinkAddr, 2, 0x02, 0xfc, 0, 0, 0, 5), "", ""},
+		{"solicitation", marshalNeighborSolicitation(net.ParseIP("fe80::1"), net.HardwareAddr{2, 0xfc, 0, 0, 0, 5}), "", ""},
+		{"nonzero code", func() []byte { p := testNeighborAdvertisement("fe80::1", targetLink...); p[1] = 1; return p }(), "", ""},
+	}
+	for _, test := range tests {
+		ip, mac, ok := parseNeighborAdvertisement(test.packet)
+		if test.ip == "" {
+			if ok {
+				t.Errorf("%s: parseNeighborAdvertisement = %s, %s; want nothing", test.name, ip, mac)
+			}
+			continue
+		}
+		if !ok || !ip.Equal(net.ParseIP(test.ip)) || mac != test.mac {
+			t.Errorf("%s: parseNeighborAdvertisement = %s, %s, %v; want %s, %s", test.name, ip, mac, ok, test.ip, test.mac)
+		}
+	}
+
+	packet := testNeighborAdvertisement("fe80::1", targetLink...)
+	for n := range len(packet) {
+		if ip, mac, ok := parseNeighborAdvertisement(packet[:n]); ok {
+			t.Errorf("parseNeighborAdvertisement of %d bytes = %s, %s", n, ip, mac)
+		}
+	}
+}
+
+func TestNeighborSolicitation(t *testing.T) {
+	target := net.ParseIP("2001:db8::12:3456")
+	if group := solicitedNodeMulticast(target); !group.Equal(net.ParseIP("ff02::1:ff12:3456")) {
+		t.Errorf("solicitedNodeMulticast(%s) = %s, want ff02::1:ff12:3456", target, group)
+	}
+
+	packet := marshalNeighborSolicitation(target, net.HardwareAddr{2, 0xfc, 0, 0, 0, 5})
+	if len(packet) != 32 || packet[0] != ndpNeighborSolicitation || !net.IP(packet[8:24]).Equal(target) {
+		t.Fatalf("solicitation = %x", packet)
+	}
+	if packet[24] != ndpOptSourceLinkAddr || packet[25] != 1 || net.HardwareAddr(packet[26:32]).String() != "02:fc:00:00:00:05" {
+		t.Errorf("source link-layer option = %x", packet[24:])
+	}
+	if packet := marshalNeighborSolicitation(target, nil); len(packet) != 24 {
+		t.Errorf("solicitation without a MAC is %d bytes, want 24", len(packet))
+	}
+}
@@ -1,145 +1,377 @@
 package scanner
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"net/netip"
 	"os/exec"
+	"regexp"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
-	"github.com/CyberOakAlpha/CrossNet/internal/hostname"
 	"github.com/CyberOakAlpha/CrossNet/internal/osdetect"
+	"github.com/CyberOakAlpha/CrossNet/internal/targets"
 )
 
+// execPingSlack is how much longer than the probe timeout the system ping
+// command may run, to allow for process start-up.
+const execPingSlack = 250 * time.Millisecond
+
 type PingResult struct {
-	IP       string
-	Hostname string
-	Alive    bool
-	RTT      time.Duration
-	Error    string
+	IP    string
+	Alive bool
+	RTT   time.Duration
+	TTL   int
+	Probe string
+	Error string
+	// Stats is only set in quality mode, where RTT is the average of the
+	// replies.
+	Stats *LatencyStats `json:",omitempty"`
 }
 
 type PingScanner struct {
-	timeout  time.Duration
+	timing   Timing
+	count    int
+	interval time.Duration
 	threads  int
 	protocol string
-	resolver *hostname.HostnameResolver
+	iface    string
+	limiter  *rateLimiter
 }
 
+// NewPingScanner returns a scanner using the normal timing template that
+// waits timeout for each host until replies have been timed.
 func NewPingScanner(timeout time.Duration, threads int) *PingScanner {
 	return &PingScanner{
-		timeout:  timeout,
-		threads:  threads,
-		resolver: hostname.NewHostnameResolver(),
+		timing:  TimingNormal.WithTimeout(timeout),
+		threads: threads,
+		limiter: newRateLimiter(RateLimit{}),
 	}
 }
 
+// SetInterface selects the link used for IPv6 multicast discovery.
+func (ps *PingScanner) SetInterface(name string) {
+	ps.iface = name
+}
+
+// SetTiming selects how later scans adapt their timeouts and how often
+// they retry silent hosts.
+func (ps *PingScanner) SetTiming(timing Timing) {
+	ps.timing = timing
+}
+
+// SetQuality switches later scans to quality mode, which sends count echo
+// requests interval apart to every host and reports loss and latency
+// statistics. A count of one or less restores plain discovery.
+func (ps *PingScanner) SetQuality(count int, interval time.Duration) {
+	ps.count = count
+	ps.interval = interval
+}
+
+// SetRateLimit paces the echo requests sent by later scans.
+func (ps *PingScanner) SetRateLimit(limit RateLimit) {
+	ps.limiter = newRateLimiter(limit)
+}
+
 func (ps *PingScanner) ScanRange(network string) ([]PingResult, error) {
-	ips, err := generateIPRange(network)
+	return ps.ScanRangeContext(context.Background(), network)
+}
+
+// ScanRangeContext is like ScanRange but stops dispatching probes once ctx
+// is cancelled. Probes still in flight are abandoned, and the hosts
+// determined so far are returned together with ctx.Err().
+func (ps *PingScanner) ScanRangeContext(ctx context.Context, network string) ([]PingResult, error) {
+	set, err := targets.Parse(network)
 	if err != nil {
 		return nil, err
 	}
+	return ps.ScanTargets(ctx, set)
+}
 
-	results := make([]PingResult, 0, len(ips))
-	resultChan := make(chan PingResult, len(ips))
-	semaphore := make(chan struct{}, ps.threads)
+// ScanTargets is like ScanRangeContext for an already parsed target set.
+func (ps *PingScanner) ScanTargets(ctx context.Context, set *targets.Set) ([]PingResult, error) {
+	results := make([]PingResult, 0)
+	err := ps.StreamTargets(ctx, set, func(result PingResult, _ Progress) {
+		results = append(results, result)
+	})
+	if err != nil && ctx.Err() == nil {
+		return nil, err
+	}
+	return results, err
+}
 
-	var wg sync.WaitGroup
+// StreamRange pings every address in network and hands each result to fn
+// as soon as it is known, together with the scan's progress. fn is called
+// from a single goroutine. Cancelling ctx stops the scan as in
+// ScanRangeContext.
+func (ps *PingScanner) StreamRange(ctx context.Context, network string, fn func(PingResult, Progress)) error {
+	set, err := targets.Parse(network)
+	if err != nil {
+		return err
+	}
+	return ps.StreamTargets(ctx, set, fn)
+}
 
-	for _, ip := range ips {
-		wg.Add(1)
-		go func(ipAddr string) {
-			defer wg.Done()
-			semaphore <- struct{}{}
-			defer func() { <-semaphore }()
+// StreamTargets is like StreamRange for an already parsed target set.
+func (ps *PingScanner) StreamTargets(ctx context.Context, set *targets.Set, fn func(PingResult, Progress)) error {
+	// Multicast discovery learns every responder in a wide IPv6 prefix at
+	// once, so those hosts are reported before the enumerated targets
+	var discovered []PingResult
+	for _, prefix := range set.Prefixes() {
+		results, err := ps.scanIPv6Link(ctx, prefix, set)
+		discovered = append(discovered, results...)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return err
+		}
+	}
 
-			result := ps.pingHost(ipAddr)
-			resultChan <- result
-		}(ip)
+	progress := newProgressCounter(len(discovered)+set.Len(), ps.limiter)
+	for _, result := range discovered {
+		fn(result, progress.step())
+	}
+	if set.Len() == 0 || ctx.Err() != nil {
+		return ctx.Err()
 	}
 
-	go func() {
-		wg.Wait()
-		close(resultChan)
-	}()
+	// Fall back to the system ping command when no ICMP socket is available
+	engine, err := NewICMPEngine()
+	if err == nil {
+		defer engine.Close()
+	}
 
-	for result := range resultChan {
+	timing := newTimingEngine(ps.timing)
+	runPool(ctx, ps.threads, addrStrings(set.All()), func(ip string) (PingResult, bool) {
+		result := ps.probe(ctx, engine, timing, ip)
+		// An interrupted probe says nothing about the host
+		return result, result.Alive || ctx.Err() == nil
+	}, func(result PingResult) {
+		fn(result, progress.step())
+	})
+
+	return ctx.Err()
+}
+
+// scanIPv6Link discovers hosts in an IPv6 prefix too large to enumerate by
+// pinging the all-nodes multicast group on the attached link. Responders
+// excluded from set are dropped.
+func (ps *PingScanner) scanIPv6Link(ctx context.Context, prefix netip.Prefix, set *targets.Set) ([]PingResult, error) {
+	engine, err := NewICMPEngine()
+	if err != nil {
+		return nil, err
+	}
+	defer engine.Close()
+
+	responders, err := discoverIPv6Link(ctx, engine, prefixNet(prefix), ps.iface, ps.timing.InitialTimeout)
+
+	var results []PingResult
+	for _, result := range responders {
+		if addr, parseErr := netip.ParseAddr(result.IP); parseErr == nil && !set.Contains(addr) {
+			continue
+		}
 		results = append(results, result)
 	}
 
-	return results, nil
+	if err != nil {
+		return results, err
+	}
+	return results, ctx.Err()
 }
 
-func (ps *PingScanner) pingHost(ip string) PingResult {
+// probe sends echo requests to ip until one is answered or the retries of
+// the timing template run out, each waiting as long as timing suggests. In
+// quality mode it sends the configured number of probes instead and
+// attaches their statistics.
+func (ps *PingScanner) probe(ctx context.Context, engine *ICMPEngine, timing *timingEngine, ip string) PingResult {
 	result := PingResult{
 		IP:    ip,
 		Alive: false,
 	}
 
-	result.Hostname = ps.resolver.Resolve(ip)
+	target := parseTarget(ip)
+	if target == nil {
+		result.Error = "invalid address"
+		return result
+	}
 
-	var cmd *exec.Cmd
-	start := time.Now()
+	if ps.count > 1 {
+		return ps.measure(ctx, engine, timing, target, result)
+	}
 
-	switch osdetect.DetectOS() {
-	case osdetect.Windows:
-		cmd = exec.Command("ping", "-n", "1", "-w", strconv.Itoa(int(ps.timeout.Milliseconds())), ip)
-	case osdetect.Linux, osdetect.Darwin:
-		timeoutSec := int(ps.timeout.Seconds())
-		if timeoutSec == 0 {
-			timeoutSec = 1
+	for attempt := 0; ; attempt++ {
+		if err := ps.limiter.wait(ctx, ip); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+
+		alive, rtt, ttl, err := echo(ctx, engine, target, ip, timing.timeout(ip, attempt))
+		if alive {
+			timing.observe(ip, rtt)
+			result.Alive = true
+			result.RTT = rtt
+			result.TTL = ttl
+			result.Probe = "icmp"
+			return result
+		}
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		if attempt >= timing.timing.Retries {
+			result.Error = errPingTimeout.Error()
+			return result
 		}
-		cmd = exec.Command("ping", "-c", "1", "-W", strconv.Itoa(timeoutSec), ip)
-	default:
-		result.Error = "Unsupported operating system"
-		return result
 	}
+}
 
-	output, err := cmd.Output()
-	result.RTT = time.Since(start)
+// measure sends ps.count echo requests ps.interval apart and summarises
+// the replies. Lost probes are not retried, since they are what is being
+// measured.
+func (ps *PingScanner) measure(ctx context.Context, engine *ICMPEngine, timing *timingEngine, target *net.IPAddr, result PingResult) PingResult {
+	var rtts []time.Duration
+	sent := 0
 
-	if err != nil {
-		result.Error = err.Error()
-		return result
+	for i := 0; i < ps.count; i++ {
+		if i > 0 {
+			sleepContext(ctx, ps.interval)
+		}
+		if err := ps.limiter.wait(ctx, result.IP); err != nil {
+			break
+		}
+		sent++
+
+		alive, rtt, ttl, err := echo(ctx, engine, target, result.IP, timing.timeout(result.IP, 0))
+		if alive {
+			timing.observe(result.IP, rtt)
+			rtts = append(rtts, rtt)
+			result.TTL = ttl
+			continue
+		}
+		if err != nil {
+			result.Error = err.Error()
+			break
+		}
 	}
 
-	outputStr := string(output)
-	outputLower := strings.ToLower(outputStr)
-	if strings.Contains(outputLower, "ttl=") ||
-		strings.Contains(outputLower, "time=") ||
-		strings.Contains(outputLower, "time<") ||
-		strings.Contains(outputStr, "bytes from") {
-		result.Alive = true
+	result.Stats = newLatencyStats(sent, rtts)
+	if len(rtts) == 0 {
+		if result.Error == "" {
+			result.Error = errPingTimeout.Error()
+		}
+		return result
 	}
 
+	result.Alive = true
+	result.Error = ""
+	result.RTT = result.Stats.Avg
+	result.Probe = "icmp"
 	return result
 }
 
-func generateIPRange(network string) ([]string, error) {
-	ip, ipnet, err := net.ParseCIDR(network)
-	if err != nil {
-		return nil, fmt.Errorf("invalid CIDR: %v", err)
+// echo sends a single echo request, falling back to the system ping
+// command when no ICMP socket is available. A host that stays silent for
+// timeout is reported as not alive without an error.
+func echo(ctx context.Context, engine *ICMPEngine, target *net.IPAddr, ip string, timeout time.Duration) (bool, time.Duration, int, error) {
+	if engine == nil {
+		return execPingAlive(ctx, ip, timeout)
 	}
 
-	var ips []string
-	for ip := ip.Mask(ipnet.Mask); ipnet.Contains(ip); incrementIP(ip) {
-		ips = append(ips, ip.String())
+	rtt, ttl, err := engine.Ping(ctx, target, timeout)
+	if errors.Is(err, errPingTimeout) {
+		return false, 0, 0, nil
 	}
+	return err == nil, rtt, ttl, err
+}
+
+var (
+	// "time=0.045 ms" on Linux and macOS, "time=12ms" or "time<1ms" on
+	// Windows
+	pingTimeField = regexp.MustCompile(`(?i)time[=<]\s*([0-9.]+)\s*ms`)
+	pingTTLField  = regexp.MustCompile(`(?i)ttl[=:]\s*(\d+)`)
+)
+
+// execPingAlive sends one echo request with the system ping command. A
+// host that does not answer within timeout is reported as not alive
+// without an error. The round-trip time and TTL are taken from the output
+// of ping, so that process start-up is not counted; the wall-clock time is
+// only used when the output cannot be parsed.
+func execPingAlive(ctx context.Context, ip string, timeout time.Duration) (bool, time.Duration, int, error) {
+	// ping only takes whole seconds on some platforms, so the exact timeout
+	// is enforced by killing it, leaving some slack for the process start
+	cmdCtx, cancel := context.WithTimeout(ctx, timeout+execPingSlack)
+	defer cancel()
 
-	if len(ips) > 2 {
-		ips = ips[1 : len(ips)-1]
+	cmd := pingCommand(cmdCtx, ip, timeout)
+	if cmd == nil {
+		return false, 0, 0, fmt.Errorf("unsupported operating system")
 	}
 
-	return ips, nil
+	start := time.Now()
+	output, err := cmd.Output()
+	rtt := time.Since(start)
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		return false, rtt, 0, ctx.Err()
+	case cmdCtx.Err() != nil:
+		return false, rtt, 0, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		// Exit status 1 means no reply arrived; 2 and above are errors
+		return false, rtt, 0, nil
+	default:
+		return false, rtt, 0, err
+	}
+
+	outputStr := string(output)
+	ttl := 0
+	if matches := pingTTLField.FindStringSubmatch(outputStr); matches != nil {
+		ttl, _ = strconv.Atoi(matches[1])
+	}
+	if matches := pingTimeField.FindStringSubmatch(outputStr); matches != nil {
+		if ms, parseErr := strconv.ParseFloat(matches[1], 64); parseErr == nil {
+			rtt = time.Duration(ms * float64(time.Millisecond))
+		}
+	}
+
+	// ping on Linux and macOS only exits zero when a reply arrived, so the
+	// localized output only needs inspecting on Windows
+	if osdetect.DetectOS() != osdetect.Windows {
+		return true, rtt, ttl, nil
+	}
+
+	outputLower := strings.ToLower(outputStr)
+	alive := strings.Contains(outputLower, "ttl=") ||
+		strings.Contains(outputLower, "time=") ||
+		strings.Contains(outputLower, "time<") ||
+		strings.Contains(outputStr, "bytes from")
+
+	return alive, rtt, ttl, nil
 }
 
-func incrementIP(ip net.IP) {
-	for j := len(ip) - 1; j >= 0; j-- {
-		ip[j]++
-		if ip[j] > 0 {
-			break
+// pingCommand builds a single-probe invocation of the system ping command
+// that is killed when ctx is cancelled.
+func pingCommand(ctx context.Context, ip string, timeout time.Duration) *exec.Cmd {
+	switch osdetect.DetectOS() {
+	case osdetect.Windows:
+		return exec.CommandContext(ctx, "ping", "-n", "1", "-w", strconv.Itoa(int(timeout.Milliseconds())), ip)
+	case osdetect.Linux:
+		// Round up so that ping itself never gives up before timeout
+		timeoutSec := int((timeout + time.Second - 1) / time.Second)
+		return exec.CommandContext(ctx, "ping", "-c", "1", "-W", strconv.Itoa(timeoutSec), ip)
+	case osdetect.Darwin:
+		if isIPv6(ip) {
+			return exec.CommandContext(ctx, "ping6", "-c", "1", ip)
 		}
+		// -W is in milliseconds on macOS
+		return exec.CommandContext(ctx, "ping", "-c", "1", "-W", strconv.Itoa(int(timeout.Milliseconds())), ip)
+	default:
+		return nil
 	}
-}
\ No newline at end of file
+}
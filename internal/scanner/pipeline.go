@@ -0,0 +1,213 @@
+package scanner
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/CyberOakAlpha/CrossNet/internal/hostname"
+	"github.com/CyberOakAlpha/CrossNet/internal/snmp"
+	"github.com/CyberOakAlpha/CrossNet/internal/targets"
+)
+
+// Stages of a Pipeline, as reported in each PipelineUpdate.
+const (
+	StageDiscovery  = "discovery"
+	StageNeighbors  = "neighbors"
+	StageEnrichment = "enrichment"
+)
+
+// neighborRefresh is how often the neighbour stage re-reads the neighbour
+// cache while discovery is still running.
+const neighborRefresh = time.Second
+
+// Discoverer is a host discovery scan such as a PingScanner or a
+// TCPDiscoveryScanner.
+type Discoverer interface {
+	StreamTargets(ctx context.Context, set *targets.Set, fn func(PingResult, Progress)) error
+}
+
+// PipelineUpdate reports a host that a stage of a Pipeline has merged new
+// evidence into.
+type PipelineUpdate struct {
+	Stage string
+	// Host is the host as merged so far. It is nil for discovery results
+	// that were not kept because the target did not answer.
+	Host *Host
+	// Progress is that of the discovery stage when the update was made
+	Progress Progress
+}
+
+// Pipeline finds hosts in a single pass over the targets. Each target is
+// probed once by the discovery stage. The hosts that answer are passed to
+// the neighbour stage, which looks up their MACs in the neighbour cache
+// the probes have just filled, and at the end adds the targets found only
+// in the cache. It sends no packets of its own. The hosts that answered
+// discovery are passed on to the enrichment stage, which resolves names,
+// and queries SNMP agents if enabled, in the background so that discovery
+// results are reported at once and names follow as separate updates.
+// Hosts known only from the cache are not enriched. Every stage merges its
+// evidence into a HostTable.
+type Pipeline struct {
+	discoverer Discoverer
+	hosts      *HostTable
+	neighbors  bool
+	keepDown   bool
+	methods    []hostname.MethodConfig
+	snmp       *snmp.Client
+}
+
+func NewPipeline(discoverer Discoverer, hosts *HostTable) *Pipeline {
+	return &Pipeline{
+		discoverer: discoverer,
+		hosts:      hosts,
+		methods:    hostname.DefaultMethods(),
+	}
+}
+
+// SetNeighbors enables the neighbour stage in later runs.
+func (p *Pipeline) SetNeighbors(enabled bool) {
+	p.neighbors = enabled
+}
+
+// SetKeepDown makes later runs merge the targets that did not answer as
+// down hosts instead of leaving them out.
+func (p *Pipeline) SetKeepDown(keep bool) {
+	p.keepDown = keep
+}
+
+// SetResolveMethods selects the hostname resolution methods of the
+// enrichment stage in later runs. With none, names are not resolved.
+func (p *Pipeline) SetResolveMethods(methods []hostname.MethodConfig) {
+	p.methods = methods
+}
+
+// SetSNMP makes the enrichment stage of later runs query the SNMP agent of
+// each live host with client. With nil, SNMP is not queried.
+func (p *Pipeline) SetSNMP(client *snmp.Client) {
+	p.snmp = client
+}
+
+// Run takes the targets in set through the pipeline, handing every update
+// to fn. fn is never called concurrently. Cancelling ctx stops the
+// discovery stage as in the Discoverer, and the later stages wind down
+// without reading the neighbour cache again. The discovery error, or
+// ctx.Err() if the run was cut short, is returned once every stage is done.
+func (p *Pipeline) Run(ctx context.Context, set *targets.Set, fn func(PipelineUpdate)) error {
+	var mutex sync.Mutex
+	var progress Progress
+	emit := func(stage string, host *Host) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		fn(PipelineUpdate{Stage: stage, Host: host, Progress: progress})
+	}
+
+	enricher := NewEnricher(p.hosts)
+	enricher.SetMethods(p.methods)
+	enricher.SetSNMP(p.snmp)
+	enricher.Start(ctx, func(host Host) {
+		emit(StageEnrichment, &host)
+	})
+
+	found := make(chan Host, 256)
+	harvested := make(chan struct{})
+	go func() {
+		defer close(harvested)
+		p.harvest(ctx, set, found, enricher, emit)
+	}()
+
+	err := p.discoverer.StreamTargets(ctx, set, func(result PingResult, current Progress) {
+		mutex.Lock()
+		progress = current
+		mutex.Unlock()
+
+		if !result.Alive && !p.keepDown {
+			emit(StageDiscovery, nil)
+			return
+		}
+		host := p.hosts.AddPing(result)
+		emit(StageDiscovery, &host)
+		if result.Alive {
+			found <- host
+		}
+	})
+	close(found)
+	<-harvested
+	enricher.Wait()
+	return err
+}
+
+// harvest merges the MAC of each host from found and passes the host on to
+// the enricher. Neighbours in the target set that did not answer discovery
+// are merged as cached entries and are not enriched, since only the cache
+// vouches for them. The neighbour cache is read at most once every
+// neighborRefresh while discovery runs, and once more at the end to catch
+// the hosts answered since.
+func (p *Pipeline) harvest(ctx context.Context, set *targets.Set, found <-chan Host, enricher *Enricher, emit func(string, *Host)) {
+	if !p.neighbors {
+		for host := range found {
+			enricher.Add(host)
+		}
+		return
+	}
+
+	var entries []ARPEntry
+	byIP := make(map[string]ARPEntry)
+	var lastRead time.Time
+	refresh := func() {
+		lastRead = time.Now()
+		table, err := readNeighborTable(ctx)
+		if err != nil {
+			return
+		}
+		entries = table
+		clear(byIP)
+		for _, entry := range entries {
+			byIP[entry.IP] = entry
+		}
+	}
+
+	merged := make(map[string]bool)
+	merge := func(entry ARPEntry) Host {
+		merged[entry.IP] = true
+		host := p.hosts.AddARP(entry, true)
+		emit(StageNeighbors, &host)
+		return host
+	}
+
+	var pending []string
+	for host := range found {
+		entry, ok := byIP[host.IP]
+		if !ok && time.Since(lastRead) >= neighborRefresh && ctx.Err() == nil {
+			refresh()
+			entry, ok = byIP[host.IP]
+		}
+		if ok {
+			host = merge(entry)
+		} else {
+			pending = append(pending, host.IP)
+		}
+		enricher.Add(host)
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	refresh()
+	// Hosts that are still missing are off-link
+	for _, ip := range pending {
+		if entry, ok := byIP[ip]; ok {
+			merge(entry)
+		}
+	}
+	for _, entry := range entries {
+		if merged[entry.IP] {
+			continue
+		}
+		if addr, err := netip.ParseAddr(entry.IP); err != nil || !set.Contains(addr) {
+			continue
+		}
+		merge(entry)
+	}
+}
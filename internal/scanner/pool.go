@@ -0,0 +1,58 @@
+package scanner
+
+import (
+	"context"
+	"iter"
+	"sync"
+)
+
+// runPool hands the jobs to a fixed number of workers and passes their
+// results to emit from the calling goroutine. Jobs are pulled from the
+// sequence only as workers become free, so memory use depends on the size
+// of the pool rather than the number of targets. Once ctx is cancelled no
+// further jobs are started. Results for which work returns false are
+// dropped.
+func runPool[J, R any](ctx context.Context, workers int, jobs iter.Seq[J], work func(J) (R, bool), emit func(R)) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobChan := make(chan J)
+	resultChan := make(chan R, workers)
+
+	go func() {
+		defer close(jobChan)
+		for job := range jobs {
+			if ctx.Err() != nil {
+				return
+			}
+			select {
+			case jobChan <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobChan {
+				if result, ok := work(job); ok {
+					resultChan <- result
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(resultChan)
+	}()
+
+	for result := range resultChan {
+		emit(result)
+	}
+}
@@ -0,0 +1,142 @@
+package scanner
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countTo yields 0 to n-1 and counts how many were pulled.
+func countTo(n int, pulled *atomic.Int32) func(func(int) bool) {
+	return func(yield func(int) bool) {
+		for i := range n {
+			pulled.Add(1)
+			if !yield(i) {
+				return
+			}
+		}
+	}
+}
+
+func TestRunPool(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers int
+	}{
+		{"one worker", 1},
+		{"no workers", 0},
+		{"many workers", 8},
+		{"more workers than jobs", 200},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var pulled, running, peak atomic.Int32
+			var got []int
+			runPool(context.Background(), test.workers, countTo(100, &pulled), func(i int) (int, bool) {
+				n := running.Add(1)
+				for {
+					p := peak.Load()
+					if n <= p || peak.CompareAndSwap(p, n) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				running.Add(-1)
+				// Odd results are dropped
+				return i * 2, i%2 == 0
+			}, func(result int) {
+				// emit runs on the calling goroutine, so no lock is needed
+				got = append(got, result)
+			})
+
+			if pulled.Load() != 100 || len(got) != 50 {
+				t.Fatalf("pulled %d jobs and emitted %d results, want 100 and 50", pulled.Load(), len(got))
+			}
+			if limit := int32(max(test.workers, 1)); peak.Load() > limit {
+				t.Errorf("%d jobs ran at once, want at most %d", peak.Load(), limit)
+			}
+			if test.workers <= 1 && !slices.IsSorted(got) {
+				t.Errorf("a single worker emitted %v out of order", got)
+			}
+			slices.Sort(got)
+			for i, result := range got {
+				if result != i*4 {
+					t.Fatalf("results = %v, want every even job doubled", got)
+				}
+			}
+		})
+	}
+}
+
+func TestRunPoolPullsLazily(t *testing.T) {
+	var pulled atomic.Int32
+	release := make(chan struct{})
+	var started sync.WaitGroup
+	started.Add(4)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runPool(context.Background(), 4, countTo(1000, &pulled), func(i int) (int, bool) {
+			if i < 4 {
+				started.Done()
+			}
+			<-release
+			return i, true
+		}, func(int) {})
+	}()
+
+	// With every worker busy, at most one more job waits to be handed over
+	started.Wait()
+	time.Sleep(20 * time.Millisecond)
+	if n := pulled.Load(); n > 5 {
+		t.Errorf("pulled %d jobs while 4 workers were busy, want at most 5", n)
+	}
+	close(release)
+	<-done
+	if n := pulled.Load(); n != 1000 {
+		t.Errorf("pulled %d jobs, want 1000", n)
+	}
+}
+
+func TestRunPoolCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var pulled, worked atomic.Int32
+	emitted := 0
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		runPool(ctx, 4, countTo(1_000_000, &pulled), func(i int) (int, bool) {
+			worked.Add(1)
+			time.Sleep(time.Millisecond)
+			return i, true
+		}, func(int) {
+			emitted++
+			if emitted == 10 {
+				cancel()
+			}
+		})
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("runPool did not return after cancellation")
+	}
+	// Jobs already handed to workers finish, but no new ones start. At
+	// most 4 results were buffered and 4 jobs running when emit cancelled,
+	// and one more may have been handed over as it did
+	if n := worked.Load(); n > 10+4+4+1 {
+		t.Errorf("%d jobs ran after cancelling at 10 results, want at most 19", n)
+	}
+	if n := pulled.Load(); n > worked.Load()+1 {
+		t.Errorf("pulled %d jobs but ran %d", n, worked.Load())
+	}
+	if emitted < 10 || int32(emitted) != worked.Load() {
+		t.Errorf("emitted %d of %d results, want all of them", emitted, worked.Load())
+	}
+}
@@ -0,0 +1,309 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CyberOakAlpha/CrossNet/internal/targets"
+)
+
+//go:embed data/top-ports.txt
+var topPortsData string
+
+type PortState string
+
+const (
+	PortOpen     PortState = "open"
+	PortClosed   PortState = "closed"
+	PortFiltered PortState = "filtered"
+)
+
+type PortResult struct {
+	IP      string
+	Port    int
+	State   PortState
+	Service string
+	Latency time.Duration
+	Error   string
+}
+
+type PortScanner struct {
+	timeout time.Duration
+	threads int
+	limiter *rateLimiter
+}
+
+type topPort struct {
+	port    int
+	service string
+}
+
+var topPorts = loadTopPorts()
+
+func NewPortScanner(timeout time.Duration, threads int) *PortScanner {
+	return &PortScanner{
+		timeout: timeout,
+		threads: threads,
+		limiter: newRateLimiter(RateLimit{}),
+	}
+}
+
+// SetRateLimit paces the connection attempts made by later scans.
+func (ps *PortScanner) SetRateLimit(limit RateLimit) {
+	ps.limiter = newRateLimiter(limit)
+}
+
+func (ps *PortScanner) ScanRange(network string, ports []int) ([]PortResult, error) {
+	return ps.ScanRangeContext(context.Background(), network, ports)
+}
+
+// ScanRangeContext is like ScanRange but stops dispatching connections once
+// ctx is cancelled. Ports that were cut short are left out of the partial
+// results returned with ctx.Err().
+func (ps *PortScanner) ScanRangeContext(ctx context.Context, network string, ports []int) ([]PortResult, error) {
+	set, err := targets.Parse(network)
+	if err != nil {
+		return nil, err
+	}
+	return ps.ScanTargets(ctx, set, ports)
+}
+
+// ScanTargets is like ScanRangeContext for an already parsed target set.
+func (ps *PortScanner) ScanTargets(ctx context.Context, set *targets.Set, ports []int) ([]PortResult, error) {
+	results := make([]PortResult, 0)
+	err := ps.StreamTargets(ctx, set, ports, func(result PortResult, _ Progress) {
+		results = append(results, result)
+	})
+	if err != nil && ctx.Err() == nil {
+		return nil, err
+	}
+
+	SortPortResults(results)
+	return results, err
+}
+
+// SortPortResults orders results by address and then by port.
+func SortPortResults(results []PortResult) {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].IP != results[j].IP {
+			return compareIPs(results[i].IP, results[j].IP) < 0
+		}
+		return results[i].Port < results[j].Port
+	})
+}
+
+// StreamRange connects to every port on every address in network and hands
+// each result to fn as soon as it is known, in completion order, together
+// with the scan's progress. fn is called from a single goroutine.
+func (ps *PortScanner) StreamRange(ctx context.Context, network string, ports []int, fn func(PortResult, Progress)) error {
+	set, err := targets.Parse(network)
+	if err != nil {
+		return err
+	}
+	return ps.StreamTargets(ctx, set, ports, fn)
+}
+
+// StreamTargets is like StreamRange for an already parsed target set.
+func (ps *PortScanner) StreamTargets(ctx context.Context, set *targets.Set, ports []int, fn func(PortResult, Progress)) error {
+	ips, err := enumerate(set)
+	if err != nil {
+		return err
+	}
+	if len(ports) == 0 {
+		return fmt.Errorf("no ports to scan")
+	}
+
+	jobs := func(yield func(portJob) bool) {
+		for ip := range ips {
+			for _, port := range ports {
+				if !yield(portJob{ip: ip, port: port}) {
+					return
+				}
+			}
+		}
+	}
+
+	progress := newProgressCounter(set.Len()*len(ports), ps.limiter)
+	runPool(ctx, ps.threads, jobs, func(job portJob) (PortResult, bool) {
+		result := ps.scanPort(ctx, job.ip, job.port)
+		return result, result.State != PortFiltered || ctx.Err() == nil
+	}, func(result PortResult) {
+		fn(result, progress.step())
+	})
+
+	return ctx.Err()
+}
+
+type portJob struct {
+	ip   string
+	port int
+}
+
+func (ps *PortScanner) scanPort(parent context.Context, ip string, port int) PortResult {
+	result := PortResult{
+		IP:      ip,
+		Port:    port,
+		Service: ServiceName(port),
+	}
+
+	// The timeout only starts once the rate limiter lets the probe go
+	if err := ps.limiter.wait(parent, ip); err != nil {
+		result.State = PortFiltered
+		result.Error = err.Error()
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(parent, ps.timeout)
+	defer cancel()
+
+	state, latency, err := tcpConnect(ctx, ip, port)
+	result.State = state
+	result.Latency = latency
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+// ParsePorts parses a port specification such as "22,80,8000-8100" or
+// "top20". A bare "top" selects the whole embedded top-ports list.
+func ParsePorts(spec string) ([]int, error) {
+	seen := make(map[int]bool)
+	var ports []int
+
+	add := func(port int) {
+		if !seen[port] {
+			seen[port] = true
+			ports = append(ports, port)
+		}
+	}
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(strings.ToLower(part))
+		if part == "" {
+			continue
+		}
+
+		if strings.HasPrefix(part, "top") {
+			count := len(topPorts)
+			if part != "top" {
+				n, err := strconv.Atoi(part[3:])
+				if err != nil || n <= 0 {
+					return nil, fmt.Errorf("invalid top-ports count: %s", part)
+				}
+				if n < count {
+					count = n
+				}
+			}
+			for _, tp := range topPorts[:count] {
+				add(tp.port)
+			}
+			continue
+		}
+
+		if bounds := strings.SplitN(part, "-", 2); len(bounds) == 2 {
+			low, err := parsePort(bounds[0])
+			if err != nil {
+				return nil, err
+			}
+			high, err := parsePort(bounds[1])
+			if err != nil {
+				return nil, err
+			}
+			if low > high {
+				return nil, fmt.Errorf("invalid port range: %s", part)
+			}
+			for port := low; port <= high; port++ {
+				add(port)
+			}
+			continue
+		}
+
+		port, err := parsePort(part)
+		if err != nil {
+			return nil, err
+		}
+		add(port)
+	}
+
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("no ports in specification %q", spec)
+	}
+
+	return ports, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port: %s", s)
+	}
+	return port, nil
+}
+
+// ServiceName returns the conventional service name for a port from the
+// embedded top-ports list, or an empty string when the port is not listed.
+func ServiceName(port int) string {
+	for _, tp := range topPorts {
+		if tp.port == port {
+			return tp.service
+		}
+	}
+	return ""
+}
+
+func loadTopPorts() []topPort {
+	var ports []topPort
+
+	scanner := bufio.NewScanner(strings.NewReader(topPortsData))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		port, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+
+		tp := topPort{port: port}
+		if len(fields) > 1 {
+			tp.service = fields[1]
+		}
+		ports = append(ports, tp)
+	}
+
+	return ports
+}
+
+func compareIPs(a, b string) int {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	if ipA == nil || ipB == nil {
+		return strings.Compare(a, b)
+	}
+	if v4 := ipA.To4(); v4 != nil {
+		ipA = v4
+	}
+	if v4 := ipB.To4(); v4 != nil {
+		ipB = v4
+	}
+	if len(ipA) != len(ipB) {
+		return len(ipA) - len(ipB)
+	}
+	for i := range ipA {
+		if ipA[i] != ipB[i] {
+			return int(ipA[i]) - int(ipB[i])
+		}
+	}
+	return 0
+}
@@ -0,0 +1,71 @@
+package scanner
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParsePorts(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []int
+	}{
+		{"22", []int{22}},
+		{"22,80,443", []int{22, 80, 443}},
+		{" 22 , 80 ,", []int{22, 80}},
+		{"8000-8003", []int{8000, 8001, 8002, 8003}},
+		{"1-1", []int{1}},
+		{"65534-65535", []int{65534, 65535}},
+		// Duplicates are dropped and the order of first mention kept
+		{"443,20-23,22,443", []int{443, 20, 21, 22, 23}},
+		{"top3", []int{80, 23, 443}},
+		{"TOP3,22,80", []int{80, 23, 443, 22}},
+	}
+	for _, test := range tests {
+		got, err := ParsePorts(test.spec)
+		if err != nil || !slices.Equal(got, test.want) {
+			t.Errorf("ParsePorts(%q) = %v, %v; want %v", test.spec, got, err, test.want)
+		}
+	}
+
+	all, err := ParsePorts("top")
+	if err != nil || len(all) != len(topPorts) {
+		t.Fatalf("ParsePorts(top) = %d ports, %v; want %d", len(all), err, len(topPorts))
+	}
+	// Asking for more than the list holds gives the whole list
+	if more, err := ParsePorts("top100000"); err != nil || !slices.Equal(more, all) {
+		t.Errorf("ParsePorts(top100000) = %d ports, %v; want %d", len(more), err, len(all))
+	}
+	if ports, _ := ParsePorts("top20"); len(ports) != 20 || !slices.Equal(ports, all[:20]) {
+		t.Errorf("ParsePorts(top20) = %v, want the first 20 of the list", ports)
+	}
+
+	for _, spec := range []string{
+		"", ",", " ",
+		"0", "65536", "99999", "-1", "http",
+		"80-", "-80", "100-90", "1-65536", "0-10", "a-b", "1-2-3",
+		"top0", "top-5", "topx", "top 5",
+		"22,65536",
+	} {
+		if ports, err := ParsePorts(spec); err == nil {
+			t.Errorf("ParsePorts(%q) = %v, want an error", spec, ports)
+		}
+	}
+}
+
+func TestServiceName(t *testing.T) {
+	tests := []struct {
+		port int
+		want string
+	}{
+		{80, "http"},
+		{443, "https"},
+		{0, ""},
+		{65535, ""},
+	}
+	for _, test := range tests {
+		if got := ServiceName(test.port); got != test.want {
+			t.Errorf("ServiceName(%d) = %q, want %q", test.port, got, test.want)
+		}
+	}
+}
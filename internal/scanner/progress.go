@@ -0,0 +1,62 @@
+package scanner
+
+import "time"
+
+// Progress reports how far a streaming scan has got through its targets.
+type Progress struct {
+	Completed int
+	Total     int
+	Elapsed   time.Duration
+	// Probes counts the packets or connection attempts sent so far, which
+	// can exceed Completed when a target takes several probes.
+	Probes int
+}
+
+// ETA extrapolates the time left from the pace of the targets completed so
+// far. It is zero until the first target completes.
+func (p Progress) ETA() time.Duration {
+	if p.Completed == 0 || p.Completed >= p.Total {
+		return 0
+	}
+	perTarget := p.Elapsed / time.Duration(p.Completed)
+	return perTarget * time.Duration(p.Total-p.Completed)
+}
+
+// Rate returns the probes sent per second so far, which is the effective
+// rate after any RateLimit has been applied.
+func (p Progress) Rate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Probes) / p.Elapsed.Seconds()
+}
+
+type progressCounter struct {
+	start     time.Time
+	completed int
+	total     int
+	limiter   *rateLimiter
+	baseline  int
+}
+
+// newProgressCounter starts counting a scan of total targets whose probes
+// go through limiter. Probes sent by earlier scans are not counted.
+func newProgressCounter(total int, limiter *rateLimiter) *progressCounter {
+	return &progressCounter{
+		start:    time.Now(),
+		total:    total,
+		limiter:  limiter,
+		baseline: limiter.probes(),
+	}
+}
+
+// step records one more completed target and returns the new progress.
+func (pc *progressCounter) step() Progress {
+	pc.completed++
+	return Progress{
+		Completed: pc.completed,
+		Total:     pc.total,
+		Elapsed:   time.Since(pc.start),
+		Probes:    pc.limiter.probes() - pc.baseline,
+	}
+}
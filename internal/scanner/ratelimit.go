@@ -0,0 +1,142 @@
+package scanner
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxPacedSubnets bounds the per-subnet pacing table. Once it grows past
+// this size, subnets whose next slot has already passed are forgotten.
+const maxPacedSubnets = 4096
+
+// RateLimit bounds how fast a scanner sends probes. The zero value sends
+// probes as fast as the worker pool allows.
+type RateLimit struct {
+	// Rate is the sustained number of probes per second across the scan.
+	Rate float64
+	// Burst is how many probes may go out back to back before Rate
+	// applies. Values below 1 are treated as 1.
+	Burst int
+	// SubnetRate caps the probes per second sent into any one /24, or /64
+	// for IPv6, so that sweeps spread out instead of hammering a single
+	// segment.
+	SubnetRate float64
+}
+
+// rateLimiter is a token bucket for the global rate combined with a
+// next-slot table for per-subnet pacing. It also counts every probe so
+// that scans can report the rate they actually achieved.
+type rateLimiter struct {
+	limit RateLimit
+	// now is the clock, which tests replace
+	now func() time.Time
+
+	mutex   sync.Mutex
+	tokens  float64
+	last    time.Time
+	subnets map[netip.Prefix]time.Time
+
+	sent atomic.Int64
+}
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	return &rateLimiter{
+		limit:   limit,
+		now:     time.Now,
+		tokens:  float64(limit.Burst),
+		last:    time.Now(),
+		subnets: make(map[netip.Prefix]time.Time),
+	}
+}
+
+// wait blocks until a probe to ip may be sent. It returns ctx.Err() if ctx
+// is cancelled first, in which case the probe must not be sent.
+func (rl *rateLimiter) wait(ctx context.Context, ip string) error {
+	if delay := rl.reserve(ip); delay > 0 {
+		sleepContext(ctx, delay)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	rl.sent.Add(1)
+	return nil
+}
+
+// reserve books the next slot for a probe to ip and returns how long the
+// caller has to wait for it.
+func (rl *rateLimiter) reserve(ip string) time.Duration {
+	if rl.limit.Rate <= 0 && rl.limit.SubnetRate <= 0 {
+		return 0
+	}
+
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	now := rl.now()
+	var delay time.Duration
+
+	if rate := rl.limit.Rate; rate > 0 {
+		rl.tokens += now.Sub(rl.last).Seconds() * rate
+		if burst := float64(rl.limit.Burst); rl.tokens > burst {
+			rl.tokens = burst
+		}
+		rl.last = now
+
+		// Tokens go negative for probes that have reserved a future slot
+		rl.tokens--
+		if rl.tokens < 0 {
+			delay = time.Duration(-rl.tokens / rate * float64(time.Second))
+		}
+	}
+
+	if rate := rl.limit.SubnetRate; rate > 0 {
+		subnet, ok := pacingSubnet(ip)
+		if !ok {
+			return delay
+		}
+
+		if len(rl.subnets) >= maxPacedSubnets {
+			for key, next := range rl.subnets {
+				if next.Before(now) {
+					delete(rl.subnets, key)
+				}
+			}
+		}
+
+		slot := now.Add(delay)
+		if next := rl.subnets[subnet]; next.After(slot) {
+			slot = next
+		}
+		rl.subnets[subnet] = slot.Add(time.Duration(float64(time.Second) / rate))
+		delay = slot.Sub(now)
+	}
+
+	return delay
+}
+
+// probes returns how many probes have been sent through the limiter.
+func (rl *rateLimiter) probes() int {
+	return int(rl.sent.Load())
+}
+
+// pacingSubnet returns the /24 or /64 that ip belongs to.
+func pacingSubnet(ip string) (netip.Prefix, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	addr = addr.WithZone("").Unmap()
+
+	bits := 64
+	if addr.Is4() {
+		bits = 24
+	}
+	subnet, err := addr.Prefix(bits)
+	return subnet, err == nil
+}
@@ -0,0 +1,143 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newTestRateLimiter returns a limiter whose clock stands still until the
+// returned time is moved.
+func newTestRateLimiter(limit RateLimit) (*rateLimiter, *time.Time) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rl := newRateLimiter(limit)
+	rl.now = func() time.Time { return now }
+	rl.last = now
+	return rl, &now
+}
+
+func TestRateLimiterReserve(t *testing.T) {
+	ms := time.Millisecond
+	type step struct {
+		advance time.Duration
+		ip      string
+		want    time.Duration
+	}
+	tests := []struct {
+		name  string
+		limit RateLimit
+		steps []step
+	}{
+		{
+			name:  "unlimited",
+			limit: RateLimit{},
+			steps: []step{{0, "10.0.0.1", 0}, {0, "10.0.0.1", 0}, {0, "10.0.0.1", 0}},
+		},
+		{
+			name:  "burst then rate",
+			limit: RateLimit{Rate: 10, Burst: 3},
+			steps: []step{
+				{0, "10.0.0.1", 0},
+				{0, "10.0.0.2", 0},
+				{0, "10.0.0.3", 0},
+				{0, "10.0.0.4", 100 * ms},
+				{0, "10.0.0.5", 200 * ms},
+				// Waiting out the reservations and a token more
+				{300 * ms, "10.0.0.6", 0},
+				{0, "10.0.0.7", 100 * ms},
+				// A long pause refills the bucket to the burst and no more
+				{10 * time.Second, "10.0.0.8", 0},
+				{0, "10.0.0.9", 0},
+				{0, "10.0.0.10", 0},
+				{0, "10.0.0.11", 100 * ms},
+			},
+		},
+		{
+			name:  "burst below one",
+			limit: RateLimit{Rate: 4, Burst: -5},
+			steps: []step{{0, "10.0.0.1", 0}, {0, "10.0.0.2", 250 * ms}},
+		},
+		{
+			name:  "per subnet",
+			limit: RateLimit{SubnetRate: 2},
+			steps: []step{
+				{0, "10.0.0.1", 0},
+				{0, "10.0.0.200", 500 * ms},
+				{0, "10.0.1.1", 0},
+				{0, "10.0.0.3", time.Second},
+				{100 * ms, "::ffff:10.0.0.4", 1400 * ms},
+				{2 * time.Second, "10.0.0.5", 0},
+				{0, "2001:db8::1", 0},
+				{0, "2001:db8::ffff", 500 * ms},
+				{0, "fe80::1%eth0", 0},
+				{0, "2001:db8:0:1::1", 0},
+				// Not an address, so not paced
+				{0, "printer", 0},
+				{0, "printer", 0},
+			},
+		},
+		{
+			name:  "rate and subnet",
+			limit: RateLimit{Rate: 10, Burst: 1, SubnetRate: 2},
+			steps: []step{
+				{0, "10.0.0.1", 0},
+				{0, "10.0.1.1", 100 * ms},
+				{0, "10.0.0.2", 500 * ms},
+				{0, "10.0.2.1", 300 * ms},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rl, now := newTestRateLimiter(test.limit)
+			for i, step := range test.steps {
+				*now = now.Add(step.advance)
+				if got := rl.reserve(step.ip); got != step.want {
+					t.Errorf("step %d: reserve(%s) = %v, want %v", i, step.ip, got, step.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterForgetsSubnets(t *testing.T) {
+	rl, now := newTestRateLimiter(RateLimit{SubnetRate: 1})
+	for i := range maxPacedSubnets {
+		rl.reserve(fmt.Sprintf("10.%d.%d.1", i/256, i%256))
+	}
+	if len(rl.subnets) != maxPacedSubnets {
+		t.Fatalf("paced %d subnets, want %d", len(rl.subnets), maxPacedSubnets)
+	}
+
+	// Subnets whose next slot has passed go once the table is full
+	*now = now.Add(2 * time.Second)
+	if delay := rl.reserve("10.0.0.2"); delay != 0 {
+		t.Errorf("reserve after the slot passed = %v, want 0", delay)
+	}
+	if len(rl.subnets) != 1 {
+		t.Errorf("paced %d subnets after pruning, want 1", len(rl.subnets))
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	rl := newRateLimiter(RateLimit{Rate: 1, Burst: 1})
+	if err := rl.wait(context.Background(), "10.0.0.1"); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+
+	// The second probe would wait a second; cancelling gives up at once
+	// and the probe is not counted
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	if err := rl.wait(ctx, "10.0.0.1"); err != context.Canceled {
+		t.Errorf("wait with a cancelled context = %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("cancelled wait took %v", elapsed)
+	}
+	if rl.probes() != 1 {
+		t.Errorf("probes() = %d, want 1", rl.probes())
+	}
+}
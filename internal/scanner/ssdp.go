@@ -0,0 +1,344 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CyberOakAlpha/CrossNet/internal/targets"
+)
+
+// SSDPGroup is the multicast group and port that UPnP devices listen on
+// for searches.
+const SSDPGroup = "239.255.255.250:1900"
+
+const (
+	// ssdpMX is how many seconds a device may wait before answering a
+	// search, so that the answers of a busy network are spread out.
+	ssdpMX = 2
+	// ssdpFetchWorkers bounds the device descriptions fetched at a time.
+	ssdpFetchWorkers = 8
+	// maxDescriptionSize bounds how much of a device description is read.
+	maxDescriptionSize = 1 << 20
+)
+
+// UPnPDevice is a UPnP root device found with SSDP, as described by the
+// XML document at its Location.
+type UPnPDevice struct {
+	Location string
+	// Server is the SERVER header of the search response, which names the
+	// operating system and UPnP stack.
+	Server       string `json:",omitempty"`
+	DeviceType   string `json:",omitempty"`
+	FriendlyName string `json:",omitempty"`
+	Manufacturer string `json:",omitempty"`
+	ModelName    string `json:",omitempty"`
+	ModelNumber  string `json:",omitempty"`
+	SerialNumber string `json:",omitempty"`
+	UDN          string `json:",omitempty"`
+}
+
+// Model returns the model name and number of the device.
+func (d UPnPDevice) Model() string {
+	return strings.TrimSpace(d.ModelName + " " + d.ModelNumber)
+}
+
+// SSDPResult is a device that answered an SSDP search.
+type SSDPResult struct {
+	IP     string
+	Device UPnPDevice
+	// Err says why the device description could not be read. The device
+	// did answer the search, so the host is up regardless.
+	Err error
+}
+
+// SSDPScanner finds UPnP devices such as smart TVs, NAS boxes and routers
+// by sending an SSDP M-SEARCH to the multicast group and reading the
+// device description each responder points to. Unlike the other scans it
+// does not probe the targets one by one: every device on the link may
+// answer, and only those among the targets are kept.
+type SSDPScanner struct {
+	timeout time.Duration
+	wait    time.Duration
+	iface   string
+	address string
+}
+
+// NewSSDPScanner returns a scanner that listens for answers for three
+// seconds and gives each description fetch timeout to complete.
+func NewSSDPScanner(timeout time.Duration) *SSDPScanner {
+	return &SSDPScanner{
+		timeout: timeout,
+		wait:    (ssdpMX + 1) * time.Second,
+		address: SSDPGroup,
+	}
+}
+
+// SetInterface sends the search only from the named interface instead of
+// from every interface attached to the targets.
+func (ss *SSDPScanner) SetInterface(name string) {
+	ss.iface = name
+}
+
+// SetWait sets how long later scans listen for answers.
+func (ss *SSDPScanner) SetWait(wait time.Duration) {
+	if wait > 0 {
+		ss.wait = wait
+	}
+}
+
+// SetAddress sends the search to addr, a host and port, instead of
+// SSDPGroup. A unicast address searches a single device.
+func (ss *SSDPScanner) SetAddress(addr string) {
+	ss.address = addr
+}
+
+// ssdpResponse is an answer to a search, before its description is read.
+type ssdpResponse struct {
+	ip       netip.Addr
+	location string
+	server   string
+}
+
+// StreamTargets searches for UPnP devices and hands each one among set to
+// fn once its description has been read, or failed to be. fn is called
+// from a single goroutine. Once ctx is cancelled no further descriptions
+// are fetched and ctx.Err() is returned.
+func (ss *SSDPScanner) StreamTargets(ctx context.Context, set *targets.Set, fn func(SSDPResult)) error {
+	dest, err := net.ResolveUDPAddr("udp4", ss.address)
+	if err != nil {
+		return fmt.Errorf("invalid SSDP address %q: %v", ss.address, err)
+	}
+	conns, err := ss.open(dest.IP, set)
+	if err != nil {
+		return err
+	}
+
+	search := []byte(fmt.Sprintf("M-SEARCH * HTTP/1.1\r\nHOST: %s\r\nMAN: \"ssdp:discover\"\r\nMX: %d\r\nST: ssdp:all\r\n\r\n", dest, ssdpMX))
+	deadline := time.Now().Add(ss.wait)
+	responses := make(chan ssdpResponse)
+	var wg sync.WaitGroup
+	for _, conn := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer conn.Close()
+			ss.listen(ctx, conn, dest, search, deadline, responses)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(responses)
+	}()
+
+	// A device answers once for each of its services, so each description
+	// is fetched once per responder
+	jobs := func(yield func(ssdpResponse) bool) {
+		seen := make(map[string]bool)
+		for response := range responses {
+			key := response.ip.String() + " " + response.location
+			if seen[key] || !set.Contains(response.ip) {
+				continue
+			}
+			seen[key] = true
+			if !yield(response) {
+				break
+			}
+		}
+		// Let the listeners finish
+		for range responses {
+		}
+	}
+	client := &http.Client{Timeout: ss.timeout, CheckRedirect: sameHostRedirect}
+	runPool(ctx, ssdpFetchWorkers, jobs, func(response ssdpResponse) (SSDPResult, bool) {
+		result := SSDPResult{
+			IP: response.ip.String(),
+			Device: UPnPDevice{
+				Location: response.location,
+				Server:   response.server,
+			},
+		}
+		result.Err = fetchDescription(ctx, client, response.ip, &result.Device)
+		return result, ctx.Err() == nil
+	}, fn)
+	return ctx.Err()
+}
+
+// open returns the sockets to search from. A multicast search is sent from
+// each interface attached to the targets, as a socket bound to an address
+// of an interface sends multicast out of that interface.
+func (ss *SSDPScanner) open(dest net.IP, set *targets.Set) ([]*net.UDPConn, error) {
+	var conns []*net.UDPConn
+	if dest.IsMulticast() {
+		for _, local := range ssdpSources(ss.iface, set) {
+			conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: local})
+			if err == nil {
+				conns = append(conns, conn)
+			}
+		}
+		if len(conns) == 0 && ss.iface != "" {
+			return nil, fmt.Errorf("interface %s has no usable IPv4 address for SSDP", ss.iface)
+		}
+	}
+	if len(conns) == 0 {
+		// Let the routing table pick the way
+		conn, err := net.ListenUDP("udp4", nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open SSDP socket: %v", err)
+		}
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+// ssdpSources returns the IPv4 addresses of the named interface, or of
+// every up multicast interface whose subnet holds any of the targets.
+func ssdpSources(name string, set *targets.Set) []net.IP {
+	var ifaces []net.Interface
+	if name != "" {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil
+		}
+		ifaces = []net.Interface{*iface}
+	} else {
+		ifaces, _ = net.Interfaces()
+	}
+
+	var sources []net.IP
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.To4() == nil {
+				continue
+			}
+			ip, _ := netip.AddrFromSlice(ipNet.IP.To4())
+			bits, _ := ipNet.Mask.Size()
+			if name != "" || set.Overlaps(netip.PrefixFrom(ip, bits)) {
+				sources = append(sources, ipNet.IP)
+			}
+		}
+	}
+	return sources
+}
+
+// listen sends the search twice, since either datagram may be lost, and
+// passes on the answers that come in before deadline.
+func (ss *SSDPScanner) listen(ctx context.Context, conn *net.UDPConn, dest *net.UDPAddr, search []byte, deadline time.Time, responses chan<- ssdpResponse) {
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+	conn.SetReadDeadline(deadline)
+
+	for range 2 {
+		if _, err := conn.WriteToUDP(search, dest); err != nil {
+			return
+		}
+	}
+
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return
+		}
+		response, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		response.Body.Close()
+		location := response.Header.Get("Location")
+		if response.StatusCode != http.StatusOK || location == "" {
+			continue
+		}
+		responses <- ssdpResponse{
+			ip:       from.Addr().Unmap(),
+			location: location,
+			server:   response.Header.Get("Server"),
+		}
+	}
+}
+
+// upnpDescription is the part of a UPnP device description that is kept.
+type upnpDescription struct {
+	Device struct {
+		DeviceType   string `xml:"deviceType"`
+		FriendlyName string `xml:"friendlyName"`
+		Manufacturer string `xml:"manufacturer"`
+		ModelName    string `xml:"modelName"`
+		ModelNumber  string `xml:"modelNumber"`
+		SerialNumber string `xml:"serialNumber"`
+		UDN          string `xml:"UDN"`
+	} `xml:"device"`
+}
+
+// fetchDescription reads the description at device.Location into device.
+// Only descriptions served by the responder itself are fetched, so that
+// an answer cannot send the scanner to another host, and redirects are
+// held to the same rule by sameHostRedirect.
+func fetchDescription(ctx context.Context, client *http.Client, ip netip.Addr, device *UPnPDevice) error {
+	location, err := url.Parse(device.Location)
+	if err != nil || (location.Scheme != "http" && location.Scheme != "https") {
+		return fmt.Errorf("invalid location %q", device.Location)
+	}
+	if host, err := netip.ParseAddr(location.Hostname()); err != nil || host.Unmap() != ip {
+		return fmt.Errorf("location %q is not on %s", device.Location, ip)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, device.Location, nil)
+	if err != nil {
+		return fmt.Errorf("invalid location %q", device.Location)
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to fetch device description: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch device description: %s", response.Status)
+	}
+
+	var description upnpDescription
+	if err := xml.NewDecoder(io.LimitReader(response.Body, maxDescriptionSize)).Decode(&description); err != nil {
+		return fmt.Errorf("failed to parse device description: %v", err)
+	}
+	d := description.Device
+	device.DeviceType = strings.TrimSpace(d.DeviceType)
+	device.FriendlyName = strings.TrimSpace(d.FriendlyName)
+	device.Manufacturer = strings.TrimSpace(d.Manufacturer)
+	device.ModelName = strings.TrimSpace(d.ModelName)
+	device.ModelNumber = strings.TrimSpace(d.ModelNumber)
+	device.SerialNumber = strings.TrimSpace(d.SerialNumber)
+	device.UDN = strings.TrimSpace(d.UDN)
+	return nil
+}
+
+// sameHostRedirect follows a redirect only when it stays on the host the
+// description was first asked of.
+func sameHostRedirect(request *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return fmt.Errorf("stopped after 10 redirects")
+	}
+	to, err := netip.ParseAddr(request.URL.Hostname())
+	from, _ := netip.ParseAddr(via[0].URL.Hostname())
+	if err != nil || to.Unmap() != from.Unmap() {
+		return fmt.Errorf("redirect to %s refused", request.URL.Host)
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CyberOakAlpha/CrossNet/internal/targets"
+)
+
+const testDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <specVersion><major>1</major><minor>0</minor></specVersion>
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:MediaRenderer:1</deviceType>
+    <friendlyName> Living Room TV </friendlyName>
+    <manufacturer>Example Electronics</manufacturer>
+    <modelName>UE55</modelName>
+    <modelNumber>2024</modelNumber>
+    <serialNumber>SN-0042</serialNumber>
+    <UDN>uuid:3f1c2a6e-0000-1000-8000-00155d0abcde</UDN>
+  </device>
+</root>`
+
+// testSSDPResponder answers each M-SEARCH on a loopback address with one
+// response per location, as a device does for each of its services. It
+// returns the address to search and a count of the searches received.
+func testSSDPResponder(t *testing.T, locations ...string) (string, *atomic.Int32) {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	var searches atomic.Int32
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if !strings.HasPrefix(string(buf[:n]), "M-SEARCH * HTTP/1.1\r\n") || !strings.Contains(string(buf[:n]), "ST: ssdp:all\r\n") {
+				continue
+			}
+			searches.Add(1)
+			for i, location := range locations {
+				response := fmt.Sprintf("HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=1800\r\nLOCATION: %s\r\nSERVER: Linux/5.4 UPnP/1.0 Example/1.0\r\nST: urn:example:service:%d\r\nUSN: uuid:test::urn:example:service:%d\r\n\r\n", location, i, i)
+				conn.WriteToUDP([]byte(response), from)
+			}
+		}
+	}()
+	return conn.LocalAddr().String(), &searches
+}
+
+// testDescriptionServer serves testDescription and counts the fetches.
+func testDescriptionServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if r.URL.Path != "/description.xml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprint(w, testDescription)
+	}))
+	t.Cleanup(server.Close)
+	return server, &fetches
+}
+
+// testSSDPScan searches addr for the devices among spec.
+func testSSDPScan(t *testing.T, addr, spec string) []SSDPResult {
+	t.Helper()
+	set, err := targets.Parse(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss := NewSSDPScanner(2 * time.Second)
+	ss.SetAddress(addr)
+	ss.SetWait(300 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var results []SSDPResult
+	if err := ss.StreamTargets(ctx, set, func(result SSDPResult) {
+		results = append(results, result)
+	}); err != nil {
+		t.Fatalf("StreamTargets: %v", err)
+	}
+	return results
+}
+
+func TestSSDPScan(t *testing.T) {
+	server, fetches := testDescriptionServer(t)
+	location := server.URL + "/description.xml"
+	embedded := location + "?device=2"
+	// Three services under one description and one under another, all
+	// answered for both searches
+	addr, searches := testSSDPResponder(t, location, location, embedded, location)
+
+	results := testSSDPScan(t, addr, "127.0.0.1")
+	if got := searches.Load(); got != 2 {
+		t.Errorf("responder got %d searches, want 2", got)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want one per location: %+v", len(results), results)
+	}
+	if fetches.Load() != 2 {
+		t.Errorf("descriptions fetched %d times, want once each", fetches.Load())
+	}
+	slices.SortFunc(results, func(a, b SSDPResult) int { return strings.Compare(a.Device.Location, b.Device.Location) })
+	if results[1].Device.Location != embedded {
+		t.Errorf("second location = %q, want %q", results[1].Device.Location, embedded)
+	}
+
+	result := results[0]
+	if result.Err != nil {
+		t.Fatalf("Err = %v", result.Err)
+	}
+	want := UPnPDevice{
+		Location:     location,
+		Server:       "Linux/5.4 UPnP/1.0 Example/1.0",
+		DeviceType:   "urn:schemas-upnp-org:device:MediaRenderer:1",
+		FriendlyName: "Living Room TV",
+		Manufacturer: "Example Electronics",
+		ModelName:    "UE55",
+		ModelNumber:  "2024",
+		SerialNumber: "SN-0042",
+		UDN:          "uuid:3f1c2a6e-0000-1000-8000-00155d0abcde",
+	}
+	if result.IP != "127.0.0.1" || result.Device != want {
+		t.Errorf("result = %s %+v, want 127.0.0.1 %+v", result.IP, result.Device, want)
+	}
+	if model := result.Device.Model(); model != "UE55 2024" {
+		t.Errorf("Model() = %q, want UE55 2024", model)
+	}
+}
+
+func TestSSDPScanLocations(t *testing.T) {
+	server, fetches := testDescriptionServer(t)
+	// The port is that of the description server, but the host is not the
+	// responder
+	elsewhere := strings.Replace(server.URL, "127.0.0.1", "127.0.0.2", 1) + "/description.xml"
+	missing := server.URL + "/missing.xml"
+	addr, _ := testSSDPResponder(t, elsewhere, missing, "ftp://127.0.0.1/description.xml")
+
+	results := testSSDPScan(t, addr, "127.0.0.1")
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3: %+v", len(results), results)
+	}
+	errs := make(map[string]string)
+	for _, result := range results {
+		if result.Err == nil {
+			t.Errorf("%s: no error", result.Device.Location)
+			continue
+		}
+		errs[result.Device.Location] = result.Err.Error()
+	}
+	if !strings.Contains(errs[elsewhere], "is not on 127.0.0.1") {
+		t.Errorf("location on another host: %q, want it refused", errs[elsewhere])
+	}
+	if !strings.Contains(errs[missing], "404") {
+		t.Errorf("missing description: %q, want the HTTP status", errs[missing])
+	}
+	if !strings.Contains(errs["ftp://127.0.0.1/description.xml"], "invalid location") {
+		t.Errorf("ftp location: %q, want it refused", errs["ftp://127.0.0.1/description.xml"])
+	}
+	// Only the missing description was asked for
+	if fetches.Load() != 1 {
+		t.Errorf("description server got %d requests, want 1", fetches.Load())
+	}
+}
+
+func TestSSDPScanTargets(t *testing.T) {
+	server, fetches := testDescriptionServer(t)
+	addr, searches := testSSDPResponder(t, server.URL+"/description.xml")
+
+	// Responders outside the targets are dropped before anything is
+	// fetched
+	if results := testSSDPScan(t, addr, "127.0.0.2-254"); len(results) != 0 {
+		t.Errorf("got %+v, want no results", results)
+	}
+	if searches.Load() == 0 {
+		t.Error("responder got no search")
+	}
+	if fetches.Load() != 0 {
+		t.Errorf("description server got %d requests, want none", fetches.Load())
+	}
+
+	results := testSSDPScan(t, addr, "127.0.0.0/8")
+	ips := make([]string, len(results))
+	for i, result := range results {
+		ips[i] = result.IP
+	}
+	if !slices.Equal(ips, []string{"127.0.0.1"}) {
+		t.Errorf("results from %v, want 127.0.0.1", ips)
+	}
+}
+
+func TestSSDPScanRedirect(t *testing.T) {
+	// The second listener is on another address than the responder
+	listener, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("no second loopback address: %v", err)
+	}
+	var contacted atomic.Int32
+	other := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contacted.Add(1)
+		fmt.Fprint(w, testDescription)
+	}))
+	other.Listener.Close()
+	other.Listener = listener
+	other.Start()
+	t.Cleanup(other.Close)
+
+	description, _ := testDescriptionServer(t)
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/local.xml" {
+			http.Redirect(w, r, description.URL+"/description.xml", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, other.URL+"/description.xml", http.StatusFound)
+	}))
+	t.Cleanup(redirect.Close)
+	local, away := redirect.URL+"/local.xml", redirect.URL+"/away.xml"
+	addr, _ := testSSDPResponder(t, local, away)
+
+	results := testSSDPScan(t, addr, "127.0.0.1")
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2: %+v", len(results), results)
+	}
+	for _, result := range results {
+		switch result.Device.Location {
+		case local:
+			// Another port of the responder is still the responder
+			if result.Err != nil || result.Device.FriendlyName != "Living Room TV" {
+				t.Errorf("redirect on the responder = %+v, %v; want its description", result.Device, result.Err)
+			}
+		case away:
+			if result.Err == nil || !strings.Contains(result.Err.Error(), "refused") {
+				t.Errorf("redirect to another host: %v, want it refused", result.Err)
+			}
+		}
+	}
+	if contacted.Load() != 0 {
+		t.Errorf("other host got %d requests, want none", contacted.Load())
+	}
+}
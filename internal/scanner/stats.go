@@ -0,0 +1,100 @@
+package scanner
+
+import (
+	"math"
+	"time"
+)
+
+// LatencyStats summarises a series of echo requests to one host.
+type LatencyStats struct {
+	Sent     int
+	Received int
+	// Loss is the percentage of probes that went unanswered.
+	Loss float64
+	Min  time.Duration
+	Avg  time.Duration
+	Max  time.Duration
+	// MDev is the standard deviation of the round-trip times, as reported
+	// by ping as mdev.
+	MDev time.Duration
+	// Jitter is the mean difference between consecutive round-trip times.
+	Jitter time.Duration
+}
+
+// newLatencyStats computes the statistics for sent probes that were
+// answered after the given round-trip times, in the order they were sent.
+func newLatencyStats(sent int, rtts []time.Duration) *LatencyStats {
+	var acc latencyAccumulator
+	for _, rtt := range rtts {
+		acc.reply(rtt)
+	}
+	acc.sent = sent
+	return acc.stats()
+}
+
+// latencyAccumulator builds LatencyStats one probe at a time in constant
+// space, for series that run too long to keep every round-trip time.
+type latencyAccumulator struct {
+	sent     int
+	received int
+	min      time.Duration
+	max      time.Duration
+	last     time.Duration
+	jitter   time.Duration
+	// sum and sumSquares are kept as floats so that hours of samples in
+	// nanoseconds cannot overflow
+	sum        float64
+	sumSquares float64
+}
+
+// reply records a probe answered after rtt.
+func (acc *latencyAccumulator) reply(rtt time.Duration) {
+	if acc.received == 0 {
+		acc.min, acc.max = rtt, rtt
+	} else {
+		acc.min = min(acc.min, rtt)
+		acc.max = max(acc.max, rtt)
+		diff := rtt - acc.last
+		if diff < 0 {
+			diff = -diff
+		}
+		acc.jitter += diff
+	}
+	acc.last = rtt
+
+	value := float64(rtt)
+	acc.sum += value
+	acc.sumSquares += value * value
+	acc.sent++
+	acc.received++
+}
+
+// lost records a probe that went unanswered.
+func (acc *latencyAccumulator) lost() {
+	acc.sent++
+}
+
+func (acc *latencyAccumulator) stats() *LatencyStats {
+	stats := &LatencyStats{
+		Sent:     acc.sent,
+		Received: acc.received,
+	}
+	if acc.sent > 0 {
+		stats.Loss = float64(acc.sent-acc.received) * 100 / float64(acc.sent)
+	}
+	if acc.received == 0 {
+		return stats
+	}
+
+	n := float64(acc.received)
+	mean := acc.sum / n
+	stats.Min = acc.min
+	stats.Max = acc.max
+	stats.Avg = time.Duration(mean)
+	stats.MDev = time.Duration(math.Sqrt(math.Max(acc.sumSquares/n-mean*mean, 0)))
+	if acc.received > 1 {
+		stats.Jitter = acc.jitter / time.Duration(acc.received-1)
+	}
+
+	return stats
+}
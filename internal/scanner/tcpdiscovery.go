@@ -0,0 +1,177 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/CyberOakAlpha/CrossNet/internal/targets"
+)
+
+// DefaultProbePorts are the TCP ports used for discovery when none are
+// configured: ssh, http, https, smb and rdp.
+var DefaultProbePorts = []int{22, 80, 443, 445, 3389}
+
+// TCPDiscoveryScanner finds live hosts on segments that filter ICMP. A host
+// counts as alive as soon as any probe port accepts the connection or
+// actively refuses it, since a RST proves something answered.
+type TCPDiscoveryScanner struct {
+	timeout time.Duration
+	threads int
+	ports   []int
+	limiter *rateLimiter
+}
+
+func NewTCPDiscoveryScanner(timeout time.Duration, threads int, ports []int) *TCPDiscoveryScanner {
+	if len(ports) == 0 {
+		ports = DefaultProbePorts
+	}
+	return &TCPDiscoveryScanner{
+		timeout: timeout,
+		threads: threads,
+		ports:   ports,
+		limiter: newRateLimiter(RateLimit{}),
+	}
+}
+
+// SetRateLimit paces the connection attempts made by later scans. Every
+// probe port counts as a separate probe.
+func (ts *TCPDiscoveryScanner) SetRateLimit(limit RateLimit) {
+	ts.limiter = newRateLimiter(limit)
+}
+
+func (ts *TCPDiscoveryScanner) ScanRange(network string) ([]PingResult, error) {
+	return ts.ScanRangeContext(context.Background(), network)
+}
+
+// ScanRangeContext is like ScanRange but stops dispatching probes once ctx
+// is cancelled and returns the hosts determined so far with ctx.Err().
+func (ts *TCPDiscoveryScanner) ScanRangeContext(ctx context.Context, network string) ([]PingResult, error) {
+	set, err := targets.Parse(network)
+	if err != nil {
+		return nil, err
+	}
+	return ts.ScanTargets(ctx, set)
+}
+
+// ScanTargets is like ScanRangeContext for an already parsed target set.
+func (ts *TCPDiscoveryScanner) ScanTargets(ctx context.Context, set *targets.Set) ([]PingResult, error) {
+	results := make([]PingResult, 0)
+	err := ts.StreamTargets(ctx, set, func(result PingResult, _ Progress) {
+		results = append(results, result)
+	})
+	if err != nil && ctx.Err() == nil {
+		return nil, err
+	}
+	return results, err
+}
+
+// StreamRange probes every address in network and hands each result to fn
+// as soon as it is known, together with the scan's progress. fn is called
+// from a single goroutine.
+func (ts *TCPDiscoveryScanner) StreamRange(ctx context.Context, network string, fn func(PingResult, Progress)) error {
+	set, err := targets.Parse(network)
+	if err != nil {
+		return err
+	}
+	return ts.StreamTargets(ctx, set, fn)
+}
+
+// StreamTargets is like StreamRange for an already parsed target set.
+func (ts *TCPDiscoveryScanner) StreamTargets(ctx context.Context, set *targets.Set, fn func(PingResult, Progress)) error {
+	ips, err := enumerate(set)
+	if err != nil {
+		return err
+	}
+
+	progress := newProgressCounter(set.Len(), ts.limiter)
+	runPool(ctx, ts.threads, ips, func(ip string) (PingResult, bool) {
+		result := ts.probeHost(ctx, ip)
+		return result, result.Alive || ctx.Err() == nil
+	}, func(result PingResult) {
+		fn(result, progress.step())
+	})
+
+	return ctx.Err()
+}
+
+type tcpProbe struct {
+	port  int
+	state PortState
+	rtt   time.Duration
+}
+
+// probeHost connects to every probe port in parallel and reports the first
+// one that proves the host is alive, cancelling the others.
+func (ts *TCPDiscoveryScanner) probeHost(parent context.Context, ip string) PingResult {
+	result := PingResult{
+		IP:    ip,
+		Alive: false,
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	probes := make(chan tcpProbe, len(ts.ports))
+	for _, port := range ts.ports {
+		go func(port int) {
+			// Each probe's timeout starts once the rate limiter lets it go
+			if ts.limiter.wait(ctx, ip) != nil {
+				probes <- tcpProbe{port: port, state: PortFiltered}
+				return
+			}
+			probeCtx, probeCancel := context.WithTimeout(ctx, ts.timeout)
+			defer probeCancel()
+
+			state, rtt, _ := tcpConnect(probeCtx, ip, port)
+			probes <- tcpProbe{port: port, state: state, rtt: rtt}
+		}(port)
+	}
+
+	for range ts.ports {
+		probe := <-probes
+		if probe.state == PortFiltered {
+			continue
+		}
+
+		cancel()
+		result.Alive = true
+		result.RTT = probe.rtt
+		result.Probe = fmt.Sprintf("tcp/%d %s", probe.port, probe.state)
+		return result
+	}
+
+	result.Error = "no response on probe ports"
+	return result
+}
+
+// tcpConnect performs a full TCP connect and classifies the outcome: an
+// accepted connection means open, an active refusal (RST) means closed and
+// silence or an ICMP error means filtered.
+func tcpConnect(ctx context.Context, ip string, port int) (PortState, time.Duration, error) {
+	var dialer net.Dialer
+
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip, strconv.Itoa(port)))
+	rtt := time.Since(start)
+
+	switch {
+	case err == nil:
+		conn.Close()
+		return PortOpen, rtt, nil
+	case isConnRefused(err):
+		return PortClosed, rtt, nil
+	default:
+		return PortFiltered, rtt, err
+	}
+}
+
+func isConnRefused(err error) bool {
+	// Windows reports WSAECONNREFUSED, which syscall.ECONNREFUSED does not match
+	return errors.Is(err, syscall.ECONNREFUSED) || strings.Contains(err.Error(), "refused")
+}
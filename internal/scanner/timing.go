@@ -0,0 +1,160 @@
+package scanner
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rttGranularity is the clock granularity G of RFC 6298, which keeps the
+// timeout above the smoothed RTT when the variance collapses to zero.
+const rttGranularity = time.Millisecond
+
+// Timing controls how long scanners wait for replies and how often silent
+// hosts are probed again. Timeouts adapt to the round-trip times observed
+// during the scan and stay between MinTimeout and MaxTimeout.
+type Timing struct {
+	Name string
+	// InitialTimeout is used until the first replies have been timed.
+	InitialTimeout time.Duration
+	MinTimeout     time.Duration
+	MaxTimeout     time.Duration
+	// Retries is how many more probes a host that stayed silent gets. Each
+	// retransmission doubles the timeout, up to MaxTimeout.
+	Retries int
+}
+
+var (
+	// TimingFast suits wired LANs where every host answers quickly.
+	TimingFast = Timing{
+		Name:           "fast",
+		InitialTimeout: 500 * time.Millisecond,
+		MinTimeout:     100 * time.Millisecond,
+		MaxTimeout:     time.Second,
+		Retries:        1,
+	}
+	// TimingNormal is the default.
+	TimingNormal = Timing{
+		Name:           "normal",
+		InitialTimeout: time.Second,
+		MinTimeout:     200 * time.Millisecond,
+		MaxTimeout:     3 * time.Second,
+		Retries:        2,
+	}
+	// TimingPatient gives slow Wi-Fi clients, sleeping devices and
+	// congested links time to answer.
+	TimingPatient = Timing{
+		Name:           "patient",
+		InitialTimeout: 2 * time.Second,
+		MinTimeout:     500 * time.Millisecond,
+		MaxTimeout:     10 * time.Second,
+		Retries:        4,
+	}
+)
+
+// ParseTiming returns the timing template with the given name.
+func ParseTiming(name string) (Timing, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "fast":
+		return TimingFast, nil
+	case "", "normal":
+		return TimingNormal, nil
+	case "patient":
+		return TimingPatient, nil
+	default:
+		return Timing{}, fmt.Errorf("unknown timing template %q, use fast, normal or patient", name)
+	}
+}
+
+// WithTimeout returns t waiting d for hosts until replies have been timed,
+// raising the maximum timeout if it would cut d short.
+func (t Timing) WithTimeout(d time.Duration) Timing {
+	if d <= 0 {
+		return t
+	}
+	t.InitialTimeout = d
+	if t.MaxTimeout < d {
+		t.MaxTimeout = d
+	}
+	return t
+}
+
+// rttEstimator smooths round-trip samples as described in RFC 6298.
+type rttEstimator struct {
+	srtt    time.Duration
+	rttvar  time.Duration
+	samples int
+}
+
+func (re *rttEstimator) observe(rtt time.Duration) {
+	if re.samples == 0 {
+		re.srtt = rtt
+		re.rttvar = rtt / 2
+	} else {
+		delta := re.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		re.rttvar = (3*re.rttvar + delta) / 4
+		re.srtt = (7*re.srtt + rtt) / 8
+	}
+	re.samples++
+}
+
+// rto returns the retransmission timeout, SRTT + max(G, 4*RTTVAR).
+func (re *rttEstimator) rto() time.Duration {
+	return re.srtt + max(rttGranularity, 4*re.rttvar)
+}
+
+// timingEngine derives per-probe timeouts for one scan. Every reply feeds
+// a scan-wide estimator and an estimator for the replying host. Hosts that
+// have answered before are timed by their own history and the rest by the
+// scan-wide one, since nothing is known about them yet.
+type timingEngine struct {
+	timing Timing
+
+	mutex  sync.Mutex
+	global rttEstimator
+	hosts  map[string]*rttEstimator
+}
+
+func newTimingEngine(timing Timing) *timingEngine {
+	return &timingEngine{
+		timing: timing,
+		hosts:  make(map[string]*rttEstimator),
+	}
+}
+
+// timeout returns how long to wait for the reply to the given attempt at
+// probing ip, counting from zero.
+func (te *timingEngine) timeout(ip string, attempt int) time.Duration {
+	te.mutex.Lock()
+	base := te.timing.InitialTimeout
+	if host, ok := te.hosts[ip]; ok {
+		base = host.rto()
+	} else if te.global.samples > 0 {
+		base = te.global.rto()
+	}
+	te.mutex.Unlock()
+
+	base = min(max(base, te.timing.MinTimeout), te.timing.MaxTimeout)
+	for i := 0; i < attempt && base < te.timing.MaxTimeout; i++ {
+		base *= 2
+	}
+	return min(base, te.timing.MaxTimeout)
+}
+
+// observe records the round-trip time of a reply from ip.
+func (te *timingEngine) observe(ip string, rtt time.Duration) {
+	te.mutex.Lock()
+	defer te.mutex.Unlock()
+
+	te.global.observe(rtt)
+	host, ok := te.hosts[ip]
+	if !ok {
+		host = &rttEstimator{}
+		te.hosts[ip] = host
+	}
+	host.observe(rtt)
+}
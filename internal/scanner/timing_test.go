@@ -0,0 +1,114 @@
+package scanner
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRTTEstimator(t *testing.T) {
+	ms := func(f float64) time.Duration { return time.Duration(f * float64(time.Millisecond)) }
+	tests := []struct {
+		samples []time.Duration
+		srtt    time.Duration
+		rttvar  time.Duration
+		rto     time.Duration
+	}{
+		// The first sample sets SRTT to R and RTTVAR to R/2
+		{[]time.Duration{ms(100)}, ms(100), ms(50), ms(300)},
+		{[]time.Duration{ms(100), ms(100)}, ms(100), ms(37.5), ms(250)},
+		{[]time.Duration{ms(100), ms(200)}, ms(112.5), ms(62.5), ms(362.5)},
+		{[]time.Duration{ms(200), ms(100)}, ms(187.5), ms(100), ms(587.5)},
+		{[]time.Duration{0}, 0, 0, rttGranularity},
+	}
+	for _, test := range tests {
+		var re rttEstimator
+		for _, rtt := range test.samples {
+			re.observe(rtt)
+		}
+		if re.srtt != test.srtt || re.rttvar != test.rttvar || re.rto() != test.rto {
+			t.Errorf("after %v: SRTT %v, RTTVAR %v, RTO %v; want %v, %v, %v",
+				test.samples, re.srtt, re.rttvar, re.rto(), test.srtt, test.rttvar, test.rto)
+		}
+	}
+
+	// A steady RTT wears the variance down, but the granularity keeps the
+	// timeout above the RTT itself
+	var re rttEstimator
+	for range 100 {
+		re.observe(ms(10))
+	}
+	if re.rto() != ms(10)+rttGranularity {
+		t.Errorf("RTO after a steady 10ms = %v, want %v", re.rto(), ms(10)+rttGranularity)
+	}
+}
+
+func TestTimingEngine(t *testing.T) {
+	te := newTimingEngine(TimingNormal)
+
+	// Nothing timed yet: the initial timeout, doubled on every retry up to
+	// the maximum
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		if got := te.timeout("10.0.0.1", attempt); got != want {
+			t.Errorf("initial timeout(attempt %d) = %v, want %v", attempt, got, want)
+		}
+	}
+
+	// A fast reply brings the timeout down to the minimum, for the host and
+	// for those not heard from yet
+	te.observe("10.0.0.1", 10*time.Millisecond)
+	tests := []struct {
+		ip      string
+		attempt int
+		want    time.Duration
+	}{
+		{"10.0.0.1", 0, 200 * time.Millisecond},
+		{"10.0.0.1", 1, 400 * time.Millisecond},
+		{"10.0.0.1", 3, 1600 * time.Millisecond},
+		{"10.0.0.1", 4, 3 * time.Second},
+		{"10.0.0.2", 0, 200 * time.Millisecond},
+	}
+	for _, test := range tests {
+		if got := te.timeout(test.ip, test.attempt); got != test.want {
+			t.Errorf("timeout(%s, %d) = %v, want %v", test.ip, test.attempt, got, test.want)
+		}
+	}
+
+	// A slow host is held to the maximum, and drags the scan-wide estimate
+	// up, but the fast host keeps its own
+	te.observe("10.0.0.3", 2*time.Second)
+	tests = []struct {
+		ip      string
+		attempt int
+		want    time.Duration
+	}{
+		{"10.0.0.3", 0, 3 * time.Second},
+		{"10.0.0.2", 0, 2263750 * time.Microsecond},
+		{"10.0.0.2", 1, 3 * time.Second},
+		{"10.0.0.1", 0, 200 * time.Millisecond},
+	}
+	for _, test := range tests {
+		if got := te.timeout(test.ip, test.attempt); got != test.want {
+			t.Errorf("timeout(%s, %d) = %v, want %v", test.ip, test.attempt, got, test.want)
+		}
+	}
+}
+
+func TestTimingWithTimeout(t *testing.T) {
+	tests := []struct {
+		timing  Timing
+		d       time.Duration
+		initial time.Duration
+		max     time.Duration
+	}{
+		{TimingNormal, 0, time.Second, 3 * time.Second},
+		{TimingNormal, 2 * time.Second, 2 * time.Second, 3 * time.Second},
+		{TimingFast, 5 * time.Second, 5 * time.Second, 5 * time.Second},
+	}
+	for _, test := range tests {
+		got := test.timing.WithTimeout(test.d)
+		if got.InitialTimeout != test.initial || got.MaxTimeout != test.max {
+			t.Errorf("%s.WithTimeout(%v) = initial %v, max %v; want %v, %v",
+				test.timing.Name, test.d, got.InitialTimeout, got.MaxTimeout, test.initial, test.max)
+		}
+	}
+}
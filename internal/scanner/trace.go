@@ -0,0 +1,705 @@
+package scanner
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/CyberOakAlpha/CrossNet/internal/hostname"
+)
+
+// TraceProtocol selects the kind of probe a Tracer sends.
+type TraceProtocol string
+
+const (
+	// TraceUDP sends datagrams to high ports, as traceroute does by
+	// default. The destination answers with port unreachable.
+	TraceUDP TraceProtocol = "udp"
+	// TraceICMP sends echo requests, as tracert does on Windows.
+	TraceICMP TraceProtocol = "icmp"
+	// TraceTCP sends connection attempts, which pass firewalls that only
+	// let traffic to a service through.
+	TraceTCP TraceProtocol = "tcp"
+)
+
+const (
+	// DefaultTraceUDPPort is the first destination port of UDP probes;
+	// each probe uses the next one so replies can be told apart.
+	DefaultTraceUDPPort = 33434
+	// DefaultTraceTCPPort is the destination port of TCP probes.
+	DefaultTraceTCPPort = 80
+
+	icmpDestUnreachable   = 3
+	icmpTimeExceeded      = 11
+	icmpv6DestUnreachable = 1
+	icmpv6TimeExceeded    = 3
+
+	icmpPortUnreachable   = 3
+	icmpv6PortUnreachable = 4
+
+	// IP protocol numbers, which the syscall package does not define on
+	// every platform
+	protoICMP   = 1
+	protoTCP    = 6
+	protoUDP    = 17
+	protoICMPv6 = 58
+)
+
+// ParseTraceProtocol returns the probe protocol called name.
+func ParseTraceProtocol(name string) (TraceProtocol, error) {
+	switch protocol := TraceProtocol(strings.ToLower(name)); protocol {
+	case TraceUDP, TraceICMP, TraceTCP:
+		return protocol, nil
+	}
+	return "", fmt.Errorf("unknown trace protocol %q (use udp, icmp or tcp)", name)
+}
+
+// TraceHop is one step of a path. IP is the router that answered first;
+// it is empty when no probe with this TTL was answered.
+type TraceHop struct {
+	TTL      int
+	IP       string
+	Hostname string
+	// RTTs holds the round-trip times of the answered probes in the order
+	// they were sent.
+	RTTs  []time.Duration
+	Stats *LatencyStats
+	// Reached is set on the hop where the destination itself answered.
+	Reached bool
+	// Note flags an unreachable message in traceroute notation, such as
+	// !H for host unreachable or !X for administratively prohibited.
+	Note string `json:",omitempty"`
+	// Error says why a probe could not be sent, so that a local failure
+	// is not taken for a silent router.
+	Error string `json:",omitempty"`
+}
+
+// TraceResult is the path to one destination.
+type TraceResult struct {
+	Target   string
+	IP       string
+	Protocol TraceProtocol
+	Port     int `json:",omitempty"`
+	Started  time.Time
+	Hops     []TraceHop
+	Reached  bool
+}
+
+// Tracer discovers the routers on the path to a host by sending probes
+// with increasing TTLs and listening for the ICMP time exceeded messages
+// they provoke. Replies are read from a raw ICMP socket, so tracing needs
+// root, CAP_NET_RAW or an administrator on Windows.
+type Tracer struct {
+	timeout  time.Duration
+	maxHops  int
+	probes   int
+	protocol TraceProtocol
+	port     int
+	limiter  *rateLimiter
+	resolver *hostname.HostnameResolver
+}
+
+// NewTracer returns a tracer that names hops with reverse DNS only, as
+// routers do not answer NetBIOS, LLMNR or mDNS and every hop would wait
+// out those methods.
+func NewTracer(timeout time.Duration, maxHops int) *Tracer {
+	t := &Tracer{
+		timeout:  timeout,
+		maxHops:  maxHops,
+		probes:   3,
+		protocol: TraceICMP,
+		limiter:  newRateLimiter(RateLimit{}),
+	}
+	methods, _ := hostname.ParseMethods(hostname.MethodDNS)
+	t.SetResolveMethods(methods)
+	return t
+}
+
+// SetResolveMethods selects the methods hops are named with in later
+// traces. Each hop waits for its name before the next TTL is probed, so
+// slow methods slow the trace down. With none, hops are not named.
+func (t *Tracer) SetResolveMethods(methods []hostname.MethodConfig) {
+	if len(methods) == 0 {
+		t.resolver = nil
+		return
+	}
+	t.resolver = hostname.NewHostnameResolver()
+	t.resolver.SetMethods(methods)
+}
+
+// SetProtocol selects the probe protocol for later traces. A port of zero
+// uses DefaultTraceUDPPort or DefaultTraceTCPPort; ICMP ignores it.
+func (t *Tracer) SetProtocol(protocol TraceProtocol, port int) {
+	t.protocol = protocol
+	t.port = port
+}
+
+// SetProbes sets how many probes are sent for each TTL.
+func (t *Tracer) SetProbes(probes int) {
+	if probes > 0 {
+		t.probes = probes
+	}
+}
+
+// SetRateLimit paces the probes of later traces.
+func (t *Tracer) SetRateLimit(limit RateLimit) {
+	t.limiter = newRateLimiter(limit)
+}
+
+// Trace follows the path to host, an address or a name, and hands each
+// hop to fn as soon as its probes are done. It stops at the destination,
+// at an unreachable message or after the maximum number of hops. The hops
+// found so far are returned together with ctx.Err() if ctx is cancelled.
+func (t *Tracer) Trace(ctx context.Context, host string, fn func(TraceHop)) (*TraceResult, error) {
+	target, err := resolveMonitorTarget(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ipv6 := target.IP.To4() == nil
+
+	result := &TraceResult{
+		Target:   host,
+		IP:       formatIP(target.IP, target.Zone),
+		Protocol: t.protocol,
+		Started:  time.Now(),
+	}
+	switch t.protocol {
+	case TraceUDP:
+		result.Port = orDefault(t.port, DefaultTraceUDPPort)
+	case TraceTCP:
+		result.Port = orDefault(t.port, DefaultTraceTCPPort)
+	}
+
+	listener, err := newTraceListener(ipv6)
+	if err != nil {
+		return nil, err
+	}
+	defer listener.close()
+
+	session := &traceSession{
+		tracer:   t,
+		listener: listener,
+		target:   target,
+		ipv6:     ipv6,
+		port:     result.Port,
+		id:       uint16(os.Getpid() & 0xffff),
+	}
+	if t.protocol == TraceUDP {
+		if err := session.openUDP(); err != nil {
+			return nil, err
+		}
+		defer session.udp.Close()
+	}
+
+	for ttl := 1; ttl <= t.maxHops; ttl++ {
+		hop, done := session.hop(ctx, ttl)
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		if hop.IP != "" && t.resolver != nil {
+			hop.Hostname = t.resolver.ResolveContext(ctx, hop.IP)
+		}
+
+		result.Hops = append(result.Hops, hop)
+		fn(hop)
+		if hop.Reached {
+			result.Reached = true
+		}
+		if done {
+			break
+		}
+	}
+
+	return result, ctx.Err()
+}
+
+// orDefault returns value, or fallback when value is zero.
+func orDefault(value, fallback int) int {
+	if value == 0 {
+		return fallback
+	}
+	return value
+}
+
+// traceSession holds the sockets and probe counters of one trace.
+type traceSession struct {
+	tracer   *Tracer
+	listener *traceListener
+	target   *net.IPAddr
+	ipv6     bool
+	port     int
+	id       uint16
+	seq      uint16
+	udp      *net.UDPConn
+	udpPort  int
+}
+
+// traceProbeKey identifies a probe in the header quoted by an ICMP error:
+// source and destination port for UDP and TCP, identifier and sequence
+// number for ICMP.
+type traceProbeKey struct {
+	first  uint16
+	second uint16
+}
+
+func (s *traceSession) openUDP() error {
+	network := "udp4"
+	if s.ipv6 {
+		network = "udp6"
+	}
+	conn, err := net.ListenUDP(network, nil)
+	if err != nil {
+		return fmt.Errorf("failed to open UDP socket: %v", err)
+	}
+	s.udp = conn
+	s.udpPort = conn.LocalAddr().(*net.UDPAddr).Port
+	return nil
+}
+
+// hop sends the probes for one TTL and reports whether the trace is over.
+func (s *traceSession) hop(ctx context.Context, ttl int) (TraceHop, bool) {
+	hop := TraceHop{TTL: ttl}
+	var acc latencyAccumulator
+	done := false
+
+	for i := 0; i < s.tracer.probes; i++ {
+		ip := formatIP(s.target.IP, s.target.Zone)
+		if s.tracer.limiter.wait(ctx, ip) != nil {
+			break
+		}
+
+		reply, err := s.probe(ctx, ttl)
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			hop.Error = err.Error()
+		}
+		if err != nil || reply.from == nil {
+			acc.lost()
+			continue
+		}
+
+		acc.reply(reply.rtt)
+		hop.RTTs = append(hop.RTTs, reply.rtt)
+		if hop.IP == "" {
+			hop.IP = formatIP(reply.from, s.target.Zone)
+		}
+		if reply.reached {
+			hop.Reached = true
+			done = true
+		}
+		if reply.note != "" {
+			hop.Note = reply.note
+			done = true
+		}
+	}
+
+	hop.Stats = acc.stats()
+	return hop, done
+}
+
+// traceReply is the answer to one probe. from is nil when the probe timed
+// out or, with err set, could not be sent.
+type traceReply struct {
+	from    net.IP
+	rtt     time.Duration
+	reached bool
+	note    string
+	err     error
+}
+
+func (s *traceSession) probe(ctx context.Context, ttl int) (traceReply, error) {
+	switch s.tracer.protocol {
+	case TraceUDP:
+		return s.probeUDP(ctx, ttl)
+	case TraceTCP:
+		return s.probeTCP(ctx, ttl)
+	default:
+		return s.probeICMP(ctx, ttl)
+	}
+}
+
+func (s *traceSession) probeICMP(ctx context.Context, ttl int) (traceReply, error) {
+	s.seq++
+	key := traceProbeKey{s.id, s.seq}
+
+	var packet []byte
+	if s.ipv6 {
+		packet = marshalEcho(icmpv6EchoRequest, s.id, s.seq, false)
+	} else {
+		packet = marshalEcho(icmpEchoRequest, s.id, s.seq, true)
+	}
+
+	if err := s.listener.setTTL(ttl); err != nil {
+		return traceReply{}, err
+	}
+	s.listener.drain()
+	start := time.Now()
+	if _, err := s.listener.conn.WriteTo(packet, s.target); err != nil {
+		return traceReply{}, fmt.Errorf("failed to send echo request: %v", err)
+	}
+	return s.listener.await(ctx, protoICMP, key, s.target.IP, start, s.tracer.timeout, nil)
+}
+
+func (s *traceSession) probeUDP(ctx context.Context, ttl int) (traceReply, error) {
+	dstPort := s.port + int(s.seq)
+	s.seq++
+	key := traceProbeKey{uint16(s.udpPort), uint16(dstPort)}
+
+	raw, err := s.udp.SyscallConn()
+	if err != nil {
+		return traceReply{}, err
+	}
+	var sockErr error
+	raw.Control(func(fd uintptr) {
+		sockErr = setHopLimit(fd, s.ipv6, ttl)
+	})
+	if sockErr != nil {
+		return traceReply{}, fmt.Errorf("failed to set TTL: %v", sockErr)
+	}
+
+	s.listener.drain()
+	start := time.Now()
+	dst := &net.UDPAddr{IP: s.target.IP, Port: dstPort, Zone: s.target.Zone}
+	if _, err := s.udp.WriteTo(make([]byte, 32), dst); err != nil {
+		return traceReply{}, fmt.Errorf("failed to send UDP probe: %v", err)
+	}
+	return s.listener.await(ctx, protoUDP, key, s.target.IP, start, s.tracer.timeout, nil)
+}
+
+// probeTCP starts a connection with a limited TTL. Routers on the way
+// answer with time exceeded, while the destination itself completes or
+// refuses the handshake.
+func (s *traceSession) probeTCP(ctx context.Context, ttl int) (traceReply, error) {
+	probeCtx, cancel := context.WithTimeout(ctx, s.tracer.timeout)
+	defer cancel()
+
+	// Each attempt binds its own source port so that late replies to an
+	// earlier TTL cannot be mistaken for this one
+	localPort, err := reserveTCPPort(s.ipv6)
+	if err != nil {
+		return traceReply{}, err
+	}
+	key := traceProbeKey{uint16(localPort), uint16(s.port)}
+
+	dialer := net.Dialer{
+		LocalAddr: &net.TCPAddr{Port: localPort},
+		Control: func(_, _ string, raw syscall.RawConn) error {
+			var sockErr error
+			raw.Control(func(fd uintptr) {
+				sockErr = setHopLimit(fd, s.ipv6, ttl)
+			})
+			return sockErr
+		},
+	}
+
+	connected := make(chan traceReply, 1)
+	s.listener.drain()
+	start := time.Now()
+	go func() {
+		address := net.JoinHostPort(formatIP(s.target.IP, s.target.Zone), strconv.Itoa(s.port))
+		conn, err := dialer.DialContext(probeCtx, "tcp", address)
+		if err == nil {
+			conn.Close()
+		}
+		switch {
+		case err == nil || isConnRefused(err):
+			connected <- traceReply{from: s.target.IP, rtt: time.Since(start), reached: true}
+		case probeCtx.Err() == nil && !isConnectError(err):
+			connected <- traceReply{err: fmt.Errorf("TCP probe failed: %v", err)}
+		}
+	}()
+
+	return s.listener.await(ctx, protoTCP, key, s.target.IP, start, s.tracer.timeout, connected)
+}
+
+// isConnectError reports whether err ended the connection attempt itself.
+// The ICMP messages that routers answer probes with abort the attempt as
+// well, so only errors before it, such as a failed bind, are reported.
+func isConnectError(err error) bool {
+	var syscallErr *os.SyscallError
+	return errors.As(err, &syscallErr) && (syscallErr.Syscall == "connect" || syscallErr.Syscall == "connectex")
+}
+
+// reserveTCPPort returns a local port that the kernel picked as free, for
+// a TCP probe to bind. A port chosen by the scanner could be in use, or in
+// TIME_WAIT from an earlier trace.
+func reserveTCPPort(ipv6 bool) (int, error) {
+	network := "tcp4"
+	if ipv6 {
+		network = "tcp6"
+	}
+	listener, err := net.Listen(network, ":0")
+	if err != nil {
+		return 0, fmt.Errorf("failed to pick a local port: %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// traceListener reads ICMP errors and echo replies from a raw socket and
+// hands them to the probe waiting for them.
+type traceListener struct {
+	sock    *icmpSocket
+	conn    net.PacketConn
+	ipv6    bool
+	id      uint16
+	replies chan tracePacket
+}
+
+// tracePacket is an ICMP message read by the listener with the parts of
+// the quoted probe needed to match it.
+type tracePacket struct {
+	from     net.IP
+	typ      uint8
+	code     uint8
+	protocol int
+	dst      net.IP
+	key      traceProbeKey
+	received time.Time
+}
+
+func newTraceListener(ipv6 bool) (*traceListener, error) {
+	network, address := "ip4:icmp", "0.0.0.0"
+	if ipv6 {
+		network, address = "ip6:ipv6-icmp", "::"
+	}
+
+	conn, err := net.ListenPacket(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("traceroute needs a raw ICMP socket (run as root or administrator): %v", err)
+	}
+
+	listener := &traceListener{
+		sock:    &icmpSocket{conn: conn, privileged: true, ipv6: ipv6},
+		conn:    conn,
+		ipv6:    ipv6,
+		replies: make(chan tracePacket, 64),
+	}
+	go listener.receiveLoop()
+	return listener, nil
+}
+
+func (l *traceListener) close() {
+	l.conn.Close()
+}
+
+func (l *traceListener) setTTL(ttl int) error {
+	raw, err := l.conn.(*net.IPConn).SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	raw.Control(func(fd uintptr) {
+		sockErr = setHopLimit(fd, l.ipv6, ttl)
+	})
+	if sockErr != nil {
+		return fmt.Errorf("failed to set TTL: %v", sockErr)
+	}
+	return nil
+}
+
+// drain discards replies to earlier probes that arrived after they timed
+// out.
+func (l *traceListener) drain() {
+	for {
+		select {
+		case <-l.replies:
+		default:
+			return
+		}
+	}
+}
+
+func (l *traceListener) receiveLoop() {
+	defer close(l.replies)
+	buf := make([]byte, 1500)
+
+	for {
+		n, from, _, err := readICMP(l.sock, buf)
+		received := time.Now()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		if from == nil {
+			continue
+		}
+
+		packet, ok := parseTracePacket(buf[:n], l.ipv6)
+		if !ok {
+			continue
+		}
+		packet.from = from.IP
+		packet.received = received
+
+		select {
+		case l.replies <- packet:
+		default:
+		}
+	}
+}
+
+// await waits for the ICMP message answering the probe identified by
+// protocol and key, or for a reply on connected, until timeout has passed
+// since start.
+func (l *traceListener) await(ctx context.Context, protocol int, key traceProbeKey, dst net.IP, start time.Time, timeout time.Duration, connected <-chan traceReply) (traceReply, error) {
+	timer := time.NewTimer(time.Until(start.Add(timeout)))
+	defer timer.Stop()
+
+	for {
+		select {
+		case reply := <-connected:
+			return reply, reply.err
+		case packet, ok := <-l.replies:
+			if !ok {
+				return traceReply{}, net.ErrClosed
+			}
+			if reply, matched := l.match(packet, protocol, key, dst); matched {
+				reply.rtt = packet.received.Sub(start)
+				return reply, nil
+			}
+		case <-timer.C:
+			return traceReply{}, nil
+		case <-ctx.Done():
+			return traceReply{}, ctx.Err()
+		}
+	}
+}
+
+func (l *traceListener) match(packet tracePacket, protocol int, key traceProbeKey, dst net.IP) (traceReply, bool) {
+	reply := traceReply{from: packet.from}
+
+	echoReply := uint8(icmpEchoReply)
+	timeExceeded := uint8(icmpTimeExceeded)
+	unreachable := uint8(icmpDestUnreachable)
+	if l.ipv6 {
+		echoReply, timeExceeded, unreachable = icmpv6EchoReply, icmpv6TimeExceeded, icmpv6DestUnreachable
+	}
+
+	if packet.typ == echoReply {
+		if protocol != protoICMP || packet.key != key || !packet.from.Equal(dst) {
+			return reply, false
+		}
+		reply.reached = true
+		return reply, true
+	}
+
+	// Errors quote the probe they answer
+	if packet.protocol != protocol || packet.key != key || !packet.dst.Equal(dst) {
+		return reply, false
+	}
+	switch packet.typ {
+	case timeExceeded:
+		return reply, true
+	case unreachable:
+		if packet.from.Equal(dst) {
+			reply.reached = true
+		}
+		reply.note = unreachableNote(packet.code, l.ipv6)
+		return reply, true
+	}
+	return reply, false
+}
+
+// unreachableNote returns the traceroute annotation for an unreachable
+// code. Port unreachable from the destination is the normal end of a UDP
+// trace and gets none.
+func unreachableNote(code uint8, ipv6 bool) string {
+	if ipv6 {
+		switch code {
+		case icmpv6PortUnreachable:
+			return ""
+		case 0:
+			return "!N"
+		case 1:
+			return "!X"
+		case 3:
+			return "!H"
+		}
+		return "!" + strconv.Itoa(int(code))
+	}
+
+	switch code {
+	case icmpPortUnreachable:
+		return ""
+	case 0:
+		return "!N"
+	case 1:
+		return "!H"
+	case 2:
+		return "!P"
+	case 4:
+		return "!F"
+	case 13:
+		return "!X"
+	}
+	return "!" + strconv.Itoa(int(code))
+}
+
+// parseTracePacket decodes an echo reply, or a time exceeded or
+// unreachable message together with the IP and transport headers it
+// quotes.
+func parseTracePacket(packet []byte, ipv6 bool) (tracePacket, bool) {
+	if len(packet) < 8 {
+		return tracePacket{}, false
+	}
+	result := tracePacket{typ: packet[0], code: packet[1]}
+
+	echoReply := uint8(icmpEchoReply)
+	if ipv6 {
+		echoReply = icmpv6EchoReply
+	}
+	if result.typ == echoReply {
+		result.protocol = protoICMP
+		result.key = traceProbeKey{binary.BigEndian.Uint16(packet[4:]), binary.BigEndian.Uint16(packet[6:])}
+		return result, true
+	}
+
+	quoted := packet[8:]
+	var transport []byte
+	if ipv6 {
+		if len(quoted) < 40 || quoted[0]>>4 != 6 {
+			return tracePacket{}, false
+		}
+		result.protocol = int(quoted[6])
+		result.dst = net.IP(append([]byte(nil), quoted[24:40]...))
+		transport = quoted[40:]
+	} else {
+		if len(quoted) < 20 || quoted[0]>>4 != 4 {
+			return tracePacket{}, false
+		}
+		headerLen := int(quoted[0]&0x0f) << 2
+		if headerLen < 20 || headerLen > len(quoted) {
+			return tracePacket{}, false
+		}
+		result.protocol = int(quoted[9])
+		result.dst = net.IP(append([]byte(nil), quoted[16:20]...))
+		transport = quoted[headerLen:]
+	}
+	if len(transport) < 8 {
+		return tracePacket{}, false
+	}
+
+	switch result.protocol {
+	case protoUDP, protoTCP:
+		result.key = traceProbeKey{binary.BigEndian.Uint16(transport[0:]), binary.BigEndian.Uint16(transport[2:])}
+	case protoICMP, protoICMPv6:
+		// Quoted echo requests are matched like echo replies
+		result.protocol = protoICMP
+		result.key = traceProbeKey{binary.BigEndian.Uint16(transport[4:]), binary.BigEndian.Uint16(transport[6:])}
+	default:
+		return tracePacket{}, false
+	}
+	return result, true
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !windows
+
+package scanner
+
+import "errors"
+
+func setHopLimit(fd uintptr, ipv6 bool, ttl int) error {
+	return errors.New("setting the TTL is not supported on this platform")
+}
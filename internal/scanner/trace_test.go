@@ -0,0 +1,144 @@
+package scanner
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// testQuotedIPv4 builds an IPv4 header of headerLen bytes for a probe of
+// protocol to dst, followed by transport.
+func testQuotedIPv4(headerLen int, protocol byte, dst string, transport []byte) []byte {
+	header := make([]byte, headerLen)
+	header[0] = 0x40 | byte(headerLen/4)
+	header[8] = 1
+	header[9] = protocol
+	copy(header[12:16], net.ParseIP("192.0.2.10").To4())
+	copy(header[16:20], net.ParseIP(dst).To4())
+	return append(header, transport...)
+}
+
+// testQuotedIPv6 builds an IPv6 header for a probe of protocol to dst,
+// followed by transport.
+func testQuotedIPv6(protocol byte, dst string, transport []byte) []byte {
+	header := make([]byte, 40)
+	header[0] = 0x60
+	header[6] = protocol
+	header[7] = 1
+	copy(header[8:24], net.ParseIP("2001:db8::10"))
+	copy(header[24:40], net.ParseIP(dst))
+	return append(header, transport...)
+}
+
+// testICMP builds an ICMP message of the given type and code whose body,
+// after the unused word, is quoted.
+func testICMP(typ, code byte, quoted []byte) []byte {
+	return append([]byte{typ, code, 0, 0, 0, 0, 0, 0}, quoted...)
+}
+
+// testPorts builds the first eight bytes of a UDP or TCP header.
+func testPorts(src, dst uint16) []byte {
+	b := binary.BigEndian.AppendUint16(nil, src)
+	b = binary.BigEndian.AppendUint16(b, dst)
+	return append(b, 0, 0, 0, 0)
+}
+
+func TestParseTracePacket(t *testing.T) {
+	udp := testPorts(40000, 33434)
+	echo := []byte{icmpEchoRequest, 0, 0, 0, 0x12, 0x34, 0x00, 0x07}
+	echo6 := []byte{icmpv6EchoRequest, 0, 0, 0, 0x12, 0x34, 0x00, 0x07}
+
+	tests := []struct {
+		name     string
+		packet   []byte
+		ipv6     bool
+		typ      byte
+		protocol int
+		dst      string
+		key      traceProbeKey
+	}{
+		{"echo reply", []byte{icmpEchoReply, 0, 0, 0, 0x12, 0x34, 0x00, 0x07}, false, icmpEchoReply, protoICMP, "", traceProbeKey{0x1234, 7}},
+		{"time exceeded for UDP", testICMP(icmpTimeExceeded, 0, testQuotedIPv4(20, protoUDP, "192.0.2.1", udp)), false, icmpTimeExceeded, protoUDP, "192.0.2.1", traceProbeKey{40000, 33434}},
+		{"header with options", testICMP(icmpTimeExceeded, 0, testQuotedIPv4(24, protoUDP, "192.0.2.1", udp)), false, icmpTimeExceeded, protoUDP, "192.0.2.1", traceProbeKey{40000, 33434}},
+		{"port unreachable", testICMP(icmpDestUnreachable, icmpPortUnreachable, testQuotedIPv4(20, protoUDP, "192.0.2.1", udp)), false, icmpDestUnreachable, protoUDP, "192.0.2.1", traceProbeKey{40000, 33434}},
+		{"time exceeded for TCP", testICMP(icmpTimeExceeded, 0, testQuotedIPv4(20, protoTCP, "192.0.2.1", testPorts(50000, 443))), false, icmpTimeExceeded, protoTCP, "192.0.2.1", traceProbeKey{50000, 443}},
+		{"time exceeded for an echo request", testICMP(icmpTimeExceeded, 0, testQuotedIPv4(20, protoICMP, "192.0.2.1", echo)), false, icmpTimeExceeded, protoICMP, "192.0.2.1", traceProbeKey{0x1234, 7}},
+		{"IPv6 echo reply", []byte{icmpv6EchoReply, 0, 0, 0, 0x12, 0x34, 0x00, 0x07}, true, icmpv6EchoReply, protoICMP, "", traceProbeKey{0x1234, 7}},
+		{"IPv6 time exceeded for UDP", testICMP(icmpv6TimeExceeded, 0, testQuotedIPv6(protoUDP, "2001:db8::1", udp)), true, icmpv6TimeExceeded, protoUDP, "2001:db8::1", traceProbeKey{40000, 33434}},
+		{"IPv6 time exceeded for an echo request", testICMP(icmpv6TimeExceeded, 0, testQuotedIPv6(protoICMPv6, "2001:db8::1", echo6)), true, icmpv6TimeExceeded, protoICMP, "2001:db8::1", traceProbeKey{0x1234, 7}},
+	}
+	for _, test := range tests {
+		packet, ok := parseTracePacket(test.packet, test.ipv6)
+		if !ok {
+			t.Errorf("%s: parseTracePacket(%x) failed", test.name, test.packet)
+			continue
+		}
+		if packet.typ != test.typ || packet.protocol != test.protocol || packet.key != test.key {
+			t.Errorf("%s: parseTracePacket = type %d, protocol %d, key %v; want %d, %d, %v",
+				test.name, packet.typ, packet.protocol, packet.key, test.typ, test.protocol, test.key)
+		}
+		if (test.dst == "" && packet.dst != nil) || (test.dst != "" && !packet.dst.Equal(net.ParseIP(test.dst))) {
+			t.Errorf("%s: dst = %v, want %q", test.name, packet.dst, test.dst)
+		}
+
+		// Cut short anywhere, the packet is rejected rather than read past
+		// its end
+		for n := range len(test.packet) {
+			if packet, ok := parseTracePacket(test.packet[:n], test.ipv6); ok {
+				t.Errorf("%s: parseTracePacket of %d bytes = %+v", test.name, n, packet)
+			}
+		}
+	}
+
+	bad := []struct {
+		name   string
+		packet []byte
+		ipv6   bool
+	}{
+		{"unknown quoted protocol", testICMP(icmpTimeExceeded, 0, testQuotedIPv4(20, 47, "192.0.2.1", udp)), false},
+		{"IPv6 quoted in ICMP", testICMP(icmpTimeExceeded, 0, testQuotedIPv6(protoUDP, "2001:db8::1", udp)), false},
+		{"IPv4 quoted in ICMPv6", testICMP(icmpv6TimeExceeded, 0, testQuotedIPv4(20, protoUDP, "192.0.2.1", append(udp, make([]byte, 20)...))), true},
+		{"header length below 20", func() []byte {
+			p := testICMP(icmpTimeExceeded, 0, testQuotedIPv4(20, protoUDP, "192.0.2.1", udp))
+			p[8] = 0x44
+			return p
+		}(), false},
+		{"header length past the end", func() []byte {
+			p := testICMP(icmpTimeExceeded, 0, testQuotedIPv4(20, protoUDP, "192.0.2.1", udp))
+			p[8] = 0x4f
+			return p
+		}(), false},
+		{"empty", nil, false},
+	}
+	for _, test := range bad {
+		if packet, ok := parseTracePacket(test.packet, test.ipv6); ok {
+			t.Errorf("%s: parseTracePacket = %+v, want nothing", test.name, packet)
+		}
+	}
+}
+
+func TestUnreachableNote(t *testing.T) {
+	tests := []struct {
+		code uint8
+		ipv6 bool
+		want string
+	}{
+		{icmpPortUnreachable, false, ""},
+		{0, false, "!N"},
+		{1, false, "!H"},
+		{2, false, "!P"},
+		{4, false, "!F"},
+		{13, false, "!X"},
+		{9, false, "!9"},
+		{icmpv6PortUnreachable, true, ""},
+		{0, true, "!N"},
+		{1, true, "!X"},
+		{3, true, "!H"},
+		{5, true, "!5"},
+	}
+	for _, test := range tests {
+		if got := unreachableNote(test.code, test.ipv6); got != test.want {
+			t.Errorf("unreachableNote(%d, %v) = %q, want %q", test.code, test.ipv6, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build linux || darwin
+
+package scanner
+
+import "syscall"
+
+// setHopLimit sets the TTL, or the hop limit for IPv6, of unicast packets
+// sent through the socket fd.
+func setHopLimit(fd uintptr, ipv6 bool, ttl int) error {
+	if ipv6 {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ttl)
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+}
@@ -0,0 +1,12 @@
+package scanner
+
+import "syscall"
+
+// setHopLimit sets the TTL, or the hop limit for IPv6, of unicast packets
+// sent through the socket fd.
+func setHopLimit(fd uintptr, ipv6 bool, ttl int) error {
+	if ipv6 {
+		return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ttl)
+	}
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+}
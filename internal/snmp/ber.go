@@ -0,0 +1,235 @@
+package snmp
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// BER tags of the types SNMP uses (RFC 3416, section 3).
+const (
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagNull        = 0x05
+	tagOID         = 0x06
+	tagSequence    = 0x30
+	tagIPAddress   = 0x40
+	tagCounter32   = 0x41
+	tagGauge32     = 0x42
+	tagTimeTicks   = 0x43
+	tagOpaque      = 0x44
+	tagCounter64   = 0x46
+	// Exceptions that take the place of a value in a response
+	tagNoSuchObject   = 0x80
+	tagNoSuchInstance = 0x81
+	tagEndOfMibView   = 0x82
+	// PDU types
+	tagGetRequest = 0xa0
+	tagResponse   = 0xa2
+	tagReport     = 0xa8
+)
+
+// encodeTLV encodes content under tag with a definite length.
+func encodeTLV(tag byte, content []byte) []byte {
+	encoded := append([]byte{tag}, encodeLength(len(content))...)
+	return append(encoded, content...)
+}
+
+// encodeLength encodes n in the short form below 128 and the long form
+// otherwise.
+func encodeLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var octets []byte
+	for ; n > 0; n >>= 8 {
+		octets = append([]byte{byte(n)}, octets...)
+	}
+	return append([]byte{0x80 | byte(len(octets))}, octets...)
+}
+
+// encodeSequence encodes the already encoded parts as a SEQUENCE.
+func encodeSequence(tag byte, parts ...[]byte) []byte {
+	var content []byte
+	for _, part := range parts {
+		content = append(content, part...)
+	}
+	return encodeTLV(tag, content)
+}
+
+// encodeInteger encodes n in the fewest two's complement octets.
+func encodeInteger(n int64) []byte {
+	var content []byte
+	for {
+		content = append([]byte{byte(n)}, content...)
+		if (n >= -0x80 && n < 0x80) || len(content) == 8 {
+			break
+		}
+		n >>= 8
+	}
+	return encodeTLV(tagInteger, content)
+}
+
+func encodeOctetString(s []byte) []byte {
+	return encodeTLV(tagOctetString, s)
+}
+
+func encodeNull() []byte {
+	return []byte{tagNull, 0}
+}
+
+// encodeOID encodes a dotted object identifier such as 1.3.6.1.2.1.1.5.0.
+func encodeOID(oid string) ([]byte, error) {
+	parts := strings.Split(strings.TrimPrefix(oid, "."), ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid OID %q", oid)
+	}
+	arcs := make([]uint64, len(parts))
+	for i, part := range parts {
+		arc, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID %q", oid)
+		}
+		arcs[i] = arc
+	}
+	if arcs[0] > 2 || (arcs[0] < 2 && arcs[1] >= 40) {
+		return nil, fmt.Errorf("invalid OID %q", oid)
+	}
+
+	// The first two arcs share a subidentifier
+	content := encodeBase128(nil, arcs[0]*40+arcs[1])
+	for _, arc := range arcs[2:] {
+		content = encodeBase128(content, arc)
+	}
+	return encodeTLV(tagOID, content), nil
+}
+
+// encodeBase128 appends n in base 128, most significant group first, with
+// the top bit set on every octet but the last.
+func encodeBase128(dst []byte, n uint64) []byte {
+	var groups []byte
+	for {
+		groups = append([]byte{byte(n & 0x7f)}, groups...)
+		n >>= 7
+		if n == 0 {
+			break
+		}
+	}
+	for i := range len(groups) - 1 {
+		groups[i] |= 0x80
+	}
+	return append(dst, groups...)
+}
+
+// element is a decoded TLV. Offset is where its content starts in the
+// message, which USM needs to find the authentication parameters.
+type element struct {
+	tag     byte
+	content []byte
+	offset  int
+}
+
+// readElement decodes the TLV at offset in message and returns it with the
+// offset just past it.
+func readElement(message []byte, offset int) (element, int, error) {
+	if offset+2 > len(message) {
+		return element{}, 0, fmt.Errorf("BER element runs past the message")
+	}
+	tag := message[offset]
+	length := int(message[offset+1])
+	offset += 2
+	if length&0x80 != 0 {
+		octets := length & 0x7f
+		if octets == 0 || octets > 4 || offset+octets > len(message) {
+			return element{}, 0, fmt.Errorf("bad BER length")
+		}
+		length = 0
+		for _, b := range message[offset : offset+octets] {
+			length = length<<8 | int(b)
+		}
+		offset += octets
+	}
+	if length < 0 || offset+length > len(message) {
+		return element{}, 0, fmt.Errorf("BER element runs past the message")
+	}
+	return element{tag: tag, content: message[offset : offset+length], offset: offset}, offset + length, nil
+}
+
+// children decodes the elements inside a constructed element such as a
+// SEQUENCE or a PDU.
+func (e element) children(message []byte) ([]element, error) {
+	var elements []element
+	end := e.offset + len(e.content)
+	for offset := e.offset; offset < end; {
+		child, next, err := readElement(message[:end], offset)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, child)
+		offset = next
+	}
+	return elements, nil
+}
+
+// expect checks that the element has the given tag.
+func (e element) expect(tag byte, what string) error {
+	if e.tag != tag {
+		return fmt.Errorf("malformed %s: tag 0x%02x, want 0x%02x", what, e.tag, tag)
+	}
+	return nil
+}
+
+// integer decodes the content of an INTEGER.
+func (e element) integer() (int64, error) {
+	if len(e.content) == 0 || len(e.content) > 8 {
+		return 0, fmt.Errorf("bad integer length %d", len(e.content))
+	}
+	n := int64(int8(e.content[0]))
+	for _, b := range e.content[1:] {
+		n = n<<8 | int64(b)
+	}
+	return n, nil
+}
+
+// unsigned decodes the content of a Counter32, Gauge32, TimeTicks or
+// Counter64, which may carry a leading zero octet.
+func (e element) unsigned() (uint64, error) {
+	if len(e.content) == 0 || len(e.content) > 9 {
+		return 0, fmt.Errorf("bad unsigned length %d", len(e.content))
+	}
+	n := new(big.Int).SetBytes(e.content)
+	if !n.IsUint64() {
+		return 0, fmt.Errorf("unsigned value out of range")
+	}
+	return n.Uint64(), nil
+}
+
+// oid decodes the content of an OBJECT IDENTIFIER.
+func (e element) oid() (string, error) {
+	var arcs []string
+	var n uint64
+	for i, b := range e.content {
+		if n >= 1<<57 {
+			return "", fmt.Errorf("OID arc out of range")
+		}
+		n = n<<7 | uint64(b&0x7f)
+		if b&0x80 != 0 {
+			if i == len(e.content)-1 {
+				return "", fmt.Errorf("truncated OID")
+			}
+			continue
+		}
+		if len(arcs) == 0 {
+			first := min(n/40, 2)
+			arcs = append(arcs, strconv.FormatUint(first, 10), strconv.FormatUint(n-first*40, 10))
+		} else {
+			arcs = append(arcs, strconv.FormatUint(n, 10))
+		}
+		n = 0
+	}
+	if len(arcs) == 0 {
+		return "", fmt.Errorf("empty OID")
+	}
+	return strings.Join(arcs, "."), nil
+}
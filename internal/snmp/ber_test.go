@@ -0,0 +1,250 @@
+package snmp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"net/netip"
+	"testing"
+)
+
+func TestInteger(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "020100"},
+		{1, "020101"},
+		{127, "02017f"},
+		{128, "02020080"},
+		{255, "020200ff"},
+		{256, "02020100"},
+		{-1, "0201ff"},
+		{-128, "020180"},
+		{-129, "0202ff7f"},
+		{-256, "0202ff00"},
+		{0x7fffffff, "02047fffffff"},
+		{-0x80000000, "020480000000"},
+		{math.MaxInt64, "02087fffffffffffffff"},
+		{math.MinInt64, "02088000000000000000"},
+	}
+	for _, test := range tests {
+		encoded := encodeInteger(test.n)
+		if got := hex.EncodeToString(encoded); got != test.want {
+			t.Errorf("encodeInteger(%d) = %s, want %s", test.n, got, test.want)
+		}
+		e, next, err := readElement(encoded, 0)
+		if err != nil || next != len(encoded) || e.tag != tagInteger {
+			t.Errorf("readElement(%x) = %+v, %d, %v", encoded, e, next, err)
+			continue
+		}
+		if n, err := e.integer(); err != nil || n != test.n {
+			t.Errorf("integer() of %x = %d, %v; want %d", encoded, n, err, test.n)
+		}
+	}
+
+	for _, content := range []string{"", "010203040506070809"} {
+		raw, _ := hex.DecodeString(content)
+		if n, err := (element{tag: tagInteger, content: raw}).integer(); err == nil {
+			t.Errorf("integer() of %q = %d, want an error", content, n)
+		}
+	}
+}
+
+func TestLength(t *testing.T) {
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{0, "00"},
+		{127, "7f"},
+		{128, "8180"},
+		{255, "81ff"},
+		{256, "820100"},
+		{65535, "82ffff"},
+		{65536, "83010000"},
+	}
+	for _, test := range tests {
+		if got := hex.EncodeToString(encodeLength(test.n)); got != test.want {
+			t.Errorf("encodeLength(%d) = %s, want %s", test.n, got, test.want)
+		}
+		content := bytes.Repeat([]byte{0xaa}, test.n)
+		encoded := encodeOctetString(content)
+		e, next, err := readElement(encoded, 0)
+		if err != nil || next != len(encoded) || e.offset != len(encoded)-test.n || !bytes.Equal(e.content, content) {
+			t.Errorf("readElement of a %d-byte string = offset %d, end %d, %v", test.n, e.offset, next, err)
+		}
+	}
+
+	bad := []string{
+		"",
+		"04",
+		// Content shorter than the length
+		"0403aabb",
+		"04820100aa",
+		// Indefinite length, and lengths of more than four octets
+		"0480",
+		"04850000000001aa",
+		// Length octets missing
+		"0482",
+	}
+	for _, message := range bad {
+		raw, _ := hex.DecodeString(message)
+		if e, _, err := readElement(raw, 0); err == nil {
+			t.Errorf("readElement(%s) = %+v, want an error", message, e)
+		}
+	}
+}
+
+func TestOID(t *testing.T) {
+	tests := []struct {
+		oid, want, decoded string
+	}{
+		{"1.3.6.1.2.1.1.5.0", "06082b06010201010500", ""},
+		{".1.3.6.1.4.1.311", "06072b060104018237", "1.3.6.1.4.1.311"},
+		{"0.0", "060100", ""},
+		{"0.39", "060127", ""},
+		{"1.39", "06014f", ""},
+		{"2.0", "060150", ""},
+		// The first subidentifier of the 2 arc may take several octets
+		{"2.999.3", "0603883703", ""},
+		{"1.3.6.1.4.1.2021.4294967295", "060c2b060104018f658fffffff7f", ""},
+		{"1.3.18446744073709551615", "060b2b81ffffffffffffffff7f", ""},
+	}
+	for _, test := range tests {
+		encoded, err := encodeOID(test.oid)
+		if err != nil {
+			t.Errorf("encodeOID(%s): %v", test.oid, err)
+			continue
+		}
+		if got := hex.EncodeToString(encoded); got != test.want {
+			t.Errorf("encodeOID(%s) = %s, want %s", test.oid, got, test.want)
+		}
+		want := test.decoded
+		if want == "" {
+			want = test.oid
+		}
+		e, _, _ := readElement(encoded, 0)
+		if got, err := e.oid(); err != nil || got != want {
+			t.Errorf("oid() of %s = %s, %v; want %s", test.want, got, err, want)
+		}
+	}
+
+	for _, oid := range []string{"", "1", "3.1", "1.40", "0.40", "1.3.a", "1..3", "1.3.-1", "1.3.18446744073709551616"} {
+		if encoded, err := encodeOID(oid); err == nil {
+			t.Errorf("encodeOID(%q) = %x, want an error", oid, encoded)
+		}
+	}
+	// Empty, truncated, and arcs of 2^64 and more
+	for _, content := range []string{"", "2b86", "2b8280808080808080808000", "2bffffffffffffffffffff7f"} {
+		raw, _ := hex.DecodeString(content)
+		if oid, err := (element{tag: tagOID, content: raw}).oid(); err == nil {
+			t.Errorf("oid() of %q = %s, want an error", content, oid)
+		}
+	}
+}
+
+func TestDecodeValue(t *testing.T) {
+	tests := []struct {
+		encoded string
+		want    any
+	}{
+		{"020105", int64(5)},
+		{"0403616263", []byte("abc")},
+		{"4003616263", nil},
+		{"06032b0601", "1.3.6.1"},
+		{"4004c0000201", netip.MustParseAddr("192.0.2.1")},
+		{"410500ffffffff", uint64(math.MaxUint32)},
+		{"420101", uint64(1)},
+		{"4304000186a0", uint64(100000)},
+		{"4400", []byte{}},
+		{"460900ffffffffffffffff", uint64(math.MaxUint64)},
+		{"46080123456789abcdef", uint64(0x0123456789abcdef)},
+		{"0500", nil},
+		{"8000", nil},
+		{"8100", nil},
+		{"8200", nil},
+	}
+	for _, test := range tests {
+		raw, _ := hex.DecodeString(test.encoded)
+		e, _, err := readElement(raw, 0)
+		if err != nil {
+			t.Errorf("readElement(%s): %v", test.encoded, err)
+			continue
+		}
+		value, err := decodeValue(e)
+		if test.encoded == "4003616263" {
+			// An IpAddress must be four octets
+			if err == nil {
+				t.Errorf("decodeValue(%s) = %v, want an error", test.encoded, value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("decodeValue(%s): %v", test.encoded, err)
+			continue
+		}
+		if got, ok := value.([]byte); ok {
+			if want, _ := test.want.([]byte); !bytes.Equal(got, want) {
+				t.Errorf("decodeValue(%s) = %x, want %x", test.encoded, got, want)
+			}
+		} else if value != test.want {
+			t.Errorf("decodeValue(%s) = %#v, want %#v", test.encoded, value, test.want)
+		}
+	}
+
+	for _, encoded := range []string{"46090100000000000000ff", "4100", "470101"} {
+		raw, _ := hex.DecodeString(encoded)
+		e, _, _ := readElement(raw, 0)
+		if value, err := decodeValue(e); err == nil {
+			t.Errorf("decodeValue(%s) = %v, want an error", encoded, value)
+		}
+	}
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	// A GetRequest as net-snmp's snmpget sends it for sysName.0
+	want, _ := hex.DecodeString("302902010104067075626c6963a01c02040a1b2c3d020100020100300e300c06082b060102010105000500")
+	oid, _ := encodeOID(OIDSysName)
+	pdu := encodeSequence(tagGetRequest, encodeInteger(0x0a1b2c3d), encodeInteger(0), encodeInteger(0),
+		encodeSequence(tagSequence, encodeSequence(tagSequence, oid, encodeNull())))
+	message := encodeSequence(tagSequence, encodeInteger(int64(Version2c)), encodeOctetString([]byte("public")), pdu)
+	if !bytes.Equal(message, want) {
+		t.Fatalf("GetRequest = %x, want %x", message, want)
+	}
+
+	parts, err := messageParts(message, Version2c, 3)
+	if err != nil {
+		t.Fatalf("messageParts: %v", err)
+	}
+	if string(parts[1].content) != "public" || pduRequestID(message, parts[2]) != 0x0a1b2c3d {
+		t.Errorf("parts = %+v, want community public and request 0x0a1b2c3d", parts)
+	}
+	if _, err := messageParts(message, Version3, 3); err == nil {
+		t.Error("messageParts accepted a v2c message as v3")
+	}
+
+	// A long response takes long-form lengths at every level
+	value := encodeOctetString(bytes.Repeat([]byte("x"), 300))
+	response := encodeSequence(tagResponse, encodeInteger(9), encodeInteger(0), encodeInteger(0),
+		encodeSequence(tagSequence, encodeSequence(tagSequence, oid, value)))
+	message = encodeSequence(tagSequence, encodeInteger(int64(Version2c)), encodeOctetString([]byte("public")), response)
+	parts, err = messageParts(message, Version2c, 3)
+	if err != nil {
+		t.Fatalf("messageParts of a long response: %v", err)
+	}
+	variables, err := parseResponse(message, parts[2], 9)
+	if err != nil || len(variables) != 1 || variables[0].OID != OIDSysName || len(variables[0].Value.([]byte)) != 300 {
+		t.Errorf("parseResponse = %+v, %v; want 300 bytes of sysName", variables, err)
+	}
+	if _, err := parseResponse(message, parts[2], 10); err == nil {
+		t.Error("parseResponse accepted a response to another request")
+	}
+
+	errored := encodeSequence(tagResponse, encodeInteger(9), encodeInteger(2), encodeInteger(1),
+		encodeSequence(tagSequence, encodeSequence(tagSequence, oid, encodeNull())))
+	e, _, _ := readElement(errored, 0)
+	if _, err := parseResponse(errored, e, 9); err == nil || err.Error() != "agent answered noSuchName for variable 1" {
+		t.Errorf("parseResponse of an error status: %v", err)
+	}
+}
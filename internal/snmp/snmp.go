@@ -0,0 +1,400 @@
+// Package snmp is a minimal SNMP client that reads single objects from
+// agents with GetRequest, over SNMPv2c with a community string or over
+// SNMPv3 with the User-based Security Model.
+package snmp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+const (
+	// Port is the UDP port agents listen on.
+	Port = 161
+	// maxMessageSize is the largest reply read, and the largest the client
+	// tells v3 agents it accepts.
+	maxMessageSize = 65507
+)
+
+// Version is an SNMP protocol version, as carried in messages.
+type Version int
+
+const (
+	Version2c Version = 1
+	Version3  Version = 3
+)
+
+func (v Version) String() string {
+	switch v {
+	case Version2c:
+		return "v2c"
+	case Version3:
+		return "v3"
+	}
+	return fmt.Sprintf("version %d", int(v))
+}
+
+// Authentication protocols of the User-based Security Model.
+const (
+	AuthNone   = ""
+	AuthMD5    = "md5"
+	AuthSHA    = "sha"
+	AuthSHA256 = "sha256"
+)
+
+// Config holds the credentials the client uses.
+type Config struct {
+	Version Version
+	// Community is the v2c community string.
+	Community string
+	// User is the v3 user name. Auth is its authentication protocol, or
+	// AuthNone for a noAuthNoPriv user, and Password its passphrase.
+	User     string
+	Auth     string
+	Password string
+}
+
+// ParseConfig reads credentials given as a community string such as
+// "public" or "v2c:public", as "v3:USER" for a user without
+// authentication, or as "v3:USER:PROTOCOL:PASSWORD" where PROTOCOL is md5,
+// sha or sha256. Everything after the third colon is the password.
+func ParseConfig(spec string) (Config, error) {
+	if spec == "" {
+		return Config{}, fmt.Errorf("no SNMP community or user given")
+	}
+	version, rest, found := strings.Cut(spec, ":")
+	switch {
+	case !found || (version != "v2c" && version != "v3"):
+		return Config{Version: Version2c, Community: spec}, nil
+	case version == "v2c":
+		if rest == "" {
+			return Config{}, fmt.Errorf("no SNMP community given")
+		}
+		return Config{Version: Version2c, Community: rest}, nil
+	}
+
+	parts := strings.SplitN(rest, ":", 3)
+	config := Config{Version: Version3, User: parts[0]}
+	if config.User == "" {
+		return Config{}, fmt.Errorf("no SNMPv3 user given")
+	}
+	switch len(parts) {
+	case 1:
+		return config, nil
+	case 2:
+		return Config{}, fmt.Errorf("no password given for SNMPv3 user %s", config.User)
+	}
+	config.Auth = strings.ToLower(parts[1])
+	config.Password = parts[2]
+	if _, ok := authProtocols[config.Auth]; !ok {
+		return Config{}, fmt.Errorf("unknown SNMPv3 authentication protocol %q (valid: md5, sha, sha256)", parts[1])
+	}
+	return config, nil
+}
+
+// String describes the credentials without giving away any secret.
+func (c Config) String() string {
+	if c.Version != Version3 {
+		return c.Version.String()
+	}
+	if c.Auth == AuthNone {
+		return fmt.Sprintf("v3 user %s, no authentication", c.User)
+	}
+	return fmt.Sprintf("v3 user %s, %s authentication", c.User, c.Auth)
+}
+
+// Variable is an object read from an agent.
+type Variable struct {
+	OID string
+	// Value is an int64 for INTEGER, a []byte for OCTET STRING and Opaque,
+	// a dotted string for OBJECT IDENTIFIER, a netip.Addr for IpAddress and
+	// a uint64 for Counter32, Gauge32, TimeTicks and Counter64. It is nil
+	// if the agent has no such object.
+	Value any
+}
+
+// Client reads objects from SNMP agents.
+type Client struct {
+	config  Config
+	timeout time.Duration
+	retries int
+	// authKey is the v3 user's key before it is localized to an engine.
+	authKey []byte
+}
+
+// NewClient returns a client that uses config. Each request waits one
+// second for an answer and is retried once.
+func NewClient(config Config) (*Client, error) {
+	client := &Client{
+		config:  config,
+		timeout: time.Second,
+		retries: 1,
+	}
+	switch config.Version {
+	case Version2c:
+		if config.Community == "" {
+			return nil, fmt.Errorf("no SNMP community given")
+		}
+	case Version3:
+		if config.User == "" {
+			return nil, fmt.Errorf("no SNMPv3 user given")
+		}
+		if config.Auth != AuthNone {
+			protocol, ok := authProtocols[config.Auth]
+			if !ok {
+				return nil, fmt.Errorf("unknown SNMPv3 authentication protocol %q", config.Auth)
+			}
+			if len(config.Password) < 8 {
+				return nil, fmt.Errorf("SNMPv3 password must be at least 8 characters")
+			}
+			client.authKey = passwordToKey(protocol, config.Password)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported SNMP version %d", int(config.Version))
+	}
+	return client, nil
+}
+
+// SetTimeout sets how long each request waits for an answer.
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.timeout = timeout
+}
+
+// SetRetries sets how often a request that got no answer is sent again.
+func (c *Client) SetRetries(retries int) {
+	c.retries = retries
+}
+
+// Config returns the credentials the client uses.
+func (c *Client) Config() Config {
+	return c.config
+}
+
+// Get reads oids from the agent at addr, an IP address with an optional
+// port that defaults to 161. The variables come back in the order asked
+// for.
+func (c *Client) Get(ctx context.Context, addr string, oids ...string) ([]Variable, error) {
+	agent, err := parseAgent(addr)
+	if err != nil {
+		return nil, err
+	}
+	bindings := make([][]byte, len(oids))
+	for i, oid := range oids {
+		encoded, err := encodeOID(oid)
+		if err != nil {
+			return nil, err
+		}
+		bindings[i] = encodeSequence(tagSequence, encoded, encodeNull())
+	}
+	requestID := int64(rand.Int32())
+	pdu := encodeSequence(tagGetRequest, encodeInteger(requestID), encodeInteger(0), encodeInteger(0), encodeSequence(tagSequence, bindings...))
+
+	var response element
+	var message []byte
+	if c.config.Version == Version3 {
+		message, response, err = c.exchangeV3(ctx, agent, pdu)
+	} else {
+		message, response, err = c.exchangeV2c(ctx, agent, pdu, requestID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseResponse(message, response, requestID)
+}
+
+// exchangeV2c sends pdu in a community message and returns the reply and
+// the response PDU in it.
+func (c *Client) exchangeV2c(ctx context.Context, agent netip.AddrPort, pdu []byte, requestID int64) ([]byte, element, error) {
+	request := encodeSequence(tagSequence, encodeInteger(int64(Version2c)), encodeOctetString([]byte(c.config.Community)), pdu)
+
+	var response element
+	reply, err := c.exchange(ctx, agent, request, func(message []byte) bool {
+		parts, err := messageParts(message, Version2c, 3)
+		if err != nil || string(parts[1].content) != c.config.Community {
+			return false
+		}
+		response = parts[2]
+		return pduRequestID(message, response) == requestID
+	})
+	return reply, response, err
+}
+
+// exchange sends request to agent and returns the first reply accepted by
+// match, sending the request again after each timeout up to the retry
+// count.
+func (c *Client) exchange(ctx context.Context, agent netip.AddrPort, request []byte, match func([]byte) bool) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", agent.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SNMP socket: %v", err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	buf := make([]byte, maxMessageSize)
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if _, err := conn.Write(request); err != nil {
+			return nil, fmt.Errorf("failed to send SNMP request: %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(c.timeout))
+		for {
+			n, err := conn.Read(buf)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				break
+			}
+			if err != nil {
+				// Most often ICMP port unreachable: no agent runs there
+				return nil, fmt.Errorf("no SNMP agent on %s: %v", agent, err)
+			}
+			// Ignore stray datagrams, such as late answers to an earlier try
+			if reply := buf[:n]; match(reply) {
+				return append([]byte(nil), reply...), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no answer from SNMP agent %s", agent)
+}
+
+// messageParts decodes the outer SEQUENCE of message and returns its
+// elements if it is a message of version with the given number of parts.
+func messageParts(message []byte, version Version, count int) ([]element, error) {
+	outer, _, err := readElement(message, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := outer.expect(tagSequence, "SNMP message"); err != nil {
+		return nil, err
+	}
+	parts, err := outer.children(message)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) != count {
+		return nil, fmt.Errorf("malformed SNMP message")
+	}
+	if n, err := parts[0].integer(); err != nil || n != int64(version) {
+		return nil, fmt.Errorf("SNMP message of another version")
+	}
+	return parts, nil
+}
+
+// pduRequestID returns the request ID of pdu, or -1 if it has none.
+func pduRequestID(message []byte, pdu element) int64 {
+	fields, err := pdu.children(message)
+	if err != nil || len(fields) != 4 {
+		return -1
+	}
+	id, err := fields[0].integer()
+	if err != nil {
+		return -1
+	}
+	return id
+}
+
+// errorStatusNames are the names of the error-status values of a response
+// (RFC 3416, section 3).
+var errorStatusNames = []string{
+	"noError", "tooBig", "noSuchName", "badValue", "readOnly", "genErr",
+	"noAccess", "wrongType", "wrongLength", "wrongEncoding", "wrongValue",
+	"noCreation", "inconsistentValue", "resourceUnavailable", "commitFailed",
+	"undoFailed", "authorizationError", "notWritable", "inconsistentName",
+}
+
+// parseResponse decodes the variables of a response PDU.
+func parseResponse(message []byte, pdu element, requestID int64) ([]Variable, error) {
+	if err := pdu.expect(tagResponse, "SNMP response"); err != nil {
+		return nil, err
+	}
+	fields, err := pdu.children(message)
+	if err != nil || len(fields) != 4 {
+		return nil, fmt.Errorf("malformed SNMP response")
+	}
+	if id, err := fields[0].integer(); err != nil || id != requestID {
+		return nil, fmt.Errorf("SNMP response to another request")
+	}
+	status, err := fields[1].integer()
+	if err != nil {
+		return nil, fmt.Errorf("malformed SNMP response")
+	}
+	if status != 0 {
+		name := fmt.Sprintf("error %d", status)
+		if status > 0 && status < int64(len(errorStatusNames)) {
+			name = errorStatusNames[status]
+		}
+		index, _ := fields[2].integer()
+		return nil, fmt.Errorf("agent answered %s for variable %d", name, index)
+	}
+
+	if err := fields[3].expect(tagSequence, "variable bindings"); err != nil {
+		return nil, err
+	}
+	bindings, err := fields[3].children(message)
+	if err != nil {
+		return nil, fmt.Errorf("malformed variable bindings: %v", err)
+	}
+	variables := make([]Variable, 0, len(bindings))
+	for _, binding := range bindings {
+		parts, err := binding.children(message)
+		if err != nil || len(parts) != 2 || parts[0].tag != tagOID {
+			return nil, fmt.Errorf("malformed variable binding")
+		}
+		oid, err := parts[0].oid()
+		if err != nil {
+			return nil, err
+		}
+		value, err := decodeValue(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("bad value for %s: %v", oid, err)
+		}
+		variables = append(variables, Variable{OID: oid, Value: value})
+	}
+	return variables, nil
+}
+
+// decodeValue converts the value of a variable binding to the Go type
+// documented on Variable.
+func decodeValue(e element) (any, error) {
+	switch e.tag {
+	case tagInteger:
+		return e.integer()
+	case tagOctetString, tagOpaque:
+		return append([]byte(nil), e.content...), nil
+	case tagOID:
+		return e.oid()
+	case tagIPAddress:
+		ip, ok := netip.AddrFromSlice(e.content)
+		if !ok {
+			return nil, fmt.Errorf("bad IpAddress length %d", len(e.content))
+		}
+		return ip, nil
+	case tagCounter32, tagGauge32, tagTimeTicks, tagCounter64:
+		return e.unsigned()
+	case tagNull, tagNoSuchObject, tagNoSuchInstance, tagEndOfMibView:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown type 0x%02x", e.tag)
+}
+
+// parseAgent reads an IP address with an optional port, which defaults to
+// Port.
+func parseAgent(addr string) (netip.AddrPort, error) {
+	if ip, err := netip.ParseAddr(addr); err == nil {
+		return netip.AddrPortFrom(ip, Port), nil
+	}
+	agent, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid SNMP agent address %q", addr)
+	}
+	return agent, nil
+}
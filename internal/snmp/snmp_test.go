@@ -0,0 +1,363 @@
+package snmp
+
+import (
+	"context"
+	"crypto/hmac"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testAgent is an SNMP agent on a loopback port that answers GetRequests
+// for the objects it holds, over v2c and over v3 with USM authentication.
+type testAgent struct {
+	addr      string
+	community string
+	objects   map[string][]byte
+	users     map[string]testUser
+	engineID  []byte
+	boots     int64
+	// clock is the engine time. Discovery reports it skew seconds behind,
+	// as an agent rebooted since its clock was learned would appear.
+	clock int64
+	skew  int64
+	// tamper breaks the digest of authenticated responses.
+	tamper bool
+
+	mutex   sync.Mutex
+	reports []string
+	gets    int
+}
+
+// testUser is a v3 user of the agent, keyed for its engine.
+type testUser struct {
+	protocol authProtocol
+	key      []byte
+}
+
+// testSystemGroup is the system group of the agent, without sysLocation.
+var testSystemGroup = map[string][]byte{
+	OIDSysDescr:    encodeOctetString([]byte("Linux edge 6.1.0\r\n  x86_64\x00")),
+	OIDSysObjectID: mustEncodeOID("1.3.6.1.4.1.8072.3.2.10"),
+	OIDSysUpTime:   encodeTLV(tagTimeTicks, []byte{0x00, 0xbc, 0x61, 0x4e}),
+	OIDSysName:     encodeOctetString([]byte("edge-router")),
+}
+
+func mustEncodeOID(oid string) []byte {
+	encoded, err := encodeOID(oid)
+	if err != nil {
+		panic(err)
+	}
+	return encoded
+}
+
+// newTestAgent starts an agent with the system group, the community
+// "public" and no users, which setup may change before it serves.
+func newTestAgent(t *testing.T, setup func(*testAgent)) *testAgent {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	agent := &testAgent{
+		addr:      conn.LocalAddr().String(),
+		community: "public",
+		objects:   testSystemGroup,
+		users:     make(map[string]testUser),
+		engineID:  []byte{0x80, 0x00, 0x1f, 0x88, 0x80, 0x12, 0x34, 0x56, 0x78},
+		boots:     3,
+		clock:     86400,
+	}
+	if setup != nil {
+		setup(agent)
+	}
+	go func() {
+		buf := make([]byte, maxMessageSize)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if reply := agent.handle(append([]byte(nil), buf[:n]...)); reply != nil {
+				conn.WriteToUDP(reply, from)
+			}
+		}
+	}()
+	return agent
+}
+
+// addUser adds a v3 user with protocol, which may be AuthNone.
+func (a *testAgent) addUser(name, protocol, password string) {
+	user := testUser{}
+	if protocol != AuthNone {
+		user.protocol = authProtocols[protocol]
+		user.key = localizeKey(user.protocol, passwordToKey(user.protocol, password), a.engineID)
+	}
+	a.users[name] = user
+}
+
+// counts returns the reports sent, by the OID they carry, and the number
+// of GetRequests answered.
+func (a *testAgent) counts() ([]string, int) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return append([]string(nil), a.reports...), a.gets
+}
+
+func (a *testAgent) handle(message []byte) []byte {
+	outer, _, err := readElement(message, 0)
+	if err != nil || outer.tag != tagSequence {
+		return nil
+	}
+	parts, err := outer.children(message)
+	if err != nil || len(parts) < 3 {
+		return nil
+	}
+	version, _ := parts[0].integer()
+	switch {
+	case version == int64(Version2c) && len(parts) == 3:
+		if string(parts[1].content) != a.community {
+			return nil
+		}
+		response := a.respond(message, parts[2])
+		if response == nil {
+			return nil
+		}
+		return encodeSequence(tagSequence, encodeInteger(int64(Version2c)), encodeOctetString(parts[1].content), response)
+	case version == int64(Version3) && len(parts) == 4:
+		return a.handleV3(message, parts)
+	}
+	return nil
+}
+
+// respond answers a GetRequest with the objects asked for.
+func (a *testAgent) respond(message []byte, pdu element) []byte {
+	fields, err := pdu.children(message)
+	if err != nil || pdu.tag != tagGetRequest || len(fields) != 4 {
+		return nil
+	}
+	requestID, _ := fields[0].integer()
+	bindings, _ := fields[3].children(message)
+	var answers [][]byte
+	for _, binding := range bindings {
+		parts, _ := binding.children(message)
+		oid, _ := parts[0].oid()
+		value, ok := a.objects[oid]
+		if !ok {
+			value = []byte{tagNoSuchObject, 0}
+		}
+		answers = append(answers, encodeSequence(tagSequence, mustEncodeOID(oid), value))
+	}
+	a.mutex.Lock()
+	a.gets++
+	a.mutex.Unlock()
+	return encodeSequence(tagResponse, encodeInteger(requestID), encodeInteger(0), encodeInteger(0), encodeSequence(tagSequence, answers...))
+}
+
+func (a *testAgent) handleV3(message []byte, parts []element) []byte {
+	header, err := parts[1].children(message)
+	if err != nil || len(header) != 4 || len(header[2].content) != 1 {
+		return nil
+	}
+	msgID, _ := header[0].integer()
+	flags := header[2].content[0]
+	security, _, err := readElement(message, parts[2].offset)
+	if err != nil {
+		return nil
+	}
+	params, err := security.children(message)
+	if err != nil || len(params) != 6 {
+		return nil
+	}
+	scoped, err := parts[3].children(message)
+	if err != nil || len(scoped) != 3 {
+		return nil
+	}
+	requestID := pduRequestID(message, scoped[2])
+
+	if len(params[0].content) == 0 {
+		return a.report(msgID, requestID, "1.3.6.1.6.3.15.1.1.4.0", a.clock-a.skew, "", &testUser{})
+	}
+	name := string(params[3].content)
+	user, ok := a.users[name]
+	switch {
+	case !ok:
+		return a.report(msgID, requestID, "1.3.6.1.6.3.15.1.1.3.0", a.clock, "", &testUser{})
+	case (flags&flagAuth != 0) != (user.key != nil):
+		return a.report(msgID, requestID, "1.3.6.1.6.3.15.1.1.1.0", a.clock, "", &testUser{})
+	}
+	if user.key != nil {
+		digest := params[4]
+		signed := append([]byte(nil), message...)
+		clear(signed[digest.offset : digest.offset+len(digest.content)])
+		mac := hmac.New(user.protocol.hash, user.key)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil)[:user.protocol.macLen], digest.content) {
+			return a.report(msgID, requestID, "1.3.6.1.6.3.15.1.1.5.0", a.clock, "", &testUser{})
+		}
+		// Authenticated requests must be within 150 seconds of the engine
+		boots, _ := params[1].integer()
+		clock, _ := params[2].integer()
+		if boots != a.boots || clock < a.clock-150 || clock > a.clock+150 {
+			return a.report(msgID, requestID, usmNotInTimeWindow, a.clock, name, &user)
+		}
+	}
+
+	response := a.respond(message, scoped[2])
+	if response == nil {
+		return nil
+	}
+	return a.encodeV3(msgID, name, &user, a.clock, response)
+}
+
+// report refuses a request with a Report PDU that points at the usmStats
+// counter oid, signed if user has a key.
+func (a *testAgent) report(msgID, requestID int64, oid string, clock int64, name string, user *testUser) []byte {
+	a.mutex.Lock()
+	a.reports = append(a.reports, oid)
+	a.mutex.Unlock()
+
+	binding := encodeSequence(tagSequence, mustEncodeOID(oid), encodeTLV(tagCounter32, []byte{1}))
+	pdu := encodeSequence(tagReport, encodeInteger(requestID), encodeInteger(0), encodeInteger(0), encodeSequence(tagSequence, binding))
+	return a.encodeV3(msgID, name, user, clock, pdu)
+}
+
+// encodeV3 wraps pdu in a v3 message from the agent's engine at clock,
+// signed with the user's key if it has one.
+func (a *testAgent) encodeV3(msgID int64, name string, user *testUser, clock int64, pdu []byte) []byte {
+	flags := byte(0)
+	if user.key != nil {
+		flags |= flagAuth
+	}
+	header := encodeSequence(tagSequence, encodeInteger(msgID), encodeInteger(maxMessageSize), encodeOctetString([]byte{flags}), encodeInteger(usmSecurityModel))
+	authParams := make([]byte, user.protocol.macLen)
+	privParams := encodeOctetString(nil)
+	params := encodeSequence(tagSequence, encodeOctetString(a.engineID), encodeInteger(a.boots), encodeInteger(clock),
+		encodeOctetString([]byte(name)), encodeOctetString(authParams), privParams)
+	scoped := encodeSequence(tagSequence, encodeOctetString(a.engineID), encodeOctetString(nil), pdu)
+	message := encodeSequence(tagSequence, encodeInteger(int64(Version3)), header, encodeOctetString(params), scoped)
+	if user.key != nil {
+		start := len(message) - len(scoped) - len(privParams) - len(authParams)
+		mac := hmac.New(user.protocol.hash, user.key)
+		mac.Write(message)
+		copy(message[start:], mac.Sum(nil)[:user.protocol.macLen])
+		if a.tamper {
+			message[start] ^= 0xff
+		}
+	}
+	return message
+}
+
+func newTestClient(t *testing.T, spec string) *Client {
+	t.Helper()
+	config, err := ParseConfig(spec)
+	if err != nil {
+		t.Fatalf("ParseConfig(%q): %v", spec, err)
+	}
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	client.SetTimeout(300 * time.Millisecond)
+	client.SetRetries(0)
+	return client
+}
+
+func checkSystemGroup(t *testing.T, info *SystemInfo) {
+	t.Helper()
+	want := SystemInfo{
+		Name:     "edge-router",
+		Descr:    "Linux edge 6.1.0 x86_64",
+		ObjectID: "1.3.6.1.4.1.8072.3.2.10",
+		UpTime:   12345678 * 10 * time.Millisecond,
+	}
+	if *info != want {
+		t.Errorf("System = %+v, want %+v", *info, want)
+	}
+}
+
+func TestSystemV2c(t *testing.T) {
+	agent := newTestAgent(t, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	info, err := newTestClient(t, "public").System(ctx, agent.addr)
+	if err != nil {
+		t.Fatalf("System: %v", err)
+	}
+	checkSystemGroup(t, info)
+
+	variables, err := newTestClient(t, "v2c:public").Get(ctx, agent.addr, OIDSysName, OIDSysLocation, OIDSysUpTime)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if len(variables) != 3 || variables[0].OID != OIDSysName || variables[1].Value != nil || variables[2].Value != uint64(12345678) {
+		t.Errorf("Get = %+v, want sysName, a missing sysLocation and sysUpTime", variables)
+	}
+
+	// Agents drop requests with another community
+	start := time.Now()
+	if _, err := newTestClient(t, "private").System(ctx, agent.addr); err == nil || !strings.Contains(err.Error(), "no answer") {
+		t.Errorf("System with a wrong community: %v, want no answer", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("wrong community took %v, want about one timeout", elapsed)
+	}
+}
+
+func TestGetRefused(t *testing.T) {
+	// A closed port answers with ICMP port unreachable
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := conn.LocalAddr().String()
+	conn.Close()
+
+	client := newTestClient(t, "public")
+	client.SetRetries(3)
+	if _, err := client.Get(context.Background(), addr, OIDSysName); err == nil {
+		t.Error("Get from a closed port: no error")
+	}
+	if _, err := client.Get(context.Background(), "agent.example", OIDSysName); err == nil {
+		t.Error("Get from a host name: no error")
+	}
+	if _, err := client.Get(context.Background(), addr, "1.3.bad"); err == nil {
+		t.Error("Get of an invalid OID: no error")
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		spec string
+		want Config
+	}{
+		{"public", Config{Version: Version2c, Community: "public"}},
+		{"v2c:s3cr:et", Config{Version: Version2c, Community: "s3cr:et"}},
+		{"v1:public", Config{Version: Version2c, Community: "v1:public"}},
+		{"v3:monitor", Config{Version: Version3, User: "monitor"}},
+		{"v3:admin:SHA:pass:word", Config{Version: Version3, User: "admin", Auth: AuthSHA, Password: "pass:word"}},
+	}
+	for _, test := range tests {
+		config, err := ParseConfig(test.spec)
+		if err != nil || config != test.want {
+			t.Errorf("ParseConfig(%q) = %+v, %v; want %+v", test.spec, config, err, test.want)
+		}
+	}
+
+	for _, spec := range []string{"", "v2c:", "v3:", "v3:admin:sha", "v3:admin:sha512:password"} {
+		if config, err := ParseConfig(spec); err == nil {
+			t.Errorf("ParseConfig(%q) = %+v, want an error", spec, config)
+		}
+	}
+	if _, err := NewClient(Config{Version: Version3, User: "admin", Auth: AuthMD5, Password: "short"}); err == nil {
+		t.Error("NewClient with a 5-character password: no error")
+	}
+	if s := (Config{Version: Version3, User: "admin", Auth: AuthSHA, Password: "secret-password"}).String(); strings.Contains(s, "secret") {
+		t.Errorf("String() = %q gives the password away", s)
+	}
+}
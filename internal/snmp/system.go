@@ -0,0 +1,95 @@
+package snmp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Objects of the MIB-II system group (RFC 3418).
+const (
+	OIDSysDescr    = "1.3.6.1.2.1.1.1.0"
+	OIDSysObjectID = "1.3.6.1.2.1.1.2.0"
+	OIDSysUpTime   = "1.3.6.1.2.1.1.3.0"
+	OIDSysName     = "1.3.6.1.2.1.1.5.0"
+	OIDSysLocation = "1.3.6.1.2.1.1.6.0"
+)
+
+// SystemInfo is what a device says about itself in the system group.
+type SystemInfo struct {
+	// Name is sysName, usually the host name the device was configured
+	// with.
+	Name string `json:",omitempty"`
+	// Descr is sysDescr, which names the hardware and software, such as
+	// "Cisco IOS Software, C2960 Software ..." or a printer model.
+	Descr string `json:",omitempty"`
+	// ObjectID is sysObjectID, the vendor's identifier of the model under
+	// the enterprises subtree 1.3.6.1.4.1.
+	ObjectID string `json:",omitempty"`
+	// UpTime is sysUpTime, how long the agent has been running.
+	UpTime time.Duration `json:",omitempty"`
+	// Location is sysLocation, where the device is said to be.
+	Location string `json:",omitempty"`
+}
+
+// System reads the system group of the agent at addr, given as for Get.
+// Objects the agent does not have are left empty.
+func (c *Client) System(ctx context.Context, addr string) (*SystemInfo, error) {
+	variables, err := c.Get(ctx, addr, OIDSysName, OIDSysDescr, OIDSysObjectID, OIDSysUpTime, OIDSysLocation)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &SystemInfo{}
+	for _, variable := range variables {
+		switch value := variable.Value.(type) {
+		case []byte:
+			text := displayString(value)
+			switch variable.OID {
+			case OIDSysName:
+				info.Name = text
+			case OIDSysDescr:
+				info.Descr = text
+			case OIDSysLocation:
+				info.Location = text
+			}
+		case string:
+			if variable.OID == OIDSysObjectID {
+				info.ObjectID = value
+			}
+		case uint64:
+			// TimeTicks count hundredths of a second
+			if variable.OID == OIDSysUpTime {
+				info.UpTime = time.Duration(value) * 10 * time.Millisecond
+			}
+		}
+	}
+	if *info == (SystemInfo{}) {
+		return nil, fmt.Errorf("SNMP agent %s has no system group", addr)
+	}
+	return info, nil
+}
+
+// displayString turns a DisplayString into one line of text. Agents pad
+// values with NULs and put line breaks in sysDescr; bytes that are not
+// UTF-8 are taken as Latin-1.
+func displayString(value []byte) string {
+	var b strings.Builder
+	for len(value) > 0 {
+		r, size := utf8.DecodeRune(value)
+		if r == utf8.RuneError && size == 1 {
+			r = rune(value[0])
+		}
+		value = value[size:]
+		switch {
+		case r == '\r' || r == '\n' || r == '\t':
+			b.WriteByte(' ')
+		case r < ' ' || r == 0x7f:
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
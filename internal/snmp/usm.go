@@ -0,0 +1,263 @@
+package snmp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"math/rand/v2"
+	"net/netip"
+	"time"
+)
+
+// User-based Security Model constants (RFC 3414).
+const (
+	usmSecurityModel = 3
+	flagAuth         = 0x01
+	flagPriv         = 0x02
+	flagReportable   = 0x04
+	// passwordKeyLength is how many octets of the repeated password are
+	// hashed into a key.
+	passwordKeyLength = 1 << 20
+)
+
+// authProtocol is a USM authentication protocol: the hash behind its HMAC
+// and how many octets of the HMAC a message carries.
+type authProtocol struct {
+	hash   func() hash.Hash
+	macLen int
+}
+
+var authProtocols = map[string]authProtocol{
+	AuthMD5:    {md5.New, 12},
+	AuthSHA:    {sha1.New, 12},
+	AuthSHA256: {sha256.New, 24}, // RFC 7860
+}
+
+// usmStatsOIDs name the counters that a Report PDU points at to say why a
+// v3 request was refused.
+const usmNotInTimeWindow = "1.3.6.1.6.3.15.1.1.2.0"
+
+var usmStatsOIDs = map[string]string{
+	"1.3.6.1.6.3.15.1.1.1.0": "unsupported security level",
+	usmNotInTimeWindow:       "not in time window",
+	"1.3.6.1.6.3.15.1.1.3.0": "unknown user name",
+	"1.3.6.1.6.3.15.1.1.4.0": "unknown engine ID",
+	"1.3.6.1.6.3.15.1.1.5.0": "wrong digest, the password may be wrong",
+	"1.3.6.1.6.3.15.1.1.6.0": "decryption error",
+}
+
+// passwordToKey turns a password into a user key by hashing a megabyte of
+// it repeated (RFC 3414, appendix A.2).
+func passwordToKey(protocol authProtocol, password string) []byte {
+	h := protocol.hash()
+	chunk := make([]byte, 64)
+	for written, i := 0, 0; written < passwordKeyLength; written += len(chunk) {
+		for j := range chunk {
+			chunk[j] = password[i%len(password)]
+			i++
+		}
+		h.Write(chunk)
+	}
+	return h.Sum(nil)
+}
+
+// localizeKey derives the key a user has with one engine from the user
+// key.
+func localizeKey(protocol authProtocol, key, engineID []byte) []byte {
+	h := protocol.hash()
+	h.Write(key)
+	h.Write(engineID)
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+// engine is what a v3 request needs to know about the agent's SNMP engine:
+// its ID and clock, which authenticated requests must be close to.
+type engine struct {
+	id    []byte
+	boots int64
+	time  int64
+	at    time.Time
+}
+
+// clock estimates the engine's boot count and time now.
+func (e engine) clock() (int64, int64) {
+	if e.at.IsZero() {
+		return 0, 0
+	}
+	return e.boots, e.time + int64(time.Since(e.at)/time.Second)
+}
+
+// usmReply is a decoded v3 reply.
+type usmReply struct {
+	message []byte
+	engine  engine
+	pdu     element
+}
+
+// exchangeV3 discovers the agent's engine, sends pdu to it as the
+// configured user and returns the reply and the response PDU in it. A
+// request refused for being outside the engine's time window is sent once
+// more with the clock from the refusal.
+func (c *Client) exchangeV3(ctx context.Context, agent netip.AddrPort, pdu []byte) ([]byte, element, error) {
+	// An empty request from an unknown user makes the agent report its
+	// engine ID and clock (RFC 3414, section 4)
+	empty := encodeSequence(tagGetRequest, encodeInteger(int64(rand.Int32())), encodeInteger(0), encodeInteger(0), encodeSequence(tagSequence))
+	discovery, err := c.sendV3(ctx, agent, engine{}, "", flagReportable, empty)
+	if err != nil {
+		return nil, element{}, err
+	}
+	if len(discovery.engine.id) == 0 {
+		return nil, element{}, fmt.Errorf("SNMP agent %s did not give its engine ID", agent)
+	}
+
+	flags := byte(flagReportable)
+	if c.config.Auth != AuthNone {
+		flags |= flagAuth
+	}
+	target := discovery.engine
+	for attempt := 0; ; attempt++ {
+		reply, err := c.sendV3(ctx, agent, target, c.config.User, flags, pdu)
+		if err != nil {
+			return nil, element{}, err
+		}
+		if reply.pdu.tag != tagReport {
+			return reply.message, reply.pdu, nil
+		}
+		reason := reportReason(reply.message, reply.pdu)
+		if reason == usmNotInTimeWindow && attempt == 0 {
+			target = reply.engine
+			continue
+		}
+		if description, ok := usmStatsOIDs[reason]; ok {
+			reason = description
+		}
+		return nil, element{}, fmt.Errorf("SNMP agent %s refused the request: %s", agent, reason)
+	}
+}
+
+// sendV3 sends pdu in a v3 message to the engine, authenticated if flags
+// ask for it, and returns the decoded reply.
+func (c *Client) sendV3(ctx context.Context, agent netip.AddrPort, target engine, user string, flags byte, pdu []byte) (usmReply, error) {
+	var key []byte
+	var protocol authProtocol
+	if flags&flagAuth != 0 {
+		protocol = authProtocols[c.config.Auth]
+		key = localizeKey(protocol, c.authKey, target.id)
+	}
+
+	msgID := int64(rand.Int32())
+	boots, clock := target.clock()
+	header := encodeSequence(tagSequence, encodeInteger(msgID), encodeInteger(maxMessageSize), encodeOctetString([]byte{flags}), encodeInteger(usmSecurityModel))
+	authParams := make([]byte, protocol.macLen)
+	privParams := encodeOctetString(nil)
+	params := encodeSequence(tagSequence, encodeOctetString(target.id), encodeInteger(boots), encodeInteger(clock),
+		encodeOctetString([]byte(user)), encodeOctetString(authParams), privParams)
+	scoped := encodeSequence(tagSequence, encodeOctetString(target.id), encodeOctetString(nil), pdu)
+	request := encodeSequence(tagSequence, encodeInteger(int64(Version3)), header, encodeOctetString(params), scoped)
+	if key != nil {
+		// The authentication parameters are the last but one field of the
+		// security parameters, which come right before the scoped PDU
+		start := len(request) - len(scoped) - len(privParams) - len(authParams)
+		mac := hmac.New(protocol.hash, key)
+		mac.Write(request)
+		copy(request[start:], mac.Sum(nil)[:protocol.macLen])
+	}
+
+	var reply usmReply
+	var replyErr error
+	_, err := c.exchange(ctx, agent, request, func(message []byte) bool {
+		reply, replyErr = parseV3(message, msgID, key, protocol)
+		return reply.message != nil
+	})
+	if err != nil {
+		return usmReply{}, err
+	}
+	return reply, replyErr
+}
+
+// parseV3 decodes a v3 reply to the message msgID. A reply that is not for
+// that message has no message set. If key is set the reply must be a
+// report or be authenticated with it.
+func parseV3(message []byte, msgID int64, key []byte, protocol authProtocol) (usmReply, error) {
+	parts, err := messageParts(message, Version3, 4)
+	if err != nil || parts[1].tag != tagSequence || parts[2].tag != tagOctetString {
+		return usmReply{}, nil
+	}
+	header, err := parts[1].children(message)
+	if err != nil || len(header) != 4 || len(header[2].content) != 1 {
+		return usmReply{}, nil
+	}
+	if id, err := header[0].integer(); err != nil || id != msgID {
+		return usmReply{}, nil
+	}
+	reply := usmReply{message: message}
+	flags := header[2].content[0]
+	if flags&flagPriv != 0 {
+		return reply, fmt.Errorf("encrypted SNMP replies are not supported")
+	}
+
+	security, _, err := readElement(message, parts[2].offset)
+	if err != nil || security.tag != tagSequence {
+		return reply, fmt.Errorf("malformed USM security parameters")
+	}
+	params, err := security.children(message)
+	if err != nil || len(params) != 6 {
+		return reply, fmt.Errorf("malformed USM security parameters")
+	}
+	reply.engine.id = append([]byte(nil), params[0].content...)
+	reply.engine.boots, _ = params[1].integer()
+	reply.engine.time, _ = params[2].integer()
+	reply.engine.at = time.Now()
+
+	scoped, err := parts[3].children(message)
+	if err != nil || len(scoped) != 3 {
+		return reply, fmt.Errorf("malformed scoped PDU")
+	}
+	reply.pdu = scoped[2]
+
+	if key == nil || (flags&flagAuth == 0 && reply.pdu.tag == tagReport) {
+		return reply, nil
+	}
+	if flags&flagAuth == 0 {
+		return reply, fmt.Errorf("SNMP reply is not authenticated")
+	}
+	digest := params[4]
+	if len(digest.content) != protocol.macLen {
+		return reply, fmt.Errorf("SNMP reply has a bad digest")
+	}
+	signed := append([]byte(nil), message...)
+	clear(signed[digest.offset : digest.offset+len(digest.content)])
+	mac := hmac.New(protocol.hash, key)
+	mac.Write(signed)
+	if !hmac.Equal(mac.Sum(nil)[:protocol.macLen], digest.content) {
+		return reply, fmt.Errorf("SNMP reply has a bad digest")
+	}
+	return reply, nil
+}
+
+// reportReason returns the OID of the first variable of a Report PDU,
+// which names the counter of the error being reported.
+func reportReason(message []byte, pdu element) string {
+	fields, err := pdu.children(message)
+	if err != nil || len(fields) != 4 {
+		return "malformed report"
+	}
+	bindings, err := fields[3].children(message)
+	if err != nil || len(bindings) == 0 {
+		return "empty report"
+	}
+	parts, err := bindings[0].children(message)
+	if err != nil || len(parts) == 0 {
+		return "malformed report"
+	}
+	oid, err := parts[0].oid()
+	if err != nil {
+		return "malformed report"
+	}
+	return oid
+}
@@ -0,0 +1,178 @@
+package snmp
+
+import (
+	"context"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPasswordToKey(t *testing.T) {
+	// RFC 3414, appendix A.3
+	engineID, _ := hex.DecodeString("000000000000000000000002")
+	tests := []struct {
+		protocol       string
+		key, localized string
+	}{
+		{AuthMD5, "9faf3283884e92834ebc9847d8edd963", "526f5eed9fcce26f8964c2930787d82b"},
+		{AuthSHA, "9fb5cc0381497b3793528939ff788d5d79145211", "6695febc9288e36282235fc7151f128497b38f3f"},
+	}
+	for _, test := range tests {
+		protocol := authProtocols[test.protocol]
+		key := passwordToKey(protocol, "maplesyrup")
+		if got := hex.EncodeToString(key); got != test.key {
+			t.Errorf("%s passwordToKey = %s, want %s", test.protocol, got, test.key)
+		}
+		if got := hex.EncodeToString(localizeKey(protocol, key, engineID)); got != test.localized {
+			t.Errorf("%s localizeKey = %s, want %s", test.protocol, got, test.localized)
+		}
+	}
+}
+
+func TestSystemV3(t *testing.T) {
+	agent := newTestAgent(t, func(a *testAgent) {
+		a.addUser("md5user", AuthMD5, "md5password")
+		a.addUser("shauser", AuthSHA, "shapassword")
+		a.addUser("sha256user", AuthSHA256, "sha256password")
+		a.addUser("monitor", AuthNone, "")
+	})
+
+	tests := []struct {
+		name, spec string
+	}{
+		{"md5", "v3:md5user:md5:md5password"},
+		{"sha", "v3:shauser:sha:shapassword"},
+		{"sha256", "v3:sha256user:sha256:sha256password"},
+		{"no authentication", "v3:monitor"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			reports, gets := agent.counts()
+			info, err := newTestClient(t, test.spec).System(ctx, agent.addr)
+			if err != nil {
+				t.Fatalf("System: %v", err)
+			}
+			checkSystemGroup(t, info)
+
+			// One discovery, then the request itself
+			newReports, newGets := agent.counts()
+			if want := append(reports, "1.3.6.1.6.3.15.1.1.4.0"); !slices.Equal(newReports, want) || newGets != gets+1 {
+				t.Errorf("agent sent reports %v and answered %d gets, want %v and %d", newReports, newGets, want, gets+1)
+			}
+		})
+	}
+}
+
+func TestSystemV3TimeWindow(t *testing.T) {
+	// The clock learned in discovery is far behind the engine's, so the
+	// first request is refused and the one after it carries the right time
+	agent := newTestAgent(t, func(a *testAgent) {
+		a.addUser("admin", AuthSHA, "maplesyrup1")
+		a.skew = 1000
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	info, err := newTestClient(t, "v3:admin:sha:maplesyrup1").System(ctx, agent.addr)
+	if err != nil {
+		t.Fatalf("System: %v", err)
+	}
+	checkSystemGroup(t, info)
+	reports, gets := agent.counts()
+	if want := []string{"1.3.6.1.6.3.15.1.1.4.0", usmNotInTimeWindow}; !slices.Equal(reports, want) || gets != 1 {
+		t.Errorf("agent sent reports %v and answered %d gets, want %v and 1", reports, gets, want)
+	}
+}
+
+func TestSystemV3Refused(t *testing.T) {
+	agent := newTestAgent(t, func(a *testAgent) {
+		a.addUser("admin", AuthSHA, "maplesyrup1")
+		a.addUser("monitor", AuthNone, "")
+	})
+
+	tests := []struct {
+		name, spec, want string
+	}{
+		{"wrong password", "v3:admin:sha:maplesyrup2", "wrong digest"},
+		{"wrong protocol", "v3:admin:md5:maplesyrup1", "wrong digest"},
+		{"unknown user", "v3:nobody:sha:maplesyrup1", "unknown user name"},
+		{"no authentication", "v3:admin", "unsupported security level"},
+		{"unexpected authentication", "v3:monitor:sha:maplesyrup1", "unsupported security level"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			info, err := newTestClient(t, test.spec).System(ctx, agent.addr)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("System = %+v, %v; want an error saying %q", info, err, test.want)
+			}
+		})
+	}
+}
+
+func TestSystemV3BadDigest(t *testing.T) {
+	// The agent accepts the request, but its response is not signed with
+	// the user's key
+	agent := newTestAgent(t, func(a *testAgent) {
+		a.addUser("admin", AuthSHA256, "maplesyrup1")
+		a.tamper = true
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	info, err := newTestClient(t, "v3:admin:sha256:maplesyrup1").System(ctx, agent.addr)
+	if err == nil || !strings.Contains(err.Error(), "bad digest") {
+		t.Errorf("System = %+v, %v; want the response rejected for its digest", info, err)
+	}
+	if _, gets := agent.counts(); gets != 1 {
+		t.Errorf("agent answered %d gets, want 1", gets)
+	}
+}
+
+func TestParseV3(t *testing.T) {
+	protocol := authProtocols[AuthSHA]
+	key := localizeKey(protocol, passwordToKey(protocol, "maplesyrup1"), []byte("engine"))
+	agent := &testAgent{engineID: []byte("engine"), boots: 1, clock: 100}
+	user := testUser{protocol: protocol, key: key}
+	pdu := encodeSequence(tagResponse, encodeInteger(7), encodeInteger(0), encodeInteger(0), encodeSequence(tagSequence))
+	message := agent.encodeV3(42, "admin", &user, 100, pdu)
+
+	reply, err := parseV3(message, 42, key, protocol)
+	if err != nil || reply.pdu.tag != tagResponse || string(reply.engine.id) != "engine" || reply.engine.boots != 1 || reply.engine.time != 100 {
+		t.Fatalf("parseV3 = %+v, %v; want the response from engine at 1/100", reply, err)
+	}
+	if reply, _ := parseV3(message, 43, key, protocol); reply.message != nil {
+		t.Error("parseV3 accepted a reply to another message")
+	}
+
+	// Any change to a signed message breaks its digest
+	for _, i := range []int{len(message) - 1, len(message) / 2} {
+		changed := slices.Clone(message)
+		changed[i] ^= 0x01
+		if _, err := parseV3(changed, 42, key, protocol); err == nil {
+			t.Errorf("parseV3 accepted a message changed at byte %d", i)
+		}
+	}
+
+	// Responses must be signed when a key is used, reports need not be
+	unsigned := agent.encodeV3(42, "admin", &testUser{}, 100, pdu)
+	if _, err := parseV3(unsigned, 42, key, protocol); err == nil || !strings.Contains(err.Error(), "not authenticated") {
+		t.Errorf("parseV3 of an unsigned response: %v, want it refused", err)
+	}
+	report := agent.report(42, 7, "1.3.6.1.6.3.15.1.1.3.0", 100, "", &testUser{})
+	if reply, err := parseV3(report, 42, key, protocol); err != nil || reply.pdu.tag != tagReport {
+		t.Errorf("parseV3 of an unsigned report = %+v, %v; want the report", reply, err)
+	}
+
+	// Truncated messages are not taken for replies
+	for n := range len(message) {
+		if reply, err := parseV3(message[:n], 42, key, protocol); reply.message != nil && err == nil {
+			t.Errorf("parseV3 accepted the first %d bytes", n)
+		}
+	}
+}
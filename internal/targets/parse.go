@@ -0,0 +1,268 @@
+package targets
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// maxIncludeDepth stops @file lists that include each other from recursing
+// forever.
+const maxIncludeDepth = 8
+
+// Parse builds a target set from a specification such as
+// "192.168.1.0/24,10.0.0.5-80,nas.lan,@hosts.txt". Entries are separated by
+// commas or whitespace and may be:
+//
+//   - CIDR networks; IPv4 network and broadcast addresses and the IPv6
+//     subnet-router anycast address are skipped
+//   - single addresses, optionally with an IPv6 zone such as fe80::1%eth0
+//   - dash ranges, either per octet (10.0.0.5-80, 10.0.1-3.1-254) or
+//     between two full addresses (10.0.0.5-10.0.0.80, fd00::1-fd00::ff)
+//   - hostnames, which are resolved to all of their addresses
+//   - @file, naming a file of further entries where # starts a comment
+func Parse(spec string) (*Set, error) {
+	b := &builder{}
+	if err := b.add(spec, 0); err != nil {
+		return nil, err
+	}
+	if len(b.ranges) == 0 && len(b.prefixes) == 0 {
+		return nil, fmt.Errorf("no targets in %q", spec)
+	}
+
+	return &Set{
+		ranges:   merge(b.ranges),
+		prefixes: b.prefixes,
+	}, nil
+}
+
+type builder struct {
+	// exclusion keeps networks whole, since excluding the network or
+	// broadcast address of a range is harmless and wide prefixes must
+	// still be matched
+	exclusion bool
+	ranges    []Range
+	prefixes  []netip.Prefix
+}
+
+func (b *builder) add(spec string, depth int) error {
+	entries := strings.FieldsFunc(spec, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+	for _, entry := range entries {
+		if err := b.addEntry(entry, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *builder) addEntry(entry string, depth int) error {
+	if strings.HasPrefix(entry, "@") {
+		return b.addFile(entry[1:], depth)
+	}
+
+	if addr, err := netip.ParseAddr(entry); err == nil {
+		b.addRange(addr.Unmap(), addr.Unmap())
+		return nil
+	}
+
+	switch {
+	case strings.Contains(entry, "/"):
+		return b.addPrefix(entry)
+	case strings.Contains(entry, "-") && looksNumeric(entry):
+		return b.addDashRange(entry)
+	case looksNumeric(entry):
+		return fmt.Errorf("invalid address: %s", entry)
+	}
+
+	return b.addHostname(entry)
+}
+
+func (b *builder) addFile(path string, depth int) error {
+	if depth >= maxIncludeDepth {
+		return fmt.Errorf("target files nested too deeply at %s", path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open target file: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if err := b.add(line, depth+1); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read target file %s: %v", path, err)
+	}
+	return nil
+}
+
+func (b *builder) addPrefix(entry string) error {
+	prefix, err := netip.ParsePrefix(entry)
+	if err != nil {
+		return fmt.Errorf("invalid CIDR: %s", entry)
+	}
+	if prefix.Addr().Is4In6() {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	prefix = prefix.Masked()
+
+	first, last := prefix.Addr(), lastAddr(prefix)
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+
+	switch {
+	case b.exclusion:
+	case prefix.Addr().Is6() && hostBits > maxIPv6HostBits:
+		b.prefixes = append(b.prefixes, prefix)
+		return nil
+	case prefix.Addr().Is6() && hostBits > 0:
+		// IPv6 has no broadcast address; only skip the subnet-router
+		// anycast address at the start of the prefix
+		first = first.Next()
+	case prefix.Addr().Is4() && hostBits > 1:
+		first, last = first.Next(), last.Prev()
+	}
+
+	b.addRange(first, last)
+	return nil
+}
+
+// addDashRange handles both full-address ranges (10.0.0.5-10.0.0.80) and
+// per-octet ranges (10.0.1-3.1-254).
+func (b *builder) addDashRange(entry string) error {
+	if parts := strings.Split(entry, "-"); len(parts) == 2 {
+		first, err1 := netip.ParseAddr(parts[0])
+		last, err2 := netip.ParseAddr(parts[1])
+		if err1 == nil && err2 == nil {
+			first, last = first.Unmap(), last.Unmap()
+			if first.Is4() != last.Is4() || last.Less(first) {
+				return fmt.Errorf("invalid range: %s", entry)
+			}
+			r := Range{First: first, Last: last}
+			if first.Is6() && r.size() > 1<<maxIPv6HostBits && !b.exclusion {
+				return fmt.Errorf("IPv6 range too large to enumerate: %s", entry)
+			}
+			b.addRange(first, last)
+			return nil
+		}
+	}
+
+	octets := strings.Split(entry, ".")
+	if len(octets) != 4 {
+		return fmt.Errorf("invalid range: %s", entry)
+	}
+
+	var low, high [4]int
+	for i, octet := range octets {
+		var err error
+		low[i], high[i], err = parseOctetRange(octet)
+		if err != nil {
+			return fmt.Errorf("invalid range %s: %v", entry, err)
+		}
+	}
+
+	// Every combination of the first three octets contributes one
+	// contiguous run over the last octet
+	for a := low[0]; a <= high[0]; a++ {
+		for bb := low[1]; bb <= high[1]; bb++ {
+			for c := low[2]; c <= high[2]; c++ {
+				first := netip.AddrFrom4([4]byte{byte(a), byte(bb), byte(c), byte(low[3])})
+				last := netip.AddrFrom4([4]byte{byte(a), byte(bb), byte(c), byte(high[3])})
+				b.addRange(first, last)
+			}
+		}
+	}
+	return nil
+}
+
+func (b *builder) addHostname(entry string) error {
+	ips, err := net.LookupIP(entry)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %v", entry, err)
+	}
+	for _, ip := range ips {
+		if addr, ok := netip.AddrFromSlice(ip); ok {
+			addr = addr.Unmap()
+			b.addRange(addr, addr)
+		}
+	}
+	return nil
+}
+
+// addRange appends a range, extending the previous one when they touch so
+// that octet ranges such as 10.0-255.0-255.0-255 stay compact while they
+// are built.
+func (b *builder) addRange(first, last netip.Addr) {
+	r := Range{First: first, Last: last}
+	if n := len(b.ranges); n > 0 && adjoins(b.ranges[n-1], r) && b.ranges[n-1].First.Compare(r.First) <= 0 {
+		if b.ranges[n-1].Last.Less(last) {
+			b.ranges[n-1].Last = last
+		}
+		return
+	}
+	b.ranges = append(b.ranges, r)
+}
+
+func parseOctetRange(s string) (int, int, error) {
+	lowStr, highStr, isRange := strings.Cut(s, "-")
+	low, err := parseOctet(lowStr)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !isRange {
+		return low, low, nil
+	}
+	high, err := parseOctet(highStr)
+	if err != nil {
+		return 0, 0, err
+	}
+	if high < low {
+		return 0, 0, fmt.Errorf("octet range %s is reversed", s)
+	}
+	return low, high, nil
+}
+
+func parseOctet(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > 255 {
+		return 0, fmt.Errorf("invalid octet %q", s)
+	}
+	return n, nil
+}
+
+// looksNumeric tells address ranges apart from hostnames that happen to
+// contain dashes.
+func looksNumeric(entry string) bool {
+	if strings.Contains(entry, ":") {
+		return true
+	}
+	for _, r := range entry {
+		if (r < '0' || r > '9') && r != '.' && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// lastAddr returns the highest address inside prefix.
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	bytes := prefix.Addr().AsSlice()
+	for i := prefix.Bits(); i < len(bytes)*8; i++ {
+		bytes[i/8] |= 0x80 >> (i % 8)
+	}
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
@@ -0,0 +1,373 @@
+package targets
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		len     int
+		ranges  []string
+		in, out []string
+	}{
+		{
+			name:   "single address",
+			spec:   "192.168.1.10",
+			len:    1,
+			ranges: []string{"192.168.1.10-192.168.1.10"},
+		},
+		{
+			name:   "IPv4 network skips network and broadcast addresses",
+			spec:   "192.168.1.0/24",
+			len:    254,
+			ranges: []string{"192.168.1.1-192.168.1.254"},
+			out:    []string{"192.168.1.0", "192.168.1.255"},
+		},
+		{
+			name:   "IPv4 point-to-point network keeps both addresses",
+			spec:   "10.0.0.0/31",
+			len:    2,
+			ranges: []string{"10.0.0.0-10.0.0.1"},
+		},
+		{
+			name:   "IPv4 host route",
+			spec:   "10.0.0.7/32",
+			len:    1,
+			ranges: []string{"10.0.0.7-10.0.0.7"},
+		},
+		{
+			name:   "network is masked",
+			spec:   "10.1.2.3/30",
+			len:    2,
+			ranges: []string{"10.1.2.1-10.1.2.2"},
+		},
+		{
+			name:   "IPv4-mapped network is unmapped",
+			spec:   "::ffff:10.0.0.0/126",
+			len:    2,
+			ranges: []string{"10.0.0.1-10.0.0.2"},
+		},
+		{
+			name:   "last octet range",
+			spec:   "10.0.0.5-80",
+			len:    76,
+			ranges: []string{"10.0.0.5-10.0.0.80"},
+		},
+		{
+			name:   "several octet ranges",
+			spec:   "10.0.1-3.1-254",
+			len:    3 * 254,
+			ranges: []string{"10.0.1.1-10.0.1.254", "10.0.2.1-10.0.2.254", "10.0.3.1-10.0.3.254"},
+			out:    []string{"10.0.2.0", "10.0.2.255", "10.0.4.1"},
+		},
+		{
+			name:   "full octet ranges stay one range",
+			spec:   "10.0-1.0-255.0-255",
+			len:    2 << 16,
+			ranges: []string{"10.0.0.0-10.1.255.255"},
+		},
+		{
+			name:   "full address range",
+			spec:   "10.0.0.250-10.0.1.5",
+			len:    12,
+			ranges: []string{"10.0.0.250-10.0.1.5"},
+		},
+		{
+			name:   "IPv6 address range",
+			spec:   "fd00::1-fd00::ff",
+			len:    255,
+			ranges: []string{"fd00::1-fd00::ff"},
+		},
+		{
+			name:   "IPv6 network skips the subnet-router anycast address",
+			spec:   "fd00::/120",
+			len:    255,
+			ranges: []string{"fd00::1-fd00::ff"},
+			out:    []string{"fd00::"},
+		},
+		{
+			name:   "IPv6 address with zone",
+			spec:   "fe80::1%eth0",
+			len:    1,
+			ranges: []string{"fe80::1%eth0-fe80::1%eth0"},
+			in:     []string{"fe80::1%eth0"},
+			out:    []string{"fe80::1%eth1"},
+		},
+		{
+			name:   "IPv4-mapped address is unmapped",
+			spec:   "::ffff:192.0.2.1",
+			len:    1,
+			ranges: []string{"192.0.2.1-192.0.2.1"},
+		},
+		{
+			name:   "duplicates and overlaps merge",
+			spec:   "10.0.0.1-10,10.0.0.5-20 10.0.0.21\t10.0.0.1",
+			len:    21,
+			ranges: []string{"10.0.0.1-10.0.0.21"},
+		},
+		{
+			name:   "families are kept apart and sorted",
+			spec:   "fd00::1,10.0.0.2,10.0.0.1",
+			len:    3,
+			ranges: []string{"10.0.0.1-10.0.0.2", "fd00::1-fd00::1"},
+		},
+		{
+			name: "wide IPv6 prefix is matched but not enumerated",
+			spec: "fd00::/64,10.0.0.1",
+			len:  1,
+			in:   []string{"fd00::1", "fd00::ffff:ffff:ffff:ffff", "10.0.0.1"},
+			out:  []string{"fd00:0:0:1::1"},
+		},
+		{
+			name:   "IPv6 prefix at the cut-off is enumerated",
+			spec:   "fd00::/112",
+			len:    1<<16 - 1,
+			ranges: []string{"fd00::1-fd00::ffff"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			set, err := Parse(test.spec)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", test.spec, err)
+			}
+			if got := set.Len(); got != test.len {
+				t.Errorf("Len() = %d, want %d", got, test.len)
+			}
+			if test.ranges != nil {
+				if got := formatRanges(set.Ranges()); got != strings.Join(test.ranges, " ") {
+					t.Errorf("Ranges() = %s, want %s", got, strings.Join(test.ranges, " "))
+				}
+			}
+			for _, addr := range test.in {
+				if !set.Contains(netip.MustParseAddr(addr)) {
+					t.Errorf("Contains(%s) = false, want true", addr)
+				}
+			}
+			for _, addr := range test.out {
+				if set.Contains(netip.MustParseAddr(addr)) {
+					t.Errorf("Contains(%s) = true, want false", addr)
+				}
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		want string
+	}{
+		{"empty", "", "no targets"},
+		{"only separators", " , \t", "no targets"},
+		{"bad CIDR", "10.0.0.0/33", "invalid CIDR"},
+		{"bad address", "10.0.0.256", "invalid address"},
+		{"too few octets", "10.0.0", "invalid address"},
+		{"reversed octet range", "10.0.0.80-5", "reversed"},
+		{"octet out of range", "10.0.0.1-300", "invalid octet"},
+		{"octet range on too few octets", "10.0.1-5", "invalid range"},
+		{"reversed address range", "10.0.0.9-10.0.0.1", "invalid range"},
+		{"mixed family range", "10.0.0.1-fd00::1", "invalid range"},
+		{"IPv6 range too large", "fd00::1-fd00::1:1", "too large"},
+		{"missing file", "@" + filepath.Join(t.TempDir(), "missing.txt"), "failed to open target file"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			set, err := Parse(test.spec)
+			if err == nil {
+				t.Fatalf("Parse(%q) = %d targets, want an error", test.spec, set.Len())
+			}
+			if !strings.Contains(err.Error(), test.want) {
+				t.Errorf("Parse(%q) error %q does not mention %q", test.spec, err, test.want)
+			}
+		})
+	}
+}
+
+func TestParseFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	inner := write("inner.txt", "10.0.1.1 # a comment\n# 10.0.1.2\n10.0.1.3,10.0.1.4\n")
+	outer := write("outer.txt", "10.0.0.0/30\n@"+inner+"\n")
+	set, err := Parse("@" + outer + ",10.0.2.1")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := "10.0.0.1-10.0.0.2 10.0.1.1-10.0.1.1 10.0.1.3-10.0.1.4 10.0.2.1-10.0.2.1"
+	if got := formatRanges(set.Ranges()); got != want {
+		t.Errorf("Ranges() = %s, want %s", got, want)
+	}
+
+	// Files that include each other stop at the nesting limit
+	loop := filepath.Join(dir, "loop.txt")
+	write("loop.txt", "10.0.0.1\n@"+loop+"\n")
+	if _, err := Parse("@" + loop); err == nil || !strings.Contains(err.Error(), "nested too deeply") {
+		t.Errorf("Parse of a self-including file: error %v, want nested too deeply", err)
+	}
+
+	bad := write("bad.txt", "10.0.0.1\n10.0.0.999\n")
+	if _, err := Parse("@" + bad); err == nil || !strings.Contains(err.Error(), bad) {
+		t.Errorf("Parse of a file with a bad entry: error %v, want one naming the file", err)
+	}
+}
+
+func TestExclude(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		exclude string
+		len     int
+		ranges  []string
+		in, out []string
+	}{
+		{
+			name:    "single address",
+			spec:    "10.0.0.0/24",
+			exclude: "10.0.0.1",
+			len:     253,
+			ranges:  []string{"10.0.0.2-10.0.0.254"},
+		},
+		{
+			name:    "hole in the middle",
+			spec:    "10.0.0.1-100",
+			exclude: "10.0.0.40-60",
+			len:     79,
+			ranges:  []string{"10.0.0.1-10.0.0.39", "10.0.0.61-10.0.0.100"},
+			in:      []string{"10.0.0.39", "10.0.0.61"},
+			out:     []string{"10.0.0.40", "10.0.0.60"},
+		},
+		{
+			name:    "network is excluded in full",
+			spec:    "10.0.0.0/23",
+			exclude: "10.0.1.0/24",
+			len:     255,
+			ranges:  []string{"10.0.0.1-10.0.0.255"},
+			out:     []string{"10.0.1.0", "10.0.1.1"},
+		},
+		{
+			name:    "everything",
+			spec:    "10.0.0.1-10",
+			exclude: "10.0.0.0/24",
+			len:     0,
+			ranges:  []string{},
+		},
+		{
+			name:    "address outside the set",
+			spec:    "10.0.0.1-10",
+			exclude: "192.168.0.1",
+			len:     10,
+			ranges:  []string{"10.0.0.1-10.0.0.10"},
+		},
+		{
+			name:    "from a wide IPv6 prefix",
+			spec:    "fd00::/64",
+			exclude: "fd00::5,fd00::100-fd00::1ff",
+			len:     0,
+			in:      []string{"fd00::4", "fd00::200"},
+			out:     []string{"fd00::5", "fd00::100", "fd00::1ff"},
+		},
+		{
+			name:    "wide IPv6 exclusion",
+			spec:    "fd00::1-fd00::ff,10.0.0.1",
+			exclude: "fd00::/48",
+			len:     1,
+			ranges:  []string{"10.0.0.1-10.0.0.1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			set, err := Parse(test.spec)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", test.spec, err)
+			}
+			if err := set.Exclude(test.exclude); err != nil {
+				t.Fatalf("Exclude(%q): %v", test.exclude, err)
+			}
+			if got := set.Len(); got != test.len {
+				t.Errorf("Len() = %d, want %d", got, test.len)
+			}
+			if test.ranges != nil {
+				if got := formatRanges(set.Ranges()); got != strings.Join(test.ranges, " ") {
+					t.Errorf("Ranges() = %s, want %s", got, strings.Join(test.ranges, " "))
+				}
+			}
+			count := 0
+			for range set.All() {
+				count++
+			}
+			if count != test.len {
+				t.Errorf("All() yields %d addresses, want %d", count, test.len)
+			}
+			for _, addr := range test.in {
+				if !set.Contains(netip.MustParseAddr(addr)) {
+					t.Errorf("Contains(%s) = false, want true", addr)
+				}
+			}
+			for _, addr := range test.out {
+				if set.Contains(netip.MustParseAddr(addr)) {
+					t.Errorf("Contains(%s) = true, want false", addr)
+				}
+			}
+		})
+	}
+
+	set, _ := Parse("10.0.0.0/24")
+	if err := set.Exclude("10.0.0.1-0"); err == nil {
+		t.Error("Exclude of an invalid range: no error")
+	}
+}
+
+func formatRanges(ranges []Range) string {
+	parts := make([]string, len(ranges))
+	for i, r := range ranges {
+		parts[i] = r.First.String() + "-" + r.Last.String()
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestOverlaps(t *testing.T) {
+	set, err := Parse("10.0.0.5-20, 192.168.1.0/24, fd00::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		prefix string
+		want   bool
+	}{
+		{"10.0.0.0/24", true},
+		{"10.0.0.16/28", true},
+		{"10.0.0.0/30", false},
+		{"10.0.0.24/29", false},
+		{"10.0.0.7/32", true},
+		{"192.168.0.0/16", true},
+		{"192.168.2.0/24", false},
+		// Host bits are ignored
+		{"10.0.0.200/29", false},
+		{"10.0.0.21/29", true},
+		{"fd00::/64", true},
+		{"fd01::/64", false},
+		{"0.0.0.0/0", true},
+	}
+	for _, test := range tests {
+		if got := set.Overlaps(netip.MustParsePrefix(test.prefix)); got != test.want {
+			t.Errorf("Overlaps(%s) = %v, want %v", test.prefix, got, test.want)
+		}
+	}
+}
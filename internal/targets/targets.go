@@ -0,0 +1,216 @@
+package targets
+
+import (
+	"iter"
+	"math"
+	"math/bits"
+	"net/netip"
+	"sort"
+)
+
+// maxIPv6HostBits caps how many addresses an IPv6 prefix may expand to.
+// Anything wider, such as a /64, is left to multicast discovery.
+const maxIPv6HostBits = 16
+
+// Set is a de-duplicated collection of scan targets. Addresses are kept as
+// sorted, merged ranges so that large networks only cost a few bytes.
+type Set struct {
+	ranges   []Range
+	prefixes []netip.Prefix
+	excluded []Range
+}
+
+// Range is an inclusive span of addresses of a single family.
+type Range struct {
+	First netip.Addr
+	Last  netip.Addr
+}
+
+// Len returns the number of addresses in the set's enumerable ranges.
+func (s *Set) Len() int {
+	total := uint64(0)
+	for _, r := range s.ranges {
+		total += r.size()
+		if total > math.MaxInt {
+			return math.MaxInt
+		}
+	}
+	return int(total)
+}
+
+// Ranges returns the enumerable ranges in ascending order.
+func (s *Set) Ranges() []Range {
+	return s.ranges
+}
+
+// Prefixes returns the IPv6 prefixes that are too wide to enumerate.
+// Scanners discover the hosts in them instead of probing every address,
+// and use Contains to drop excluded responders.
+func (s *Set) Prefixes() []netip.Prefix {
+	return s.prefixes
+}
+
+// Contains reports whether addr is one of the targets.
+func (s *Set) Contains(addr netip.Addr) bool {
+	for _, r := range s.ranges {
+		if r.contains(addr) {
+			return true
+		}
+	}
+	for _, prefix := range s.prefixes {
+		if prefix.Contains(addr.WithZone("")) {
+			for _, r := range s.excluded {
+				if r.contains(addr) || r.contains(addr.WithZone("")) {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Overlaps reports whether any address of prefix is in the enumerable
+// ranges.
+func (s *Set) Overlaps(prefix netip.Prefix) bool {
+	prefix = prefix.Masked()
+	first, last := prefix.Addr(), lastAddr(prefix)
+	for _, r := range s.ranges {
+		if r.First.Compare(last) <= 0 && first.Compare(r.Last) <= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// All yields the addresses in the enumerable ranges in ascending order,
+// IPv4 first. Addresses are produced one at a time, so walking even a /8
+// costs no more memory than walking a /24.
+func (s *Set) All() iter.Seq[netip.Addr] {
+	return func(yield func(netip.Addr) bool) {
+		for _, r := range s.ranges {
+			for addr := range r.All() {
+				if !yield(addr) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Exclude removes every address matched by spec, which uses the same
+// syntax as Parse. Networks in spec are excluded in full.
+func (s *Set) Exclude(spec string) error {
+	b := &builder{exclusion: true}
+	if err := b.add(spec, 0); err != nil {
+		return err
+	}
+	excluded := merge(b.ranges)
+
+	var remaining []Range
+	for _, r := range s.ranges {
+		remaining = append(remaining, subtract(r, excluded)...)
+	}
+	s.ranges = remaining
+	s.excluded = merge(append(s.excluded, excluded...))
+	return nil
+}
+
+// All yields the addresses from First to Last.
+func (r Range) All() iter.Seq[netip.Addr] {
+	return func(yield func(netip.Addr) bool) {
+		for addr := r.First; ; addr = addr.Next() {
+			if !yield(addr) || addr == r.Last {
+				return
+			}
+		}
+	}
+}
+
+func (r Range) size() uint64 {
+	first, last := r.First.As16(), r.Last.As16()
+	hi, lo := be128(last)
+	firstHi, firstLo := be128(first)
+
+	lo, borrow := bits.Sub64(lo, firstLo, 0)
+	hi, _ = bits.Sub64(hi, firstHi, borrow)
+	if hi != 0 || lo == math.MaxUint64 {
+		return math.MaxUint64
+	}
+	return lo + 1
+}
+
+func (r Range) contains(addr netip.Addr) bool {
+	return r.First.Compare(addr) <= 0 && addr.Compare(r.Last) <= 0
+}
+
+func be128(b [16]byte) (uint64, uint64) {
+	var hi, lo uint64
+	for i := 0; i < 8; i++ {
+		hi = hi<<8 | uint64(b[i])
+		lo = lo<<8 | uint64(b[i+8])
+	}
+	return hi, lo
+}
+
+// merge sorts ranges and joins the ones that overlap or touch.
+func merge(ranges []Range) []Range {
+	if len(ranges) == 0 {
+		return nil
+	}
+
+	sorted := make([]Range, len(ranges))
+	copy(sorted, ranges)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].First.Less(sorted[j].First)
+	})
+
+	merged := []Range{sorted[0]}
+	for _, r := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if adjoins(*last, r) {
+			if last.Last.Less(r.Last) {
+				last.Last = r.Last
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// adjoins reports whether b, which starts no earlier than a, overlaps a or
+// starts right after it.
+func adjoins(a, b Range) bool {
+	if a.First.Is4() != b.First.Is4() || a.First.Zone() != b.First.Zone() {
+		return false
+	}
+	if b.First.Compare(a.Last) <= 0 {
+		return true
+	}
+	next := a.Last.Next()
+	return next.IsValid() && next == b.First
+}
+
+// subtract returns what is left of r after removing the sorted ranges in
+// excluded.
+func subtract(r Range, excluded []Range) []Range {
+	remaining := []Range{r}
+	for _, ex := range excluded {
+		var next []Range
+		for _, cur := range remaining {
+			if ex.Last.Less(cur.First) || cur.Last.Less(ex.First) {
+				next = append(next, cur)
+				continue
+			}
+			if cur.First.Less(ex.First) {
+				next = append(next, Range{First: cur.First, Last: ex.First.Prev()})
+			}
+			if ex.Last.Less(cur.Last) {
+				next = append(next, Range{First: ex.Last.Next(), Last: cur.Last})
+			}
+		}
+		remaining = next
+	}
+	return remaining
+}
@@ -0,0 +1,93 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/CyberOakAlpha/CrossNet/internal/scanner"
+)
+
+// MonitorRequest starts a continuous probe of one host. Its samples are
+// streamed over /api/scan-progress as "sample" events, and /api/stop-scan
+// ends it with a "complete" event carrying the summary.
+type MonitorRequest struct {
+	Host string `json:"host"`
+	// Port selects TCP connects instead of ICMP echo requests
+	Port     int `json:"port,omitempty"`
+	Count    int `json:"count,omitempty"`
+	Interval int `json:"interval_ms,omitempty"`
+	Timeout  int `json:"timeout_ms,omitempty"`
+	Window   int `json:"window,omitempty"`
+}
+
+func (s *Server) handleMonitor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req MonitorRequest
+	ctx, ok := s.startJob(w, r, jobMonitor, &req)
+	if !ok {
+		return
+	}
+
+	go s.runMonitor(ctx, req)
+}
+
+func (s *Server) runMonitor(ctx context.Context, req MonitorRequest) {
+	defer s.endJob()
+
+	interval := time.Duration(req.Interval) * time.Millisecond
+	if interval <= 0 {
+		interval = time.Second
+	}
+	timeout := time.Duration(req.Timeout) * time.Millisecond
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+
+	monitor := scanner.NewMonitor(timeout, interval)
+	monitor.SetPort(req.Port)
+	monitor.SetWindow(req.Window)
+
+	log.Printf("Starting monitor of %s, port: %d, interval: %v, timeout: %v", req.Host, req.Port, interval, timeout)
+	s.broadcastEvent(ScanEvent{
+		Type:    "progress",
+		Message: fmt.Sprintf("Monitoring %s...", req.Host),
+	})
+
+	summary, err := monitor.Run(ctx, req.Host, req.Count, func(sample scanner.MonitorSample) {
+		s.broadcastEvent(ScanEvent{
+			Type:      "sample",
+			Result:    sample,
+			Completed: sample.Seq,
+			Total:     req.Count,
+		})
+	})
+	if err != nil && ctx.Err() == nil {
+		log.Printf("Monitor error: %v", err)
+		s.broadcastEvent(ScanEvent{
+			Type:  "error",
+			Error: fmt.Sprintf("Monitor failed: %v", err),
+		})
+		return
+	}
+
+	message := fmt.Sprintf("Monitor of %s finished: %d probes sent, %d received, %.1f%% loss",
+		req.Host, summary.Sent, summary.Received, summary.Loss)
+	if summary.Received > 0 {
+		message += fmt.Sprintf(", rtt min/avg/max %v/%v/%v, jitter %v",
+			summary.Min.Round(time.Microsecond), summary.Avg.Round(time.Microsecond),
+			summary.Max.Round(time.Microsecond), summary.Jitter.Round(time.Microsecond))
+	}
+	log.Print(message)
+	s.broadcastEvent(ScanEvent{
+		Type:    "complete",
+		Message: message,
+		Result:  summary,
+	})
+}
@@ -1,51 +1,98 @@
 package web
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"net/netip"
 	"path/filepath"
 	"sync"
 	"time"
 
+	"github.com/CyberOakAlpha/CrossNet/internal/hostname"
 	"github.com/CyberOakAlpha/CrossNet/internal/network"
 	"github.com/CyberOakAlpha/CrossNet/internal/scanner"
+	"github.com/CyberOakAlpha/CrossNet/internal/snmp"
+	"github.com/CyberOakAlpha/CrossNet/internal/targets"
 )
 
 type Server struct {
 	port     int
-	clients  map[chan ScanEvent]bool
+	clients  map[*eventClient]bool
 	mutex    sync.RWMutex
 	scanning bool
+	// monitoring is set while the running job is a monitor, which reports
+	// its summary when stopped instead of failing
+	monitoring bool
+	cancel     context.CancelFunc
 }
 
 type ScanRequest struct {
-	Network  string `json:"network"`
-	ScanType string `json:"scan_type"`
-	Threads  int    `json:"threads"`
-	Timeout  int    `json:"timeout"`
+	Network    string `json:"network"`
+	ScanType   string `json:"scan_type"`
+	Threads    int    `json:"threads"`
+	Timeout    int    `json:"timeout"`
+	Interface  string `json:"interface,omitempty"`
+	Ports      string `json:"ports,omitempty"`
+	ProbePorts string `json:"probe_ports,omitempty"`
+	Exclude    string `json:"exclude,omitempty"`
+	// Rate, Burst and SubnetRate pace the probes as in scanner.RateLimit
+	Rate       float64 `json:"rate,omitempty"`
+	Burst      int     `json:"burst,omitempty"`
+	SubnetRate float64 `json:"subnet_rate,omitempty"`
+	// Timing names the ping timing template. Without one, ping scans use
+	// the normal template starting from Timeout.
+	Timing  string `json:"timing,omitempty"`
+	Retries *int   `json:"retries,omitempty"`
+	// Count is the number of echo requests per host. Above one, ping
+	// results carry loss, jitter and min/avg/max statistics.
+	Count int `json:"count,omitempty"`
+	// Resolve lists the hostname resolution methods in order, as in
+	// hostname.ParseMethods. Empty selects the defaults.
+	Resolve string `json:"resolve,omitempty"`
+	// DNSServer lists servers, comma-separated, that reverse DNS queries
+	// are sent to instead of the system resolver.
+	DNSServer string `json:"dns_server,omitempty"`
+	// DNSTimeout, such as "500ms", is how long each query to those
+	// servers waits, and DNSRetries how often an unanswered one is sent
+	// again. They default to one second and one retry.
+	DNSTimeout string `json:"dns_timeout,omitempty"`
+	DNSRetries *int   `json:"dns_retries,omitempty"`
+	// SNMP holds the credentials, as in snmp.ParseConfig, to query the
+	// SNMP system group of live hosts with. Empty leaves SNMP out.
+	SNMP string `json:"snmp,omitempty"`
 }
 
 type ScanEvent struct {
-	Type     string      `json:"type"`
-	Progress int         `json:"progress,omitempty"`
-	Message  string      `json:"message,omitempty"`
-	Result   interface{} `json:"result,omitempty"`
-	Error    string      `json:"error,omitempty"`
+	Type      string      `json:"type"`
+	Progress  int         `json:"progress,omitempty"`
+	Message   string      `json:"message,omitempty"`
+	Result    interface{} `json:"result,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	Completed int         `json:"completed,omitempty"`
+	Total     int         `json:"total,omitempty"`
+	ETA       int         `json:"eta_seconds,omitempty"`
+	Rate      float64     `json:"rate,omitempty"`
+	// Skipped counts the progress events this client fell too far behind
+	// to be sent since the previous one it received.
+	Skipped int `json:"skipped,omitempty"`
 }
 
 type CurrentIPResponse struct {
-	Success bool   `json:"success"`
-	IP      string `json:"ip,omitempty"`
-	Network string `json:"network,omitempty"`
-	Error   string `json:"error,omitempty"`
+	Success     bool   `json:"success"`
+	IP          string `json:"ip,omitempty"`
+	Network     string `json:"network,omitempty"`
+	IPv6        string `json:"ipv6,omitempty"`
+	IPv6Network string `json:"ipv6_network,omitempty"`
+	Error       string `json:"error,omitempty"`
 }
 
 func NewServer(port int) *Server {
 	return &Server{
 		port:    port,
-		clients: make(map[chan ScanEvent]bool),
+		clients: make(map[*eventClient]bool),
 	}
 }
 
@@ -55,6 +102,8 @@ func (s *Server) Start() error {
 	http.HandleFunc("/api/scan", s.handleScan)
 	http.HandleFunc("/api/scan-progress", s.handleScanProgress)
 	http.HandleFunc("/api/stop-scan", s.handleStopScan)
+	http.HandleFunc("/api/monitor", s.handleMonitor)
+	http.HandleFunc("/api/trace", s.handleTrace)
 
 	// Serve static files with proper MIME types
 	http.HandleFunc("/style.css", s.handleCSS)
@@ -88,7 +137,7 @@ func (s *Server) handleJS(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleCurrentIP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	ip, network, err := network.GetCurrentIP()
+	ip, ipNetwork, err := network.GetCurrentIP()
 	if err != nil {
 		response := CurrentIPResponse{
 			Success: false,
@@ -101,7 +150,11 @@ func (s *Server) handleCurrentIP(w http.ResponseWriter, r *http.Request) {
 	response := CurrentIPResponse{
 		Success: true,
 		IP:      ip,
-		Network: network,
+		Network: ipNetwork,
+	}
+	if ipv6, ipv6Network, err := network.GetCurrentIPv6(); err == nil {
+		response.IPv6 = ipv6
+		response.IPv6Network = ipv6Network
 	}
 	json.NewEncoder(w).Encode(response)
 }
@@ -112,29 +165,69 @@ func (s *Server) handleScan(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var req ScanRequest
+	ctx, ok := s.startJob(w, r, jobScan, &req)
+	if !ok {
+		return
+	}
+
+	go s.runScan(ctx, req)
+}
+
+// jobKind tells startJob what sort of run claims the server.
+type jobKind int
+
+const (
+	jobScan jobKind = iota
+	jobTrace
+	// jobMonitor runs until stopped and reports its summary when it is
+	jobMonitor
+)
+
+// startJob decodes the body of r into req and claims the server for a run
+// of the given kind, which /api/stop-scan cancels through the returned
+// context. It writes the response, and the caller must call endJob once
+// the run has wound down if it returns true.
+func (s *Server) startJob(w http.ResponseWriter, r *http.Request, kind jobKind, req interface{}) (context.Context, bool) {
 	s.mutex.Lock()
 	if s.scanning {
 		s.mutex.Unlock()
 		http.Error(w, "Scan already in progress", http.StatusConflict)
-		return
+		return nil, false
 	}
 	s.scanning = true
+	s.monitoring = kind == jobMonitor
 	s.mutex.Unlock()
 
-	var req ScanRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
 		s.mutex.Lock()
 		s.scanning = false
+		s.monitoring = false
 		s.mutex.Unlock()
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
+		return nil, false
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mutex.Lock()
+	s.cancel = cancel
+	s.mutex.Unlock()
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"status": "started"})
 
-	go s.runScan(req)
+	return ctx, true
+}
+
+// endJob releases the server after a run started with startJob.
+func (s *Server) endJob() {
+	s.mutex.Lock()
+	s.cancel()
+	s.cancel = nil
+	s.scanning = false
+	s.monitoring = false
+	s.mutex.Unlock()
 }
 
 func (s *Server) handleScanProgress(w http.ResponseWriter, r *http.Request) {
@@ -143,22 +236,22 @@ func (s *Server) handleScanProgress(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Connection", "keep-alive")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	clientChan := make(chan ScanEvent, 100)
+	client := newEventClient()
 
 	s.mutex.Lock()
-	s.clients[clientChan] = true
+	s.clients[client] = true
 	s.mutex.Unlock()
 
 	defer func() {
 		s.mutex.Lock()
-		delete(s.clients, clientChan)
+		delete(s.clients, client)
 		s.mutex.Unlock()
-		close(clientChan)
+		client.close()
 	}()
 
 	for {
 		select {
-		case event := <-clientChan:
+		case event := <-client.events:
 			data, _ := json.Marshal(event)
 			fmt.Fprintf(w, "data: %s\n\n", data)
 			w.(http.Flusher).Flush()
@@ -166,6 +259,9 @@ func (s *Server) handleScanProgress(w http.ResponseWriter, r *http.Request) {
 			if event.Type == "complete" || event.Type == "error" {
 				return
 			}
+		case <-client.done:
+			// Cut off for falling behind; the browser sees the stream end
+			return
 		case <-r.Context().Done():
 			return
 		}
@@ -178,38 +274,115 @@ func (s *Server) handleStopScan(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The scan goroutine clears the scanning flag once its probes and
+	// helper processes have actually wound down
 	s.mutex.Lock()
-	s.scanning = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	monitoring := s.monitoring
 	s.mutex.Unlock()
 
-	s.broadcastEvent(ScanEvent{
-		Type:    "error",
-		Message: "Scan stopped by user",
-	})
+	if !monitoring {
+		s.broadcastEvent(ScanEvent{
+			Type:    "error",
+			Message: "Scan stopped by user",
+		})
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "stopped"})
 }
 
-func (s *Server) runScan(req ScanRequest) {
-	defer func() {
-		s.mutex.Lock()
-		s.scanning = false
-		s.mutex.Unlock()
-	}()
+func (s *Server) runScan(ctx context.Context, req ScanRequest) {
+	defer s.endJob()
 
 	timeout := time.Duration(req.Timeout) * time.Second
 
+	set, err := targets.Parse(req.Network)
+	if err == nil && req.Exclude != "" {
+		err = set.Exclude(req.Exclude)
+	}
+	if err != nil {
+		s.broadcastEvent(ScanEvent{
+			Type:  "error",
+			Error: fmt.Sprintf("Invalid targets: %v", err),
+		})
+		return
+	}
+	log.Printf("Scanning targets: %s (exclude: %q)", req.Network, req.Exclude)
+
+	timing, err := pingTiming(req, timeout)
+	if err != nil {
+		s.broadcastEvent(ScanEvent{
+			Type:  "error",
+			Error: fmt.Sprintf("Invalid timing: %v", err),
+		})
+		return
+	}
+
+	methods, err := hostname.ParseMethods(req.Resolve)
+	if err != nil {
+		s.broadcastEvent(ScanEvent{
+			Type:  "error",
+			Error: fmt.Sprintf("Invalid name resolution: %v", err),
+		})
+		return
+	}
+	if req.DNSServer != "" {
+		client, err := dnsClient(req)
+		if err != nil {
+			s.broadcastEvent(ScanEvent{
+				Type:  "error",
+				Error: fmt.Sprintf("Invalid DNS servers: %v", err),
+			})
+			return
+		}
+		methods = hostname.ReplaceMethod(methods, hostname.NewDNSMethod(client))
+		log.Printf("Reverse DNS via %v", client.Servers())
+	}
+	var snmpClient *snmp.Client
+	if req.SNMP != "" {
+		config, err := snmp.ParseConfig(req.SNMP)
+		if err == nil {
+			snmpClient, err = snmp.NewClient(config)
+		}
+		if err != nil {
+			s.broadcastEvent(ScanEvent{
+				Type:  "error",
+				Error: fmt.Sprintf("Invalid SNMP credentials: %v", err),
+			})
+			return
+		}
+		log.Printf("SNMP: %s", config)
+	}
+
+	limit := scanner.RateLimit{
+		Rate:       req.Rate,
+		Burst:      req.Burst,
+		SubnetRate: req.SubnetRate,
+	}
+	if limit.Rate > 0 || limit.SubnetRate > 0 {
+		log.Printf("Rate limit: %g probes/s (burst %d), %g probes/s per subnet", limit.Rate, limit.Burst, limit.SubnetRate)
+	}
+
+	// Every result is merged into the host it concerns, and the merged
+	// host is sent to the clients in a "host" event
+	hosts := scanner.NewHostTable()
 	switch req.ScanType {
 	case "ping":
-		s.runPingScan(req.Network, timeout, req.Threads)
+		s.runPingScan(ctx, hosts, set, timing, req.Count, req.Threads, req.Interface, limit, methods, snmpClient, false)
 	case "arp":
-		s.runARPScan(req.Network, req.Threads)
+		s.runARPScan(ctx, hosts, set, req.Threads, req.Interface, limit, methods, snmpClient)
 	case "both":
-		s.runPingScan(req.Network, timeout, req.Threads)
-		if s.isScanning() {
-			s.runARPScan(req.Network, req.Threads)
-		}
+		// One pass: the MACs are read from the neighbour cache the pings fill
+		s.runPingScan(ctx, hosts, set, timing, req.Count, req.Threads, req.Interface, limit, methods, snmpClient, true)
+	case "ports":
+		s.runPortScan(ctx, hosts, set, req.Ports, timeout, req.Threads, limit)
+	case "tcp":
+		s.runTCPScan(ctx, hosts, set, req.ProbePorts, timeout, req.Threads, limit, methods, snmpClient)
+	case "ssdp":
+		s.runSSDPScan(ctx, hosts, set, timeout, req.Interface, methods, snmpClient)
 	default:
 		s.broadcastEvent(ScanEvent{
 			Type:  "error",
@@ -218,22 +391,84 @@ func (s *Server) runScan(req ScanRequest) {
 		return
 	}
 
+	if ctx.Err() != nil {
+		return
+	}
+
 	s.broadcastEvent(ScanEvent{
 		Type:    "complete",
 		Message: "Scan completed",
+		Result:  hosts.Hosts(),
+	})
+}
+
+// broadcastHost sends the merged state of a host to the clients.
+func (s *Server) broadcastHost(host scanner.Host) {
+	s.broadcastEvent(ScanEvent{
+		Type:   "host",
+		Result: host,
 	})
 }
 
-func (s *Server) runPingScan(network string, timeout time.Duration, threads int) {
-	log.Printf("Starting ping scan on network: %s, timeout: %v, threads: %d", network, timeout, threads)
+// pingInterval spaces the echo requests to a host when Count is above one.
+const pingInterval = 200 * time.Millisecond
+
+// pingTiming returns the timing template requested for ping scans.
+func pingTiming(req ScanRequest, timeout time.Duration) (scanner.Timing, error) {
+	timing := scanner.TimingNormal.WithTimeout(timeout)
+	if req.Timing != "" {
+		var err error
+		timing, err = scanner.ParseTiming(req.Timing)
+		if err != nil {
+			return timing, err
+		}
+	}
+	if req.Retries != nil && *req.Retries >= 0 {
+		timing.Retries = *req.Retries
+	}
+	return timing, nil
+}
+
+// dnsClient returns the client for the DNS servers of a request, with the
+// query timeout and retries it asks for.
+func dnsClient(req ScanRequest) (*hostname.DNSClient, error) {
+	client, err := hostname.ParseDNSServers(req.DNSServer)
+	if err != nil {
+		return nil, err
+	}
+	if req.DNSTimeout != "" {
+		timeout, err := time.ParseDuration(req.DNSTimeout)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid query timeout %q", req.DNSTimeout)
+		}
+		client.SetTimeout(timeout)
+	}
+	if req.DNSRetries != nil && *req.DNSRetries >= 0 {
+		client.SetRetries(*req.DNSRetries)
+	}
+	return client, nil
+}
+
+func (s *Server) runPingScan(ctx context.Context, hosts *scanner.HostTable, set *targets.Set, timing scanner.Timing, count, threads int, iface string, limit scanner.RateLimit, methods []hostname.MethodConfig, snmpClient *snmp.Client, neighbors bool) {
+	log.Printf("Starting ping scan on %d targets, timing: %s, retries: %d, threads: %d", set.Len(), timing.Name, timing.Retries, threads)
 	s.broadcastEvent(ScanEvent{
 		Type:     "progress",
 		Progress: 0,
 		Message:  "Starting ping scan...",
 	})
 
-	pingScanner := scanner.NewPingScanner(timeout, threads)
-	results, err := pingScanner.ScanRange(network)
+	pingScanner := scanner.NewPingScanner(timing.InitialTimeout, threads)
+	pingScanner.SetTiming(timing)
+	pingScanner.SetInterface(iface)
+	pingScanner.SetRateLimit(limit)
+	pingScanner.SetQuality(count, pingInterval)
+
+	reporter := s.newProgressReporter("Ping scan", 0, 100)
+	aliveCount, total, err := s.runPipeline(ctx, hosts, set, pingScanner, methods, snmpClient, neighbors, reporter)
+	if ctx.Err() != nil {
+		log.Printf("Ping scan cancelled after finding %d alive hosts", aliveCount)
+		return
+	}
 	if err != nil {
 		log.Printf("Ping scan error: %v", err)
 		s.broadcastEvent(ScanEvent{
@@ -243,40 +478,88 @@ func (s *Server) runPingScan(network string, timeout time.Duration, threads int)
 		return
 	}
 
-	log.Printf("Ping scan completed, got %d total results", len(results))
+	log.Printf("Ping scan finished: found %d alive hosts out of %d total", aliveCount, total)
+	reporter.finish()
+}
 
-	processed := 0
-	total := len(results)
+// runPipeline probes every target once with discoverer, broadcasting each
+// host as the stages of the pipeline merge evidence into it. It returns the
+// number of hosts that answered and the number of targets.
+func (s *Server) runPipeline(ctx context.Context, hosts *scanner.HostTable, set *targets.Set, discoverer scanner.Discoverer, methods []hostname.MethodConfig, snmpClient *snmp.Client, neighbors bool, reporter *progressReporter) (int, int, error) {
+	pipeline := scanner.NewPipeline(discoverer, hosts)
+	pipeline.SetNeighbors(neighbors)
+	pipeline.SetResolveMethods(methods)
+	pipeline.SetSNMP(snmpClient)
 
 	aliveCount := 0
-	for _, result := range results {
-		if !s.isScanning() {
+	total := 0
+	err := pipeline.Run(ctx, set, func(update scanner.PipelineUpdate) {
+		total = update.Progress.Total
+		if update.Host == nil {
+			reporter.update(update.Progress)
 			return
 		}
-
-		if result.Alive {
+		host := *update.Host
+		switch update.Stage {
+		case scanner.StageDiscovery:
 			aliveCount++
-			log.Printf("Found alive host: %s (hostname: %s, rtt: %v)", result.IP, result.Hostname, result.RTT)
+			log.Printf("Found alive host: %s (rtt: %v)", host.IP, host.RTT)
+			reporter.update(update.Progress)
+		case scanner.StageNeighbors:
+			log.Printf("Found neighbour: %s (mac: %s, vendor: %s)", host.IP, host.MAC, host.Vendor)
+		case scanner.StageEnrichment:
+			log.Printf("Resolved %s to %s", host.IP, host.Hostname())
+		}
+		s.broadcastHost(host)
+	})
+	return aliveCount, total, err
+}
+
+func (s *Server) runTCPScan(ctx context.Context, hosts *scanner.HostTable, set *targets.Set, probeSpec string, timeout time.Duration, threads int, limit scanner.RateLimit, methods []hostname.MethodConfig, snmpClient *snmp.Client) {
+	log.Printf("Starting TCP discovery on %d targets, probe ports: %s, timeout: %v, threads: %d", set.Len(), probeSpec, timeout, threads)
+	s.broadcastEvent(ScanEvent{
+		Type:     "progress",
+		Progress: 0,
+		Message:  "Starting TCP discovery...",
+	})
+
+	var probePorts []int
+	if probeSpec != "" {
+		var err error
+		probePorts, err = scanner.ParsePorts(probeSpec)
+		if err != nil {
 			s.broadcastEvent(ScanEvent{
-				Type:   "result",
-				Result: result,
+				Type:  "error",
+				Error: fmt.Sprintf("Invalid probe ports: %v", err),
 			})
+			return
 		}
+	}
+
+	tcpScanner := scanner.NewTCPDiscoveryScanner(timeout, threads, probePorts)
+	tcpScanner.SetRateLimit(limit)
 
-		processed++
-		progress := (processed * 100) / total
+	reporter := s.newProgressReporter("TCP discovery", 0, 100)
+	aliveCount, total, err := s.runPipeline(ctx, hosts, set, tcpScanner, methods, snmpClient, false, reporter)
+	if ctx.Err() != nil {
+		log.Printf("TCP discovery cancelled after finding %d alive hosts", aliveCount)
+		return
+	}
+	if err != nil {
+		log.Printf("TCP discovery error: %v", err)
 		s.broadcastEvent(ScanEvent{
-			Type:     "progress",
-			Progress: progress,
-			Message:  fmt.Sprintf("Ping scan progress: %d/%d", processed, total),
+			Type:  "error",
+			Error: fmt.Sprintf("TCP discovery failed: %v", err),
 		})
+		return
 	}
 
-	log.Printf("Ping scan finished: found %d alive hosts out of %d total", aliveCount, total)
+	log.Printf("TCP discovery finished: found %d alive hosts out of %d total", aliveCount, total)
+	reporter.finish()
 }
 
-func (s *Server) runARPScan(network string, threads int) {
-	log.Printf("Starting ARP scan on network: %s, threads: %d", network, threads)
+func (s *Server) runARPScan(ctx context.Context, hosts *scanner.HostTable, set *targets.Set, threads int, iface string, limit scanner.RateLimit, methods []hostname.MethodConfig, snmpClient *snmp.Client) {
+	log.Printf("Starting ARP scan on %d targets, threads: %d", set.Len(), threads)
 	s.broadcastEvent(ScanEvent{
 		Type:     "progress",
 		Progress: 0,
@@ -284,23 +567,36 @@ func (s *Server) runARPScan(network string, threads int) {
 	})
 
 	arpScanner := scanner.NewARPScanner(threads)
+	arpScanner.SetInterface(iface)
+	arpScanner.SetRateLimit(limit)
+
+	// Names are resolved in the background and follow as further updates
+	enricher := scanner.NewEnricher(hosts)
+	enricher.SetMethods(methods)
+	enricher.SetSNMP(snmpClient)
+	enricher.Start(ctx, func(host scanner.Host) {
+		log.Printf("Resolved %s to %s", host.IP, host.Hostname())
+		s.broadcastHost(host)
+	})
+	defer enricher.Wait()
 
 	log.Printf("Getting ARP table...")
-	arpEntries, err := arpScanner.GetARPTable()
+	arpEntries, err := arpScanner.GetARPTableContext(ctx)
 	if err != nil {
 		log.Printf("ARP table error: %v", err)
 	} else {
 		log.Printf("Found %d entries in ARP table", len(arpEntries))
 		for _, entry := range arpEntries {
-			if !s.isScanning() {
+			if ctx.Err() != nil {
 				return
 			}
+			if addr, err := netip.ParseAddr(entry.IP); err != nil || !set.Contains(addr) {
+				continue
+			}
 
-			log.Printf("Found ARP entry: %s -> %s (hostname: %s)", entry.IP, entry.MAC, entry.Hostname)
-			s.broadcastEvent(ScanEvent{
-				Type:   "result",
-				Result: entry,
-			})
+			// Only the sweep below proves the host alive and worth enriching
+			log.Printf("Found ARP entry: %s -> %s (vendor: %s)", entry.IP, entry.MAC, entry.Vendor)
+			s.broadcastHost(hosts.AddARP(entry, true))
 		}
 	}
 
@@ -310,7 +606,22 @@ func (s *Server) runARPScan(network string, threads int) {
 		Message:  "Scanning network for active devices...",
 	})
 
-	networkEntries, err := arpScanner.ScanNetwork(network)
+	// The network sweep fills the second half of the progress bar
+	reporter := s.newProgressReporter("ARP scan", 50, 50)
+	found := 0
+	err = arpScanner.StreamTargets(ctx, set, func(entry scanner.ARPEntry, progress scanner.Progress) {
+		if entry.MAC != "" {
+			found++
+			host := hosts.AddARP(entry, false)
+			s.broadcastHost(host)
+			enricher.Add(host)
+		}
+		reporter.update(progress)
+	})
+	if ctx.Err() != nil {
+		log.Printf("ARP scan cancelled after finding %d devices", found)
+		return
+	}
 	if err != nil {
 		s.broadcastEvent(ScanEvent{
 			Type:  "error",
@@ -319,38 +630,247 @@ func (s *Server) runARPScan(network string, threads int) {
 		return
 	}
 
-	for _, entry := range networkEntries {
-		if !s.isScanning() {
-			return
-		}
+	reporter.finish()
+}
 
+func (s *Server) runSSDPScan(ctx context.Context, hosts *scanner.HostTable, set *targets.Set, timeout time.Duration, iface string, methods []hostname.MethodConfig, snmpClient *snmp.Client) {
+	log.Printf("Starting SSDP discovery for %d targets", set.Len())
+	s.broadcastEvent(ScanEvent{
+		Type:     "progress",
+		Progress: 0,
+		Message:  "Searching for UPnP devices...",
+	})
+
+	ssdpScanner := scanner.NewSSDPScanner(timeout)
+	ssdpScanner.SetInterface(iface)
+
+	enricher := scanner.NewEnricher(hosts)
+	enricher.SetMethods(methods)
+	enricher.SetSNMP(snmpClient)
+	enricher.Start(ctx, func(host scanner.Host) {
+		log.Printf("Resolved %s to %s", host.IP, host.Hostname())
+		s.broadcastHost(host)
+	})
+	defer enricher.Wait()
+
+	devices := 0
+	err := ssdpScanner.StreamTargets(ctx, set, func(result scanner.SSDPResult) {
+		devices++
+		if result.Err != nil {
+			log.Printf("Found UPnP device %s at %s: %v", result.IP, result.Device.Location, result.Err)
+		} else {
+			log.Printf("Found UPnP device %s: %s (%s %s)", result.IP, result.Device.FriendlyName, result.Device.Manufacturer, result.Device.Model())
+		}
+		host := hosts.AddSSDP(result)
+		s.broadcastHost(host)
+		enricher.Add(host)
+	})
+	if ctx.Err() != nil {
+		log.Printf("SSDP discovery cancelled after finding %d devices", devices)
+		return
+	}
+	if err != nil {
 		s.broadcastEvent(ScanEvent{
-			Type:   "result",
-			Result: entry,
+			Type:  "error",
+			Error: fmt.Sprintf("SSDP discovery failed: %v", err),
 		})
+		return
 	}
 
+	log.Printf("SSDP discovery finished: found %d UPnP devices", devices)
+}
+
+func (s *Server) runPortScan(ctx context.Context, hosts *scanner.HostTable, set *targets.Set, portSpec string, timeout time.Duration, threads int, limit scanner.RateLimit) {
+	if portSpec == "" {
+		portSpec = "top"
+	}
+	log.Printf("Starting port scan on %d targets, ports: %s, timeout: %v, threads: %d", set.Len(), portSpec, timeout, threads)
 	s.broadcastEvent(ScanEvent{
 		Type:     "progress",
-		Progress: 100,
-		Message:  "ARP scan completed",
+		Progress: 0,
+		Message:  "Starting port scan...",
+	})
+
+	ports, err := scanner.ParsePorts(portSpec)
+	if err != nil {
+		s.broadcastEvent(ScanEvent{
+			Type:  "error",
+			Error: fmt.Sprintf("Invalid ports: %v", err),
+		})
+		return
+	}
+
+	portScanner := scanner.NewPortScanner(timeout, threads)
+	portScanner.SetRateLimit(limit)
+
+	reporter := s.newProgressReporter("Port scan", 0, 100)
+	openCount := 0
+	total := 0
+	err = portScanner.StreamTargets(ctx, set, ports, func(result scanner.PortResult, progress scanner.Progress) {
+		total = progress.Total
+		if result.State == scanner.PortOpen {
+			openCount++
+			s.broadcastHost(hosts.AddPort(result))
+		}
+		reporter.update(progress)
+	})
+	if ctx.Err() != nil {
+		log.Printf("Port scan cancelled after finding %d open ports", openCount)
+		return
+	}
+	if err != nil {
+		log.Printf("Port scan error: %v", err)
+		s.broadcastEvent(ScanEvent{
+			Type:  "error",
+			Error: fmt.Sprintf("Port scan failed: %v", err),
+		})
+		return
+	}
+
+	log.Printf("Port scan finished: found %d open ports out of %d probed", openCount, total)
+	reporter.finish()
+}
+
+// progressReporter turns scanner progress into SSE progress events. It maps
+// the scan onto span percent of the progress bar starting at base, and only
+// sends an event when the percentage moves so large scans do not flood the
+// clients.
+type progressReporter struct {
+	server   *Server
+	label    string
+	base     int
+	span     int
+	last     int
+	progress scanner.Progress
+}
+
+func (s *Server) newProgressReporter(label string, base, span int) *progressReporter {
+	return &progressReporter{
+		server: s,
+		label:  label,
+		base:   base,
+		span:   span,
+		last:   -1,
+	}
+}
+
+func (pr *progressReporter) update(progress scanner.Progress) {
+	pr.progress = progress
+	if progress.Total == 0 {
+		return
+	}
+	percent := pr.base + progress.Completed*pr.span/progress.Total
+	if percent == pr.last && progress.Completed < progress.Total {
+		return
+	}
+	pr.last = percent
+
+	eta := progress.ETA().Round(time.Second)
+	message := fmt.Sprintf("%s progress: %d/%d", pr.label, progress.Completed, progress.Total)
+	if eta > 0 {
+		message += fmt.Sprintf(", about %v remaining", eta)
+	}
+
+	pr.server.broadcastEvent(ScanEvent{
+		Type:      "progress",
+		Progress:  percent,
+		Message:   message,
+		Completed: progress.Completed,
+		Total:     progress.Total,
+		ETA:       int(eta.Seconds()),
+	})
+}
+
+// finish reports the end of the scan together with the probe rate it
+// actually achieved.
+func (pr *progressReporter) finish() {
+	message := fmt.Sprintf("%s completed", pr.label)
+	if probes := pr.progress.Probes; probes > 0 {
+		message += fmt.Sprintf(": %d probes in %v, %.1f probes/s", probes, pr.progress.Elapsed.Round(time.Millisecond), pr.progress.Rate())
+	}
+
+	pr.server.broadcastEvent(ScanEvent{
+		Type:     "progress",
+		Progress: pr.base + pr.span,
+		Message:  message,
+		Rate:     pr.progress.Rate(),
 	})
 }
 
 func (s *Server) broadcastEvent(event ScanEvent) {
 	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-
+	clients := make([]*eventClient, 0, len(s.clients))
 	for client := range s.clients {
+		clients = append(clients, client)
+	}
+	s.mutex.RUnlock()
+
+	for _, client := range clients {
+		client.send(event)
+	}
+}
+
+// eventBuffer is how many events a client may lag behind before progress
+// events are skipped.
+const eventBuffer = 100
+
+// eventTimeout is how long any other event waits for room before the
+// client is disconnected.
+var eventTimeout = 5 * time.Second
+
+// eventClient is a browser following /api/scan-progress.
+type eventClient struct {
+	events chan ScanEvent
+	// done is closed when the client goes away or is cut off
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// mutex keeps the events of concurrent broadcasts in order
+	mutex   sync.Mutex
+	skipped int
+}
+
+func newEventClient() *eventClient {
+	return &eventClient{
+		events: make(chan ScanEvent, eventBuffer),
+		done:   make(chan struct{}),
+	}
+}
+
+// send hands event to the client without letting a slow browser stall the
+// scan indefinitely. Each progress event supersedes the one before, so one
+// that finds the buffer full is skipped and counted in the next that gets
+// through. Hosts, samples, hops and the final "complete" or "error" event
+// cannot be lost without the page going wrong, so they wait up to
+// eventTimeout, after which the client is disconnected. Its page then
+// leaves the running state instead of waiting for an end that never comes.
+func (c *eventClient) send(event ScanEvent) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if event.Type == "progress" {
+		event.Skipped = c.skipped
 		select {
-		case client <- event:
+		case c.events <- event:
+			c.skipped = 0
+		case <-c.done:
 		default:
+			c.skipped++
 		}
+		return
+	}
+
+	timer := time.NewTimer(eventTimeout)
+	defer timer.Stop()
+	select {
+	case c.events <- event:
+	case <-c.done:
+	case <-timer.C:
+		log.Printf("Disconnecting event stream client that fell behind on %q event", event.Type)
+		c.close()
 	}
 }
 
-func (s *Server) isScanning() bool {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	return s.scanning
-}
\ No newline at end of file
+func (c *eventClient) close() {
+	c.closeOnce.Do(func() { close(c.done) })
+}
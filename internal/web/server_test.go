@@ -0,0 +1,110 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventClientSkipsProgress(t *testing.T) {
+	client := newEventClient()
+	for i := range eventBuffer + 3 {
+		client.send(ScanEvent{Type: "progress", Completed: i})
+	}
+	for range eventBuffer {
+		<-client.events
+	}
+
+	// The next progress event through counts the ones that found no room
+	client.send(ScanEvent{Type: "progress", Completed: eventBuffer + 3})
+	event := <-client.events
+	if event.Skipped != 3 || event.Completed != eventBuffer+3 {
+		t.Errorf("got progress %d with %d skipped, want %d with 3", event.Completed, event.Skipped, eventBuffer+3)
+	}
+	client.send(ScanEvent{Type: "progress"})
+	if event := <-client.events; event.Skipped != 0 {
+		t.Errorf("skipped count not reset: %d", event.Skipped)
+	}
+}
+
+func TestEventClientDisconnectsWhenBehind(t *testing.T) {
+	defer func(timeout time.Duration) { eventTimeout = timeout }(eventTimeout)
+	eventTimeout = time.Minute
+
+	client := newEventClient()
+	for range eventBuffer {
+		client.send(ScanEvent{Type: "host"})
+	}
+
+	// A final event is never dropped silently: it waits for room, and a
+	// client that makes none is cut off
+	delivered := make(chan struct{})
+	go func() {
+		client.send(ScanEvent{Type: "complete"})
+		close(delivered)
+	}()
+	<-client.events
+	<-delivered
+	select {
+	case <-client.done:
+		t.Fatal("client disconnected although it made room")
+	default:
+	}
+
+	eventTimeout = 10 * time.Millisecond
+	client.send(ScanEvent{Type: "error"})
+	select {
+	case <-client.done:
+	default:
+		t.Fatal("client still connected after falling behind")
+	}
+
+	// Later events to a disconnected client return at once
+	start := time.Now()
+	client.send(ScanEvent{Type: "host"})
+	if elapsed := time.Since(start); elapsed >= eventTimeout {
+		t.Errorf("send to a disconnected client took %v", elapsed)
+	}
+}
+
+func TestStopRightAfterStart(t *testing.T) {
+	tests := []struct {
+		kind      jobKind
+		wantError bool
+	}{
+		{jobScan, true},
+		{jobTrace, true},
+		{jobMonitor, false},
+	}
+	for _, test := range tests {
+		s := NewServer(0)
+		client := newEventClient()
+		s.clients[client] = true
+
+		// Stop arrives before the handler has started the job goroutine
+		start := httptest.NewRequest(http.MethodPost, "/api/monitor", strings.NewReader(`{"host":"10.0.0.1"}`))
+		var req MonitorRequest
+		ctx, ok := s.startJob(httptest.NewRecorder(), start, test.kind, &req)
+		if !ok {
+			t.Fatalf("startJob of kind %d failed", test.kind)
+		}
+		s.handleStopScan(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/stop-scan", nil))
+		if ctx.Err() == nil {
+			t.Errorf("kind %d: job not cancelled", test.kind)
+		}
+
+		select {
+		case event := <-client.events:
+			if !test.wantError || event.Type != "error" {
+				t.Errorf("kind %d: got %q event on stop", test.kind, event.Type)
+			}
+		default:
+			if test.wantError {
+				t.Errorf("kind %d: no error event on stop", test.kind)
+			}
+		}
+		s.endJob()
+	}
+}
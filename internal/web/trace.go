@@ -0,0 +1,120 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/CyberOakAlpha/CrossNet/internal/hostname"
+	"github.com/CyberOakAlpha/CrossNet/internal/scanner"
+)
+
+// TraceRequest follows the path to one host. Each hop is streamed over
+// /api/scan-progress as a "hop" event, and the "complete" event carries
+// the whole path so that it can be stored with scan results.
+type TraceRequest struct {
+	Host     string `json:"host"`
+	Protocol string `json:"protocol,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	MaxHops  int    `json:"max_hops,omitempty"`
+	Queries  int    `json:"queries,omitempty"`
+	Timeout  int    `json:"timeout_ms,omitempty"`
+	// Resolve lists the methods the hops are named with, as in
+	// hostname.ParseMethods. Empty uses reverse DNS only.
+	Resolve string `json:"resolve,omitempty"`
+}
+
+func (s *Server) handleTrace(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req TraceRequest
+	ctx, ok := s.startJob(w, r, jobTrace, &req)
+	if !ok {
+		return
+	}
+
+	go s.runTrace(ctx, req)
+}
+
+func (s *Server) runTrace(ctx context.Context, req TraceRequest) {
+	defer s.endJob()
+
+	protocol := scanner.TraceICMP
+	if req.Protocol != "" {
+		var err error
+		protocol, err = scanner.ParseTraceProtocol(req.Protocol)
+		if err != nil {
+			s.broadcastEvent(ScanEvent{
+				Type:  "error",
+				Error: fmt.Sprintf("Invalid trace protocol: %v", err),
+			})
+			return
+		}
+	}
+	maxHops := req.MaxHops
+	if maxHops <= 0 {
+		maxHops = 30
+	}
+	timeout := time.Duration(req.Timeout) * time.Millisecond
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+
+	tracer := scanner.NewTracer(timeout, maxHops)
+	tracer.SetProtocol(protocol, req.Port)
+	tracer.SetProbes(req.Queries)
+	if req.Resolve != "" {
+		methods, err := hostname.ParseMethods(req.Resolve)
+		if err != nil {
+			s.broadcastEvent(ScanEvent{
+				Type:  "error",
+				Error: fmt.Sprintf("Invalid name resolution: %v", err),
+			})
+			return
+		}
+		tracer.SetResolveMethods(methods)
+	}
+
+	log.Printf("Starting trace to %s over %s, max hops: %d", req.Host, protocol, maxHops)
+	s.broadcastEvent(ScanEvent{
+		Type:    "progress",
+		Message: fmt.Sprintf("Tracing route to %s...", req.Host),
+	})
+
+	trace, err := tracer.Trace(ctx, req.Host, func(hop scanner.TraceHop) {
+		s.broadcastEvent(ScanEvent{
+			Type:      "hop",
+			Result:    hop,
+			Completed: hop.TTL,
+			Total:     maxHops,
+		})
+	})
+	if ctx.Err() != nil {
+		log.Printf("Trace to %s cancelled", req.Host)
+		return
+	}
+	if err != nil {
+		log.Printf("Trace error: %v", err)
+		s.broadcastEvent(ScanEvent{
+			Type:  "error",
+			Error: fmt.Sprintf("Trace failed: %v", err),
+		})
+		return
+	}
+
+	message := fmt.Sprintf("Reached %s in %d hops", trace.IP, len(trace.Hops))
+	if !trace.Reached {
+		message = fmt.Sprintf("%s was not reached within %d hops", trace.IP, len(trace.Hops))
+	}
+	log.Print(message)
+	s.broadcastEvent(ScanEvent{
+		Type:    "complete",
+		Message: message,
+		Result:  trace,
+	})
+}
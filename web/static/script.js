@@ -1,7 +1,9 @@
 class CrossNetGUI {
     constructor() {
         this.isScanning = false;
+        this.isMonitoring = false;
         this.scanResults = [];
+        this.traces = [];
         this.eventSource = null;
 
         this.initializeElements();
@@ -15,9 +17,21 @@ class CrossNetGUI {
             getCurrentIPBtn: document.getElementById('get-ip-btn'),
             currentIPInput: document.getElementById('current-ip'),
             networkInput: document.getElementById('network'),
+            excludeInput: document.getElementById('exclude'),
             scanTypeSelect: document.getElementById('scan-type'),
             threadsInput: document.getElementById('threads'),
             timeoutInput: document.getElementById('timeout'),
+            timingSelect: document.getElementById('timing'),
+            rateInput: document.getElementById('rate'),
+            burstInput: document.getElementById('burst'),
+            subnetRateInput: document.getElementById('subnet-rate'),
+            countInput: document.getElementById('count'),
+            resolveInput: document.getElementById('resolve'),
+            dnsServerInput: document.getElementById('dns-server'),
+            dnsTimeoutInput: document.getElementById('dns-timeout'),
+            dnsRetriesInput: document.getElementById('dns-retries'),
+            snmpInput: document.getElementById('snmp'),
+            portsInput: document.getElementById('ports'),
             scanBtn: document.getElementById('scan-btn'),
             stopBtn: document.getElementById('stop-btn'),
             clearBtn: document.getElementById('clear-btn'),
@@ -30,7 +44,23 @@ class CrossNetGUI {
             resultsTable: document.getElementById('results-table'),
             resultsBody: document.getElementById('results-body'),
             exportCSV: document.getElementById('export-csv'),
-            exportJSON: document.getElementById('export-json')
+            exportJSON: document.getElementById('export-json'),
+            monitorHostInput: document.getElementById('monitor-host'),
+            monitorPortInput: document.getElementById('monitor-port'),
+            monitorIntervalInput: document.getElementById('monitor-interval'),
+            monitorBtn: document.getElementById('monitor-btn'),
+            monitorStopBtn: document.getElementById('monitor-stop-btn'),
+            monitorStatus: document.getElementById('monitor-status'),
+            monitorTable: document.getElementById('monitor-table'),
+            monitorBody: document.getElementById('monitor-body'),
+            traceHostInput: document.getElementById('trace-host'),
+            traceProtocolSelect: document.getElementById('trace-protocol'),
+            tracePortInput: document.getElementById('trace-port'),
+            traceResolveInput: document.getElementById('trace-resolve'),
+            traceBtn: document.getElementById('trace-btn'),
+            traceStatus: document.getElementById('trace-status'),
+            traceTable: document.getElementById('trace-table'),
+            traceBody: document.getElementById('trace-body')
         };
 
         // Check if critical elements exist
@@ -57,6 +87,9 @@ class CrossNetGUI {
         this.elements.clearBtn.addEventListener('click', () => this.clearResults());
         this.elements.exportCSV.addEventListener('click', () => this.exportResults('csv'));
         this.elements.exportJSON.addEventListener('click', () => this.exportResults('json'));
+        this.elements.monitorBtn.addEventListener('click', () => this.startMonitor());
+        this.elements.monitorStopBtn.addEventListener('click', () => this.stopMonitor());
+        this.elements.traceBtn.addEventListener('click', () => this.startTrace());
 
         this.elements.currentIPInput.addEventListener('input', () => this.updateNetworkFromIP());
 
@@ -83,7 +116,11 @@ class CrossNetGUI {
             if (data.success) {
                 this.elements.currentIPInput.value = data.ip;
                 this.elements.networkInput.value = data.network;
-                this.updateStatus('Current IP detected: ' + data.ip, 'complete');
+                let detected = 'Current IP detected: ' + data.ip;
+                if (data.ipv6) {
+                    detected += ` (IPv6: ${data.ipv6}, prefix ${data.ipv6_network})`;
+                }
+                this.updateStatus(detected, 'complete');
                 console.log('IP detected successfully:', data.ip);
             } else {
                 this.updateStatus('Failed to detect IP: ' + data.error, 'error');
@@ -124,7 +161,7 @@ class CrossNetGUI {
 
         const network = this.elements.networkInput.value.trim();
         if (!network) {
-            alert('Please enter a network to scan');
+            alert('Please enter the targets to scan');
             return;
         }
 
@@ -137,8 +174,23 @@ class CrossNetGUI {
             network: network,
             scan_type: this.elements.scanTypeSelect.value,
             threads: parseInt(this.elements.threadsInput.value),
-            timeout: parseInt(this.elements.timeoutInput.value)
+            timeout: parseInt(this.elements.timeoutInput.value),
+            timing: this.elements.timingSelect.value,
+            ports: this.elements.portsInput.value.trim(),
+            exclude: this.elements.excludeInput.value.trim(),
+            rate: parseFloat(this.elements.rateInput.value) || 0,
+            burst: parseInt(this.elements.burstInput.value) || 1,
+            subnet_rate: parseFloat(this.elements.subnetRateInput.value) || 0,
+            count: parseInt(this.elements.countInput.value) || 1,
+            resolve: this.elements.resolveInput.value.trim(),
+            dns_server: this.elements.dnsServerInput.value.trim(),
+            dns_timeout: this.elements.dnsTimeoutInput.value.trim(),
+            dns_retries: parseInt(this.elements.dnsRetriesInput.value),
+            snmp: this.elements.snmpInput.value.trim()
         };
+        if (scanConfig.scan_type === 'tcp' && scanConfig.ports !== 'top') {
+            scanConfig.probe_ports = scanConfig.ports;
+        }
 
         try {
             this.updateStatus('Starting scan...', 'scanning');
@@ -176,6 +228,10 @@ class CrossNetGUI {
         this.eventSource.onerror = (error) => {
             console.error('EventSource failed:', error);
             this.eventSource.close();
+            this.eventSource = null;
+            // The server drops a page that falls too far behind, so the
+            // results shown may be missing hosts
+            this.updateStatus('Lost the connection to the scan; results may be incomplete', 'error');
             this.isScanning = false;
             this.updateScanButtons();
             this.hideProgress();
@@ -185,12 +241,18 @@ class CrossNetGUI {
     handleScanUpdate(data) {
         switch (data.type) {
             case 'progress':
-                this.updateProgress(data.progress, data.message);
+                this.updateProgress(data.progress || 0, data.message, data.eta_seconds);
                 break;
-            case 'result':
-                this.addResult(data.result);
+            case 'host':
+                this.addHost(data.result);
                 break;
             case 'complete':
+                // The final list of merged hosts replaces the one built
+                // from the individual updates
+                if (Array.isArray(data.result)) {
+                    this.scanResults = data.result;
+                    this.renderResults();
+                }
                 this.scanComplete(data);
                 break;
             case 'error':
@@ -200,30 +262,54 @@ class CrossNetGUI {
         }
     }
 
-    updateProgress(progress, message) {
+    updateProgress(progress, message, etaSeconds) {
         this.elements.progress.style.width = progress + '%';
-        this.elements.progressText.textContent = progress + '%';
+        this.elements.progressText.textContent = etaSeconds
+            ? `${progress}% (~${this.formatETA(etaSeconds)} left)`
+            : progress + '%';
         if (message) {
             this.updateStatus(message, 'scanning');
         }
     }
 
-    addResult(result) {
-        console.log('Adding result:', result);
-        // Check if this IP already exists to avoid duplicates
-        const existingIndex = this.scanResults.findIndex(r => (r.IP || r.ip) === (result.IP || result.ip));
-        if (existingIndex >= 0) {
-            console.log('Updating existing result for IP:', result.IP || result.ip);
-            // Update existing result with new data
-            this.scanResults[existingIndex] = result;
+    formatETA(seconds) {
+        if (seconds < 60) {
+            return seconds + 's';
+        }
+        const minutes = Math.floor(seconds / 60);
+        return `${minutes}m ${seconds % 60}s`;
+    }
+
+    // addHost stores the merged state of a host sent by the server. Hosts
+    // are matched by IP and, when both sides know it, MAC, as on the server.
+    addHost(host) {
+        const index = this.scanResults.findIndex(r => r.IP === host.IP && (!r.MAC || !host.MAC || r.MAC === host.MAC));
+        if (index >= 0) {
+            this.scanResults[index] = host;
         } else {
-            console.log('Adding new result for IP:', result.IP || result.ip);
-            this.scanResults.push(result);
+            this.scanResults.push(host);
         }
-        console.log('Total results now:', this.scanResults.length);
         this.renderResults();
     }
 
+    // isListed reports whether a host answered or is at least in the
+    // neighbour cache.
+    isListed(host) {
+        return host.Alive || (host.Sources || []).includes('arp-cache');
+    }
+
+    formatPorts(result) {
+        if (!result.Ports || result.Ports.length === 0) {
+            return 'N/A';
+        }
+        return result.Ports.map(p => p.Service ? `${p.Port}/${p.Service}` : `${p.Port}`).join(', ');
+    }
+
+    // formatServices lists the DNS-SD services a host advertises over mDNS.
+    formatServices(host) {
+        return (host.Services || []).map(s => s.Port ? `${s.Instance} (${s.Type}:${s.Port})` : `${s.Instance} (${s.Type})`).join(', ');
+    }
+
     scanComplete(data) {
         this.isScanning = false;
         this.updateScanButtons();
@@ -234,7 +320,7 @@ class CrossNetGUI {
             this.eventSource = null;
         }
 
-        const aliveCount = this.scanResults.filter(r => r.Alive || r.Online || r.alive || r.online).length;
+        const aliveCount = this.scanResults.filter(r => r.Alive).length;
         this.updateStatus(`Scan completed. Found ${aliveCount} active devices.`, 'complete');
     }
 
@@ -259,8 +345,8 @@ class CrossNetGUI {
     }
 
     renderResults() {
-        const aliveResults = this.scanResults.filter(r => r.Alive || r.Online || r.alive || r.online);
-        this.elements.aliveCount.textContent = aliveResults.length;
+        const listed = this.scanResults.filter(r => this.isListed(r));
+        this.elements.aliveCount.textContent = listed.filter(r => r.Alive).length;
 
         if (this.scanResults.length === 0) {
             this.elements.noResults.style.display = 'block';
@@ -273,22 +359,26 @@ class CrossNetGUI {
 
         this.elements.resultsBody.innerHTML = '';
 
-        aliveResults.forEach(result => {
+        listed.forEach(host => {
             const row = document.createElement('tr');
 
-            const isAlive = result.Alive || result.Online || result.alive || result.online;
-            const statusClass = isAlive ? 'status-up' : 'status-down';
-            const status = (result.Alive || result.alive) ? 'UP' : (result.Online || result.online) ? 'ACTIVE' : 'DOWN';
-            const method = (result.RTT !== undefined || result.rtt !== undefined) ? 'PING' : 'ARP';
-            const responseTime = (result.RTT || result.rtt) ? this.formatDuration(result.RTT || result.rtt) : 'N/A';
+            const statusClass = host.Alive ? 'status-up' : 'status-down';
+            const status = host.Alive ? 'UP' : 'CACHED';
+            let responseTime = host.RTT ? this.formatDuration(host.RTT) : 'N/A';
+            if (host.Stats) {
+                responseTime = this.formatStats(host.Stats);
+            }
 
             row.innerHTML = `
-                <td>${result.IP || result.ip}</td>
-                <td>${result.MAC || result.mac || 'N/A'}</td>
-                <td>${result.Hostname || result.hostname || 'N/A'}</td>
+                <td>${host.IP}</td>
+                <td>${host.MAC || 'N/A'}</td>
+                <td>${this.formatVendor(host) || 'N/A'}${this.formatUPnP(host)}${this.formatSNMP(host)}</td>
+                <td>${this.escapeHTML(this.formatHostnames(host)) || 'N/A'}${this.formatNetBIOS(host)}</td>
                 <td class="${statusClass}">${status}</td>
                 <td>${responseTime}</td>
-                <td>${method}</td>
+                <td>${(host.Sources || []).join(' + ')}</td>
+                <td>${this.formatPorts(host)}</td>
+                <td>${this.escapeHTML(this.formatServices(host)) || 'N/A'}</td>
             `;
 
             this.elements.resultsBody.appendChild(row);
@@ -303,9 +393,302 @@ class CrossNetGUI {
         return Math.round(ms) + 'ms';
     }
 
+    // formatVendor names the maker of a device, calling out MACs that were
+    // made up locally so that they are not taken for an unknown vendor.
+    formatVendor(result) {
+        if (!result.MAC) {
+            return '';
+        }
+        if (result.LocallyAdministered) {
+            return 'Locally administered (random)';
+        }
+        return result.Vendor || 'Unknown';
+    }
+
+    // formatHostnames lists every name found for a host with its source.
+    formatHostnames(host) {
+        return (host.Hostnames || []).map(h => `${h.Name} (${h.Source})`).join(', ');
+    }
+
+    // formatUPnP adds the UPnP devices a host announced over SSDP below its
+    // vendor.
+    formatUPnP(host) {
+        const devices = this.describeUPnP(host);
+        return devices ? `<br><small>${this.escapeHTML(devices)}</small>` : '';
+    }
+
+    // describeUPnP names each UPnP device of a host with its maker, model
+    // and serial number.
+    describeUPnP(host) {
+        return (host.UPnP || []).map(d => {
+            const model = [d.Manufacturer, d.ModelName, d.ModelNumber].filter(Boolean).join(' ');
+            const details = [model, d.SerialNumber ? `S/N ${d.SerialNumber}` : ''].filter(Boolean).join(', ');
+            const name = d.FriendlyName || d.Location;
+            return details ? `${name} (${details})` : name;
+        }).join('; ');
+    }
+
+    // formatSNMP adds what the SNMP agent of a host said about it below its
+    // vendor.
+    formatSNMP(host) {
+        const system = this.describeSNMP(host);
+        return system ? `<br><small>SNMP: ${this.escapeHTML(system)}</small>` : '';
+    }
+
+    // describeSNMP summarises the SNMP system group of a host: its name,
+    // description, uptime and location.
+    describeSNMP(host) {
+        const system = host.SNMP;
+        if (!system) {
+            return '';
+        }
+        return [
+            system.Name,
+            system.Descr,
+            system.UpTime ? `up ${this.formatUptime(system.UpTime)}` : '',
+            system.Location ? `at ${system.Location}` : ''
+        ].filter(Boolean).join(', ');
+    }
+
+    // formatUptime shows an uptime in nanoseconds in days, hours and minutes.
+    formatUptime(nanoseconds) {
+        const minutes = Math.floor(nanoseconds / 60e9);
+        const days = Math.floor(minutes / 1440);
+        const time = `${Math.floor(minutes / 60) % 24}h ${minutes % 60}m`;
+        return days > 0 ? `${days}d ${time}` : time;
+    }
+
+    // formatNetBIOS adds what a host said in its NetBIOS node status reply
+    // below its names.
+    formatNetBIOS(host) {
+        const status = host.NetBIOS;
+        if (!status) {
+            return '';
+        }
+        const details = [];
+        if (status.Domain) {
+            details.push(`Domain: ${status.Domain}`);
+        }
+        if (status.Users && status.Users.length > 0) {
+            details.push(`Users: ${status.Users.join(', ')}`);
+        }
+        if (status.MAC) {
+            details.push(`MAC: ${status.MAC}`);
+        }
+        return details.length > 0 ? `<br><small>${this.escapeHTML(details.join('; '))}</small>` : '';
+    }
+
+    // escapeHTML makes names chosen by the devices themselves, such as
+    // service instances, safe to show as markup.
+    escapeHTML(text) {
+        const div = document.createElement('div');
+        div.textContent = text;
+        return div.innerHTML;
+    }
+
+    formatStats(stats) {
+        const loss = `${stats.Received}/${stats.Sent} (${stats.Loss.toFixed(0)}% loss)`;
+        if (stats.Received === 0) {
+            return loss;
+        }
+        return `${this.formatDuration(stats.Avg)} avg, ${this.formatDuration(stats.Min)}-${this.formatDuration(stats.Max)}, ` +
+            `jitter ${this.formatDuration(stats.Jitter)}, ${loss}`;
+    }
+
     updateScanButtons() {
         this.elements.scanBtn.disabled = this.isScanning;
-        this.elements.stopBtn.disabled = !this.isScanning;
+        this.elements.stopBtn.disabled = !this.isScanning || this.isMonitoring;
+        this.elements.monitorBtn.disabled = this.isScanning;
+        this.elements.monitorStopBtn.disabled = !this.isMonitoring;
+        this.elements.traceBtn.disabled = this.isScanning;
+    }
+
+    // startJob posts config to a monitor or trace endpoint and hands the
+    // events of the run to onEvent until done is called.
+    async startJob(url, config, onEvent) {
+        this.isScanning = true;
+        this.updateScanButtons();
+
+        const response = await fetch(url, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json'
+            },
+            body: JSON.stringify(config)
+        });
+        if (!response.ok) {
+            throw new Error(`HTTP error! status: ${response.status}`);
+        }
+
+        this.eventSource = new EventSource('/api/scan-progress');
+        this.eventSource.onmessage = (event) => onEvent(JSON.parse(event.data));
+        this.eventSource.onerror = () => this.jobComplete();
+    }
+
+    jobComplete() {
+        if (this.eventSource) {
+            this.eventSource.close();
+            this.eventSource = null;
+        }
+        this.isScanning = false;
+        this.isMonitoring = false;
+        this.updateScanButtons();
+    }
+
+    async startMonitor() {
+        if (this.isScanning) return;
+
+        const host = this.elements.monitorHostInput.value.trim();
+        if (!host) {
+            alert('Please enter the host to monitor');
+            return;
+        }
+
+        const monitorConfig = {
+            host: host,
+            port: parseInt(this.elements.monitorPortInput.value) || 0,
+            interval_ms: parseInt(this.elements.monitorIntervalInput.value) || 1000
+        };
+
+        this.isMonitoring = true;
+        this.elements.monitorBody.innerHTML = '';
+        this.elements.monitorTable.style.display = 'table';
+
+        try {
+            await this.startJob('/api/monitor', monitorConfig, data => this.handleMonitorUpdate(data));
+        } catch (error) {
+            this.updateMonitorStatus('Monitor failed: ' + error.message, 'error');
+            this.jobComplete();
+        }
+    }
+
+    handleMonitorUpdate(data) {
+        switch (data.type) {
+            case 'progress':
+                this.updateMonitorStatus(data.message, 'scanning');
+                break;
+            case 'sample':
+                this.addMonitorSample(data.result);
+                break;
+            case 'complete':
+                this.updateMonitorStatus(data.message, 'complete');
+                this.jobComplete();
+                break;
+            case 'error':
+                this.updateMonitorStatus('Monitor error: ' + data.error, 'error');
+                this.jobComplete();
+                break;
+        }
+    }
+
+    addMonitorSample(sample) {
+        const recentStats = sample.Window;
+        const row = document.createElement('tr');
+        const statusClass = sample.Alive ? 'status-up' : 'status-down';
+        const recent = recentStats.Received > 0;
+
+        row.innerHTML = `
+            <td>${sample.Seq}</td>
+            <td>${new Date(sample.Time).toLocaleTimeString()}</td>
+            <td class="${statusClass}">${sample.Alive ? this.formatDuration(sample.RTT) : sample.Error}</td>
+            <td>${sample.TTL ? `${sample.Probe} ttl=${sample.TTL}` : sample.Probe}</td>
+            <td>${recentStats.Loss.toFixed(1)}%</td>
+            <td>${recent ? this.formatDuration(recentStats.Avg) : 'N/A'}</td>
+            <td>${recent ? this.formatDuration(recentStats.Jitter) : 'N/A'}</td>
+        `;
+
+        // Newest first, keeping the table to the last few minutes
+        this.elements.monitorBody.prepend(row);
+        while (this.elements.monitorBody.rows.length > 200) {
+            this.elements.monitorBody.lastChild.remove();
+        }
+        this.updateMonitorStatus(`Monitoring ${sample.IP}: ${sample.Seq} probes, ${recentStats.Loss.toFixed(1)}% recent loss`, 'scanning');
+    }
+
+    stopMonitor() {
+        fetch('/api/stop-scan', { method: 'POST' })
+            .catch(error => console.error('Error stopping monitor:', error));
+    }
+
+    updateMonitorStatus(message, type) {
+        this.elements.monitorStatus.textContent = message;
+        this.elements.monitorStatus.className = `status-${type}`;
+    }
+
+    async startTrace() {
+        if (this.isScanning) return;
+
+        const host = this.elements.traceHostInput.value.trim();
+        if (!host) {
+            alert('Please enter the host to trace');
+            return;
+        }
+
+        const traceConfig = {
+            host: host,
+            protocol: this.elements.traceProtocolSelect.value,
+            port: parseInt(this.elements.tracePortInput.value) || 0,
+            resolve: this.elements.traceResolveInput.value.trim()
+        };
+
+        this.elements.traceBody.innerHTML = '';
+        this.elements.traceTable.style.display = 'table';
+
+        try {
+            await this.startJob('/api/trace', traceConfig, data => this.handleTraceUpdate(data));
+        } catch (error) {
+            this.updateTraceStatus('Trace failed: ' + error.message, 'error');
+            this.jobComplete();
+        }
+    }
+
+    handleTraceUpdate(data) {
+        switch (data.type) {
+            case 'progress':
+                this.updateTraceStatus(data.message, 'scanning');
+                break;
+            case 'hop':
+                this.addTraceHop(data.result);
+                break;
+            case 'complete':
+                // Completed paths are kept for the JSON export
+                this.traces.push(data.result);
+                this.updateTraceStatus(data.message, 'complete');
+                this.jobComplete();
+                break;
+            case 'error':
+                this.updateTraceStatus('Trace error: ' + (data.error || data.message), 'error');
+                this.jobComplete();
+                break;
+        }
+    }
+
+    addTraceHop(hop) {
+        const row = document.createElement('tr');
+        const times = (hop.RTTs || []).map(rtt => this.formatDuration(rtt));
+        while (times.length < hop.Stats.Sent) {
+            times.push('*');
+        }
+        if (hop.Note) {
+            times.push(hop.Note);
+        }
+        if (hop.Error) {
+            times.push('(' + this.escapeHTML(hop.Error) + ')');
+        }
+
+        row.innerHTML = `
+            <td>${hop.TTL}</td>
+            <td class="${hop.Reached ? 'status-up' : ''}">${hop.IP || '*'}</td>
+            <td>${hop.Hostname || 'N/A'}</td>
+            <td>${times.join(' ')}</td>
+            <td>${hop.Stats.Loss.toFixed(0)}%</td>
+        `;
+        this.elements.traceBody.appendChild(row);
+    }
+
+    updateTraceStatus(message, type) {
+        this.elements.traceStatus.textContent = message;
+        this.elements.traceStatus.className = `status-${type}`;
     }
 
     updateStatus(message, type = 'idle') {
@@ -323,39 +706,68 @@ class CrossNetGUI {
     }
 
     exportResults(format) {
-        if (this.scanResults.length === 0) {
+        if (this.scanResults.length === 0 && (format !== 'json' || this.traces.length === 0)) {
             alert('No results to export');
             return;
         }
 
-        const aliveResults = this.scanResults.filter(r => r.Alive || r.Online || r.alive || r.online);
+        const listed = this.scanResults.filter(r => this.isListed(r));
 
         if (format === 'csv') {
-            this.exportCSV(aliveResults);
+            this.exportCSV(listed);
         } else if (format === 'json') {
-            this.exportJSON(aliveResults);
+            this.exportJSON(listed);
         }
     }
 
     exportCSV(results) {
-        const headers = ['IP Address', 'MAC Address', 'Hostname', 'Status', 'Response Time', 'Method'];
+        const headers = ['IP Address', 'MAC Address', 'Vendor', 'Hostname', 'Status', 'Response Time', 'Seen By', 'Open Ports',
+            'Sent', 'Received', 'Loss %', 'Min', 'Avg', 'Max', 'Jitter', 'First Seen', 'Last Seen',
+            'NetBIOS Domain', 'NetBIOS Users', 'NetBIOS MAC', 'Services', 'UPnP Devices',
+            'SNMP Name', 'SNMP Description', 'SNMP Object ID', 'SNMP Uptime', 'SNMP Location'];
         const csvContent = [
             headers.join(','),
-            ...results.map(result => [
-                result.IP || result.ip,
-                result.MAC || result.mac || '',
-                result.Hostname || result.hostname || '',
-                (result.Alive || result.alive) ? 'UP' : 'ACTIVE',
-                (result.RTT || result.rtt) ? this.formatDuration(result.RTT || result.rtt) : '',
-                (result.RTT !== undefined || result.rtt !== undefined) ? 'PING' : 'ARP'
+            ...results.map(host => [
+                host.IP,
+                host.MAC || '',
+                this.formatVendor(host),
+                this.formatHostnames(host),
+                host.Alive ? 'UP' : 'CACHED',
+                host.RTT ? this.formatDuration(host.RTT) : '',
+                (host.Sources || []).join(' '),
+                host.Ports ? host.Ports.map(p => p.Port).join(' ') : '',
+                ...this.statsFields(host.Stats),
+                host.FirstSeen,
+                host.LastSeen,
+                host.NetBIOS ? host.NetBIOS.Domain || '' : '',
+                host.NetBIOS ? (host.NetBIOS.Users || []).join(' ') : '',
+                host.NetBIOS ? host.NetBIOS.MAC || '' : '',
+                this.formatServices(host),
+                this.describeUPnP(host),
+                host.SNMP ? host.SNMP.Name || '' : '',
+                host.SNMP ? host.SNMP.Descr || '' : '',
+                host.SNMP ? host.SNMP.ObjectID || '' : '',
+                host.SNMP && host.SNMP.UpTime ? this.formatUptime(host.SNMP.UpTime) : '',
+                host.SNMP ? host.SNMP.Location || '' : ''
             ].map(field => `"${field}"`).join(','))
         ].join('\n');
 
         this.downloadFile(csvContent, 'crossnet-results.csv', 'text/csv');
     }
 
+    statsFields(stats) {
+        if (!stats) {
+            return ['', '', '', '', '', '', ''];
+        }
+        const ms = d => (d / 1000000).toFixed(3);
+        return [stats.Sent, stats.Received, stats.Loss.toFixed(1),
+            ms(stats.Min), ms(stats.Avg), ms(stats.Max), ms(stats.Jitter)];
+    }
+
     exportJSON(results) {
-        const jsonContent = JSON.stringify(results, null, 2);
+        // Paths traced in this session are stored alongside the hosts
+        const exported = this.traces.length > 0 ? { hosts: results, traces: this.traces } : results;
+        const jsonContent = JSON.stringify(exported, null, 2);
         this.downloadFile(jsonContent, 'crossnet-results.json', 'application/json');
     }
 